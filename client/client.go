@@ -0,0 +1,368 @@
+// Package client is a Go client for the receipt processor HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"receipt-processor/internal/backoff"
+	"receipt-processor/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Largest error body read into an error message
+const maxErrorBodyBytes = 4 << 10
+
+// ErrNotFound is returned when the server has no receipt with the requested ID.
+var ErrNotFound = errors.New("receipt not found")
+
+// ErrConflict is matched by errors for changes the receipt's current state does not allow,
+// such as modifying an archived receipt. Use errors.Is; the error also carries the server's message.
+var ErrConflict = errors.New("conflict")
+
+// Receipt is a receipt as the API accepts and returns it. It and the types below are the server's own wire types.
+type (
+	Receipt        = model.Receipt
+	Item           = model.Item
+	Event          = model.Event
+	Note           = model.Note
+	PointsMutation = model.PointsMutation
+	Reservation    = model.Reservation
+)
+
+// ValidationError is returned when the server rejects a receipt as invalid.
+type ValidationError struct {
+	Message string
+	// Violations names each invalid field. It is empty when the server answered in plain text.
+	Violations []FieldViolation
+}
+
+// FieldViolation is one invalid field: its JSON path, such as "items[1].price", and the check it failed.
+type FieldViolation struct {
+	Field   string `json:"field"`
+	Reason  string `json:"reason"`
+	Message string `json:"message,omitempty"`
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid receipt: " + e.Message
+}
+
+// APIError is returned for any other error response.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("receipt processor answered %d: %s", e.StatusCode, e.Message)
+}
+
+// Is reports a 409 answer as ErrConflict.
+func (e *APIError) Is(target error) bool {
+	return target == ErrConflict && e.StatusCode == http.StatusConflict
+}
+
+// Client talks to one receipt processor server. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	apiKey     string
+	adminToken string
+	retries    int
+	backoff    time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client requests are sent with.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithAPIKey sends the key as X-API-Key on every request.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithAdminToken sends the token as X-Admin-Token on every request.
+func WithAdminToken(token string) Option {
+	return func(c *Client) { c.adminToken = token }
+}
+
+// WithRetries retries network failures, 429s and 5xx answers up to n more times, waiting a jittered,
+// doubling delay starting at backoff. Only reads and receipt submissions are retried. Every attempt at a
+// submission carries the same Idempotency-Key, so the server stores it once and answers retries with the
+// same ID. Other writes, such as reservations and transfers, are never retried.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = n
+		c.backoff = backoff
+	}
+}
+
+// New returns a client for the server at baseURL, e.g. "http://localhost:8080".
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		backoff:    200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ProcessReceipt submits a receipt and returns the ID it was stored under.
+func (c *Client) ProcessReceipt(ctx context.Context, receipt Receipt) (string, error) {
+	body, err := json.Marshal(receipt)
+	if err != nil {
+		return "", err
+	}
+	var response struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/receipts/process", body, &response, true); err != nil {
+		return "", err
+	}
+	return response.ID, nil
+}
+
+// GetPoints returns the points awarded to a receipt.
+func (c *Client) GetPoints(ctx context.Context, id string) (int, error) {
+	var response model.ResponsePoints
+	if err := c.do(ctx, http.MethodGet, receiptPath(id, "/points"), nil, &response, true); err != nil {
+		return 0, err
+	}
+	return response.Points, nil
+}
+
+// GetReceipt returns a stored receipt.
+func (c *Client) GetReceipt(ctx context.Context, id string) (Receipt, error) {
+	var receipt Receipt
+	err := c.do(ctx, http.MethodGet, receiptPath(id, ""), nil, &receipt, true)
+	return receipt, err
+}
+
+// BatchPoints returns the points of several receipts at once. IDs the server has no receipt for map to nil.
+func (c *Client) BatchPoints(ctx context.Context, ids []string) (map[string]*int, error) {
+	body, err := json.Marshal(model.BatchPointsRequest{IDs: ids})
+	if err != nil {
+		return nil, err
+	}
+	var points map[string]*int
+	// Looking points up changes nothing, so it is retried like a read
+	err = c.do(ctx, http.MethodPost, "/receipts/points", body, &points, true)
+	return points, err
+}
+
+// CountReceipts counts the stored receipts, or only a retailer's when retailer is not empty.
+func (c *Client) CountReceipts(ctx context.Context, retailer string) (int, error) {
+	path := "/receipts/count"
+	if retailer != "" {
+		path += "?retailer=" + url.QueryEscape(retailer)
+	}
+	var response model.ResponseCount
+	if err := c.do(ctx, http.MethodGet, path, nil, &response, true); err != nil {
+		return 0, err
+	}
+	return response.Count, nil
+}
+
+// Timeline returns a receipt's lifecycle events, oldest first.
+func (c *Client) Timeline(ctx context.Context, id string) ([]Event, error) {
+	var events []Event
+	err := c.do(ctx, http.MethodGet, receiptPath(id, "/timeline"), nil, &events, true)
+	return events, err
+}
+
+// PointsHistory returns every change to a receipt's points, oldest first.
+func (c *Client) PointsHistory(ctx context.Context, id string) ([]PointsMutation, error) {
+	var history []PointsMutation
+	err := c.do(ctx, http.MethodGet, receiptPath(id, "/points/history"), nil, &history, true)
+	return history, err
+}
+
+// Notes returns the notes left on a receipt.
+func (c *Client) Notes(ctx context.Context, id string) ([]Note, error) {
+	var notes []Note
+	err := c.do(ctx, http.MethodGet, receiptPath(id, "/notes"), nil, &notes, true)
+	return notes, err
+}
+
+// AddNote leaves a note on a receipt and returns it as stored.
+func (c *Client) AddNote(ctx context.Context, id, text, author string) (Note, error) {
+	var note Note
+	body, err := json.Marshal(model.NoteRequest{Text: text, Author: author})
+	if err != nil {
+		return note, err
+	}
+	err = c.do(ctx, http.MethodPost, receiptPath(id, "/notes"), body, &note, false)
+	return note, err
+}
+
+// ReservePoints holds points on a receipt until the reservation is committed, rolled back or expires.
+func (c *Client) ReservePoints(ctx context.Context, id string, points int) (Reservation, error) {
+	var reservation Reservation
+	body, err := json.Marshal(model.ReserveRequest{Points: points})
+	if err != nil {
+		return reservation, err
+	}
+	err = c.do(ctx, http.MethodPost, receiptPath(id, "/points/reserve"), body, &reservation, false)
+	return reservation, err
+}
+
+// CommitReservation makes a reservation's redemption final and returns the points left on the receipt.
+func (c *Client) CommitReservation(ctx context.Context, reservation Reservation) (int, error) {
+	return c.settleReservation(ctx, reservation, "/points/commit/")
+}
+
+// RollbackReservation returns a reservation's points to its receipt and returns the points it then has.
+func (c *Client) RollbackReservation(ctx context.Context, reservation Reservation) (int, error) {
+	return c.settleReservation(ctx, reservation, "/points/rollback/")
+}
+
+func (c *Client) settleReservation(ctx context.Context, reservation Reservation, action string) (int, error) {
+	var response model.ResponsePoints
+	path := receiptPath(reservation.ReceiptID, action+url.PathEscape(reservation.ID))
+	if err := c.do(ctx, http.MethodPost, path, nil, &response, false); err != nil {
+		return 0, err
+	}
+	return response.Points, nil
+}
+
+// TransferPoints moves amount points from one receipt to another.
+func (c *Client) TransferPoints(ctx context.Context, id, targetID string, amount int) (model.TransferResponse, error) {
+	var response model.TransferResponse
+	body, err := json.Marshal(model.TransferRequest{TargetID: targetID, Amount: amount})
+	if err != nil {
+		return response, err
+	}
+	err = c.do(ctx, http.MethodPost, receiptPath(id, "/points/transfer"), body, &response, false)
+	return response, err
+}
+
+func receiptPath(id, suffix string) string {
+	return "/receipts/" + url.PathEscape(id) + suffix
+}
+
+// Function to send a request and decode a JSON answer into out. Only idempotent requests are retried; writes
+// among them carry an Idempotency-Key, and a 409 saying the first attempt is still running is retried too
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out any, idempotent bool) error {
+	attempts := 1
+	idempotencyKey := ""
+	if idempotent {
+		attempts += c.retries
+		if method != http.MethodGet && c.retries > 0 {
+			idempotencyKey = uuid.New().String()
+		}
+	}
+	var lastErr error
+	for attempt := range attempts {
+		if attempt > 0 {
+			if err := backoff.Wait(ctx, c.backoff, attempt); err != nil {
+				return err
+			}
+		}
+		status, reply, err := c.send(ctx, method, path, body, idempotencyKey)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		if status == http.StatusTooManyRequests || status >= 500 || (status == http.StatusConflict && idempotencyKey != "") {
+			lastErr = responseError(path, status, reply)
+			continue
+		}
+		if status < 200 || status > 299 {
+			return responseError(path, status, reply)
+		}
+		return decodeResponse(reply, out)
+	}
+	return lastErr
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body []byte, idempotencyKey string) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.adminToken != "" {
+		req.Header.Set("X-Admin-Token", c.adminToken)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	reply, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, reply, nil
+}
+
+// Function to turn an error answer into the matching error. The server answers JSON clients with a
+// code and any field violations; answers without them are matched on the status alone
+func responseError(path string, status int, reply []byte) error {
+	var body struct {
+		Error      string           `json:"error"`
+		Code       string           `json:"code"`
+		Violations []FieldViolation `json:"violations"`
+	}
+	if decodeResponse(reply, &body) != nil || body.Code == "" {
+		body.Error = strings.TrimSpace(string(reply[:min(len(reply), maxErrorBodyBytes)]))
+		switch {
+		case status == http.StatusNotFound && strings.HasPrefix(path, "/receipts/"):
+			body.Code = "not_found"
+		case status == http.StatusBadRequest && path == "/receipts/process":
+			body.Code = "invalid_receipt"
+		}
+	}
+	switch body.Code {
+	case "not_found":
+		return ErrNotFound
+	case "invalid_receipt":
+		return &ValidationError{Message: body.Error, Violations: body.Violations}
+	}
+	return &APIError{StatusCode: status, Message: body.Error}
+}
+
+// Function to decode a JSON answer, unwrapping the {"data":...,"meta":...} envelope when the server uses it
+func decodeResponse(reply []byte, out any) error {
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+		Meta json.RawMessage `json:"meta"`
+	}
+	if json.Unmarshal(reply, &envelope) == nil && envelope.Data != nil && envelope.Meta != nil {
+		reply = envelope.Data
+	}
+	return json.Unmarshal(reply, out)
+}
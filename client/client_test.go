@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"receipt-processor/internal/api"
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+)
+
+var targetReceipt = Receipt{
+	Retailer:     "Target",
+	PurchaseDate: "2022-01-01",
+	PurchaseTime: "13:01",
+	Items: []Item{
+		{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+		{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+		{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},
+		{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},
+		{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"},
+	},
+	Total: "35.35",
+}
+
+// Function to build the real API over an in-memory store, wrapped in wrap when it is not nil
+func startServer(t *testing.T, wrap func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+	handler, err := api.NewServer(store.NewMemory(16), points.NewCalculator(points.DefaultConfig()),
+		api.WithLogger(slog.New(slog.DiscardHandler)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientDecodesAnswers(t *testing.T) {
+	server := startServer(t, nil)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	id, err := c.ProcessReceipt(ctx, targetReceipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.GetPoints(ctx, id); err != nil || got != 28 {
+		t.Errorf("GetPoints = %d, %v, want 28", got, err)
+	}
+	stored, err := c.GetReceipt(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Retailer != "Target" || len(stored.Items) != 5 || stored.Total != "35.35" {
+		t.Errorf("GetReceipt = %+v, want the submitted receipt", stored)
+	}
+	if got, err := c.CountReceipts(ctx, "Target"); err != nil || got != 1 {
+		t.Errorf("CountReceipts = %d, %v, want 1", got, err)
+	}
+
+	unknown := "7fb1377b-b223-49d9-a31a-5a02701dd310"
+	batch, err := c.BatchPoints(ctx, []string{id, unknown})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch[id] == nil || *batch[id] != 28 || batch[unknown] != nil {
+		t.Errorf("BatchPoints = %v, want 28 for the receipt and nil for the unknown ID", batch)
+	}
+
+	reservation, err := c.ReservePoints(ctx, id, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reservation.ReceiptID != id || reservation.Points != 10 {
+		t.Errorf("ReservePoints = %+v, want 10 points on %s", reservation, id)
+	}
+	if remaining, err := c.CommitReservation(ctx, reservation); err != nil || remaining != 18 {
+		t.Errorf("CommitReservation = %d, %v, want 18", remaining, err)
+	}
+	history, err := c.PointsHistory(ctx, id)
+	if err != nil || len(history) < 2 {
+		t.Errorf("PointsHistory = %+v, %v, want the award and the redemption", history, err)
+	}
+	if events, err := c.Timeline(ctx, id); err != nil || len(events) == 0 {
+		t.Errorf("Timeline = %+v, %v, want events", events, err)
+	}
+	if _, err := c.AddNote(ctx, id, "checked", "tester"); err != nil {
+		t.Fatal(err)
+	}
+	if notes, err := c.Notes(ctx, id); err != nil || len(notes) != 1 || notes[0].Text != "checked" {
+		t.Errorf("Notes = %+v, %v, want the note left", notes, err)
+	}
+}
+
+func TestClientMapsErrors(t *testing.T) {
+	server := startServer(t, nil)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	if _, err := c.GetPoints(ctx, "7fb1377b-b223-49d9-a31a-5a02701dd310"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetPoints of an unknown ID = %v, want ErrNotFound", err)
+	}
+	invalid := targetReceipt
+	invalid.Total = "35.3"
+	_, err := c.ProcessReceipt(ctx, invalid)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("ProcessReceipt of an invalid receipt = %v, want a ValidationError", err)
+	}
+	if len(validationErr.Violations) == 0 || validationErr.Violations[0].Field != "total" {
+		t.Errorf("violations = %+v, want one for total", validationErr.Violations)
+	}
+}
+
+func TestClientUnwrapsEnvelopes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"points":42},"meta":{"requestId":"abc"}}`))
+	}))
+	t.Cleanup(server.Close)
+	if got, err := New(server.URL).GetPoints(context.Background(), "id"); err != nil || got != 42 {
+		t.Errorf("GetPoints = %d, %v, want 42 from inside the envelope", got, err)
+	}
+}
+
+// The first attempt at a submission is stored but its answer is lost; the retry must get the same ID back
+// without storing the receipt twice
+func TestRetriedSubmissionIsStoredOnce(t *testing.T) {
+	var mutex sync.Mutex
+	var keys []string
+	server := startServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/receipts/process" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			mutex.Lock()
+			keys = append(keys, r.Header.Get("Idempotency-Key"))
+			first := len(keys) == 1
+			mutex.Unlock()
+			if first {
+				next.ServeHTTP(httptest.NewRecorder(), r)
+				http.Error(w, "upstream went away", http.StatusBadGateway)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := New(server.URL, WithRetries(3, time.Millisecond))
+	ctx := context.Background()
+
+	id, err := c.ProcessReceipt(ctx, targetReceipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("Idempotency-Keys sent = %q, want the same key on both attempts", keys)
+	}
+	if got, err := c.CountReceipts(ctx, ""); err != nil || got != 1 {
+		t.Errorf("CountReceipts = %d, %v, want the receipt stored once", got, err)
+	}
+	if got, err := c.GetPoints(ctx, id); err != nil || got != 28 {
+		t.Errorf("GetPoints of the replayed ID = %d, %v, want 28", got, err)
+	}
+}
+
+func TestOtherWritesAreNotRetried(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	c := New(server.URL, WithRetries(3, time.Millisecond))
+
+	_, err := c.TransferPoints(context.Background(), "a", "b", 1)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("TransferPoints = %v, want the 503", err)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("transfer sent %d times, want once", got)
+	}
+
+	attempts.Store(0)
+	if _, err := c.GetPoints(context.Background(), "a"); err == nil {
+		t.Error("GetPoints succeeded against a failing server")
+	}
+	if got := attempts.Load(); got != 4 {
+		t.Errorf("GetPoints sent %d times, want 4", got)
+	}
+}
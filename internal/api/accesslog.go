@@ -0,0 +1,214 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type AccessLogConfig struct {
+	Path      string `yaml:"path"`
+	Format    string `yaml:"format"`
+	MaxSizeMB int    `yaml:"maxSizeMB"`
+	MaxFiles  int    `yaml:"maxFiles"`
+}
+
+// Lines waiting to be written; when the writer falls behind new lines are dropped rather than blocking requests
+const accessLogQueueSize = 4096
+
+type AccessLogger struct {
+	config  AccessLogConfig
+	lines   chan []byte
+	file    *os.File
+	writer  *bufio.Writer
+	size    int64
+	reopen  chan os.Signal
+	done    chan struct{}
+	closing sync.Once
+	// Lines dropped because the writer fell behind or the write failed; registered by each server logging here
+	dropped prometheus.Counter
+}
+
+type accessLogRecord struct {
+	Time      string `json:"time"`
+	ClientIP  string `json:"clientIp"`
+	Identity  string `json:"identity,omitempty"`
+	RequestID string `json:"requestId"`
+	TraceID   string `json:"traceId,omitempty"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Protocol  string `json:"protocol"`
+	Status    int    `json:"status"`
+	Bytes     int    `json:"bytes"`
+	Duration  int64  `json:"durationMs"`
+	Referer   string `json:"referer,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+// Function to open the access log and start its background writer
+func newAccessLogger(config AccessLogConfig) (*AccessLogger, error) {
+	logger := &AccessLogger{
+		config: config,
+		lines:  make(chan []byte, accessLogQueueSize),
+		reopen: make(chan os.Signal, 1),
+		done:   make(chan struct{}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "access_log_dropped_lines_total",
+			Help: "Access log lines dropped because the writer fell behind or the write failed.",
+		}),
+	}
+	if err := logger.open(); err != nil {
+		return nil, err
+	}
+	signal.Notify(logger.reopen, syscall.SIGHUP, syscall.SIGUSR2)
+	go logger.run()
+	return logger, nil
+}
+
+func (l *AccessLogger) open() error {
+	file, err := os.OpenFile(l.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	l.size = info.Size()
+	return nil
+}
+
+func (l *AccessLogger) closeFile() {
+	if l.file != nil {
+		l.writer.Flush()
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// Function to shift path.N to path.N+1, dropping the oldest, and start a fresh file
+func (l *AccessLogger) rotate() {
+	l.closeFile()
+	for i := l.config.MaxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.config.Path, i), fmt.Sprintf("%s.%d", l.config.Path, i+1))
+	}
+	if l.config.MaxFiles > 0 {
+		os.Rename(l.config.Path, l.config.Path+".1")
+	} else {
+		os.Remove(l.config.Path)
+	}
+	l.open()
+}
+
+func (l *AccessLogger) write(line []byte) {
+	if l.file == nil {
+		l.dropped.Inc()
+		return
+	}
+	if _, err := l.writer.Write(line); err != nil {
+		l.dropped.Inc()
+		return
+	}
+	l.size += int64(len(line))
+	if l.config.MaxSizeMB > 0 && l.size >= int64(l.config.MaxSizeMB)<<20 {
+		l.rotate()
+	}
+}
+
+func (l *AccessLogger) run() {
+	defer close(l.done)
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+	for {
+		select {
+		case line, ok := <-l.lines:
+			if !ok {
+				l.closeFile()
+				return
+			}
+			l.write(line)
+		case <-flush.C:
+			if l.file != nil && l.writer.Flush() != nil {
+				l.dropped.Inc()
+			}
+		case <-l.reopen:
+			// External logrotate has moved the file away; start writing a new one at the configured path
+			l.closeFile()
+			l.open()
+		}
+	}
+}
+
+// Function to stop accepting lines and flush what has been queued
+func (l *AccessLogger) Close() {
+	l.closing.Do(func() {
+		signal.Stop(l.reopen)
+		close(l.lines)
+		<-l.done
+	})
+}
+
+// Function to format one request as a Combined Log Format line or a JSON object
+func (l *AccessLogger) format(r *http.Request, clientIP string, status, bytes int, start time.Time, identity string) []byte {
+	if l.config.Format == "json" {
+		line, _ := json.Marshal(accessLogRecord{
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			ClientIP:  clientIP,
+			Identity:  identity,
+			RequestID: requestIDFromContext(r.Context()),
+			TraceID:   traceIDFromContext(r.Context()),
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+			Protocol:  r.Proto,
+			Status:    status,
+			Bytes:     bytes,
+			Duration:  time.Since(start).Milliseconds(),
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+		})
+		return append(line, '\n')
+	}
+
+	user := identity
+	if user == "" {
+		user = "-"
+	}
+	size := "-"
+	if bytes > 0 {
+		size = strconv.Itoa(bytes)
+	}
+	return fmt.Appendf(nil, "%s - %s [%s] %q %d %s %q %q\n",
+		clientIP, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.URL.RequestURI()+" "+r.Proto, status, size, r.Referer(), r.UserAgent())
+}
+
+// Middleware to queue an access log line for every request without ever waiting on the disk
+func (l *AccessLogger) middleware(clientIP func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		identity := new(string)
+		next.ServeHTTP(recorder, r.WithContext(withIdentityHolder(r.Context(), identity)))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		select {
+		case l.lines <- l.format(r, clientIP(r), recorder.status, recorder.bytes, start, *identity):
+		default:
+			l.dropped.Inc()
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/store"
+)
+
+// Function to answer 409 when a receipt has been archived; callers hold the receipt's shard lock
+func (s *server) rejectArchived(w http.ResponseWriter, r *http.Request, shard *store.Shard, id string) bool {
+	if _, archived := shard.Archived[id]; archived {
+		s.writeError(w, r, errArchived)
+		return true
+	}
+	return false
+}
+
+// Function to move one receipt to the archive; callers hold the receipt's shard lock
+func (s *server) archiveReceiptLocked(id string) error {
+	if err := s.store.Archive(id); err != nil {
+		return err
+	}
+	s.recordEvent(id, EventArchived, "system", nil)
+	return nil
+}
+
+// Function to move every receipt stored longer than archiveAfter to the archive; callers hold no shard lock.
+// Receipts with open reservations are left for the next run so their points can still be committed or rolled back
+func (s *server) archiveReceipts(now time.Time) (int, error) {
+	archived := 0
+	for _, shard := range s.store.Shards() {
+		shard.Lock()
+		// Reservations on this shard's receipts only change under its lock, so they hold still until it is released
+		reserved := s.reservedPoints()
+		for id := range shard.Receipts {
+			if reserved[id] > 0 || now.Sub(shard.StoredAt[id]) < s.archiveAfter {
+				continue
+			}
+			if err := s.archiveReceiptLocked(id); err != nil {
+				shard.Unlock()
+				return archived, err
+			}
+			archived++
+		}
+		shard.Unlock()
+	}
+	return archived, nil
+}
+
+// Function to archive old receipts at every UTC midnight
+func (s *server) archiveNightly(stop <-chan struct{}) {
+	for {
+		now := time.Now().UTC()
+		midnight := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		timer := time.NewTimer(midnight.Sub(now))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		archived, err := s.archiveReceipts(s.clock())
+		if err != nil {
+			s.logger.Error("archiving receipts failed", "archived", archived, "error", err)
+			continue
+		}
+		s.logger.Info("archived receipts", "archived", archived)
+	}
+}
+
+// A stored receipt as GET /receipts/{id} returns it; only archived receipts carry the archived flag
+type ReceiptResponse struct {
+	model.Receipt
+	Archived bool `json:"archived,omitempty"`
+}
+
+// Handler to return a stored receipt; archived receipts are flagged in the body and the response metadata
+func (s *server) getReceiptHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if s.rejectStoreLight(w, r) {
+		return
+	}
+	receipt, archived, exists, err := s.readReceipt(id)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "migrating receipt failed", "requestId", requestIDFromContext(r.Context()), "receiptId", id, "error", err)
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The receipt could not be read."))
+		return
+	}
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+
+	if !archived {
+		s.writeJSON(w, r, http.StatusOK, ReceiptResponse{Receipt: receipt})
+		return
+	}
+	// The flag is in the body, and also in the envelope's metadata and a header for clients that read those
+	w.Header().Set("X-Receipt-Archived", "true")
+	s.writeJSON(w, r, http.StatusOK, ReceiptResponse{Receipt: receipt, Archived: true}, map[string]any{"archived": true})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+)
+
+func TestArchivedReceipts(t *testing.T) {
+	config := defaultConfig()
+	config.ArchiveAfterDays = 30
+	s, err := newServer(store.NewMemory(defaultLockShards), points.NewCalculator(points.DefaultConfig()),
+		WithConfig(config), WithLogger(discardLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	old := processReceipt(t, server, targetReceipt)
+
+	archived, err := s.archiveReceipts(time.Now().Add(31 * 24 * time.Hour))
+	if err != nil || archived != 1 {
+		t.Fatalf("archiveReceipts = %d, %v, want 1 receipt archived", archived, err)
+	}
+	fresh := processReceipt(t, server, cornerMarketReceipt)
+
+	tests := []struct {
+		name     string
+		id       string
+		archived bool
+	}{
+		{"archived receipt", old, true},
+		{"hot receipt", fresh, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, body := send(t, server, http.MethodGet, "/receipts/"+test.id, "")
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("GET = %d %s", response.StatusCode, body)
+			}
+			var got struct {
+				Retailer string `json:"retailer"`
+				Archived *bool  `json:"archived"`
+			}
+			decodeBody(t, body, &got)
+			if got.Retailer == "" {
+				t.Errorf("body %s has no receipt", body)
+			}
+			if test.archived && (got.Archived == nil || !*got.Archived) {
+				t.Errorf("body %s does not say the receipt is archived", body)
+			}
+			if !test.archived && got.Archived != nil {
+				t.Errorf("body %s flags a hot receipt", body)
+			}
+			if header := response.Header.Get("X-Receipt-Archived"); (header == "true") != test.archived {
+				t.Errorf("X-Receipt-Archived = %q", header)
+			}
+		})
+	}
+
+	if got := receiptPoints(t, server, old); got != 28 {
+		t.Errorf("archived receipt has %d points, want 28", got)
+	}
+	if response, body := send(t, server, http.MethodPost, "/receipts/"+old+"/notes", `{"text":"late"}`); response.StatusCode != http.StatusConflict {
+		t.Errorf("adding a note to an archived receipt = %d %s, want 409", response.StatusCode, body)
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+type identityKey struct{}
+
+type identityHolderKey struct{}
+
+// Paths reachable without an API key
+var publicPaths = map[string]bool{"/health": true, "/healthz": true, "/readyz": true, "/graphiql": true}
+
+// Function to parse API keys given as comma separated name:key pairs
+func parseAPIKeys(value string) map[string]string {
+	keys := make(map[string]string)
+	for _, entry := range splitList(value) {
+		name, key, _ := strings.Cut(entry, ":")
+		keys[strings.TrimSpace(name)] = strings.TrimSpace(key)
+	}
+	return keys
+}
+
+// Function to check whether a request carries the admin token
+func (s *server) isAdminRequest(r *http.Request) bool {
+	return s.isAdminToken(r.Header.Get("X-Admin-Token"))
+}
+
+// Function to check a token against the admin token; nothing matches when none is configured
+func (s *server) isAdminToken(token string) bool {
+	return s.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+// Function to find the identity behind the request's API key, if it has a valid one
+func (s *server) apiKeyIdentity(r *http.Request) (string, bool) {
+	return s.lookupAPIKey(r.Header.Get("X-API-Key"))
+}
+
+// Function to find the identity an API key authenticates
+func (s *server) lookupAPIKey(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	for name, candidate := range s.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(candidate)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Function to give outer middleware a slot that learns the identity once auth has run further in
+func withIdentityHolder(ctx context.Context, holder *string) context.Context {
+	return context.WithValue(ctx, identityHolderKey{}, holder)
+}
+
+// Function to attach the authenticated identity to a request
+func withIdentity(r *http.Request, identity string) *http.Request {
+	if holder, ok := r.Context().Value(identityHolderKey{}).(*string); ok {
+		*holder = identity
+	}
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+}
+
+// Function to get the authenticated identity placed in the context by the auth middleware
+func identityFromContext(ctx context.Context) string {
+	identity, _ := ctx.Value(identityKey{}).(string)
+	return identity
+}
+
+// Middleware to require a valid API key, or a client certificate standing in for one, on regular routes
+func (s *server) apiKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		identity, ok := s.apiKeyIdentity(r)
+		if !ok && s.isAdminRequest(r) {
+			identity, ok = "admin", true
+		}
+		if certIdentity, hasCert := clientCertIdentity(r); hasCert && !ok {
+			if s.requireAPIKeyWithCert {
+				s.writeError(w, r, errorWithStatus(http.StatusUnauthorized, "A valid API key is required."))
+				return
+			}
+			identity, ok = certIdentity, true
+		}
+		if !ok {
+			if len(s.apiKeys) > 0 {
+				s.writeError(w, r, errorWithStatus(http.StatusUnauthorized, "A valid API key is required."))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, withIdentity(r, identity))
+	})
+}
+
+// Middleware guarding privileged routes with the admin token and recording each call in the audit log
+func (s *server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !s.isAdminRequest(r) {
+			if _, ok := s.apiKeyIdentity(r); ok {
+				s.writeError(w, r, errorWithStatus(http.StatusForbidden, "This API key is not allowed to use admin endpoints."))
+				return
+			}
+			s.writeError(w, r, errorWithStatus(http.StatusUnauthorized, "Admin token required."))
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, withIdentity(r, "admin"))
+		s.auditLog(r, "admin", recorder.status)
+	})
+}
+
+// Function to write an audit record for a privileged action
+func (s *server) auditLog(r *http.Request, identity string, status int) {
+	s.auditLogger.InfoContext(r.Context(), "audit",
+		"identity", identity,
+		"requestId", requestIDFromContext(r.Context()),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", status,
+		"clientIp", s.clientIP(r),
+	)
+}
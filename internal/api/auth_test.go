@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuthentication(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   AuthConfig
+		header []string
+		want   int
+	}{
+		{"no admin token configured", AuthConfig{}, []string{"X-Admin-Token", "admin-token"}, http.StatusNotFound},
+		{"no admin token configured with API keys", AuthConfig{APIKeys: "reader:reader-key"},
+			[]string{"X-API-Key", "reader-key"}, http.StatusNotFound},
+		{"no credentials", AuthConfig{AdminToken: "admin-token"}, nil, http.StatusUnauthorized},
+		{"wrong token", AuthConfig{AdminToken: "admin-token"}, []string{"X-Admin-Token", "guess"}, http.StatusUnauthorized},
+		{"correct token", AuthConfig{AdminToken: "admin-token"}, []string{"X-Admin-Token", "admin-token"}, http.StatusOK},
+		{"API key on an admin route", AuthConfig{AdminToken: "admin-token", APIKeys: "reader:reader-key"},
+			[]string{"X-API-Key", "reader-key"}, http.StatusForbidden},
+		{"admin token passed as an API key", AuthConfig{AdminToken: "admin-token", APIKeys: "reader:reader-key"},
+			[]string{"X-API-Key", "admin-token"}, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t, WithAuth(test.auth))
+			request := httptest.NewRequest(http.MethodGet, "/admin/usage", nil)
+			// Admin routes only answer loopback callers by default
+			request.RemoteAddr = "127.0.0.1:50000"
+			for i := 0; i+1 < len(test.header); i += 2 {
+				request.Header.Set(test.header[i], test.header[i+1])
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, request)
+			if recorder.Code != test.want {
+				t.Errorf("GET /admin/usage = %d, want %d: %s", recorder.Code, test.want, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestAdminActionsAreAudited(t *testing.T) {
+	s := newTestServer(t, WithAuth(AuthConfig{AdminToken: "admin-token", APIKeys: "reader:reader-key"}))
+	var audit bytes.Buffer
+	s.auditLogger = slog.New(slog.NewJSONHandler(&audit, nil))
+
+	tests := []struct {
+		header  string
+		value   string
+		audited bool
+	}{
+		{"X-Admin-Token", "admin-token", true},
+		{"X-Admin-Token", "guess", false},
+		{"X-API-Key", "reader-key", false},
+	}
+	for _, test := range tests {
+		audit.Reset()
+		request := httptest.NewRequest(http.MethodGet, "/admin/usage", nil)
+		request.RemoteAddr = "127.0.0.1:50000"
+		request.Header.Set(test.header, test.value)
+		request.Header.Set("X-Request-ID", "audit-request")
+		s.ServeHTTP(httptest.NewRecorder(), request)
+
+		if !test.audited {
+			if audit.Len() != 0 {
+				t.Errorf("%s %q: rejected call was audited as %s", test.header, test.value, audit.String())
+			}
+			continue
+		}
+		type auditRecord struct {
+			Msg       string `json:"msg"`
+			Identity  string `json:"identity"`
+			RequestID string `json:"requestId"`
+			Method    string `json:"method"`
+			Path      string `json:"path"`
+			Status    int    `json:"status"`
+		}
+		var record auditRecord
+		if err := json.Unmarshal(audit.Bytes(), &record); err != nil {
+			t.Fatalf("audit record %q: %v", audit.String(), err)
+		}
+		want := auditRecord{"audit", "admin", "audit-request", http.MethodGet, "/admin/usage", http.StatusOK}
+		if record != want {
+			t.Errorf("audit record %+v, want %+v", record, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"receipt-processor/internal/store"
+)
+
+// Largest request body kept for replay when a submission asks for it with X-Store-Body
+const maxStoredBodyBytes = 64 << 10
+
+func hashIdempotencyKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Function to capture the request body when the client asks for it to be stored, leaving r.Body readable for
+// the decoder. Bodies over the limit are still processed but not kept, and the response says so
+func (s *server) captureRawBody(w http.ResponseWriter, r *http.Request) *store.Body {
+	if r.Header.Get("X-Store-Body") != "true" || !s.retainReceipts {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxStoredBodyBytes+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil || len(data) > maxStoredBodyBytes {
+		w.Header().Set("X-Body-Stored", "false")
+		return nil
+	}
+	body := &store.Body{ContentType: r.Header.Get("Content-Type"), Data: data}
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		body.KeyHash = hashIdempotencyKey(key)
+	}
+	return body
+}
+
+// Handler to send back the raw body a receipt was submitted with, to the admin or whoever holds its Idempotency-Key
+func (s *server) receiptBodyHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if s.rejectStoreLight(w, r) {
+		return
+	}
+	shard := s.store.Shard(id)
+	shard.RLock()
+	_, exists := shard.Lookup(id)
+	body, stored := shard.Bodies[id]
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	key := r.Header.Get("Idempotency-Key")
+	keyMatches := key != "" && body.KeyHash != "" &&
+		subtle.ConstantTimeCompare([]byte(hashIdempotencyKey(key)), []byte(body.KeyHash)) == 1
+	if !s.isAdminRequest(r) && !keyMatches {
+		s.writeError(w, r, errorWithStatus(http.StatusForbidden, "Reading a stored body requires the admin token or the submission's Idempotency-Key."))
+		return
+	}
+	if !stored {
+		s.writeError(w, r, errorWithStatus(http.StatusNotFound, "No body stored for that receipt."))
+		return
+	}
+	if body.ContentType != "" {
+		w.Header().Set("Content-Type", body.ContentType)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Data)
+}
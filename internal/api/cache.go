@@ -0,0 +1,81 @@
+package api
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Default number of serialized points responses kept for hot receipt lookups
+const defaultPointsCacheSize = 1024
+
+// Bounded LRU of encoded points responses by receipt ID, with its own lock so hits never touch the store.
+// Entries are written under the receipt's shard read lock and invalidated under its write lock, so a cached body is never stale
+type responseCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+	// Hits and misses, counted by result
+	lookups *prometheus.CounterVec
+}
+
+type cachedResponse struct {
+	id   string
+	body []byte
+}
+
+func newResponseCache(capacity int, lookups *prometheus.CounterVec) *responseCache {
+	return &responseCache{capacity: capacity, order: list.New(), entries: make(map[string]*list.Element), lookups: lookups}
+}
+
+// Function to get the cached body for an ID, counting the hit or miss
+func (c *responseCache) get(id string) ([]byte, bool) {
+	if c.capacity <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[id]
+	if !ok {
+		c.lookups.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	c.lookups.WithLabelValues("hit").Inc()
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedResponse).body, true
+}
+
+// Function to cache a body, evicting the least recently used entry when full; callers hold the receipt's shard read lock
+func (c *responseCache) put(id string, body []byte) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[id]; ok {
+		element.Value.(*cachedResponse).body = body
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[id] = c.order.PushFront(&cachedResponse{id: id, body: body})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).id)
+	}
+}
+
+// Function to drop a receipt's cached body; callers hold the receipt's shard write lock while changing its points
+func (c *responseCache) invalidate(id string) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[id]; ok {
+		c.order.Remove(element)
+		delete(c.entries, id)
+	}
+}
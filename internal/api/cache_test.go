@@ -0,0 +1,113 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"receipt-processor/internal/model"
+)
+
+// Function to build a cache whose lookups are counted on a throwaway counter
+func newTestCache(capacity int) *responseCache {
+	return newResponseCache(capacity, prometheus.NewCounterVec(prometheus.CounterOpts{Name: "lookups"}, []string{"result"}))
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTestCache(2)
+	cache.put("a", []byte("1"))
+	cache.put("b", []byte("2"))
+	cache.get("a")
+	cache.put("c", []byte("3"))
+	if _, ok := cache.get("b"); ok {
+		t.Error("b is still cached, want it evicted as the least recently used")
+	}
+	for _, id := range []string{"a", "c"} {
+		if _, ok := cache.get(id); !ok {
+			t.Errorf("%s was evicted, want it kept", id)
+		}
+	}
+	cache.invalidate("a")
+	if _, ok := cache.get("a"); ok {
+		t.Error("a is still cached after invalidation")
+	}
+}
+
+func TestCachedPointsChangeAfterAWrite(t *testing.T) {
+	s := newTestServer(t)
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	id := processReceipt(t, server, cornerMarketReceipt)
+	hits := s.metrics.pointsCacheLookups.WithLabelValues("hit")
+
+	if got := receiptPoints(t, server, id); got != 109 {
+		t.Fatalf("points = %d, want 109", got)
+	}
+	if got := receiptPoints(t, server, id); got != 109 || metricValue(t, hits) != 1 {
+		t.Fatalf("points = %d after %v cache hits, want 109 served from the cache", got, metricValue(t, hits))
+	}
+
+	redeemPoints(t, server, id, 9)
+	if got := receiptPoints(t, server, id); got != 100 {
+		t.Errorf("points after redeeming 9 = %d, want 100 rather than the cached 109", got)
+	}
+	if response, body := send(t, server, http.MethodPost, "/receipts/"+id+"/items", `{"item":{"shortDescription":"Gum","price":"1.00"},"total":"10.00"}`); response.StatusCode != http.StatusCreated {
+		t.Fatalf("adding an item = %d %s", response.StatusCode, body)
+	}
+	if got := receiptPoints(t, server, id); got == 100 {
+		t.Error("points after rescoring are still the cached 100")
+	}
+	if got := metricValue(t, hits); got != 1 {
+		t.Errorf("cache hits = %v, want only the one before the writes", got)
+	}
+}
+
+func BenchmarkResponseCache(b *testing.B) {
+	body := []byte(`{"points":28}` + "\n")
+	b.Run("hit", func(b *testing.B) {
+		cache := newTestCache(defaultPointsCacheSize)
+		cache.put("id", body)
+		for b.Loop() {
+			cache.get("id")
+		}
+	})
+	b.Run("put with eviction", func(b *testing.B) {
+		cache := newTestCache(defaultPointsCacheSize)
+		ids := make([]string, 2*defaultPointsCacheSize)
+		for i := range ids {
+			ids[i] = fmt.Sprint(i)
+		}
+		i := 0
+		for b.Loop() {
+			cache.put(ids[i%len(ids)], body)
+			i++
+		}
+	})
+}
+
+// What GET /receipts/{id}/points costs with and without the cache in front of the store
+func BenchmarkGetPoints(b *testing.B) {
+	for _, size := range []int{0, defaultPointsCacheSize} {
+		b.Run(fmt.Sprintf("cache size %d", size), func(b *testing.B) {
+			config := defaultConfig()
+			config.PointsCacheSize = size
+			s := newTestServer(b, WithConfig(config))
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(targetReceipt)))
+			var processed model.ResponseID
+			decodeBody(b, recorder.Body.String(), &processed)
+			path := "/receipts/" + processed.ID + "/points"
+			for b.Loop() {
+				recorder := httptest.NewRecorder()
+				s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+				if recorder.Code != http.StatusOK {
+					b.Fatalf("GET %s = %d", path, recorder.Code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type chaosState struct {
+	FailureRate float64
+	LatencyRate float64
+	Latency     time.Duration
+}
+
+// Body of POST /admin/chaos; latencyRate defaults to every request when only latencyMs is given
+type ChaosRequest struct {
+	Enabled     bool     `json:"enabled"`
+	FailureRate float64  `json:"failureRate"`
+	LatencyRate *float64 `json:"latencyRate"`
+	LatencyMS   int      `json:"latencyMs"`
+}
+
+type ChaosStatus struct {
+	Enabled     bool    `json:"enabled"`
+	FailureRate float64 `json:"failureRate"`
+	LatencyRate float64 `json:"latencyRate"`
+	LatencyMS   int     `json:"latencyMs"`
+}
+
+// Middleware to delay or fail a random share of requests while chaos mode is on; admin routes and health
+// probes are spared so the experiment can be stopped and the instance is not restarted under it
+func (s *server) chaosMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := s.chaos.Load()
+		if state == nil || healthPaths[r.URL.Path] || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if state.Latency > 0 && rand.Float64() < state.LatencyRate {
+			timer := time.NewTimer(state.Latency)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+		if rand.Float64() < state.FailureRate {
+			s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "Injected failure (chaos mode)."))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handler to show or change chaos mode
+func (s *server) chaosHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var request ChaosRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The chaos request is invalid."))
+			return
+		}
+		latencyRate := 1.0
+		if request.LatencyRate != nil {
+			latencyRate = *request.LatencyRate
+		}
+		if request.FailureRate < 0 || request.FailureRate > 1 || latencyRate < 0 || latencyRate > 1 || request.LatencyMS < 0 {
+			s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "Rates must be between 0 and 1 and latencyMs must not be negative."))
+			return
+		}
+		if request.Enabled {
+			s.chaos.Store(&chaosState{
+				FailureRate: request.FailureRate,
+				LatencyRate: latencyRate,
+				Latency:     time.Duration(request.LatencyMS) * time.Millisecond,
+			})
+		} else {
+			s.chaos.Store(nil)
+		}
+	default:
+		s.writeError(w, r, errorWithStatus(http.StatusMethodNotAllowed, "Method not allowed."))
+		return
+	}
+
+	status := ChaosStatus{}
+	if state := s.chaos.Load(); state != nil {
+		status = ChaosStatus{
+			Enabled:     true,
+			FailureRate: state.FailureRate,
+			LatencyRate: state.LatencyRate,
+			LatencyMS:   int(state.Latency / time.Millisecond),
+		}
+	}
+	s.writeJSON(w, r, http.StatusOK, status)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"receipt-processor/internal/points"
+)
+
+// Chart size: the default, and the range accepted from ?width= and ?height=
+const (
+	defaultChartWidth  = 640
+	defaultChartHeight = 320
+	minChartSize       = 100
+	maxChartSize       = 4000
+)
+
+type svgDocument struct {
+	XMLName xml.Name  `xml:"svg"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Width   int       `xml:"width,attr"`
+	Height  int       `xml:"height,attr"`
+	ViewBox string    `xml:"viewBox,attr"`
+	Title   string    `xml:"title"`
+	Rects   []svgRect `xml:"rect"`
+	Texts   []svgText `xml:"text"`
+}
+
+type svgRect struct {
+	X      float64 `xml:"x,attr"`
+	Y      float64 `xml:"y,attr"`
+	Width  float64 `xml:"width,attr"`
+	Height float64 `xml:"height,attr"`
+	Fill   string  `xml:"fill,attr"`
+}
+
+type svgText struct {
+	X        float64 `xml:"x,attr"`
+	Y        float64 `xml:"y,attr"`
+	FontSize float64 `xml:"font-size,attr"`
+	Anchor   string  `xml:"text-anchor,attr,omitempty"`
+	Value    string  `xml:",chardata"`
+}
+
+// Function to read a chart dimension from the query, falling back to the default when it is absent
+func chartDimension(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < minChartSize || n > maxChartSize {
+		return 0, fmt.Errorf("%s must be a whole number from %d to %d", name, minChartSize, maxChartSize)
+	}
+	return n, nil
+}
+
+// Function to round a coordinate to two places, which is finer than any screen and keeps the markup short
+func svgUnits(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// Function to draw one horizontal bar per scoring rule, labelled with the rule name and its points
+func renderBreakdownChart(breakdown map[string]int, total, width, height int) svgDocument {
+	chart := svgDocument{
+		Xmlns:   "http://www.w3.org/2000/svg",
+		Width:   width,
+		Height:  height,
+		ViewBox: fmt.Sprintf("0 0 %d %d", width, height),
+		Title:   fmt.Sprintf("Points by rule: %d in total", total),
+	}
+	largest := 1
+	for _, points := range breakdown {
+		largest = max(largest, points)
+	}
+
+	const margin = 8.0
+	labelWidth := float64(width) * 0.4
+	// Room on the right for the value printed after the longest bar
+	barSpace := float64(width) - labelWidth - 3*margin - 40
+	row := (float64(height) - 2*margin) / float64(len(points.Rules))
+	fontSize := min(row*0.5, 14)
+	for i, rule := range points.Rules {
+		points := breakdown[rule.Name]
+		y := margin + float64(i)*row
+		barWidth := barSpace * float64(points) / float64(largest)
+		baseline := svgUnits(y + row*0.5 + fontSize/3)
+		chart.Rects = append(chart.Rects, svgRect{X: svgUnits(labelWidth + margin), Y: svgUnits(y + row*0.15),
+			Width: svgUnits(barWidth), Height: svgUnits(row * 0.7), Fill: "#4c78a8"})
+		chart.Texts = append(chart.Texts,
+			svgText{X: svgUnits(labelWidth), Y: baseline, FontSize: svgUnits(fontSize), Anchor: "end", Value: rule.Name},
+			svgText{X: svgUnits(labelWidth + 2*margin + barWidth), Y: baseline, FontSize: svgUnits(fontSize), Value: strconv.Itoa(points)},
+		)
+	}
+	return chart
+}
+
+// Handler to chart how much each scoring rule contributes to a receipt's points, as SVG
+func (s *server) breakdownChartHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if s.rejectStoreLight(w, r) {
+		return
+	}
+	width, err := chartDimension(r, "width", defaultChartWidth)
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, err.Error()+"."))
+		return
+	}
+	height, err := chartDimension(r, "height", defaultChartHeight)
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, err.Error()+"."))
+		return
+	}
+
+	shard := s.store.Shard(id)
+	shard.RLock()
+	receipt, exists := shard.Lookup(id)
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	config := s.calculator.Active()
+	chart := renderBreakdownChart(points.Breakdown(receipt, config), points.Calculate(receipt, config), width, height)
+	body, err := xml.Marshal(chart)
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The chart could not be drawn."))
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// Function to parse address ranges; bare addresses are treated as single-host ranges
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or CIDR %q", entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func (s *server) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to tell whether the request came straight from a trusted proxy
+func (s *server) fromTrustedProxy(r *http.Request) bool {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	return s.isTrustedPeer(peer)
+}
+
+// Function to tell whether a peer address belongs to a trusted proxy; unix sockets and unparsable peers do not
+func (s *server) isTrustedPeer(peer string) bool {
+	addr, err := netip.ParseAddr(peer)
+	return err == nil && s.isTrustedProxy(addr)
+}
+
+// Function to parse a forwarded address, which may carry a port, brackets or quotes
+func parseForwardedAddr(value string) (netip.Addr, bool) {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// Function to collect the hop addresses from X-Forwarded-For, or from Forwarded when XFF is absent, falling back to
+// the single address in X-Real-IP for proxies that only set that
+func forwardedChain(r *http.Request) []string {
+	var chain []string
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, value := range values {
+			chain = append(chain, strings.Split(value, ",")...)
+		}
+		return chain
+	}
+	for _, value := range r.Header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(key, "for") {
+					chain = append(chain, v)
+				}
+			}
+		}
+	}
+	if len(chain) == 0 {
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			chain = append(chain, realIP)
+		}
+	}
+	return chain
+}
+
+// Function to work out the client address: the rightmost hop not operated by a trusted proxy
+func (s *server) resolveClientIP(r *http.Request) string {
+	// Unix domain socket peers have no address at all
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		return "unix"
+	}
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !s.isTrustedProxy(addr) {
+		return peer
+	}
+
+	client := addr
+	chain := forwardedChain(r)
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop, ok := parseForwardedAddr(chain[i])
+		if !ok {
+			break
+		}
+		client = hop
+		if !s.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// Middleware to resolve the client address once and share it with everything downstream
+func (s *server) clientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, s.resolveClientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Function to determine the IP address of the client that sent the request
+func (s *server) clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return s.resolveClientIP(r)
+}
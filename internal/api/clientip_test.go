@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	config := defaultConfig()
+	config.TrustedProxies = []string{"10.0.0.0/8", "fd00::/8"}
+	s := newTestServer(t, WithConfig(config))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     []string
+		want       string
+	}{
+		{"direct client", "203.0.113.5:41000", nil, "203.0.113.5"},
+		{"untrusted peer spoofing X-Forwarded-For", "203.0.113.5:41000",
+			[]string{"X-Forwarded-For", "198.51.100.9"}, "203.0.113.5"},
+		{"untrusted peer spoofing Forwarded", "203.0.113.5:41000",
+			[]string{"Forwarded", "for=198.51.100.9"}, "203.0.113.5"},
+		{"trusted proxy without headers", "10.0.0.1:41000", nil, "10.0.0.1"},
+		{"one trusted hop", "10.0.0.1:41000", []string{"X-Forwarded-For", "198.51.100.9"}, "198.51.100.9"},
+		{"multi-hop chain through trusted proxies", "10.0.0.1:41000",
+			[]string{"X-Forwarded-For", "198.51.100.9, 10.0.0.3, 10.0.0.2"}, "198.51.100.9"},
+		{"client prepending a spoofed hop", "10.0.0.1:41000",
+			[]string{"X-Forwarded-For", "192.0.2.66, 198.51.100.9, 10.0.0.2"}, "198.51.100.9"},
+		{"chain split across headers", "10.0.0.1:41000",
+			[]string{"X-Forwarded-For", "198.51.100.9", "X-Forwarded-For", "10.0.0.2"}, "198.51.100.9"},
+		{"every hop trusted", "10.0.0.1:41000", []string{"X-Forwarded-For", "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"unparsable hop stops the walk", "10.0.0.1:41000",
+			[]string{"X-Forwarded-For", "198.51.100.9, unknown, 10.0.0.2"}, "10.0.0.2"},
+		{"Forwarded header", "10.0.0.1:41000",
+			[]string{"Forwarded", `for=198.51.100.9;proto=https, for="[fd00::2]:8080"`}, "198.51.100.9"},
+		{"X-Forwarded-For wins over Forwarded", "10.0.0.1:41000",
+			[]string{"Forwarded", "for=192.0.2.66", "X-Forwarded-For", "198.51.100.9"}, "198.51.100.9"},
+		{"IPv6 trusted proxy", "[fd00::1]:41000", []string{"X-Forwarded-For", "2001:db8::9"}, "2001:db8::9"},
+		{"IPv4-mapped trusted proxy", "[::ffff:10.0.0.1]:41000",
+			[]string{"X-Forwarded-For", "198.51.100.9"}, "198.51.100.9"},
+		{"Unix socket peer", "@", []string{"X-Forwarded-For", "198.51.100.9"}, "unix"},
+		{"X-Real-IP alone", "10.0.0.1:41000", []string{"X-Real-IP", "198.51.100.9"}, "198.51.100.9"},
+		{"X-Real-IP with a port", "10.0.0.1:41000", []string{"X-Real-IP", "[2001:db8::9]:443"}, "2001:db8::9"},
+		{"untrusted peer spoofing X-Real-IP", "203.0.113.5:41000", []string{"X-Real-IP", "198.51.100.9"}, "203.0.113.5"},
+		{"X-Forwarded-For wins over X-Real-IP", "10.0.0.1:41000",
+			[]string{"X-Real-IP", "192.0.2.66", "X-Forwarded-For", "198.51.100.9"}, "198.51.100.9"},
+		{"Forwarded wins over X-Real-IP", "10.0.0.1:41000",
+			[]string{"X-Real-IP", "192.0.2.66", "Forwarded", "for=198.51.100.9"}, "198.51.100.9"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/receipts/count", nil)
+			request.RemoteAddr = test.remoteAddr
+			for i := 0; i+1 < len(test.header); i += 2 {
+				request.Header.Add(test.header[i], test.header[i+1])
+			}
+			if got := s.resolveClientIP(request); got != test.want {
+				t.Errorf("client IP %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestClientIPMiddlewareSharesTheResolvedAddress(t *testing.T) {
+	config := defaultConfig()
+	config.TrustedProxies = []string{"10.0.0.0/8"}
+	s := newTestServer(t, WithConfig(config))
+
+	var seen string
+	handler := s.clientIPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A later change to the headers must not move the client the limiter, logs and audit records see
+		r.Header.Set("X-Forwarded-For", "192.0.2.66")
+		seen = s.clientIP(r)
+	}))
+	request := httptest.NewRequest(http.MethodGet, "/receipts/count", nil)
+	request.RemoteAddr = "10.0.0.1:41000"
+	request.Header.Set("X-Forwarded-For", "198.51.100.9")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if seen != "198.51.100.9" {
+		t.Errorf("downstream client IP %q, want the one resolved on entry, 198.51.100.9", seen)
+	}
+}
@@ -0,0 +1,576 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+
+	"gopkg.in/yaml.v3"
+)
+
+// All server settings; loaded from defaults, then the config file, then the environment, then flags
+type Config struct {
+	Addr                     string                `yaml:"addr"`
+	SocketMode               string                `yaml:"socketMode"`
+	StrictMode               bool                  `yaml:"strictMode"`
+	AdminToken               string                `yaml:"adminToken"`
+	APIKeys                  string                `yaml:"apiKeys"`
+	APIKeyQuotas             map[string]KeyQuota   `yaml:"apiKeyQuotas"`
+	TrustedProxies           []string              `yaml:"trustedProxies"`
+	AdminAllow               []string              `yaml:"adminAllow"`
+	WriteAllow               []string              `yaml:"writeAllow"`
+	ScoringConfigFile        string                `yaml:"scoringConfigFile"`
+	GzipMinSize              int                   `yaml:"gzipMinSize"`
+	DailyReceiptQuota        int                   `yaml:"dailyReceiptQuota"`
+	MetricsAddr              string                `yaml:"metricsAddr"`
+	GRPCAddr                 string                `yaml:"grpcAddr"`
+	ResponseEnvelope         bool                  `yaml:"responseEnvelope"`
+	Pprof                    PprofConfig           `yaml:"pprof"`
+	CORS                     CORSConfig            `yaml:"cors"`
+	Limits                   LimitsConfig          `yaml:"limits"`
+	Maintenance              bool                  `yaml:"maintenance"`
+	AccessLog                AccessLogConfig       `yaml:"accessLog"`
+	LogLevel                 string                `yaml:"logLevel"`
+	HealthLatencyThresholdMS int                   `yaml:"healthLatencyThresholdMs"`
+	TLS                      TLSConfig             `yaml:"tls"`
+	ReservationTTLSeconds    int                   `yaml:"reservationTtlSeconds"`
+	SecurityHeaders          SecurityHeadersConfig `yaml:"securityHeaders"`
+	LockShards               int                   `yaml:"lockShards"`
+	ImportWorkers            int                   `yaml:"importWorkers"`
+	ArchiveAfterDays         int                   `yaml:"archiveAfterDays"`
+	Normalizers              []string              `yaml:"normalizers"`
+	PointsCacheSize          int                   `yaml:"pointsCacheSize"`
+	RetainReceipts           bool                  `yaml:"retainReceipts"`
+	StreamMaxErrors          int                   `yaml:"streamMaxErrors"`
+	CSVUploadMaxBytes        int                   `yaml:"csvUploadMaxBytes"`
+	XMLAPI                   bool                  `yaml:"xmlApi"`
+	SchemaValidation         bool                  `yaml:"schemaValidation"`
+	ImageDir                 string                `yaml:"imageDir"`
+	ImageMaxBytes            int                   `yaml:"imageMaxBytes"`
+	WAL                      store.LogConfig       `yaml:"wal"`
+	Loyalty                  LoyaltyConfig         `yaml:"loyalty"`
+	MaxItemsPerReceipt       int                   `yaml:"maxItemsPerReceipt"`
+	MinItemsPerReceipt       int                   `yaml:"minItemsPerReceipt"`
+
+	// Set by -print-config, -dry-run and -check; the caller acts on the first two, Run on the last
+	PrintConfig bool `yaml:"-"`
+	DryRun      bool `yaml:"-"`
+	Check       bool `yaml:"-"`
+}
+
+type PprofConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Addr    string `yaml:"addr"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Addr:        ":8080",
+		SocketMode:  "0660",
+		GzipMinSize: defaultGzipMinSize,
+		CORS: CORSConfig{
+			AllowedMethods: []string{"GET", "POST"},
+			AllowedHeaders: []string{"Content-Type"},
+			MaxAge:         600,
+		},
+		Limits:                   LimitsConfig{MaxWait: 100 * time.Millisecond},
+		AccessLog:                AccessLogConfig{Format: "combined", MaxSizeMB: 100, MaxFiles: 5},
+		LogLevel:                 "info",
+		HealthLatencyThresholdMS: 100,
+		TLS:                      TLSConfig{ClientAuth: "either", HTTPMode: "serve"},
+		ReservationTTLSeconds:    300,
+		LockShards:               defaultLockShards,
+		AdminAllow:               []string{"127.0.0.1/32", "::1/128"},
+		ImportWorkers:            runtime.GOMAXPROCS(0),
+		PointsCacheSize:          defaultPointsCacheSize,
+		RetainReceipts:           true,
+		StreamMaxErrors:          100,
+		CSVUploadMaxBytes:        10 << 20,
+		ImageMaxBytes:            5 << 20,
+		MaxItemsPerReceipt:       defaultMaxItemsPerReceipt,
+		MinItemsPerReceipt:       1,
+		WAL:                      store.LogConfig{Path: "receipts.wal", MaxSizeMB: 64, Durability: "strict"},
+		SecurityHeaders: SecurityHeadersConfig{
+			ContentSecurityPolicy: "default-src 'none'; style-src 'self'; img-src 'self'; frame-ancestors 'none'",
+			FrameOptions:          "DENY",
+			ReferrerPolicy:        "no-referrer",
+		},
+	}
+}
+
+// Environment variables and the config field each one overrides
+var configEnv = []struct {
+	name  string
+	apply func(c *Config, value string) error
+}{
+	{"ADDR", func(c *Config, v string) error { c.Addr = v; return nil }},
+	{"SOCKET_MODE", func(c *Config, v string) error { c.SocketMode = v; return nil }},
+	{"STRICT_MODE", func(c *Config, v string) error { return parseBool(v, &c.StrictMode) }},
+	{"ADMIN_TOKEN", func(c *Config, v string) error { c.AdminToken = v; return nil }},
+	{"API_KEYS", func(c *Config, v string) error { c.APIKeys = v; return nil }},
+	{"API_KEY_QUOTAS", func(c *Config, v string) error {
+		quotas, err := parseKeyQuotas(v)
+		c.APIKeyQuotas = quotas
+		return err
+	}},
+	{"ADMIN_ALLOW", func(c *Config, v string) error { c.AdminAllow = splitList(v); return nil }},
+	{"ADMIN_ALLOWED_IPS", func(c *Config, v string) error { c.AdminAllow = splitList(v); return nil }},
+	{"WRITE_ALLOW", func(c *Config, v string) error { c.WriteAllow = splitList(v); return nil }},
+	{"TRUSTED_PROXIES", func(c *Config, v string) error { c.TrustedProxies = splitList(v); return nil }},
+	{"TRUST_PROXY", func(c *Config, v string) error {
+		// Kept for existing deployments: trusting the proxy means trusting every peer
+		var trust bool
+		if err := parseBool(v, &trust); err != nil || !trust {
+			return err
+		}
+		c.TrustedProxies = []string{"0.0.0.0/0", "::/0"}
+		return nil
+	}},
+	{"SCORING_CONFIG_FILE", func(c *Config, v string) error { c.ScoringConfigFile = v; return nil }},
+	{"GZIP_MIN_SIZE", func(c *Config, v string) error { return parseInt(v, &c.GzipMinSize) }},
+	{"DAILY_RECEIPT_QUOTA", func(c *Config, v string) error { return parseInt(v, &c.DailyReceiptQuota) }},
+	{"METRICS_ADDR", func(c *Config, v string) error { c.MetricsAddr = v; return nil }},
+	{"GRPC_ADDR", func(c *Config, v string) error { c.GRPCAddr = v; return nil }},
+	{"LOYALTY_PLATFORM_URL", func(c *Config, v string) error { c.Loyalty.URL = v; return nil }},
+	{"LOYALTY_PLATFORM_TOKEN_URL", func(c *Config, v string) error { c.Loyalty.TokenURL = v; return nil }},
+	{"LOYALTY_PLATFORM_CLIENT_ID", func(c *Config, v string) error { c.Loyalty.ClientID = v; return nil }},
+	{"LOYALTY_PLATFORM_CLIENT_SECRET", func(c *Config, v string) error { c.Loyalty.ClientSecret = v; return nil }},
+	{"LOYALTY_PLATFORM_SCOPES", func(c *Config, v string) error { c.Loyalty.Scopes = splitList(v); return nil }},
+	{"ACCESS_LOG_PATH", func(c *Config, v string) error { c.AccessLog.Path = v; return nil }},
+	{"ACCESS_LOG_FORMAT", func(c *Config, v string) error { c.AccessLog.Format = v; return nil }},
+	{"ACCESS_LOG_MAX_SIZE_MB", func(c *Config, v string) error { return parseInt(v, &c.AccessLog.MaxSizeMB) }},
+	{"ACCESS_LOG_MAX_FILES", func(c *Config, v string) error { return parseInt(v, &c.AccessLog.MaxFiles) }},
+	{"LOG_LEVEL", func(c *Config, v string) error { c.LogLevel = v; return nil }},
+	{"TLS_CERT_FILE", func(c *Config, v string) error { c.TLS.CertFile = v; return nil }},
+	{"TLS_KEY_FILE", func(c *Config, v string) error { c.TLS.KeyFile = v; return nil }},
+	{"TLS_CLIENT_CA", func(c *Config, v string) error { c.TLS.ClientCAFile = v; return nil }},
+	{"TLS_ALLOWED_SUBJECTS", func(c *Config, v string) error { c.TLS.AllowedSubjects = splitList(v); return nil }},
+	{"TLS_CLIENT_AUTH", func(c *Config, v string) error { c.TLS.ClientAuth = v; return nil }},
+	{"TLS_ADDR", func(c *Config, v string) error { c.TLS.Addr = v; return nil }},
+	{"TLS_HTTP_MODE", func(c *Config, v string) error { c.TLS.HTTPMode = v; return nil }},
+	{"CONTENT_SECURITY_POLICY", func(c *Config, v string) error { c.SecurityHeaders.ContentSecurityPolicy = v; return nil }},
+	{"HSTS_MAX_AGE", func(c *Config, v string) error { return parseInt(v, &c.SecurityHeaders.HSTSMaxAge) }},
+	{"WAL_ENABLED", func(c *Config, v string) error { return parseBool(v, &c.WAL.Enabled) }},
+	{"WAL_PATH", func(c *Config, v string) error { c.WAL.Path = v; return nil }},
+	{"WAL_MAX_SIZE_MB", func(c *Config, v string) error { return parseInt(v, &c.WAL.MaxSizeMB) }},
+	{"WAL_GROUP_COMMIT_MS", func(c *Config, v string) error { return parseInt(v, &c.WAL.GroupCommitMS) }},
+	{"WAL_GROUP_COMMIT_RECORDS", func(c *Config, v string) error { return parseInt(v, &c.WAL.GroupCommitRecords) }},
+	{"WAL_DURABILITY", func(c *Config, v string) error { c.WAL.Durability = v; return nil }},
+	{"LOCK_SHARDS", func(c *Config, v string) error { return parseInt(v, &c.LockShards) }},
+	{"IMPORT_WORKERS", func(c *Config, v string) error { return parseInt(v, &c.ImportWorkers) }},
+	{"ARCHIVE_AFTER_DAYS", func(c *Config, v string) error { return parseInt(v, &c.ArchiveAfterDays) }},
+	{"NORMALIZERS", func(c *Config, v string) error { c.Normalizers = splitList(v); return nil }},
+	{"POINTS_CACHE_SIZE", func(c *Config, v string) error { return parseInt(v, &c.PointsCacheSize) }},
+	{"RETAIN_RECEIPTS", func(c *Config, v string) error { return parseBool(v, &c.RetainReceipts) }},
+	{"STREAM_MAX_ERRORS", func(c *Config, v string) error { return parseInt(v, &c.StreamMaxErrors) }},
+	{"IMAGE_DIR", func(c *Config, v string) error { c.ImageDir = v; return nil }},
+	{"IMAGE_MAX_BYTES", func(c *Config, v string) error { return parseInt(v, &c.ImageMaxBytes) }},
+	{"MAX_ITEMS_PER_RECEIPT", func(c *Config, v string) error { return parseInt(v, &c.MaxItemsPerReceipt) }},
+	{"MIN_ITEMS_PER_RECEIPT", func(c *Config, v string) error { return parseInt(v, &c.MinItemsPerReceipt) }},
+	{"XML_API", func(c *Config, v string) error { return parseBool(v, &c.XMLAPI) }},
+	{"SCHEMA_VALIDATION", func(c *Config, v string) error { return parseBool(v, &c.SchemaValidation) }},
+	{"CSV_UPLOAD_MAX_BYTES", func(c *Config, v string) error { return parseInt(v, &c.CSVUploadMaxBytes) }},
+	{"RESERVATION_TTL_SECONDS", func(c *Config, v string) error { return parseInt(v, &c.ReservationTTLSeconds) }},
+	{"HEALTH_LATENCY_THRESHOLD_MS", func(c *Config, v string) error { return parseInt(v, &c.HealthLatencyThresholdMS) }},
+	{"MAINTENANCE", func(c *Config, v string) error { return parseBool(v, &c.Maintenance) }},
+	{"RESPONSE_ENVELOPE", func(c *Config, v string) error { return parseBool(v, &c.ResponseEnvelope) }},
+	{"CORS_ALLOWED_ORIGINS", func(c *Config, v string) error { c.CORS.AllowedOrigins = splitList(v); return nil }},
+	{"CORS_ALLOWED_METHODS", func(c *Config, v string) error { c.CORS.AllowedMethods = splitList(v); return nil }},
+	{"CORS_ALLOWED_HEADERS", func(c *Config, v string) error { c.CORS.AllowedHeaders = splitList(v); return nil }},
+	{"CORS_MAX_AGE", func(c *Config, v string) error { return parseInt(v, &c.CORS.MaxAge) }},
+	{"CORS_ALLOW_CREDENTIALS", func(c *Config, v string) error { return parseBool(v, &c.CORS.AllowCredentials) }},
+	{"MAX_CONCURRENT_READS", func(c *Config, v string) error { return parseInt(v, &c.Limits.MaxReads) }},
+	{"MAX_CONCURRENT_WRITES", func(c *Config, v string) error { return parseInt(v, &c.Limits.MaxWrites) }},
+	{"LIMITER_MAX_WAIT", func(c *Config, v string) error { return parseDuration(v, &c.Limits.MaxWait) }},
+}
+
+func parseBool(value string, target *bool) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("must be true or false")
+	}
+	*target = parsed
+	return nil
+}
+
+func parseInt(value string, target *int) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("must be an integer")
+	}
+	*target = parsed
+	return nil
+}
+
+func parseDuration(value string, target *time.Duration) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("must be a duration such as 250ms")
+	}
+	*target = parsed
+	return nil
+}
+
+// Function to build the effective configuration from the config file, environment and command line
+func LoadConfig(args []string) (Config, error) {
+	config := defaultConfig()
+
+	flags := flag.NewFlagSet("receipt-processor", flag.ContinueOnError)
+	configFile := flags.String("config", "", "load settings from this YAML file")
+	flags.BoolVar(&config.PrintConfig, "print-config", false, "print the effective configuration and exit")
+	flags.BoolVar(&config.DryRun, "dry-run", false, "validate the configuration and exit")
+	flags.BoolVar(&config.Check, "check", false, "run the startup self-checks and exit")
+	addr := flags.String("addr", "", "address to listen on, or unix:///path/to.sock for a Unix domain socket")
+	enablePprof := flags.Bool("enable-pprof", false, "serve runtime profiles under /debug/pprof/")
+	pprofAddr := flags.String("pprof-addr", "", "serve runtime profiles on this separate address instead of the main listener")
+	maintenanceMode := flags.Bool("maintenance", false, "start in maintenance mode")
+	metricsAddr := flags.String("metrics-addr", "", "serve metrics on this separate address")
+	grpcAddr := flags.String("grpc-addr", "", "serve the gRPC API on this address")
+	adminAllow := flags.String("admin-allow", "", "comma separated CIDRs allowed to reach admin, metrics and pprof endpoints, or * to allow every address (default 127.0.0.1/32,::1/128)")
+	trusted := flags.String("trusted-proxies", "", "comma separated CIDRs of proxies allowed to set forwarding headers")
+	scoringFile := flags.String("scoring-config", "", "load the scoring rules from this JSON file")
+	level := flags.String("log-level", "", "log level: debug, info, warn or error")
+	tlsCert := flags.String("tls-cert", "", "serve HTTPS with this certificate file")
+	tlsKey := flags.String("tls-key", "", "private key file for --tls-cert")
+	tlsAddr := flags.String("tls-addr", "", "serve HTTPS on this address and keep plain HTTP on --addr")
+	tlsClientCA := flags.String("tls-client-ca", "", "require client certificates signed by this CA file")
+	retain := flags.Bool("retain-receipts", true, "keep full receipts; false keeps only what points lookups need")
+	durability := flags.String("durability", "", "strict waits for the WAL sync before answering; relaxed answers at once under group commit")
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile, &config); err != nil {
+			return config, err
+		}
+	}
+
+	var errs []error
+	for _, env := range configEnv {
+		if value, ok := os.LookupEnv(env.name); ok {
+			if err := env.apply(&config, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s %v", env.name, err))
+			}
+		}
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			config.Addr = *addr
+		case "enable-pprof":
+			config.Pprof.Enabled = *enablePprof
+		case "pprof-addr":
+			config.Pprof.Addr = *pprofAddr
+		case "maintenance":
+			config.Maintenance = *maintenanceMode
+		case "metrics-addr":
+			config.MetricsAddr = *metricsAddr
+		case "grpc-addr":
+			config.GRPCAddr = *grpcAddr
+		case "admin-allow":
+			config.AdminAllow = splitList(*adminAllow)
+		case "trusted-proxies":
+			config.TrustedProxies = splitList(*trusted)
+		case "scoring-config":
+			config.ScoringConfigFile = *scoringFile
+		case "log-level":
+			config.LogLevel = *level
+		case "tls-cert":
+			config.TLS.CertFile = *tlsCert
+		case "tls-key":
+			config.TLS.KeyFile = *tlsKey
+		case "tls-addr":
+			config.TLS.Addr = *tlsAddr
+		case "tls-client-ca":
+			config.TLS.ClientCAFile = *tlsClientCA
+		case "retain-receipts":
+			config.RetainReceipts = *retain
+		case "durability":
+			config.WAL.Durability = *durability
+		}
+	})
+	errs = append(errs, config.Validate())
+	return config, errors.Join(errs...)
+}
+
+// Function to read a YAML config file, rejecting keys that do not map onto a Config field
+func loadConfigFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+	if errs := unknownConfigKeys(root.Content[0], reflect.TypeOf(*config), ""); len(errs) > 0 {
+		return fmt.Errorf("config file %s: %w", path, errors.Join(errs...))
+	}
+	if err := root.Content[0].Decode(config); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Function to report every mapping key in the YAML tree that has no matching struct field
+func unknownConfigKeys(node *yaml.Node, t reflect.Type, path string) []error {
+	if node.Kind != yaml.MappingNode || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		if tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); tag != "" {
+			fields[tag] = t.Field(i).Type
+		}
+	}
+
+	var errs []error
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		fieldType, ok := fields[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown key %q (line %d)", keyPath, node.Content[i].Line))
+			continue
+		}
+		errs = append(errs, unknownConfigKeys(node.Content[i+1], fieldType, keyPath)...)
+	}
+	return errs
+}
+
+// Function to check the whole configuration, reporting every problem at once
+func (c Config) Validate() error {
+	var errs []error
+	if err := validateListenAddr(c.Addr, true); err != nil {
+		errs = append(errs, fmt.Errorf("addr %w", err))
+	}
+	if err := validateListenAddr(c.MetricsAddr, false); err != nil {
+		errs = append(errs, fmt.Errorf("metricsAddr %w", err))
+	}
+	if err := validateListenAddr(c.GRPCAddr, false); err != nil {
+		errs = append(errs, fmt.Errorf("grpcAddr %w", err))
+	}
+	if err := validateListenAddr(c.Pprof.Addr, false); err != nil {
+		errs = append(errs, fmt.Errorf("pprof.addr %w", err))
+	}
+	if mode, err := strconv.ParseUint(c.SocketMode, 8, 32); err != nil || mode > 0o777 {
+		errs = append(errs, errors.New("socketMode must be an octal permission such as 0660"))
+	}
+	for name, key := range parseAPIKeys(c.APIKeys) {
+		if name == "" || key == "" {
+			errs = append(errs, errors.New("apiKeys entries must look like name:key"))
+			break
+		}
+	}
+	if _, err := parsePrefixes(c.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("trustedProxies: %w", err))
+	}
+	if len(c.AdminAllow) == 0 {
+		errs = append(errs, errors.New("adminAllow must list at least one range, or * to allow every address"))
+	} else if _, err := parseAdminAllow(c.AdminAllow); err != nil {
+		errs = append(errs, fmt.Errorf("adminAllow: %w", err))
+	}
+	if _, err := parsePrefixes(c.WriteAllow); err != nil {
+		errs = append(errs, fmt.Errorf("writeAllow: %w", err))
+	}
+	keys := parseAPIKeys(c.APIKeys)
+	for name, quota := range c.APIKeyQuotas {
+		if _, ok := keys[name]; !ok {
+			errs = append(errs, fmt.Errorf("apiKeyQuotas.%s does not name a configured API key", name))
+		}
+		if quota.Daily < 0 || quota.Monthly < 0 {
+			errs = append(errs, fmt.Errorf("apiKeyQuotas.%s must not be negative", name))
+		}
+	}
+	if c.DailyReceiptQuota < 0 {
+		errs = append(errs, errors.New("dailyReceiptQuota must not be negative"))
+	}
+	if c.GzipMinSize < 0 {
+		errs = append(errs, errors.New("gzipMinSize must not be negative"))
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("cors.maxAge must not be negative"))
+	}
+	if c.Limits.MaxReads < 0 || c.Limits.MaxWrites < 0 || c.Limits.MaxWait < 0 {
+		errs = append(errs, errors.New("limits must not be negative"))
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls.certFile and tls.keyFile must be set together"))
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLS.enabled() {
+		errs = append(errs, errors.New("tls.clientCAFile requires tls.certFile and tls.keyFile"))
+	}
+	if c.TLS.Addr != "" && !c.TLS.enabled() {
+		errs = append(errs, errors.New("tls.addr requires tls.certFile and tls.keyFile"))
+	}
+	if err := validateListenAddr(c.TLS.Addr, false); err != nil {
+		errs = append(errs, fmt.Errorf("tls.addr %w", err))
+	}
+	if c.TLS.HTTPMode != "serve" && c.TLS.HTTPMode != "redirect" {
+		errs = append(errs, errors.New("tls.httpMode must be serve or redirect"))
+	}
+	if c.TLS.ClientAuth != "either" && c.TLS.ClientAuth != "both" {
+		errs = append(errs, errors.New("tls.clientAuth must be either or both"))
+	}
+	if c.TLS.ClientAuth == "both" && c.APIKeys == "" {
+		errs = append(errs, errors.New("tls.clientAuth both requires apiKeys"))
+	}
+	for _, pattern := range c.TLS.AllowedSubjects {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("tls.allowedSubjects %q: %w", pattern, err))
+		}
+	}
+	if c.SecurityHeaders.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("securityHeaders.hstsMaxAge must not be negative"))
+	}
+	if c.WAL.Enabled && c.WAL.Path == "" {
+		errs = append(errs, errors.New("wal.path must be set when the WAL is enabled"))
+	}
+	if c.WAL.GroupCommitMS < 0 || c.WAL.GroupCommitRecords < 0 {
+		errs = append(errs, errors.New("wal.groupCommitMs and wal.groupCommitRecords must not be negative"))
+	}
+	if c.WAL.Durability != "strict" && c.WAL.Durability != "relaxed" {
+		errs = append(errs, errors.New("wal.durability must be strict or relaxed"))
+	}
+	if c.WAL.MaxSizeMB < 0 {
+		errs = append(errs, errors.New("wal.maxSizeMB must not be negative"))
+	}
+	if c.LockShards <= 0 {
+		errs = append(errs, errors.New("lockShards must be positive"))
+	}
+	if c.ImportWorkers <= 0 {
+		errs = append(errs, errors.New("importWorkers must be positive"))
+	}
+	if _, err := buildNormalizers(c.Normalizers); err != nil {
+		errs = append(errs, fmt.Errorf("normalizers: %w", err))
+	}
+	if c.ImageMaxBytes <= 0 {
+		errs = append(errs, errors.New("imageMaxBytes must be positive"))
+	}
+	if c.MinItemsPerReceipt < 1 {
+		errs = append(errs, errors.New("minItemsPerReceipt must be at least 1"))
+	}
+	if c.MaxItemsPerReceipt < c.MinItemsPerReceipt {
+		errs = append(errs, errors.New("maxItemsPerReceipt must not be less than minItemsPerReceipt"))
+	}
+	if c.CSVUploadMaxBytes <= 0 {
+		errs = append(errs, errors.New("csvUploadMaxBytes must be positive"))
+	}
+	if c.StreamMaxErrors < 0 {
+		errs = append(errs, errors.New("streamMaxErrors must not be negative"))
+	}
+	if c.PointsCacheSize < 0 {
+		errs = append(errs, errors.New("pointsCacheSize must not be negative"))
+	}
+	if c.ArchiveAfterDays < 0 {
+		errs = append(errs, errors.New("archiveAfterDays must not be negative"))
+	}
+	if c.ReservationTTLSeconds <= 0 {
+		errs = append(errs, errors.New("reservationTtlSeconds must be positive"))
+	}
+	if c.HealthLatencyThresholdMS <= 0 {
+		errs = append(errs, errors.New("healthLatencyThresholdMs must be positive"))
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("logLevel: %w", err))
+	}
+	if c.Loyalty.URL != "" {
+		for _, field := range [][2]string{{"loyalty.url", c.Loyalty.URL}, {"loyalty.tokenUrl", c.Loyalty.TokenURL}} {
+			if target, err := url.Parse(field[1]); err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+				errs = append(errs, fmt.Errorf("%s must be an http or https URL", field[0]))
+			}
+		}
+		if c.Loyalty.ClientID == "" {
+			errs = append(errs, errors.New("loyalty.clientId is required when loyalty.url is set"))
+		}
+	}
+	if c.AccessLog.Format != "combined" && c.AccessLog.Format != "json" {
+		errs = append(errs, errors.New("accessLog.format must be combined or json"))
+	}
+	if c.AccessLog.MaxSizeMB < 0 || c.AccessLog.MaxFiles < 0 {
+		errs = append(errs, errors.New("accessLog limits must not be negative"))
+	}
+	if c.CORS.AllowCredentials && c.CORS.allowsAnyOrigin() {
+		errs = append(errs, errors.New("cors.allowCredentials cannot be combined with a wildcard origin"))
+	}
+	if c.ScoringConfigFile != "" {
+		if _, err := loadScoringConfigFile(c.ScoringConfigFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Function to check that a listen address is host:port, or a unix:// path when allowed
+func validateListenAddr(addr string, allowUnix bool) error {
+	if addr == "" {
+		if allowUnix {
+			return errors.New("must not be empty")
+		}
+		return nil
+	}
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok && allowUnix {
+		if path == "" {
+			return errors.New("must name a socket path")
+		}
+		return nil
+	}
+	if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+		return fmt.Errorf("must look like host:port, got %q", addr)
+	}
+	return nil
+}
+
+// Function to render the configuration as YAML with secrets redacted
+func (c Config) Redacted() string {
+	if c.AdminToken != "" {
+		c.AdminToken = "REDACTED"
+	}
+	if c.APIKeys != "" {
+		c.APIKeys = "REDACTED"
+	}
+	if c.Loyalty.ClientSecret != "" {
+		c.Loyalty.ClientSecret = "REDACTED"
+	}
+	out, _ := yaml.Marshal(c)
+	return string(out)
+}
+
+// Function to read a scoring config JSON file; unset fields keep their default values
+func loadScoringConfigFile(path string) (points.Config, error) {
+	config := points.DefaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("reading scoring config: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("parsing scoring config %s: %w", path, err)
+	}
+	return config, nil
+}
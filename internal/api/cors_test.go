@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	exact := CORSConfig{
+		AllowedOrigins:   []string{"https://dashboard.example"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type"},
+		MaxAge:           600,
+		AllowCredentials: true,
+	}
+	wildcard := exact
+	wildcard.AllowedOrigins = []string{"*"}
+	wildcard.AllowCredentials = false
+
+	tests := []struct {
+		name        string
+		config      CORSConfig
+		method      string
+		origin      string
+		preflight   bool
+		want        int
+		allowOrigin string
+		reachedNext bool
+	}{
+		{"preflight", exact, http.MethodOptions, "https://dashboard.example", true, http.StatusNoContent, "https://dashboard.example", false},
+		{"preflight from any origin", wildcard, http.MethodOptions, "https://other.example", true, http.StatusNoContent, "*", false},
+		{"preflight from a disallowed origin", exact, http.MethodOptions, "https://evil.example", true, http.StatusForbidden, "", false},
+		{"request from an allowed origin", exact, http.MethodGet, "https://dashboard.example", false, http.StatusTeapot, "https://dashboard.example", true},
+		{"request from a disallowed origin", exact, http.MethodGet, "https://evil.example", false, http.StatusTeapot, "", true},
+		{"request without an origin", exact, http.MethodGet, "", false, http.StatusTeapot, "", true},
+		{"CORS disabled", CORSConfig{}, http.MethodOptions, "https://dashboard.example", true, http.StatusTeapot, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reachedNext := false
+			handler := corsMiddleware(test.config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reachedNext = true
+				w.WriteHeader(http.StatusTeapot)
+			}))
+			request := httptest.NewRequest(test.method, "/receipts/count", nil)
+			if test.origin != "" {
+				request.Header.Set("Origin", test.origin)
+			}
+			if test.preflight {
+				request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.want || reachedNext != test.reachedNext {
+				t.Errorf("status %d, reached the handler %v, want %d, %v", recorder.Code, reachedNext, test.want, test.reachedNext)
+			}
+			header := recorder.Header()
+			if got := header.Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, test.allowOrigin)
+			}
+			if test.origin == "" || !test.config.enabled() {
+				for name := range header {
+					if strings.HasPrefix(name, "Access-Control-") || name == "Vary" {
+						t.Errorf("a request CORS does not apply to got a %s header", name)
+					}
+				}
+			}
+			if test.want == http.StatusNoContent {
+				if header.Get("Access-Control-Allow-Methods") != "GET, POST" || header.Get("Access-Control-Max-Age") != "600" {
+					t.Errorf("preflight headers %v", header)
+				}
+				if got := header.Get("Access-Control-Allow-Credentials"); (got == "true") != test.config.AllowCredentials {
+					t.Errorf("Access-Control-Allow-Credentials = %q with credentials %v", got, test.config.AllowCredentials)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSCredentialsNeedExactOrigins(t *testing.T) {
+	config := defaultConfig()
+	config.CORS.AllowedOrigins = []string{"*"}
+	config.CORS.AllowCredentials = true
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "cors.allowCredentials") {
+		t.Errorf("Validate = %v, want the wildcard and credentials rejected together", err)
+	}
+}
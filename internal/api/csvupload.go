@@ -0,0 +1,203 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"receipt-processor/internal/model"
+)
+
+// Columns every CSV upload must have; items follow them as numbered itemN,priceN column pairs
+var csvUploadColumns = []string{"retailer", "purchaseDate", "purchaseTime", "total"}
+
+const csvUploadScheme = "the header must be retailer,purchaseDate,purchaseTime,total followed by item1,price1,item2,price2,... column pairs"
+
+// Where each receipt field sits in an uploaded CSV
+type csvUploadLayout struct {
+	columns map[string]int
+	items   [][2]int
+}
+
+// Outcome of one CSV row; rows are counted like a spreadsheet, header included
+type CSVRowResult struct {
+	Row    int    `json:"row"`
+	ID     string `json:"id,omitempty"`
+	Points *int   `json:"points,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type CSVUploadSummary struct {
+	Imported int            `json:"imported"`
+	Failed   int            `json:"failed"`
+	Rows     []CSVRowResult `json:"rows"`
+}
+
+// Function to number an itemN or priceN column, returning 0 for any other name
+func csvItemColumn(name, prefix string) int {
+	if len(name) <= len(prefix) || !strings.EqualFold(name[:len(prefix)], prefix) {
+		return 0
+	}
+	n, err := strconv.Atoi(name[len(prefix):])
+	if err != nil || n < 1 {
+		return 0
+	}
+	return n
+}
+
+// Function to map a CSV upload's header onto receipt fields, rejecting unknown, repeated and missing columns
+func parseCSVUploadHeader(header []string) (csvUploadLayout, error) {
+	layout := csvUploadLayout{columns: make(map[string]int)}
+	seen := make(map[string]bool)
+	descriptions, prices := make(map[int]int), make(map[int]int)
+	for i, cell := range header {
+		name := strings.TrimSpace(cell)
+		if seen[strings.ToLower(name)] {
+			return layout, fmt.Errorf("column %q appears twice; %s", name, csvUploadScheme)
+		}
+		seen[strings.ToLower(name)] = true
+
+		if n := csvItemColumn(name, "item"); n > 0 {
+			descriptions[n] = i
+			continue
+		}
+		if n := csvItemColumn(name, "price"); n > 0 {
+			prices[n] = i
+			continue
+		}
+		known := false
+		for _, column := range csvUploadColumns {
+			if strings.EqualFold(name, column) {
+				layout.columns[column] = i
+				known = true
+			}
+		}
+		if !known {
+			return layout, fmt.Errorf("unknown column %q; %s", name, csvUploadScheme)
+		}
+	}
+
+	for _, column := range csvUploadColumns {
+		if _, exists := layout.columns[column]; !exists {
+			return layout, fmt.Errorf("missing column %q; %s", column, csvUploadScheme)
+		}
+	}
+	for n := 1; n <= max(len(descriptions), len(prices), 1); n++ {
+		description, hasDescription := descriptions[n]
+		price, hasPrice := prices[n]
+		if !hasDescription || !hasPrice {
+			return layout, fmt.Errorf("missing column \"item%d\" or \"price%d\"; %s", n, n, csvUploadScheme)
+		}
+		layout.items = append(layout.items, [2]int{description, price})
+	}
+	return layout, nil
+}
+
+// Function to read one CSV row into a receipt; item pairs left blank are skipped so rows can differ in length
+func (layout csvUploadLayout) receipt(row []string) (model.Receipt, error) {
+	receipt := model.Receipt{
+		Retailer:     row[layout.columns["retailer"]],
+		PurchaseDate: strings.TrimSpace(row[layout.columns["purchaseDate"]]),
+		PurchaseTime: strings.TrimSpace(row[layout.columns["purchaseTime"]]),
+		Total:        strings.TrimSpace(row[layout.columns["total"]]),
+	}
+	for n, columns := range layout.items {
+		description, price := row[columns[0]], strings.TrimSpace(row[columns[1]])
+		if strings.TrimSpace(description) == "" && price == "" {
+			continue
+		}
+		if strings.TrimSpace(description) == "" || price == "" {
+			return receipt, fmt.Errorf("item%d needs both a description and a price", n+1)
+		}
+		receipt.Items = append(receipt.Items, model.Item{ShortDescription: description, Price: price})
+	}
+	return receipt, nil
+}
+
+// Handler to store receipts uploaded as a spreadsheet export; every row is normalized, validated and scored
+// like a JSON submission and the response reports each row by number
+func (s *server) uploadCSVHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireContentType(w, r, "text/csv"); !ok {
+		return
+	}
+
+	// Spreadsheet exports often start with a UTF-8 byte order mark
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body, s.csvUploadMaxBytes))
+	if bom, _ := body.Peek(3); string(bom) == "\ufeff" {
+		body.Discard(3)
+	}
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s.writeError(w, r, errorWithStatus(http.StatusRequestEntityTooLarge, fmt.Sprintf("The CSV upload is larger than %d bytes.", s.csvUploadMaxBytes)))
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The CSV upload could not be parsed: "+err.Error()))
+		return
+	}
+	if len(rows) == 0 {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The CSV upload is empty; "+csvUploadScheme+"."))
+		return
+	}
+	layout, err := parseCSVUploadHeader(rows[0])
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The CSV header is invalid: "+err.Error()+"."))
+		return
+	}
+
+	summary := CSVUploadSummary{Rows: make([]CSVRowResult, len(rows)-1)}
+	var receipts []model.Receipt
+	var parsed []int
+	for i, row := range rows[1:] {
+		summary.Rows[i].Row = i + 2
+		if len(row) != len(rows[0]) {
+			summary.Rows[i].Error = fmt.Sprintf("the row has %d fields but the header has %d", len(row), len(rows[0]))
+			continue
+		}
+		receipt, err := layout.receipt(row)
+		if err != nil {
+			summary.Rows[i].Error = err.Error()
+			continue
+		}
+		receipts = append(receipts, receipt)
+		parsed = append(parsed, i)
+	}
+
+	subs, errs, err := s.prepareImports(r.Context(), receipts, s.requestActor(r), s.tenantFromRequest(r))
+	apiKey, _ := s.apiKeyIdentity(r)
+	for i := range subs {
+		subs[i].apiKey = apiKey
+	}
+	var ids []string
+	if err == nil {
+		ids, err = s.commitImports(r.Context(), subs, errs)
+	}
+	if err != nil {
+		s.logger.WarnContext(r.Context(), "CSV upload cancelled", "requestId", requestIDFromContext(r.Context()), "error", err)
+		return
+	}
+	for i, row := range parsed {
+		if errs[i] != nil {
+			summary.Rows[row].Error = errs[i].Error()
+			continue
+		}
+		summary.Rows[row].ID = ids[i]
+		summary.Rows[row].Points = &subs[i].points
+	}
+
+	for _, row := range summary.Rows {
+		if row.Error != "" {
+			summary.Failed++
+			continue
+		}
+		summary.Imported++
+	}
+	s.writeJSON(w, r, http.StatusOK, summary)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/points"
+)
+
+// Two scoring configs to compare; fields either one leaves out keep their default values
+type DiffRequest struct {
+	ConfigA points.Config `json:"configA"`
+	ConfigB points.Config `json:"configB"`
+}
+
+type RuleDelta struct {
+	Rule  string `json:"rule"`
+	A     int    `json:"a"`
+	B     int    `json:"b"`
+	Delta int    `json:"delta"`
+}
+
+type DiffResponse struct {
+	RuleDeltas []RuleDelta `json:"ruleDeltas"`
+	TotalA     int         `json:"totalA"`
+	TotalB     int         `json:"totalB"`
+}
+
+// Function to compare per-rule points for a receipt under two scoring configs
+func diffScoring(receipt model.Receipt, a, b points.Config) DiffResponse {
+	breakdownA := points.Breakdown(receipt, a)
+	breakdownB := points.Breakdown(receipt, b)
+
+	response := DiffResponse{RuleDeltas: make([]RuleDelta, 0, len(points.Rules))}
+	for _, rule := range points.Rules {
+		delta := RuleDelta{Rule: rule.Name, A: breakdownA[rule.Name], B: breakdownB[rule.Name]}
+		delta.Delta = delta.B - delta.A
+		response.RuleDeltas = append(response.RuleDeltas, delta)
+		response.TotalA += delta.A
+		response.TotalB += delta.B
+	}
+	return response
+}
+
+// Handler to show how a stored receipt's points change between two scoring configs
+func (s *server) diffReceiptHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if s.rejectStoreLight(w, r) {
+		return
+	}
+	request := DiffRequest{ConfigA: points.DefaultConfig(), ConfigB: points.DefaultConfig()}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The diff request is invalid."))
+		return
+	}
+	if err := request.ConfigA.Validate(); err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "configA: "+err.Error()))
+		return
+	}
+	if err := request.ConfigB.Validate(); err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "configB: "+err.Error()))
+		return
+	}
+
+	shard := s.store.Shard(id)
+	shard.RLock()
+	receipt, exists := shard.Lookup(id)
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, diffScoring(receipt, request.ConfigA, request.ConfigB))
+}
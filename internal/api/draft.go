@@ -0,0 +1,175 @@
+package api
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// How long a draft can wait to be confirmed, and how many may be open at once
+var draftTTL = 10 * time.Minute
+
+const maxOpenDrafts = 10000
+
+const (
+	DraftStateDraft     = "draft"
+	DraftStateConfirmed = "confirmed"
+)
+
+// A receipt stored for later confirmation. Confirmed drafts are kept until they expire, so a repeated confirm
+// gets the same answer
+type Draft struct {
+	Token     string    `json:"draftToken"`
+	State     string    `json:"state"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Set once the draft is confirmed
+	ID     string `json:"id,omitempty"`
+	Points *int   `json:"points,omitempty"`
+
+	request ProcessReceiptRequest
+	// Set while a confirm is scoring and storing the receipt
+	confirming bool
+}
+
+// Function to drop every draft past its TTL; callers hold draftsMutex
+func (s *server) expireDrafts(now time.Time) {
+	for token, draft := range s.drafts {
+		if now.After(draft.ExpiresAt) && !draft.confirming {
+			delete(s.drafts, token)
+		}
+	}
+}
+
+// Function to sweep expired drafts once a second until stop is closed
+func (s *server) expireDraftsPeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.draftsMutex.Lock()
+			s.expireDrafts(now)
+			s.draftsMutex.Unlock()
+		}
+	}
+}
+
+// Function to look up an open draft from the draftToken path value; callers hold draftsMutex
+func (s *server) findDraft(w http.ResponseWriter, r *http.Request) (*Draft, bool) {
+	s.expireDrafts(s.clock())
+	draft, exists := s.drafts[r.PathValue("draftToken")]
+	if !exists {
+		s.writeError(w, r, errorWithStatus(http.StatusNotFound, "No draft found for that token."))
+		return nil, false
+	}
+	return draft, true
+}
+
+// Handler to store a receipt as a draft; it is only validated and scored once confirmed
+func (s *server) createDraftHandler(w http.ResponseWriter, r *http.Request) {
+	bodyType, ok := s.requireContentType(w, r, s.receiptWireTypes()...)
+	if !ok {
+		return
+	}
+	request, err := s.decodeProcessRequest(w, r, bodyType)
+	var schemaErr *schemaError
+	if errors.As(err, &schemaErr) {
+		s.writeJSON(w, r, http.StatusBadRequest, SchemaErrorResponse{Error: schemaErr.Error(), Violations: schemaErr.violations})
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, malformedBodyMessage(err)))
+		return
+	}
+	if !s.checkScoringOverrides(w, r, request.ScoringOverrides) {
+		return
+	}
+
+	s.draftsMutex.Lock()
+	defer s.draftsMutex.Unlock()
+	s.expireDrafts(s.clock())
+	if len(s.drafts) >= maxOpenDrafts {
+		s.writeError(w, r, errorWithStatus(http.StatusServiceUnavailable, "Too many open drafts; confirm or discard some first."))
+		return
+	}
+	draft := &Draft{
+		Token:     rand.Text(),
+		State:     DraftStateDraft,
+		ExpiresAt: s.clock().Add(draftTTL).UTC(),
+		request:   request,
+	}
+	s.drafts[draft.Token] = draft
+	s.writeJSON(w, r, http.StatusCreated, draft)
+}
+
+// Handler to validate a draft, then score and store it as a receipt
+func (s *server) confirmDraftHandler(w http.ResponseWriter, r *http.Request) {
+	s.draftsMutex.Lock()
+	draft, exists := s.findDraft(w, r)
+	if !exists {
+		s.draftsMutex.Unlock()
+		return
+	}
+	if draft.confirming {
+		s.draftsMutex.Unlock()
+		s.writeError(w, r, conflictError("The draft is already being confirmed."))
+		return
+	}
+	if draft.State == DraftStateConfirmed {
+		confirmed := *draft
+		s.draftsMutex.Unlock()
+		s.writeJSON(w, r, http.StatusOK, confirmed)
+		return
+	}
+	// The draft is claimed rather than held locked while it is scored and stored, so other drafts are not held up
+	draft.confirming = true
+	request := draft.request
+	s.draftsMutex.Unlock()
+	defer func() {
+		s.draftsMutex.Lock()
+		draft.confirming = false
+		s.draftsMutex.Unlock()
+	}()
+
+	receipt := request.Receipt
+	err := s.normalizeReceipt(&receipt)
+	if err == nil {
+		err = s.validateReceipt(receipt)
+	}
+	if err != nil {
+		s.metrics.validationFailures.WithLabelValues(validationReason(err)).Inc()
+		s.writeError(w, r, err)
+		return
+	}
+	id, _, awarded, ok := s.scoreAndStoreReceipt(w, r, receipt, request.ScoringOverrides, nil)
+	if !ok {
+		return
+	}
+
+	s.draftsMutex.Lock()
+	draft.State = DraftStateConfirmed
+	draft.ID = id
+	draft.Points = &awarded
+	confirmed := *draft
+	s.draftsMutex.Unlock()
+	s.writeJSON(w, r, http.StatusOK, confirmed)
+}
+
+// Handler to throw away a draft that has not been confirmed
+func (s *server) discardDraftHandler(w http.ResponseWriter, r *http.Request) {
+	s.draftsMutex.Lock()
+	defer s.draftsMutex.Unlock()
+	draft, exists := s.findDraft(w, r)
+	if !exists {
+		return
+	}
+	if draft.State == DraftStateConfirmed || draft.confirming {
+		s.writeError(w, r, conflictError("The draft has already been confirmed."))
+		return
+	}
+	delete(s.drafts, draft.Token)
+	w.WriteHeader(http.StatusNoContent)
+}
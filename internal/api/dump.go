@@ -0,0 +1,365 @@
+package api
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"receipt-processor/internal/model"
+
+	"github.com/google/uuid"
+)
+
+const mediaZip = "application/zip"
+
+// Identifies a receipt-processor dump; restores refuse other formats and newer versions
+const (
+	dumpFormat        = "receipt-processor-archive"
+	dumpFormatVersion = 1
+)
+
+// Largest dump accepted for restore; it is spooled to disk, not held in memory
+const maxDumpBytes = 256 << 20
+
+// Files in a dump besides the manifest, in the order they are written
+const (
+	dumpReceiptsFile = "receipts.json"
+	dumpPointsFile   = "points.csv"
+	dumpItemsFile    = "items.csv"
+	dumpRulesFile    = "rules.json"
+	dumpManifestFile = "manifest.json"
+)
+
+// A receipt as written to receipts.json: everything a restore needs to bring it back as it was
+type DumpRecord struct {
+	ID string `json:"id"`
+	model.Receipt
+	Points    int       `json:"points"`
+	Archived  bool      `json:"archived"`
+	Pinned    bool      `json:"pinned,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type DumpFile struct {
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+type DumpManifest struct {
+	Format        string    `json:"format"`
+	FormatVersion int       `json:"formatVersion"`
+	ExportedAt    time.Time `json:"exportedAt"`
+	// Rule configs carry no version number, so this is the SHA-256 of rules.json
+	RulesVersion string     `json:"rulesVersion"`
+	Receipts     int        `json:"receipts"`
+	Archived     int        `json:"archived"`
+	Items        int        `json:"items"`
+	Files        []DumpFile `json:"files"`
+}
+
+// Writer of one file in a dump, hashing and counting what goes through it
+type dumpFileWriter struct {
+	io.Writer
+	hash  hash.Hash
+	bytes int64
+	name  string
+}
+
+func (f *dumpFileWriter) Write(p []byte) (int, error) {
+	n, err := f.Writer.Write(p)
+	f.hash.Write(p[:n])
+	f.bytes += int64(n)
+	return n, err
+}
+
+func (f *dumpFileWriter) summary() DumpFile {
+	return DumpFile{Name: f.name, Bytes: f.bytes, SHA256: hex.EncodeToString(f.hash.Sum(nil))}
+}
+
+func createDumpFile(archive *zip.Writer, name string) (*dumpFileWriter, error) {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &dumpFileWriter{Writer: entry, hash: sha256.New(), name: name}, nil
+}
+
+// Function to stream a dump of one snapshot of the store into w: the receipts, their points and items, the
+// active rules, and a manifest with a checksum of every other file
+func (s *server) writeDump(w io.Writer) error {
+	snapshot := s.store.Snapshot()
+	manifest := DumpManifest{Format: dumpFormat, FormatVersion: dumpFormatVersion, ExportedAt: s.clock().UTC()}
+	archive := zip.NewWriter(w)
+
+	receipts, err := createDumpFile(archive, dumpReceiptsFile)
+	if err != nil {
+		return err
+	}
+	io.WriteString(receipts, "[")
+	for i, stored := range snapshot {
+		if i > 0 {
+			io.WriteString(receipts, ",")
+		}
+		record, err := json.Marshal(DumpRecord{ID: stored.ID, Receipt: stored.Receipt, Points: stored.Points,
+			Archived: stored.Archived, Pinned: stored.Pinned, CreatedAt: stored.StoredAt})
+		if err != nil {
+			return err
+		}
+		if _, err := receipts.Write(append([]byte("\n"), record...)); err != nil {
+			return err
+		}
+		manifest.Receipts++
+		manifest.Items += len(stored.Receipt.Items)
+		if stored.Archived {
+			manifest.Archived++
+		}
+	}
+	if _, err := io.WriteString(receipts, "\n]\n"); err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, receipts.summary())
+
+	points, err := createDumpFile(archive, dumpPointsFile)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(points)
+	writer.Write([]string{"id", "points"})
+	for _, stored := range snapshot {
+		writer.Write([]string{stored.ID, strconv.Itoa(stored.Points)})
+	}
+	if writer.Flush(); writer.Error() != nil {
+		return writer.Error()
+	}
+	manifest.Files = append(manifest.Files, points.summary())
+
+	items, err := createDumpFile(archive, dumpItemsFile)
+	if err != nil {
+		return err
+	}
+	writer = csv.NewWriter(items)
+	writer.Write([]string{"receiptId", "line", "shortDescription", "price"})
+	for _, stored := range snapshot {
+		for i, item := range stored.Receipt.Items {
+			writer.Write([]string{stored.ID, strconv.Itoa(i + 1), item.ShortDescription, item.Price})
+		}
+	}
+	if writer.Flush(); writer.Error() != nil {
+		return writer.Error()
+	}
+	manifest.Files = append(manifest.Files, items.summary())
+
+	rules, err := createDumpFile(archive, dumpRulesFile)
+	if err != nil {
+		return err
+	}
+	config, err := json.MarshalIndent(s.calculator.Active(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := rules.Write(append(config, '\n')); err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, rules.summary())
+	manifest.RulesVersion = rules.summary().SHA256
+
+	entry, err := archive.Create(dumpManifestFile)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// Function to check a dump's manifest and every checksum in it, returning the receipts it holds
+func readDump(archive *zip.Reader) ([]DumpRecord, error) {
+	files := make(map[string]*zip.File)
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+	if files[dumpManifestFile] == nil {
+		return nil, errors.New("the archive has no " + dumpManifestFile)
+	}
+	var manifest DumpManifest
+	if err := readDumpJSON(files[dumpManifestFile], &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Format != dumpFormat || manifest.FormatVersion < 1 || manifest.FormatVersion > dumpFormatVersion {
+		return nil, fmt.Errorf("the archive is not a %s version %d or earlier", dumpFormat, dumpFormatVersion)
+	}
+
+	listed := make(map[string]bool)
+	for _, summary := range manifest.Files {
+		file := files[summary.Name]
+		if file == nil {
+			return nil, fmt.Errorf("the archive is missing %s", summary.Name)
+		}
+		contents, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.New()
+		_, err = io.Copy(digest, contents)
+		contents.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", summary.Name, err)
+		}
+		if hex.EncodeToString(digest.Sum(nil)) != summary.SHA256 {
+			return nil, fmt.Errorf("%s does not match its checksum", summary.Name)
+		}
+		listed[summary.Name] = true
+	}
+	if !listed[dumpReceiptsFile] {
+		return nil, errors.New("the manifest does not list " + dumpReceiptsFile)
+	}
+
+	var records []DumpRecord
+	if err := readDumpJSON(files[dumpReceiptsFile], &records); err != nil {
+		return nil, err
+	}
+	if len(records) != manifest.Receipts {
+		return nil, fmt.Errorf("%s holds %d receipts but the manifest counts %d", dumpReceiptsFile, len(records), manifest.Receipts)
+	}
+	return records, nil
+}
+
+func readDumpJSON(file *zip.File, v any) error {
+	contents, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+	if err := json.NewDecoder(contents).Decode(v); err != nil {
+		return fmt.Errorf("reading %s: %w", file.Name, err)
+	}
+	return nil
+}
+
+// Function to bring back the receipts of a dump with their IDs, points and stored times. Points are restored
+// as they were rather than rescored, and archived receipts go straight back to the archive
+func (s *server) restoreDump(r *http.Request, archive *zip.Reader) (ImportSummary, error) {
+	summary := ImportSummary{Errors: []string{}}
+	records, err := readDump(archive)
+	if err != nil {
+		return summary, err
+	}
+
+	subs := make([]submission, len(records))
+	errs := make([]error, len(records))
+	for i, record := range records {
+		receipt := record.Receipt
+		err := s.normalizeReceipt(&receipt)
+		if err == nil {
+			err = s.validateReceipt(receipt)
+		}
+		if _, parseErr := uuid.Parse(record.ID); err == nil && parseErr != nil {
+			err = errors.New("invalid id")
+		}
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		subs[i] = submission{
+			receipt:     receipt,
+			points:      record.Points,
+			fingerprint: fingerprintReceipt(receipt),
+			actor:       s.requestActor(r),
+			restoredID:  record.ID,
+			storedAt:    record.CreatedAt,
+		}
+	}
+	ids, err := s.commitImports(r.Context(), subs, errs)
+	if err != nil {
+		return summary, err
+	}
+
+	for i, record := range records {
+		if ids[i] == "" || !record.Archived && !record.Pinned {
+			continue
+		}
+		shard := s.store.Shard(ids[i])
+		shard.Lock()
+		if _, exists := shard.Receipts[ids[i]]; exists && record.Archived {
+			errs[i] = s.archiveReceiptLocked(ids[i])
+		}
+		if errs[i] == nil && record.Pinned {
+			errs[i] = s.store.Pin(ids[i], true)
+		}
+		shard.Unlock()
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			summary.Failed++
+			summary.Errors = append(summary.Errors, fmt.Sprintf("receipt %s: %v", records[i].ID, err))
+			continue
+		}
+		summary.Imported++
+	}
+	return summary, nil
+}
+
+// Function to send a dump as a download. Once the archive has started there is no status left to change, so a
+// failure part way is only logged and the client gets a truncated file
+func (s *server) writeDumpDownload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", mediaZip)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="receipts-%s.zip"`, s.clock().UTC().Format("20060102")))
+	if err := s.writeDump(w); err != nil {
+		s.logger.ErrorContext(r.Context(), "writing archive export failed", "path", r.URL.Path, "error", err)
+	}
+}
+
+// Handler to restore a dump uploaded as the request body
+func (s *server) restoreDumpHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireContentType(w, r, mediaZip); !ok {
+		return
+	}
+	spool, err := os.CreateTemp("", "receipt-dump-*.zip")
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The archive could not be read."))
+		return
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	size, err := io.Copy(spool, http.MaxBytesReader(w, r.Body, maxDumpBytes))
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusRequestEntityTooLarge, fmt.Sprintf("The archive must be at most %d bytes.", maxDumpBytes)))
+		return
+	}
+	archive, err := zip.NewReader(spool, size)
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The body is not a zip archive."))
+		return
+	}
+	s.restoreDumpFrom(w, r, archive)
+}
+
+// Function to restore a dump and answer with the import summary
+func (s *server) restoreDumpFrom(w http.ResponseWriter, r *http.Request, archive *zip.Reader) {
+	summary, err := s.restoreDump(r, archive)
+	if err != nil && r.Context().Err() != nil {
+		s.logger.WarnContext(r.Context(), "restore cancelled", "requestId", requestIDFromContext(r.Context()), "error", err)
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The archive cannot be restored: "+err.Error()))
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, summary)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+)
+
+// Function to list what the store holds, by ID
+func sortedSnapshot(s *server) []store.Stored {
+	snapshot := s.store.Snapshot()
+	slices.SortFunc(snapshot, func(a, b store.Stored) int { return strings.Compare(a.ID, b.ID) })
+	return snapshot
+}
+
+// A dump restored into an emptied store brings back every receipt as it was: its ID, points, archive state and
+// stored time
+func TestDumpRestoresWhatWasPurged(t *testing.T) {
+	storedAt := time.Date(2022, 3, 20, 14, 33, 0, 0, time.UTC)
+	config := defaultConfig()
+	config.AdminToken = "admin-token"
+	config.ArchiveAfterDays = 30
+	s, err := newServer(store.NewMemory(defaultLockShards), points.NewCalculator(points.DefaultConfig()),
+		WithConfig(config), WithLogger(discardLogger()), WithClock(func() time.Time { return storedAt }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	old := processReceipt(t, server, targetReceipt)
+	if archived, err := s.archiveReceipts(storedAt.Add(31 * 24 * time.Hour)); err != nil || archived != 1 {
+		t.Fatalf("archiveReceipts = %d, %v, want 1 receipt archived", archived, err)
+	}
+	spent := processReceipt(t, server, cornerMarketReceipt)
+	redeemPoints(t, server, spent, 9)
+	processReceipt(t, server, morningReceipt)
+	want := sortedSnapshot(s)
+
+	response, dump := send(t, server, http.MethodGet, "/receipts/export?format=archive", "", "X-Admin-Token", "admin-token")
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != mediaZip {
+		t.Fatalf("export = %d %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	for _, stored := range want {
+		if err := s.deleteReceipt(context.Background(), stored.ID, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := s.store.Count(); got != 0 {
+		t.Fatalf("%d receipts left after the purge", got)
+	}
+
+	response, body := send(t, server, http.MethodPost, "/admin/restore", dump, "Content-Type", mediaZip, "X-Admin-Token", "admin-token")
+	var summary ImportSummary
+	decodeBody(t, body, &summary)
+	if response.StatusCode != http.StatusOK || summary.Imported != len(want) || summary.Failed != 0 {
+		t.Fatalf("restore = %d %s, want all %d receipts imported", response.StatusCode, body, len(want))
+	}
+	if got := sortedSnapshot(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored store\n%+v\nwant\n%+v", got, want)
+	}
+	if got := receiptPoints(t, server, spent); got != 100 {
+		t.Errorf("points of the spent receipt = %d, want the 100 left after redeeming 9", got)
+	}
+	if response, _ := send(t, server, http.MethodGet, "/receipts/"+old, ""); response.Header.Get("X-Receipt-Archived") != "true" {
+		t.Error("the archived receipt came back out of the archive")
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+)
+
+// Function to decode a JSON response body the test expects to be well formed
+func decodeBody(t testing.TB, body string, value any) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(body), value); err != nil {
+		t.Fatalf("decoding %q: %v", body, err)
+	}
+}
+
+// Function to reserve points on a receipt and commit the reservation
+func redeemPoints(t *testing.T, server *httptest.Server, id string, amount int) {
+	t.Helper()
+	response, body := send(t, server, http.MethodPost, "/receipts/"+id+"/points/reserve", `{"points":`+strconv.Itoa(amount)+`}`)
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("reserving %d points = %d %s", amount, response.StatusCode, body)
+	}
+	var reservation model.Reservation
+	decodeBody(t, body, &reservation)
+	if response, body = send(t, server, http.MethodPost, "/receipts/"+id+"/points/commit/"+reservation.ID, ""); response.StatusCode != http.StatusOK {
+		t.Fatalf("committing the reservation = %d %s", response.StatusCode, body)
+	}
+}
+
+func TestComposedServer(t *testing.T) {
+	server := startServer(t, points.DefaultConfig())
+	target := processReceipt(t, server, targetReceipt)
+	market := processReceipt(t, server, cornerMarketReceipt)
+	if got := receiptPoints(t, server, target); got != 28 {
+		t.Errorf("Target receipt scored %d, want 28", got)
+	}
+	if got := receiptPoints(t, server, market); got != 109 {
+		t.Errorf("M&M Corner Market receipt scored %d, want 109", got)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"count", http.MethodGet, "/receipts/count", "", http.StatusOK},
+		{"stored receipt", http.MethodGet, "/receipts/" + target, "", http.StatusOK},
+		{"unknown receipt", http.MethodGet, "/receipts/7fb1377b-b223-49d9-a31a-5a02701dd310/points", "", http.StatusNotFound},
+		{"malformed ID", http.MethodGet, "/receipts/not-an-id/points", "", http.StatusNotFound},
+		{"invalid receipt", http.MethodPost, "/receipts/process", `{"retailer":"Target"}`, http.StatusBadRequest},
+		{"malformed body", http.MethodPost, "/receipts/process", `{`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if response, body := send(t, server, test.method, test.path, test.body); response.StatusCode != test.want {
+				t.Errorf("%s %s = %d %s, want %d", test.method, test.path, response.StatusCode, body, test.want)
+			}
+		})
+	}
+
+	_, body := send(t, server, http.MethodGet, "/receipts/count?retailer=Target", "")
+	var count struct {
+		Count int `json:"count"`
+	}
+	decodeBody(t, body, &count)
+	if count.Count != 1 {
+		t.Errorf("Target receipts counted %d, want 1", count.Count)
+	}
+
+	redeemPoints(t, server, market, 10)
+	response, body := send(t, server, http.MethodPost, "/receipts/"+market+"/points/transfer", `{"targetId":"`+target+`","amount":9}`)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("transfer = %d %s", response.StatusCode, body)
+	}
+	var transfer model.TransferResponse
+	decodeBody(t, body, &transfer)
+	if transfer != (model.TransferResponse{SourceRemaining: 90, TargetTotal: 37}) {
+		t.Errorf("transfer left %+v, want 90 and 37", transfer)
+	}
+}
+
+func TestComposedServerReplaysItsLog(t *testing.T) {
+	config := store.LogConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "receipts.wal"), Durability: "strict"}
+	noReservations := func() map[string]int { return nil }
+
+	logged, err := store.OpenLogged(store.NewMemory(defaultLockShards), config, time.Now, noReservations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := startServerOver(t, logged, points.DefaultConfig())
+	target := processReceipt(t, server, targetReceipt)
+	market := processReceipt(t, server, cornerMarketReceipt)
+	redeemPoints(t, server, market, 10)
+	if response, body := send(t, server, http.MethodPost, "/receipts/"+market+"/points/transfer", `{"targetId":"`+target+`","amount":9}`); response.StatusCode != http.StatusOK {
+		t.Fatalf("transfer = %d %s", response.StatusCode, body)
+	}
+	server.Close()
+	if err := logged.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := store.OpenLogged(store.NewMemory(defaultLockShards), config, time.Now, noReservations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { replayed.Close() })
+	restarted := startServerOver(t, replayed, points.DefaultConfig())
+	if got := receiptPoints(t, restarted, target); got != 37 {
+		t.Errorf("Target receipt has %d points after replay, want 37", got)
+	}
+	if got := receiptPoints(t, restarted, market); got != 90 {
+		t.Errorf("M&M Corner Market receipt has %d points after replay, want 90", got)
+	}
+	_, body := send(t, restarted, http.MethodGet, "/receipts/count", "")
+	var count struct {
+		Count int `json:"count"`
+	}
+	decodeBody(t, body, &count)
+	if count.Count != 2 {
+		t.Errorf("%d receipts counted after replay, want 2", count.Count)
+	}
+}
+
+func TestKeyUsageSurvivesARestart(t *testing.T) {
+	config := defaultConfig()
+	config.APIKeys = "acme:acme-key"
+	config.APIKeyQuotas = map[string]KeyQuota{"acme": {Daily: 2}}
+	logConfig := store.LogConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "receipts.wal"), Durability: "strict"}
+	noReservations := func() map[string]int { return nil }
+
+	logged, err := store.OpenLogged(store.NewMemory(defaultLockShards), logConfig, time.Now, noReservations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := startServerOver(t, logged, points.DefaultConfig(), WithConfig(config))
+	processReceipt(t, server, targetReceipt, "X-API-Key", "acme-key")
+	processReceipt(t, server, cornerMarketReceipt, "X-API-Key", "acme-key")
+	server.Close()
+	if err := logged.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := store.OpenLogged(store.NewMemory(defaultLockShards), logConfig, time.Now, noReservations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { replayed.Close() })
+	restarted := startServerOver(t, replayed, points.DefaultConfig(), WithConfig(config))
+	_, body := send(t, restarted, http.MethodGet, "/usage", "", "X-API-Key", "acme-key")
+	var usage KeyUsage
+	if decodeBody(t, body, &usage); usage.Daily.Used != 2 || usage.Monthly.Used != 2 {
+		t.Errorf("usage after the restart = %+v, want 2 used today and this month", usage)
+	}
+	third := strings.Replace(targetReceipt, "Target", "Walgreens", 1)
+	if response, body := send(t, restarted, http.MethodPost, "/receipts/process", third, "X-API-Key", "acme-key"); response.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("third submission after the restart = %d %s, want 429", response.StatusCode, body)
+	}
+}
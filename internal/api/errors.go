@@ -0,0 +1,160 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Returned when there is no receipt with the requested ID
+var ErrNotFound = errors.New("No receipt found for that ID.")
+
+// Matched by every error refusing a change the receipt's current state does not allow
+var ErrConflict = errors.New("The change conflicts with the receipt's current state.")
+
+// Conflict with its own message; errors.Is matches it to ErrConflict
+type conflictError string
+
+func (e conflictError) Error() string {
+	return string(e)
+}
+
+func (e conflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Conflicts more than one handler answers with
+const (
+	errArchived           = conflictError("Archived receipts cannot be modified.")
+	errInsufficientPoints = conflictError("The receipt does not have enough points available.")
+	errStoreLight         = conflictError("Unsupported in store-light mode: full receipts are not retained.")
+	errPinned             = conflictError("Pinned receipts cannot be deleted; unpin the receipt first.")
+)
+
+// Refusal with its own status and message, for failures that are neither about a receipt's contents nor its state:
+// a malformed request, a missing credential, an overloaded server
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// Function to refuse a request with the given status and message
+func errorWithStatus(status int, message string) error {
+	return &statusError{status: status, message: message}
+}
+
+// Stable code for each status a statusError may carry
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusNotAcceptable:         "not_acceptable",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// One invalid field. Field is its JSON path, e.g. "items[1].price"; Reason names the check it failed and is used
+// for metrics
+type FieldViolation struct {
+	Field   string `json:"field"`
+	Reason  string `json:"reason"`
+	Message string `json:"message,omitempty"`
+}
+
+// Returned when a receipt fails validation, with every violation found. Clients see the same message for every
+// failure except a field of the wrong length or an item count out of bounds, which say what to change
+type ValidationError struct {
+	Violations []FieldViolation
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Violations) > 0 && e.Violations[0].Message != "" {
+		return e.Violations[0].Message
+	}
+	return "The receipt is invalid."
+}
+
+// Function to get the reason of the first violation, the one counted in metrics
+func (e *ValidationError) Reason() string {
+	if len(e.Violations) == 0 {
+		return ""
+	}
+	return e.Violations[0].Reason
+}
+
+// Function to report one invalid field; an empty message leaves the generic one
+func invalidField(field, reason, message string) *ValidationError {
+	return &ValidationError{Violations: []FieldViolation{{Field: field, Reason: reason, Message: message}}}
+}
+
+// Error body for clients that accept JSON; code is stable where the message may change
+type ErrorResponse struct {
+	Error      string           `json:"error"`
+	Code       string           `json:"code"`
+	Violations []FieldViolation `json:"violations,omitempty"`
+	RequestID  string           `json:"requestId"`
+}
+
+// Status and code for each sentinel error, checked in order with errors.Is
+var errorStatuses = []struct {
+	target error
+	status int
+	code   string
+}{
+	{ErrNotFound, http.StatusNotFound, "not_found"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+}
+
+// Function to answer with the status an error maps to: plain text with its message, or an ErrorResponse when the
+// client prefers JSON. This is the only place errors become responses. Unrecognized errors are logged and answered
+// 500 without their message
+func (s *server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code, message := http.StatusInternalServerError, "internal", "The request could not be completed."
+	recognized := false
+	var invalid *ValidationError
+	var refused *statusError
+	switch {
+	case errors.As(err, &invalid):
+		status, code, message, recognized = http.StatusBadRequest, "invalid_receipt", invalid.Error(), true
+	case errors.As(err, &refused):
+		status, code, message, recognized = refused.status, statusCodes[refused.status], refused.message, true
+	default:
+		for _, mapping := range errorStatuses {
+			if errors.Is(err, mapping.target) {
+				status, code, message, recognized = mapping.status, mapping.code, err.Error(), true
+				break
+			}
+		}
+	}
+	if !recognized {
+		s.logger.ErrorContext(r.Context(), "request failed", "path", r.URL.Path, "error", err)
+	}
+
+	if negotiateContentType(r.Header.Get("Accept"), []string{mediaText, mediaJSON}) != mediaJSON {
+		http.Error(w, message, status)
+		return
+	}
+	response := ErrorResponse{Error: message, Code: code, RequestID: requestIDFromContext(r.Context())}
+	if invalid != nil {
+		response.Violations = invalid.Violations
+	}
+	s.writeJSON(w, r, status, response)
+}
+
+// Function to get the reason a validation error is counted under in metrics, or "" for any other error
+func validationReason(err error) string {
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		return invalid.Reason()
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/points"
+)
+
+func TestWriteErrorMapsErrorsToResponses(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+		fields  []string
+	}{
+		{"not found", ErrNotFound, http.StatusNotFound, "not_found", ErrNotFound.Error(), nil},
+		{"wrapped not found", fmt.Errorf("loading: %w", ErrNotFound), http.StatusNotFound, "not_found", "loading: " + ErrNotFound.Error(), nil},
+		{"conflict", ErrConflict, http.StatusConflict, "conflict", ErrConflict.Error(), nil},
+		{"specific conflict", errArchived, http.StatusConflict, "conflict", errArchived.Error(), nil},
+		{"validation", &ValidationError{Violations: []FieldViolation{{Field: "total", Reason: "total"}, {Field: "retailer", Reason: "retailer"}}},
+			http.StatusBadRequest, "invalid_receipt", "The receipt is invalid.", []string{"total", "retailer"}},
+		{"validation with a message", invalidField("items", "too_many_items", "At most 2 items are allowed."),
+			http.StatusBadRequest, "invalid_receipt", "At most 2 items are allowed.", []string{"items"}},
+		{"bad request", errorWithStatus(http.StatusBadRequest, "The note must have text."), http.StatusBadRequest, "bad_request", "The note must have text.", nil},
+		{"unauthorized", errorWithStatus(http.StatusUnauthorized, "Admin token required."), http.StatusUnauthorized, "unauthorized", "Admin token required.", nil},
+		{"forbidden", errorWithStatus(http.StatusForbidden, "Access from this address is not allowed."), http.StatusForbidden, "forbidden", "Access from this address is not allowed.", nil},
+		{"other not found", errorWithStatus(http.StatusNotFound, "No draft found for that token."), http.StatusNotFound, "not_found", "No draft found for that token.", nil},
+		{"method not allowed", errorWithStatus(http.StatusMethodNotAllowed, "Method not allowed."), http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed.", nil},
+		{"too large", errorWithStatus(http.StatusRequestEntityTooLarge, "Too big."), http.StatusRequestEntityTooLarge, "too_large", "Too big.", nil},
+		{"unsupported media type", errorWithStatus(http.StatusUnsupportedMediaType, "Supported content types: text/csv."), http.StatusUnsupportedMediaType, "unsupported_media_type", "Supported content types: text/csv.", nil},
+		{"rate limited", errorWithStatus(http.StatusTooManyRequests, "Daily receipt quota exceeded."), http.StatusTooManyRequests, "rate_limited", "Daily receipt quota exceeded.", nil},
+		{"unavailable", errorWithStatus(http.StatusServiceUnavailable, "The server is overloaded, please retry later."), http.StatusServiceUnavailable, "unavailable", "The server is overloaded, please retry later.", nil},
+		{"explained internal error", errorWithStatus(http.StatusInternalServerError, "The receipt could not be stored."), http.StatusInternalServerError, "internal", "The receipt could not be stored.", nil},
+		{"unrecognized error", errors.New("disk on fire"), http.StatusInternalServerError, "internal", "The request could not be completed.", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			text := httptest.NewRecorder()
+			s.writeError(text, httptest.NewRequest(http.MethodGet, "/receipts", nil), test.err)
+			if text.Code != test.status || strings.TrimSpace(text.Body.String()) != test.message {
+				t.Errorf("plain text answer = %d %q, want %d %q", text.Code, text.Body, test.status, test.message)
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "/receipts", nil)
+			request.Header.Set("Accept", "application/json")
+			recorder := httptest.NewRecorder()
+			s.writeError(recorder, request, test.err)
+			var response ErrorResponse
+			decodeBody(t, recorder.Body.String(), &response)
+			var fields []string
+			for _, violation := range response.Violations {
+				fields = append(fields, violation.Field)
+			}
+			if recorder.Code != test.status || response.Code != test.code || response.Error != test.message || !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("JSON answer = %d %+v, want %d with code %q, error %q and violations %v",
+					recorder.Code, response, test.status, test.code, test.message, test.fields)
+			}
+		})
+	}
+}
+
+// Handlers answer through writeError, so JSON clients get an ErrorResponse from every kind of refusal
+func TestHandlersAnswerErrorsThroughWriteError(t *testing.T) {
+	config := defaultConfig()
+	config.AdminToken = "admin-token"
+	server := startServer(t, points.DefaultConfig(), WithConfig(config))
+	id := processReceipt(t, server, targetReceipt)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		admin  bool
+		status int
+		code   string
+	}{
+		{"unknown rule", http.MethodGet, "/admin/rules/noSuchRule/history", "", true, http.StatusNotFound, "not_found"},
+		{"unknown draft", http.MethodPost, "/receipts/draft/no-such-token/confirm", "", false, http.StatusNotFound, "not_found"},
+		{"body without a key", http.MethodGet, "/receipts/" + id + "/body", "", false, http.StatusForbidden, "forbidden"},
+		{"no stored body", http.MethodGet, "/receipts/" + id + "/body", "", true, http.StatusNotFound, "not_found"},
+		{"no image", http.MethodGet, "/receipts/" + id + "/image", "", false, http.StatusNotFound, "not_found"},
+		{"empty note", http.MethodPost, "/receipts/" + id + "/notes", `{"text":""}`, false, http.StatusBadRequest, "bad_request"},
+		{"unknown reservation", http.MethodPost, "/receipts/" + id + "/points/commit/no-such-reservation", "", false, http.StatusNotFound, "not_found"},
+		{"item out of range", http.MethodDelete, "/receipts/" + id + "/items/9?total=1.00", "", false, http.StatusNotFound, "not_found"},
+		{"wrong content type", http.MethodPost, "/receipts/process/stream", `{}`, false, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{"admin route without the token", http.MethodGet, "/admin/usage", "", false, http.StatusUnauthorized, "unauthorized"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := []string{"Accept", "application/json"}
+			if test.admin {
+				header = append(header, "X-Admin-Token", "admin-token")
+			}
+			response, body := send(t, server, test.method, test.path, test.body, header...)
+			var answer ErrorResponse
+			if err := json.Unmarshal([]byte(body), &answer); err != nil || response.StatusCode != test.status || answer.Code != test.code {
+				t.Errorf("%s %s = %d %s, want %d with code %q", test.method, test.path, response.StatusCode, body, test.status, test.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"receipt-processor/internal/model"
+)
+
+// How often an idle event stream gets a comment, so proxies keep it open and dead clients are noticed
+const eventStreamKeepAlive = 15 * time.Second
+
+// Name each streamed timeline event goes out under; events not listed are left off the stream
+var streamedEventNames = map[string]string{
+	EventPointsCalculated:      "points_calculated",
+	EventRecalculated:          "points_adjusted",
+	EventReserved:              "points_adjusted",
+	EventReservationRolledBack: "points_adjusted",
+	EventReservationExpired:    "points_adjusted",
+	EventPointsTransferredOut:  "points_adjusted",
+	EventPointsTransferredIn:   "points_adjusted",
+	EventAnnotationAdded:       "annotation_added",
+	EventRedeemed:              "redemption_made",
+	EventLocked:                "locked",
+	EventUnlocked:              "unlocked",
+	EventDeleted:               "deleted",
+}
+
+// Function to write one event in server-sent events framing. The ID is the event's position on the receipt's
+// timeline, which is what a reconnecting client sends back as Last-Event-ID
+func writeStreamedEvent(w http.ResponseWriter, position int, event model.Event) error {
+	name, streamed := streamedEventNames[event.Type]
+	if !streamed {
+		return nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", position, name, data)
+	return err
+}
+
+// Handler to stream a receipt's events as server-sent events until the client goes away
+func (s *server) receiptEventsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	events, timeline, cancel, exists := s.subscribeEvents(id)
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// A reconnecting client first gets what it missed; a new one only what happens from now on
+	if lastID, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil && lastID >= 0 {
+		for i := lastID; i < len(timeline); i++ {
+			if err := writeStreamedEvent(w, i+1, timeline[i]); err != nil {
+				return
+			}
+		}
+	}
+	position := len(timeline)
+	controller := http.NewResponseController(w)
+	if controller.Flush() != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-events:
+			if !open {
+				return
+			}
+			position++
+			if err := writeStreamedEvent(w, position, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		if controller.Flush() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"receipt-processor/internal/model"
+)
+
+// Full receipt as returned by the export endpoint
+type ReceiptExport struct {
+	ID string `json:"id"`
+	model.Receipt
+	Points int `json:"points"`
+}
+
+var exportFormats = []string{"application/json", "text/csv", "application/pdf", "text/html", mediaXLSX}
+
+var receiptHTMLTemplate = template.Must(template.New("receipt").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Receipt {{.ID}}</title></head>
+<body>
+<h1>{{.Retailer}}</h1>
+<p>Purchased {{.PurchaseDate}} at {{.PurchaseTime}}</p>
+<table>
+<thead><tr><th>Item</th><th>Price</th></tr></thead>
+<tbody>
+{{- range .Items}}
+<tr><td>{{.ShortDescription}}</td><td>{{.Price}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+<p>Total: {{.Total}}</p>
+<p>Points: {{.Points}}</p>
+</body>
+</html>
+`))
+
+// Handler to export a receipt in the format chosen by ?format= or the Accept header
+func (s *server) exportReceiptHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if s.rejectStoreLight(w, r) {
+		return
+	}
+	format := s.chooseFormat(w, r, exportFormats)
+	if format == "" {
+		return
+	}
+
+	shard := s.store.Shard(id)
+	shard.RLock()
+	receipt, exists := shard.Lookup(id)
+	p := shard.Points[id]
+	_, archived := shard.Archived[id]
+	createdAt := shard.StoredAt[id]
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	export := ReceiptExport{ID: id, Receipt: receipt, Points: p}
+
+	switch format {
+	case "application/json":
+		s.writeJSON(w, r, http.StatusOK, export)
+	case "text/csv":
+		w.Header().Set("Content-Type", "text/csv")
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"id", "retailer", "purchaseDate", "purchaseTime", "total", "itemCount", "points"})
+		writer.Write([]string{id, receipt.Retailer, receipt.PurchaseDate, receipt.PurchaseTime, receipt.Total,
+			strconv.Itoa(len(receipt.Items)), strconv.Itoa(p)})
+		writer.Flush()
+	case "application/pdf":
+		lines := []string{fmt.Sprintf("Purchased %s at %s", receipt.PurchaseDate, receipt.PurchaseTime), ""}
+		for _, item := range receipt.Items {
+			lines = append(lines, fmt.Sprintf("%s    %s", item.ShortDescription, item.Price))
+		}
+		lines = append(lines, "", "Total: "+receipt.Total, "Points: "+strconv.Itoa(p))
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(renderTextPDF(receipt.Retailer, lines))
+	case "text/html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		receiptHTMLTemplate.Execute(w, export)
+	case mediaXLSX:
+		s.writeXLSXDownload(w, r, "receipt-"+id+".xlsx", slices.Values([]exportedReceipt{{
+			ID: id, Receipt: receipt, Points: p, Archived: archived, CreatedAt: createdAt,
+		}}))
+	}
+}
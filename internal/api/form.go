@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"receipt-processor/internal/model"
+)
+
+const mediaForm = "application/x-www-form-urlencoded"
+
+// Limits on form-encoded submissions
+const (
+	maxFormBodyBytes = 64 << 10
+	maxFormFields    = 1000
+)
+
+// Indexed item fields, e.g. items[0].shortDescription
+var formItemField = regexp.MustCompile(`^items\[(\d+)\]\.(shortDescription|price)$`)
+
+// Problem with a form-encoded body, explained to the client as it is
+type formError struct {
+	message string
+}
+
+func (e *formError) Error() string {
+	return e.message
+}
+
+// Function to decode a form-encoded receipt. Fields may come in any order, but item indexes must run from
+// 0 without gaps; sparse indexes, repeated or unknown fields are rejected rather than guessed at
+func decodeFormReceipt(w http.ResponseWriter, r *http.Request) (model.Receipt, error) {
+	var receipt model.Receipt
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFormBodyBytes))
+	if err != nil {
+		return receipt, err
+	}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '<') {
+		return receipt, &formError{"The body is not form-encoded; send it with the Content-Type that matches it."}
+	}
+	if bytes.Count(body, []byte("&"))+1 > maxFormFields {
+		return receipt, &formError{fmt.Sprintf("The form has more than %d fields.", maxFormFields)}
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return receipt, &formError{"The form could not be parsed."}
+	}
+
+	fields := map[string]*string{
+		"retailer":     &receipt.Retailer,
+		"purchaseDate": &receipt.PurchaseDate,
+		"purchaseTime": &receipt.PurchaseTime,
+		"total":        &receipt.Total,
+	}
+	items := map[int]*model.Item{}
+	for key, value := range values {
+		if len(value) > 1 {
+			return receipt, &formError{fmt.Sprintf("The field %q is repeated.", key)}
+		}
+		if field, ok := fields[key]; ok {
+			*field = value[0]
+			continue
+		}
+		match := formItemField.FindStringSubmatch(key)
+		if match == nil {
+			return receipt, &formError{fmt.Sprintf("The field %q is not part of a receipt.", key)}
+		}
+		index, err := strconv.Atoi(match[1])
+		if err != nil || index >= maxFormFields {
+			return receipt, &formError{fmt.Sprintf("The item index in %q is out of range.", key)}
+		}
+		item := items[index]
+		if item == nil {
+			item = &model.Item{}
+			items[index] = item
+		}
+		if match[2] == "shortDescription" {
+			item.ShortDescription = value[0]
+		} else {
+			item.Price = value[0]
+		}
+	}
+	for index := range len(items) {
+		item, ok := items[index]
+		if !ok {
+			return receipt, &formError{fmt.Sprintf("Item indexes must run from 0 without gaps; items[%d] is missing.", index)}
+		}
+		receipt.Items = append(receipt.Items, *item)
+	}
+	return receipt, nil
+}
+
+// Function to pick the message for an undecodable body: form problems are spelled out, the rest stay generic
+func malformedBodyMessage(err error) string {
+	var formErr *formError
+	if errors.As(err, &formErr) {
+		return formErr.Error()
+	}
+	return "The receipt is invalid."
+}
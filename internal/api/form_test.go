@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/model"
+)
+
+func TestDecodeFormReceipt(t *testing.T) {
+	header := "retailer=Target&purchaseDate=2022-01-01&purchaseTime=13:01&total=7.74&"
+	tests := []struct {
+		name    string
+		body    string
+		items   []model.Item
+		message string
+	}{
+		{"in order", header + "items[0].shortDescription=Gum&items[0].price=1.25&items[1].shortDescription=Pepsi&items[1].price=6.49",
+			[]model.Item{{ShortDescription: "Gum", Price: "1.25"}, {ShortDescription: "Pepsi", Price: "6.49"}}, ""},
+		{"out of order", "items[1].price=6.49&total=7.74&items[0].price=1.25&items[1].shortDescription=Pepsi&retailer=Target&" +
+			"items[0].shortDescription=Gum&purchaseTime=13:01&purchaseDate=2022-01-01",
+			[]model.Item{{ShortDescription: "Gum", Price: "1.25"}, {ShortDescription: "Pepsi", Price: "6.49"}}, ""},
+		{"gap between indexes", header + "items[0].shortDescription=Gum&items[0].price=1.25&items[2].shortDescription=Pepsi&items[2].price=6.49",
+			nil, "items[1] is missing"},
+		{"indexes from 1", header + "items[1].shortDescription=Gum&items[1].price=1.25",
+			nil, "items[0] is missing"},
+		{"index past the field limit", header + "items[1000].shortDescription=Gum",
+			nil, "out of range"},
+		{"repeated index", header + "items[0].price=1.25&items[0].price=6.49",
+			nil, "is repeated"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(test.body))
+			receipt, err := decodeFormReceipt(httptest.NewRecorder(), request)
+			if test.message != "" {
+				if err == nil || !strings.Contains(malformedBodyMessage(err), test.message) {
+					t.Errorf("decodeFormReceipt = %v, want an error mentioning %q", err, test.message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if receipt.Retailer != "Target" || receipt.Total != "7.74" || !reflect.DeepEqual(receipt.Items, test.items) {
+				t.Errorf("decodeFormReceipt = %+v, want Target for 7.74 with items %+v", receipt, test.items)
+			}
+		})
+	}
+}
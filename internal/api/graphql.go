@@ -0,0 +1,695 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// Limits on GraphQL requests. Complexity counts one per field, with the fields under a list counted once per
+// element the list may hold
+const (
+	maxGraphQLBodyBytes  = 64 << 10
+	maxGraphQLDepth      = 10
+	maxGraphQLComplexity = 5000
+	defaultGraphQLFirst  = 20
+	maxGraphQLFirst      = 100
+)
+
+// Elements assumed for lists that take no first argument, for the complexity count
+var graphqlListSizes = map[string]int{
+	"items":     10,
+	"breakdown": len(points.Rules),
+	"retailers": 50,
+}
+
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+}
+
+// Body for a request that failed before execution; it has no data member at all
+type graphqlErrorResponse struct {
+	Errors []gqlerrors.FormattedError `json:"errors"`
+}
+
+type graphqlRuleScore struct {
+	Rule   string `json:"rule"`
+	Points int    `json:"points"`
+}
+
+type graphqlRetailer struct {
+	Retailer string `json:"retailer"`
+	Receipts int    `json:"receipts"`
+	Points   int    `json:"points"`
+}
+
+type graphqlReceiptSummary struct {
+	ID           string `json:"id"`
+	Retailer     string `json:"retailer"`
+	PurchaseDate string `json:"purchaseDate"`
+	Total        string `json:"total"`
+	Points       int    `json:"points"`
+}
+
+type graphqlProcessResult struct {
+	ID        string `json:"id"`
+	Points    int    `json:"points"`
+	Duplicate bool   `json:"duplicate"`
+}
+
+// Root value of one GraphQL request. Every list and aggregate in it reads the same snapshot, taken on first use;
+// mutations check the request for who is asking
+type graphqlRoot struct {
+	once       sync.Once
+	receipts   []store.Stored
+	request    *http.Request
+	store      store.Store
+	calculator *points.Calculator
+}
+
+func (root *graphqlRoot) snapshot() []store.Stored {
+	root.once.Do(func() { root.receipts = root.store.Snapshot() })
+	return root.receipts
+}
+
+var errGraphQLStoreLight = errors.New("Unsupported in store-light mode: full receipts are not retained.")
+
+// Function to make a Receipt field reading one value off the stored receipt
+func receiptField(kind graphql.Output, value func(store.Stored) any) *graphql.Field {
+	return &graphql.Field{Type: graphql.NewNonNull(kind), Resolve: func(p graphql.ResolveParams) (any, error) {
+		return value(p.Source.(store.Stored)), nil
+	}}
+}
+
+var graphqlItemType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Item",
+	Fields: graphql.Fields{
+		"shortDescription": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"price":            &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+var graphqlRuleScoreType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "RuleScore",
+	Fields: graphql.Fields{
+		"rule":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"points": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var graphqlReceiptType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Receipt",
+	Fields: graphql.Fields{
+		"id":           receiptField(graphql.ID, func(s store.Stored) any { return s.ID }),
+		"retailer":     receiptField(graphql.String, func(s store.Stored) any { return s.Receipt.Retailer }),
+		"purchaseDate": receiptField(graphql.String, func(s store.Stored) any { return s.Receipt.PurchaseDate }),
+		"purchaseTime": receiptField(graphql.String, func(s store.Stored) any { return s.Receipt.PurchaseTime }),
+		"total":        receiptField(graphql.String, func(s store.Stored) any { return s.Receipt.Total }),
+		"items": receiptField(graphql.NewList(graphql.NewNonNull(graphqlItemType)), func(s store.Stored) any {
+			return s.Receipt.Items
+		}),
+		"points":   receiptField(graphql.Int, func(s store.Stored) any { return s.Points }),
+		"archived": receiptField(graphql.Boolean, func(s store.Stored) any { return s.Archived }),
+		"pinned":   receiptField(graphql.Boolean, func(s store.Stored) any { return s.Pinned }),
+		// Points each rule awards under the active scoring config, in rule order
+		"breakdown": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphqlRuleScoreType))),
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				calculator := p.Info.RootValue.(*graphqlRoot).calculator
+				breakdown := calculator.Breakdown(p.Source.(store.Stored).Receipt)
+				scores := make([]graphqlRuleScore, 0, len(points.Rules))
+				for _, rule := range points.Rules {
+					scores = append(scores, graphqlRuleScore{Rule: rule.Name, Points: breakdown[rule.Name]})
+				}
+				return scores, nil
+			},
+		},
+	},
+})
+
+var graphqlReceiptConnectionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ReceiptConnection",
+	Fields: graphql.Fields{
+		"totalCount": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"edges": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.NewObject(graphql.ObjectConfig{
+			Name: "ReceiptEdge",
+			Fields: graphql.Fields{
+				"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"node":   &graphql.Field{Type: graphql.NewNonNull(graphqlReceiptType)},
+			},
+		}))))},
+		"pageInfo": &graphql.Field{Type: graphql.NewNonNull(graphql.NewObject(graphql.ObjectConfig{
+			Name: "PageInfo",
+			Fields: graphql.Fields{
+				"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+				"endCursor":   &graphql.Field{Type: graphql.String},
+			},
+		}))},
+	},
+})
+
+var graphqlReceiptFilterType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "ReceiptFilter",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"retailer":         &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"purchaseDateFrom": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"purchaseDateTo":   &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"minPoints":        &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		"maxPoints":        &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		"archived":         &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+		"pinned":           &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+	},
+})
+
+var graphqlStatsType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Stats",
+	Fields: graphql.Fields{
+		"mode":             &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"receipts":         &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"receiptBytes":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"openReservations": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var graphqlRetailerType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Retailer",
+	Fields: graphql.Fields{
+		"retailer": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"receipts": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"points":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var graphqlReceiptSummaryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ReceiptSummary",
+	Fields: graphql.Fields{
+		"id":           &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"retailer":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"purchaseDate": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"total":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"points":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var graphqlReceiptInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "ReceiptInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"retailer":     &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"purchaseDate": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"purchaseTime": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"total":        &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"items": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(
+			graphql.NewInputObject(graphql.InputObjectConfig{
+				Name: "ItemInput",
+				Fields: graphql.InputObjectConfigFieldMap{
+					"shortDescription": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+					"price":            &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+			}),
+		)))},
+	},
+})
+
+var graphqlProcessResultType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ProcessResult",
+	Fields: graphql.Fields{
+		"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"points":    &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"duplicate": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+	},
+})
+
+// Queries read the store; the two mutations are the receipt process endpoint and an admin-only delete
+func (s *server) mustBuildGraphQLSchema() graphql.Schema {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"receipt": &graphql.Field{
+				Type:    graphqlReceiptType,
+				Args:    graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: s.resolveGraphQLReceipt,
+			},
+			"receipts": &graphql.Field{
+				Type: graphql.NewNonNull(graphqlReceiptConnectionType),
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{Type: graphqlReceiptFilterType},
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultGraphQLFirst},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: s.resolveGraphQLReceipts,
+			},
+			// Offset paging over the same ID order, for clients that want a plain list
+			"receiptSummaries": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphqlReceiptSummaryType))),
+				Args: graphql.FieldConfigArgument{
+					"offset": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+					"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultGraphQLFirst},
+				},
+				Resolve: s.resolveGraphQLReceiptSummaries,
+			},
+			"stats": &graphql.Field{
+				Type:    graphql.NewNonNull(graphqlStatsType),
+				Resolve: func(p graphql.ResolveParams) (any, error) { return s.currentStoreStats(), nil },
+			},
+			"retailers": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphqlRetailerType))),
+				Resolve: resolveGraphQLRetailers,
+			},
+		},
+	}), Mutation: graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"processReceipt": &graphql.Field{
+				Type:    graphql.NewNonNull(graphqlProcessResultType),
+				Args:    graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphqlReceiptInputType)}},
+				Resolve: s.resolveGraphQLProcessReceipt,
+			},
+			// True when a receipt was deleted, false when there was none with that ID; a pinned receipt is an error
+			"deleteReceipt": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.Boolean),
+				Args:    graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: s.resolveGraphQLDeleteReceipt,
+			},
+		},
+	})})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+func (s *server) resolveGraphQLReceipt(p graphql.ResolveParams) (any, error) {
+	if !s.retainReceipts {
+		return nil, errGraphQLStoreLight
+	}
+	id := p.Args["id"].(string)
+	shard := s.store.Shard(id)
+	shard.RLock()
+	defer shard.RUnlock()
+	receipt, exists := shard.Lookup(id)
+	if !exists {
+		return nil, nil
+	}
+	_, archived := shard.Archived[id]
+	return store.Stored{ID: id, Receipt: receipt, Points: shard.Points[id], Archived: archived, Pinned: shard.Pinned[id]}, nil
+}
+
+// Function to test a receipt against the receipts filter; dates compare as strings, which YYYY-MM-DD allows
+func matchesGraphQLFilter(stored store.Stored, filter map[string]any) bool {
+	if retailer, ok := filter["retailer"].(string); ok && stored.Receipt.Retailer != retailer {
+		return false
+	}
+	if from, ok := filter["purchaseDateFrom"].(string); ok && stored.Receipt.PurchaseDate < from {
+		return false
+	}
+	if to, ok := filter["purchaseDateTo"].(string); ok && stored.Receipt.PurchaseDate > to {
+		return false
+	}
+	if least, ok := filter["minPoints"].(int); ok && stored.Points < least {
+		return false
+	}
+	if most, ok := filter["maxPoints"].(int); ok && stored.Points > most {
+		return false
+	}
+	if archived, ok := filter["archived"].(bool); ok && stored.Archived != archived {
+		return false
+	}
+	if pinned, ok := filter["pinned"].(bool); ok && stored.Pinned != pinned {
+		return false
+	}
+	return true
+}
+
+// Receipts are listed in ID order; a cursor is the receipt's ID, encoded so clients treat it as opaque
+func (s *server) resolveGraphQLReceipts(p graphql.ResolveParams) (any, error) {
+	if !s.retainReceipts {
+		return nil, errGraphQLStoreLight
+	}
+	first, _ := p.Args["first"].(int)
+	if first < 0 || first > maxGraphQLFirst {
+		return nil, fmt.Errorf("first must be from 0 to %d.", maxGraphQLFirst)
+	}
+	after := ""
+	if cursor, ok := p.Args["after"].(string); ok {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, errors.New("The after cursor is not valid.")
+		}
+		after = string(decoded)
+	}
+	filter, _ := p.Args["filter"].(map[string]any)
+
+	var matched []store.Stored
+	for _, stored := range p.Info.RootValue.(*graphqlRoot).snapshot() {
+		if matchesGraphQLFilter(stored, filter) {
+			matched = append(matched, stored)
+		}
+	}
+	start, _ := slices.BinarySearchFunc(matched, after, func(stored store.Stored, id string) int {
+		return strings.Compare(stored.ID, id)
+	})
+	if start < len(matched) && matched[start].ID == after {
+		start++
+	}
+	page := matched[start:min(start+first, len(matched))]
+
+	edges := make([]map[string]any, 0, len(page))
+	for _, stored := range page {
+		edges = append(edges, map[string]any{"cursor": base64.RawURLEncoding.EncodeToString([]byte(stored.ID)), "node": stored})
+	}
+	pageInfo := map[string]any{"hasNextPage": start+len(page) < len(matched), "endCursor": nil}
+	if len(edges) > 0 {
+		pageInfo["endCursor"] = edges[len(edges)-1]["cursor"]
+	}
+	return map[string]any{"totalCount": len(matched), "edges": edges, "pageInfo": pageInfo}, nil
+}
+
+func (s *server) resolveGraphQLReceiptSummaries(p graphql.ResolveParams) (any, error) {
+	if !s.retainReceipts {
+		return nil, errGraphQLStoreLight
+	}
+	offset, _ := p.Args["offset"].(int)
+	limit, _ := p.Args["limit"].(int)
+	if offset < 0 {
+		return nil, errors.New("offset must not be negative.")
+	}
+	if limit < 0 || limit > maxGraphQLFirst {
+		return nil, fmt.Errorf("limit must be from 0 to %d.", maxGraphQLFirst)
+	}
+	snapshot := p.Info.RootValue.(*graphqlRoot).snapshot()
+	page := snapshot[min(offset, len(snapshot)):min(offset+limit, len(snapshot))]
+	summaries := make([]graphqlReceiptSummary, 0, len(page))
+	for _, stored := range page {
+		summaries = append(summaries, graphqlReceiptSummary{ID: stored.ID, Retailer: stored.Receipt.Retailer,
+			PurchaseDate: stored.Receipt.PurchaseDate, Total: stored.Receipt.Total, Points: stored.Points})
+	}
+	return summaries, nil
+}
+
+// Retailers by name, with how many receipts each has and the points those hold
+func resolveGraphQLRetailers(p graphql.ResolveParams) (any, error) {
+	byName := make(map[string]*graphqlRetailer)
+	for _, stored := range p.Info.RootValue.(*graphqlRoot).snapshot() {
+		retailer, seen := byName[stored.Receipt.Retailer]
+		if !seen {
+			retailer = &graphqlRetailer{Retailer: stored.Receipt.Retailer}
+			byName[stored.Receipt.Retailer] = retailer
+		}
+		retailer.Receipts++
+		retailer.Points += stored.Points
+	}
+	retailers := make([]graphqlRetailer, 0, len(byName))
+	for _, retailer := range byName {
+		retailers = append(retailers, *retailer)
+	}
+	slices.SortFunc(retailers, func(a, b graphqlRetailer) int { return strings.Compare(a.Retailer, b.Retailer) })
+	return retailers, nil
+}
+
+// Scored and stored as POST /receipts/process would, without its body options: no overrides, no raw body, no image
+func (s *server) resolveGraphQLProcessReceipt(p graphql.ResolveParams) (any, error) {
+	r := p.Info.RootValue.(*graphqlRoot).request
+	if !s.addressAllowed(s.writeAllow, r) {
+		return nil, errors.New("Access from this address is not allowed.")
+	}
+	input, err := json.Marshal(p.Args["input"])
+	if err != nil {
+		return nil, err
+	}
+	var receipt model.Receipt
+	if err := json.Unmarshal(input, &receipt); err != nil {
+		return nil, err
+	}
+	err = s.normalizeReceipt(&receipt)
+	if err == nil {
+		err = s.validateReceipt(receipt)
+	}
+	if err != nil {
+		s.metrics.validationFailures.WithLabelValues(validationReason(err)).Inc()
+		return nil, err
+	}
+
+	ctx := p.Context
+	awarded := s.calculator.Calculate(receipt)
+	tenant := s.tenantFromRequest(r)
+	apiKey, _ := s.apiKeyIdentity(r)
+	id, duplicate, err := s.insertReceipt(ctx, submission{
+		receipt:     receipt,
+		points:      awarded,
+		fingerprint: fingerprintReceipt(receipt),
+		actor:       s.requestActor(r),
+		tenant:      tenant,
+		apiKey:      apiKey,
+	})
+	var quotaErr *keyQuotaError
+	if errors.As(err, &quotaErr) {
+		return nil, quotaErr
+	}
+	if errors.Is(err, errQuotaExceeded) {
+		return nil, errors.New("Daily receipt quota exceeded.")
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "storing receipt failed", "requestId", requestIDFromContext(ctx), "error", err)
+		return nil, errors.New("The receipt could not be stored.")
+	}
+	if !duplicate {
+		s.metrics.receiptsProcessed.Inc()
+		s.metrics.pointsAwarded.Add(float64(awarded))
+	}
+	return graphqlProcessResult{ID: id, Points: awarded, Duplicate: duplicate}, nil
+}
+
+func (s *server) resolveGraphQLDeleteReceipt(p graphql.ResolveParams) (any, error) {
+	r := p.Info.RootValue.(*graphqlRoot).request
+	if !s.isAdminRequest(r) {
+		return nil, errors.New("Deleting receipts requires an admin token.")
+	}
+	id := p.Args["id"].(string)
+	err := s.deleteReceipt(p.Context, id, s.requestActor(r))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if errors.Is(err, ErrConflict) {
+		return nil, err
+	}
+	if err != nil {
+		s.logger.ErrorContext(p.Context, "deleting receipt failed", "requestId", requestIDFromContext(p.Context), "receiptId", id, "error", err)
+		return nil, errors.New("The receipt could not be deleted.")
+	}
+	return true, nil
+}
+
+// Function to read a GraphQL request from a GET query string or a JSON POST body
+func readGraphQLRequest(w http.ResponseWriter, r *http.Request) (graphqlRequest, error) {
+	var request graphqlRequest
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		request.Query = query.Get("query")
+		request.OperationName = query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				return request, errors.New("The variables must be a JSON object.")
+			}
+		}
+	} else {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGraphQLBodyBytes))
+		if err != nil {
+			return request, fmt.Errorf("The request body must be at most %d bytes.", maxGraphQLBodyBytes)
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return request, errors.New("The request body must be a JSON object with a query.")
+		}
+	}
+	if strings.TrimSpace(request.Query) == "" {
+		return request, errors.New("A query is required.")
+	}
+	return request, nil
+}
+
+// Function to check that every operation in a document is within the depth and complexity limits. Mutations
+// are only taken over POST, so a link or an image tag cannot change anything
+func checkGraphQLDocument(document *ast.Document, method string, variables map[string]any) error {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operation.Operation == ast.OperationTypeMutation && method != http.MethodPost {
+			return gqlerrors.NewLocatedError("Mutations must be sent with POST.", []ast.Node{operation})
+		}
+		if operation.Operation != ast.OperationTypeQuery && operation.Operation != ast.OperationTypeMutation {
+			return gqlerrors.NewLocatedError("Subscriptions are not supported.", []ast.Node{operation})
+		}
+		cost, depth := graphqlSelectionCost(operation.SelectionSet, fragments, variables)
+		if depth > maxGraphQLDepth {
+			return gqlerrors.NewLocatedError(fmt.Sprintf("The query is nested %d levels deep; at most %d are allowed.",
+				depth, maxGraphQLDepth), []ast.Node{operation})
+		}
+		if cost > maxGraphQLComplexity {
+			return gqlerrors.NewLocatedError(fmt.Sprintf("The query has a complexity of %d; at most %d is allowed.",
+				cost, maxGraphQLComplexity), []ast.Node{operation})
+		}
+	}
+	return nil
+}
+
+// Function to count the fields a selection set can resolve and how deep it reaches. Runs after validation,
+// which rules out fragment cycles
+func graphqlSelectionCost(set *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, variables map[string]any) (cost, depth int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, selection := range set.Selections {
+		var childCost, childDepth int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			childCost, childDepth = graphqlSelectionCost(selection.SelectionSet, fragments, variables)
+			childCost = 1 + childCost*graphqlListSize(selection, variables)
+			childDepth++
+		case *ast.InlineFragment:
+			childCost, childDepth = graphqlSelectionCost(selection.SelectionSet, fragments, variables)
+		case *ast.FragmentSpread:
+			if fragment, ok := fragments[selection.Name.Value]; ok {
+				childCost, childDepth = graphqlSelectionCost(fragment.SelectionSet, fragments, variables)
+			}
+		}
+		cost += childCost
+		depth = max(depth, childDepth)
+	}
+	return cost, depth
+}
+
+// Function to give how many elements a field may return: its first or limit argument when it has one
+func graphqlListSize(field *ast.Field, variables map[string]any) int {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != "first" && argument.Name.Value != "limit" {
+			continue
+		}
+		var value any = argument.Value.GetValue()
+		if variable, ok := argument.Value.(*ast.Variable); ok {
+			value = variables[variable.Name.Value]
+		}
+		switch first := value.(type) {
+		case string:
+			if n, err := strconv.Atoi(first); err == nil {
+				return max(min(n, maxGraphQLFirst), 1)
+			}
+		case float64:
+			return max(min(int(first), maxGraphQLFirst), 1)
+		}
+		return maxGraphQLFirst
+	}
+	if field.Name.Value == "receipts" || field.Name.Value == "receiptSummaries" {
+		return defaultGraphQLFirst
+	}
+	return max(graphqlListSizes[field.Name.Value], 1)
+}
+
+// Function to answer a request that failed before execution, in the spec's error format
+func writeGraphQLErrors(w http.ResponseWriter, status int, errs ...error) {
+	w.Header().Set("Content-Type", mediaJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(graphqlErrorResponse{Errors: gqlerrors.FormatErrors(errs...)})
+}
+
+// Handler to run a GraphQL request over the receipts, from GET or a JSON POST
+func (s *server) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	request, err := readGraphQLRequest(w, r)
+	if err != nil {
+		writeGraphQLErrors(w, http.StatusBadRequest, err)
+		return
+	}
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+		Body: []byte(request.Query),
+		Name: "GraphQL request",
+	})})
+	if err != nil {
+		writeGraphQLErrors(w, http.StatusBadRequest, err)
+		return
+	}
+	if validation := graphql.ValidateDocument(&s.graphqlSchema, document, nil); !validation.IsValid {
+		w.Header().Set("Content-Type", mediaJSON)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(graphqlErrorResponse{Errors: validation.Errors})
+		return
+	}
+	if err := checkGraphQLDocument(document, r.Method, request.Variables); err != nil {
+		writeGraphQLErrors(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        s.graphqlSchema,
+		Root:          &graphqlRoot{request: r, store: s.store, calculator: s.calculator},
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       r.Context(),
+	})
+	w.Header().Set("Content-Type", mediaJSON)
+	json.NewEncoder(w).Encode(result)
+}
+
+// GraphiQL, pinned, from a CDN; the only inline script is the one carrying the page's nonce
+var graphiqlTemplate = template.Must(template.New("graphiql").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Receipt processor GraphiQL</title>
+<link rel="stylesheet" href="https://unpkg.com/graphiql@3.8.3/graphiql.min.css">
+<style nonce="{{.}}">body { margin: 0; height: 100vh; } #graphiql { height: 100vh; }</style>
+</head>
+<body>
+<div id="graphiql">Loading GraphiQL...</div>
+<script crossorigin src="https://unpkg.com/react@18.3.1/umd/react.production.min.js"></script>
+<script crossorigin src="https://unpkg.com/react-dom@18.3.1/umd/react-dom.production.min.js"></script>
+<script crossorigin src="https://unpkg.com/graphiql@3.8.3/graphiql.min.js"></script>
+<script nonce="{{.}}">
+const fetcher = GraphiQL.createFetcher({ url: "/graphql" });
+ReactDOM.createRoot(document.getElementById("graphiql")).render(React.createElement(GraphiQL, { fetcher }));
+</script>
+</body>
+</html>
+`))
+
+// Policy for the GraphiQL page in place of the configured one, which allows no scripts. GraphiQL's editor sets
+// inline styles, so styles cannot be limited to the nonce
+const graphiqlContentSecurityPolicy = "default-src 'none'; script-src 'nonce-%[1]s' https://unpkg.com; " +
+	"style-src 'unsafe-inline' https://unpkg.com; font-src https://unpkg.com data:; img-src 'self' data:; " +
+	"connect-src 'self'; frame-ancestors 'none'"
+
+// Handler to serve GraphiQL pointed at /graphql. API keys and the admin token go in its headers pane
+func graphiqlHandler(w http.ResponseWriter, r *http.Request) {
+	nonce := rand.Text()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", fmt.Sprintf(graphiqlContentSecurityPolicy, nonce))
+	w.Header().Set("Cache-Control", "no-store")
+	graphiqlTemplate.Execute(w, nonce)
+}
@@ -0,0 +1,400 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/store"
+	"receipt-processor/receiptpb"
+)
+
+// Page sizes for ListReceipts
+const (
+	defaultListPageSize = 50
+	maxListPageSize     = 500
+)
+
+// Request field each validation reason points at, for the InvalidArgument details
+var validationFields = map[string]string{
+	"missing_field":      "receipt",
+	"retailer":           "receipt.retailer",
+	"purchase_date":      "receipt.purchase_date",
+	"purchase_time":      "receipt.purchase_time",
+	"total":              "receipt.total",
+	"item_missing_field": "receipt.items",
+	"item_description":   "receipt.items.short_description",
+	"item_price":         "receipt.items.price",
+	"normalizer":         "receipt",
+
+	"retailer_length":         "receipt.retailer",
+	"purchase_date_length":    "receipt.purchase_date",
+	"purchase_time_length":    "receipt.purchase_time",
+	"total_length":            "receipt.total",
+	"item_description_length": "receipt.items.short_description",
+	"item_price_length":       "receipt.items.price",
+	"too_many_items":          "receipt.items",
+	"too_few_items":           "receipt.items",
+}
+
+// The gRPC service, working on the same store and scoring as the HTTP handlers
+type receiptService struct {
+	receiptpb.UnimplementedReceiptServiceServer
+	*server
+}
+
+// Function to build the gRPC server with the receipt, health and reflection services
+func (s *server) newGRPCServer() *grpc.Server {
+	// Interceptors run outermost first, in the order the HTTP middleware wraps a request
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		s.grpcLimitInterceptor,
+		s.grpcAuthInterceptor,
+		s.grpcMaintenanceInterceptor,
+		s.grpcChaosInterceptor,
+		s.grpcWriteAllowInterceptor,
+	))
+	receiptpb.RegisterReceiptServiceServer(server, receiptService{server: s})
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(receiptpb.ReceiptService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+	return server
+}
+
+// Function to stop the gRPC server, letting in-flight calls finish until ctx runs out
+func stopGRPCServer(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
+
+// Function to read the first value of a metadata key sent with a call
+func metadataValue(ctx context.Context, key string) string {
+	values := metadata.ValueFromIncomingContext(ctx, key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Interceptor requiring the same API key or admin token as the HTTP routes; health and reflection stay open
+func (s *server) grpcAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !isReceiptServiceCall(info) {
+		return handler(ctx, req)
+	}
+	identity, ok := s.lookupAPIKey(metadataValue(ctx, "x-api-key"))
+	if !ok && s.isAdminToken(metadataValue(ctx, "x-admin-token")) {
+		identity, ok = "admin", true
+	}
+	if !ok {
+		if len(s.apiKeys) > 0 {
+			return nil, status.Error(codes.Unauthenticated, "A valid API key is required.")
+		}
+		return handler(ctx, req)
+	}
+	return handler(context.WithValue(ctx, identityKey{}, identity), req)
+}
+
+// Function to tell whether a call is to the receipt service, rather than health or reflection
+func isReceiptServiceCall(info *grpc.UnaryServerInfo) bool {
+	return strings.HasPrefix(info.FullMethod, "/"+receiptpb.ReceiptService_ServiceDesc.ServiceName+"/")
+}
+
+// Function to tell whether a receipt service call changes the store; those are the calls counted as writes
+func isGRPCWrite(info *grpc.UnaryServerInfo) bool {
+	return info.FullMethod == receiptpb.ReceiptService_ProcessReceipt_FullMethodName
+}
+
+// Interceptor to cap in-flight calls with the same read and write slots as the HTTP routes
+func (s *server) grpcLimitInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	limit := s.readLimit
+	if isGRPCWrite(info) {
+		limit = s.writeLimit
+	}
+	if limit.slots == nil || !isReceiptServiceCall(info) {
+		return handler(ctx, req)
+	}
+	if !limit.acquire(ctx, s.limitWait) {
+		s.metrics.limiterShed.WithLabelValues(limit.class).Inc()
+		return nil, status.Error(codes.Unavailable, "The server is overloaded, please retry later.")
+	}
+	inFlight := s.metrics.limiterInFlight.WithLabelValues(limit.class)
+	inFlight.Inc()
+	defer func() {
+		inFlight.Dec()
+		limit.release()
+	}()
+	return handler(ctx, req)
+}
+
+// Interceptor to refuse writes with Unavailable during maintenance, telling clients when to retry
+func (s *server) grpcMaintenanceInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	state := s.maintenance.Load()
+	if state == nil || !isGRPCWrite(info) {
+		return handler(ctx, req)
+	}
+	retryAfter := 120 * time.Second
+	if !state.Until.IsZero() {
+		retryAfter = max(time.Second, time.Until(state.Until))
+	}
+	unavailable := status.New(codes.Unavailable, state.Message)
+	if detailed, err := unavailable.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)}); err == nil {
+		unavailable = detailed
+	}
+	return nil, unavailable.Err()
+}
+
+// Interceptor to delay or fail a random share of receipt service calls while chaos mode is on
+func (s *server) grpcChaosInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	state := s.chaos.Load()
+	if state == nil || !isReceiptServiceCall(info) {
+		return handler(ctx, req)
+	}
+	if state.Latency > 0 && rand.Float64() < state.LatencyRate {
+		timer := time.NewTimer(state.Latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if rand.Float64() < state.FailureRate {
+		return nil, status.Error(codes.Internal, "Injected failure (chaos mode).")
+	}
+	return handler(ctx, req)
+}
+
+// Interceptor to refuse writes from peers outside the writeAllow ranges. gRPC calls carry no forwarding headers,
+// so the peer address is checked as it is
+func (s *server) grpcWriteAllowInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if s.writeAllow == nil || !isGRPCWrite(info) || addressInPrefixes(s.writeAllow, grpcPeerIP(ctx)) {
+		return handler(ctx, req)
+	}
+	return nil, status.Error(codes.PermissionDenied, "Access from this address is not allowed.")
+}
+
+// Function to read a call's peer address, "unix" for Unix socket peers and "" when it has none
+func grpcPeerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if p.Addr.Network() == "unix" {
+		return "unix"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// Function to name who made a call, like requestActor does for HTTP
+func grpcActor(ctx context.Context) string {
+	if identity := identityFromContext(ctx); identity != "" {
+		return identity
+	}
+	if ip := grpcPeerIP(ctx); ip != "" {
+		return "client:" + ip
+	}
+	return "client:unknown"
+}
+
+// Function to pick the quota tenant of a call, trusting x-tenant-id as tenantFromRequest does for HTTP
+func (s *server) grpcTenant(ctx context.Context) string {
+	tenant := metadataValue(ctx, "x-tenant-id")
+	if tenant != "" && (s.isAdminToken(metadataValue(ctx, "x-admin-token")) || s.isTrustedPeer(grpcPeerIP(ctx))) {
+		return tenant
+	}
+	return grpcActor(ctx)
+}
+
+func toProtoReceipt(receipt model.Receipt) *receiptpb.Receipt {
+	message := &receiptpb.Receipt{
+		Retailer:     receipt.Retailer,
+		PurchaseDate: receipt.PurchaseDate,
+		PurchaseTime: receipt.PurchaseTime,
+		Total:        receipt.Total,
+	}
+	for _, item := range receipt.Items {
+		message.Items = append(message.Items, &receiptpb.Item{ShortDescription: item.ShortDescription, Price: item.Price})
+	}
+	return message
+}
+
+func fromProtoReceipt(message *receiptpb.Receipt) model.Receipt {
+	receipt := model.Receipt{
+		Retailer:     message.GetRetailer(),
+		PurchaseDate: message.GetPurchaseDate(),
+		PurchaseTime: message.GetPurchaseTime(),
+		Total:        message.GetTotal(),
+	}
+	for _, item := range message.GetItems() {
+		receipt.Items = append(receipt.Items, model.Item{ShortDescription: item.GetShortDescription(), Price: item.GetPrice()})
+	}
+	return receipt
+}
+
+// Function to report a validation failure as InvalidArgument naming the offending field
+func invalidReceiptStatus(reason string) error {
+	invalid := status.New(codes.InvalidArgument, "The receipt is invalid.")
+	detailed, err := invalid.WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: validationFields[reason], Description: reason}},
+	})
+	if err != nil {
+		return invalid.Err()
+	}
+	return detailed.Err()
+}
+
+// Function to reject unknown and malformed receipt IDs the way withReceiptID does
+func checkReceiptID(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return status.Error(codes.NotFound, ErrNotFound.Error())
+	}
+	return nil
+}
+
+func (service receiptService) ProcessReceipt(ctx context.Context, request *receiptpb.ProcessReceiptRequest) (*receiptpb.ProcessReceiptResponse, error) {
+	receipt := fromProtoReceipt(request.GetReceipt())
+	err := service.normalizeReceipt(&receipt)
+	if err == nil {
+		err = service.validateReceipt(receipt)
+	}
+	if err != nil {
+		reason := validationReason(err)
+		service.metrics.validationFailures.WithLabelValues(reason).Inc()
+		return nil, invalidReceiptStatus(reason)
+	}
+
+	awarded := service.calculator.Calculate(receipt)
+	apiKey, _ := service.lookupAPIKey(metadataValue(ctx, "x-api-key"))
+	id, duplicate, err := service.insertReceipt(ctx, submission{
+		receipt:     receipt,
+		points:      awarded,
+		fingerprint: fingerprintReceipt(receipt),
+		actor:       grpcActor(ctx),
+		tenant:      service.grpcTenant(ctx),
+		apiKey:      apiKey,
+	})
+	var quotaErr *keyQuotaError
+	switch {
+	case errors.As(err, &quotaErr):
+		return nil, status.Error(codes.ResourceExhausted, quotaErr.Error())
+	case errors.Is(err, errQuotaExceeded):
+		return nil, status.Error(codes.ResourceExhausted, "Daily receipt quota exceeded.")
+	case err != nil && ctx.Err() != nil:
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case err != nil:
+		service.logger.ErrorContext(ctx, "storing receipt failed", "error", err)
+		return nil, status.Error(codes.Internal, "The receipt could not be stored.")
+	}
+	if !duplicate {
+		service.metrics.receiptsProcessed.Inc()
+		service.metrics.pointsAwarded.Add(float64(awarded))
+	}
+	return &receiptpb.ProcessReceiptResponse{Id: id, Duplicate: duplicate}, nil
+}
+
+func (service receiptService) GetPoints(ctx context.Context, request *receiptpb.GetPointsRequest) (*receiptpb.GetPointsResponse, error) {
+	if err := checkReceiptID(request.GetId()); err != nil {
+		return nil, err
+	}
+	shard := service.store.Shard(request.GetId())
+	shard.RLock()
+	points, exists := shard.Points[request.GetId()]
+	shard.RUnlock()
+	if !exists {
+		return nil, status.Error(codes.NotFound, ErrNotFound.Error())
+	}
+	return &receiptpb.GetPointsResponse{Points: int64(points)}, nil
+}
+
+func (service receiptService) GetReceipt(ctx context.Context, request *receiptpb.GetReceiptRequest) (*receiptpb.GetReceiptResponse, error) {
+	if !service.retainReceipts {
+		return nil, status.Error(codes.FailedPrecondition, "Unsupported in store-light mode: full receipts are not retained.")
+	}
+	if err := checkReceiptID(request.GetId()); err != nil {
+		return nil, err
+	}
+	receipt, archived, exists, err := service.readReceipt(request.GetId())
+	if err != nil {
+		service.logger.ErrorContext(ctx, "migrating receipt failed", "receiptId", request.GetId(), "error", err)
+		return nil, status.Error(codes.Internal, "The receipt could not be read.")
+	}
+	if !exists {
+		return nil, status.Error(codes.NotFound, ErrNotFound.Error())
+	}
+	return &receiptpb.GetReceiptResponse{Receipt: toProtoReceipt(receipt), Archived: archived}, nil
+}
+
+// Receipts are listed in ID order, only the pinned ones when the request asks; the page token is the last ID of the
+// previous page
+func (service receiptService) ListReceipts(ctx context.Context, request *receiptpb.ListReceiptsRequest) (*receiptpb.ListReceiptsResponse, error) {
+	if !service.retainReceipts {
+		return nil, status.Error(codes.FailedPrecondition, "Unsupported in store-light mode: full receipts are not retained.")
+	}
+	size := int(request.GetPageSize())
+	if size < 0 {
+		return nil, status.Error(codes.InvalidArgument, "The page size must not be negative.")
+	}
+	if size == 0 {
+		size = defaultListPageSize
+	}
+	size = min(size, maxListPageSize)
+
+	// The snapshot is in ID order, so the page starts right after the token and one receipt past it is enough to
+	// tell whether another page follows
+	snapshot := service.store.Snapshot()
+	if request.GetPinned() {
+		snapshot = slices.DeleteFunc(snapshot, func(stored store.Stored) bool { return !stored.Pinned })
+	}
+	start, _ := slices.BinarySearchFunc(snapshot, request.GetPageToken(), func(stored store.Stored, token string) int {
+		return strings.Compare(stored.ID, token)
+	})
+	for start < len(snapshot) && snapshot[start].ID <= request.GetPageToken() {
+		start++
+	}
+	var page []*receiptpb.ListedReceipt
+	for _, stored := range snapshot[start:min(len(snapshot), start+size+1)] {
+		page = append(page, &receiptpb.ListedReceipt{
+			Id:       stored.ID,
+			Receipt:  toProtoReceipt(stored.Receipt),
+			Points:   int64(stored.Points),
+			Archived: stored.Archived,
+			Pinned:   stored.Pinned,
+		})
+	}
+
+	response := &receiptpb.ListReceiptsResponse{Receipts: page}
+	if len(page) > size {
+		response.Receipts = page[:size]
+		response.NextPageToken = page[size-1].Id
+	}
+	return response, nil
+}
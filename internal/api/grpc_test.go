@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"receipt-processor/receiptpb"
+)
+
+// Function to serve s over gRPC on an in-memory listener until the test ends, returning a connection to it
+func dialGRPC(t *testing.T, s *server) *grpc.ClientConn {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := s.newGRPCServer()
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCRoundTrip(t *testing.T) {
+	client := receiptpb.NewReceiptServiceClient(dialGRPC(t, newTestServer(t)))
+	ctx := context.Background()
+
+	target := toProtoReceipt(decodeReceipt(t, targetReceipt))
+	processed, err := client.ProcessReceipt(ctx, &receiptpb.ProcessReceiptRequest{Receipt: target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	points, err := client.GetPoints(ctx, &receiptpb.GetPointsRequest{Id: processed.GetId()})
+	if err != nil || points.GetPoints() != 28 {
+		t.Errorf("GetPoints = %d, %v, want 28", points.GetPoints(), err)
+	}
+	stored, err := client.GetReceipt(ctx, &receiptpb.GetReceiptRequest{Id: processed.GetId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fromProtoReceipt(stored.GetReceipt()); got.Retailer != "Target" || len(got.Items) != 5 || got.Total != "35.35" {
+		t.Errorf("GetReceipt = %+v, want the submitted receipt", got)
+	}
+
+	_, err = client.GetPoints(ctx, &receiptpb.GetPointsRequest{Id: "7fb1377b-b223-49d9-a31a-5a02701dd310"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetPoints of an unknown ID = %v, want NotFound", err)
+	}
+	invalid := toProtoReceipt(decodeReceipt(t, targetReceipt))
+	invalid.Total = "35.3"
+	_, err = client.ProcessReceipt(ctx, &receiptpb.ProcessReceiptRequest{Receipt: invalid})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("ProcessReceipt of an invalid receipt = %v, want InvalidArgument", err)
+	}
+	details := status.Convert(err).Details()
+	if len(details) != 1 || details[0].(*errdetails.BadRequest).GetFieldViolations()[0].GetField() != "receipt.total" {
+		t.Errorf("InvalidArgument details = %v, want a violation on receipt.total", details)
+	}
+}
+
+func TestGRPCListReceiptsPages(t *testing.T) {
+	client := receiptpb.NewReceiptServiceClient(dialGRPC(t, newTestServer(t)))
+	ctx := context.Background()
+	want := make(map[string]bool)
+	for i := range 5 {
+		receipt := toProtoReceipt(decodeReceipt(t, targetReceipt))
+		receipt.Retailer = "Target " + strconv.Itoa(i)
+		processed, err := client.ProcessReceipt(ctx, &receiptpb.ProcessReceiptRequest{Receipt: receipt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[processed.GetId()] = true
+	}
+
+	var pages []int
+	token, previous := "", ""
+	for {
+		page, err := client.ListReceipts(ctx, &receiptpb.ListReceiptsRequest{PageSize: 2, PageToken: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, len(page.GetReceipts()))
+		for _, listed := range page.GetReceipts() {
+			if listed.GetId() <= previous || !want[listed.GetId()] {
+				t.Errorf("listed %s after %s, want each stored receipt once in ID order", listed.GetId(), previous)
+			}
+			previous = listed.GetId()
+			delete(want, listed.GetId())
+		}
+		if token = page.GetNextPageToken(); token == "" {
+			break
+		}
+	}
+	if len(pages) != 3 || pages[0] != 2 || pages[1] != 2 || pages[2] != 1 || len(want) != 0 {
+		t.Errorf("pages of %v left %d receipts unlisted, want pages of 2, 2 and 1 covering all 5", pages, len(want))
+	}
+}
+
+func TestGRPCInterceptors(t *testing.T) {
+	ctx := context.Background()
+	receipt := toProtoReceipt(decodeReceipt(t, targetReceipt))
+	process := func(client receiptpb.ReceiptServiceClient) error {
+		_, err := client.ProcessReceipt(ctx, &receiptpb.ProcessReceiptRequest{Receipt: receipt})
+		return err
+	}
+	read := func(client receiptpb.ReceiptServiceClient) error {
+		_, err := client.ListReceipts(ctx, &receiptpb.ListReceiptsRequest{})
+		return err
+	}
+
+	t.Run("maintenance refuses writes", func(t *testing.T) {
+		s := newTestServer(t)
+		client := receiptpb.NewReceiptServiceClient(dialGRPC(t, s))
+		s.startMaintenance("", time.Minute)
+		err := process(client)
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("ProcessReceipt during maintenance = %v, want Unavailable", err)
+		}
+		details := status.Convert(err).Details()
+		if len(details) != 1 || details[0].(*errdetails.RetryInfo).GetRetryDelay().AsDuration() <= 0 {
+			t.Errorf("Unavailable details = %v, want a retry delay", details)
+		}
+		if err := read(client); err != nil {
+			t.Errorf("ListReceipts during maintenance = %v, want reads to keep working", err)
+		}
+	})
+
+	t.Run("chaos fails calls but not health checks", func(t *testing.T) {
+		s := newTestServer(t)
+		conn := dialGRPC(t, s)
+		s.chaos.Store(&chaosState{FailureRate: 1})
+		if err := read(receiptpb.NewReceiptServiceClient(conn)); status.Code(err) != codes.Internal {
+			t.Errorf("ListReceipts under chaos = %v, want Internal", err)
+		}
+		if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+			t.Errorf("health check under chaos = %v", err)
+		}
+	})
+
+	t.Run("limiter sheds writes past the cap", func(t *testing.T) {
+		s := newTestServer(t, WithLimits(LimitsConfig{MaxWrites: 1}))
+		client := receiptpb.NewReceiptServiceClient(dialGRPC(t, s))
+		// An HTTP write holds the only slot the gRPC calls share
+		s.writeLimit.slots <- struct{}{}
+		if err := process(client); status.Code(err) != codes.Unavailable {
+			t.Errorf("ProcessReceipt with no write slot = %v, want Unavailable", err)
+		}
+		if got := metricValue(t, s.metrics.limiterShed.WithLabelValues("write")); got != 1 {
+			t.Errorf("writes shed = %v, want 1", got)
+		}
+		s.writeLimit.release()
+		if err := process(client); err != nil {
+			t.Errorf("ProcessReceipt once the slot is free = %v", err)
+		}
+	})
+
+	t.Run("writeAllow refuses other peers", func(t *testing.T) {
+		config := defaultConfig()
+		config.WriteAllow = []string{"10.0.0.0/8"}
+		client := receiptpb.NewReceiptServiceClient(dialGRPC(t, newTestServer(t, WithConfig(config))))
+		if err := process(client); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("ProcessReceipt from outside writeAllow = %v, want PermissionDenied", err)
+		}
+		if err := read(client); err != nil {
+			t.Errorf("ListReceipts from outside writeAllow = %v, want reads to stay open", err)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// Responses smaller than this many bytes are sent uncompressed
+const defaultGzipMinSize = 1024
+
+// Response writer that buffers until it knows whether compression is worthwhile
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize     int
+	status      int
+	buf         bytes.Buffer
+	gz          *gzip.Writer
+	passthrough bool
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	if g.passthrough || !g.compressible() {
+		g.startPassthrough()
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf.Write(p)
+	if g.buf.Len() >= g.minSize {
+		if err := g.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Function to decide from the headers set so far whether the body may be compressed
+func (g *gzipResponseWriter) compressible() bool {
+	header := g.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		return false
+	}
+	return g.status != http.StatusNoContent && g.status != http.StatusNotModified
+}
+
+func (g *gzipResponseWriter) writeHeaderOnce() {
+	if !g.wroteHeader {
+		g.wroteHeader = true
+		g.ResponseWriter.WriteHeader(g.status)
+	}
+}
+
+func (g *gzipResponseWriter) startPassthrough() {
+	if g.passthrough {
+		return
+	}
+	g.passthrough = true
+	g.writeHeaderOnce()
+	if g.buf.Len() > 0 {
+		g.ResponseWriter.Write(g.buf.Bytes())
+		g.buf.Reset()
+	}
+}
+
+func (g *gzipResponseWriter) startGzip() error {
+	header := g.Header()
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	g.writeHeaderOnce()
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf.Bytes())
+	g.buf.Reset()
+	return err
+}
+
+// Function to flush whatever is pending; small buffered bodies are sent uncompressed
+func (g *gzipResponseWriter) flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+		return
+	}
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	g.startPassthrough()
+}
+
+func (g *gzipResponseWriter) finish() {
+	if g.gz != nil {
+		g.gz.Close()
+		return
+	}
+	if g.status == 0 && g.buf.Len() == 0 {
+		return
+	}
+	g.flush()
+}
+
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// Variant exposed when the underlying writer supports streaming
+type gzipFlushResponseWriter struct {
+	*gzipResponseWriter
+}
+
+func (g gzipFlushResponseWriter) Flush() {
+	g.flush()
+	g.ResponseWriter.(http.Flusher).Flush()
+}
+
+// Middleware to gzip responses for clients that accept it
+func gzipMiddleware(minSize int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: minSize}
+		defer gw.finish()
+		if _, ok := w.(http.Flusher); ok {
+			next.ServeHTTP(gzipFlushResponseWriter{gw}, r)
+			return
+		}
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// Function to check whether the client advertised gzip support
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(coding) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/points"
+)
+
+func TestGzipMiddleware(t *testing.T) {
+	large := strings.Repeat(`{"retailer":"Target"}`, 200)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		encoding       string
+		status         int
+		body           string
+		wantGzip       bool
+	}{
+		{"large body", "gzip", "application/json", "", http.StatusOK, large, true},
+		{"large body with a status", "gzip, deflate", "application/json", "", http.StatusCreated, large, true},
+		{"small body", "gzip", "application/json", "", http.StatusOK, `{"points":28}`, false},
+		{"client without gzip", "", "application/json", "", http.StatusOK, large, false},
+		{"gzip refused", "gzip;q=0", "application/json", "", http.StatusOK, large, false},
+		{"already encoded", "gzip", "application/json", "br", http.StatusOK, large, false},
+		{"event stream", "gzip", "text/event-stream", "", http.StatusOK, large, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := gzipMiddleware(defaultGzipMinSize, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				if test.encoding != "" {
+					w.Header().Set("Content-Encoding", test.encoding)
+				}
+				w.WriteHeader(test.status)
+				io.WriteString(w, test.body)
+			}))
+			request := httptest.NewRequest(http.MethodGet, "/receipts/export", nil)
+			request.Header.Set("Accept-Encoding", test.acceptEncoding)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("status %d, want %d", recorder.Code, test.status)
+			}
+			body := recorder.Body.Bytes()
+			if gzipped := recorder.Header().Get("Content-Encoding") == "gzip"; gzipped != test.wantGzip {
+				t.Fatalf("Content-Encoding %q, want gzip %v", recorder.Header().Get("Content-Encoding"), test.wantGzip)
+			}
+			if test.wantGzip {
+				body = gunzip(t, body)
+			}
+			if string(body) != test.body {
+				t.Errorf("body %.60q..., want %.60q...", body, test.body)
+			}
+		})
+	}
+}
+
+func TestGzipMiddlewareKeepsFlushing(t *testing.T) {
+	handler := gzipMiddleware(defaultGzipMinSize, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("the wrapped writer lost http.Flusher")
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		flusher.Flush()
+	}))
+	request := httptest.NewRequest(http.MethodGet, "/receipts/"+routeID+"/events", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if !recorder.Flushed || recorder.Body.String() != "data: first\n\n" {
+		t.Errorf("flushed %v with body %q, want the event flushed uncompressed", recorder.Flushed, recorder.Body)
+	}
+}
+
+func TestGzipRoundTripsAnExport(t *testing.T) {
+	server := startServer(t, points.DefaultConfig())
+	processReceipt(t, server, targetReceipt)
+	processReceipt(t, server, cornerMarketReceipt)
+
+	response, body := send(t, server, http.MethodGet, "/receipts/export", "", "Accept", mediaZip, "Accept-Encoding", "gzip")
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("export = %d with Content-Encoding %q", response.StatusCode, response.Header.Get("Content-Encoding"))
+	}
+	archive := gunzip(t, []byte(body))
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("decompressed export is not a zip archive: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, file := range reader.File {
+		names[file.Name] = true
+	}
+	if !names[dumpReceiptsFile] || !names[dumpManifestFile] {
+		t.Errorf("export holds %v, want %s and %s", names, dumpReceiptsFile, dumpManifestFile)
+	}
+}
+
+// Function to decompress a gzip body the test expects to be well formed
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"receipt-processor/internal/model"
+)
+
+type ReceiptHash struct {
+	Algorithm string `json:"algorithm"`
+	Hash      string `json:"hash"`
+}
+
+// Function to render a receipt as canonical JSON: object keys sorted, no whitespace, no HTML escaping
+func canonicalReceiptJSON(receipt model.Receipt) ([]byte, error) {
+	raw, err := json.Marshal(receipt)
+	if err != nil {
+		return nil, err
+	}
+	// Decoding into generic maps and re-encoding sorts every object's keys
+	var generic any
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(generic); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Handler to return the SHA-256 of a stored receipt's canonical JSON
+func (s *server) receiptHashHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if s.rejectStoreLight(w, r) {
+		return
+	}
+	shard := s.store.Shard(id)
+	shard.RLock()
+	receipt, exists := shard.Lookup(id)
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+
+	canonical, err := canonicalReceiptJSON(receipt)
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The receipt could not be hashed."))
+		return
+	}
+	sum := sha256.Sum256(canonical)
+	s.writeJSON(w, r, http.StatusOK, ReceiptHash{Algorithm: "sha256", Hash: hex.EncodeToString(sum[:])})
+}
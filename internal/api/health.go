@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Smoothing factor giving the moving average roughly the weight of the last 100 writes
+const writeLatencyAlpha = 2.0 / (100 + 1)
+
+type HealthStatus struct {
+	Status         string           `json:"status"`
+	WriteLatencyMs float64          `json:"writeLatencyMs"`
+	TotalRequests  int64            `json:"totalRequests"`
+	RequestCounts  map[string]int64 `json:"requestCounts"`
+}
+
+// Middleware to count every request; unrouted requests share one counter so odd paths cannot grow the map
+func (s *server) requestCountMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.totalRequests.Add(1)
+		key := "other"
+		if route := s.routeLabel(r); route != "other" {
+			key = r.Method + " " + route
+		}
+		s.routeRequestsMutex.RLock()
+		counter := s.routeRequestCounts[key]
+		s.routeRequestsMutex.RUnlock()
+		if counter == nil {
+			s.routeRequestsMutex.Lock()
+			if counter = s.routeRequestCounts[key]; counter == nil {
+				counter = new(atomic.Int64)
+				s.routeRequestCounts[key] = counter
+			}
+			s.routeRequestsMutex.Unlock()
+		}
+		counter.Add(1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Function to snapshot the request counters
+func (s *server) requestCounts() map[string]int64 {
+	s.routeRequestsMutex.RLock()
+	defer s.routeRequestsMutex.RUnlock()
+	counts := make(map[string]int64, len(s.routeRequestCounts))
+	for key, counter := range s.routeRequestCounts {
+		counts[key] = counter.Load()
+	}
+	return counts
+}
+
+// Function to fold one store write into the moving average; deferred with the write's start time
+func (s *server) observeWriteLatency(start time.Time) {
+	ms := float64(time.Since(start)) / float64(time.Millisecond)
+	s.writeLatencyMutex.Lock()
+	defer s.writeLatencyMutex.Unlock()
+	if !s.writeLatencySeen {
+		s.writeLatencyEMA = ms
+		s.writeLatencySeen = true
+		return
+	}
+	s.writeLatencyEMA += writeLatencyAlpha * (ms - s.writeLatencyEMA)
+}
+
+// Function to classify the store's recent write latency
+func (s *server) healthStatus() (HealthStatus, int) {
+	s.writeLatencyMutex.Lock()
+	defer s.writeLatencyMutex.Unlock()
+
+	status := HealthStatus{Status: "ok", WriteLatencyMs: s.writeLatencyEMA}
+	code := http.StatusOK
+	threshold := float64(s.healthLatencyThreshold) / float64(time.Millisecond)
+	switch {
+	case s.writeLatencyEMA > 5*threshold:
+		status.Status, code = "unhealthy", http.StatusServiceUnavailable
+	case s.writeLatencyEMA > threshold:
+		status.Status = "degraded"
+	}
+
+	// Warn once per transition rather than on every probe
+	degraded := status.Status != "ok"
+	if degraded && !s.healthDegraded {
+		s.logger.Warn("store write latency above threshold", "writeLatencyMs", s.writeLatencyEMA, "thresholdMs", threshold)
+	}
+	s.healthDegraded = degraded
+	return status, code
+}
+
+// Handler reporting liveness, degraded while store writes are slow and failing once they are far too slow
+func (s *server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	status, code := s.healthStatus()
+	status.TotalRequests = s.totalRequests.Load()
+	status.RequestCounts = s.requestCounts()
+	s.writeJSON(w, r, code, status)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"receipt-processor/internal/model"
+)
+
+// How long a submission's Idempotency-Key is remembered, so a retry within it gets the first answer back
+const idempotencyTTL = 24 * time.Hour
+
+// The outcome of the first submission made with an Idempotency-Key; response is empty while it is still running
+type idempotentSubmission struct {
+	response  model.ResponseID
+	duplicate bool
+	done      bool
+	expiresAt time.Time
+}
+
+// Function to scope a submission's Idempotency-Key to the tenant sending it, "" when there is none
+func (s *server) idempotencyScope(r *http.Request) string {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		return ""
+	}
+	return s.tenantFromRequest(r) + "\x00" + hashIdempotencyKey(key)
+}
+
+// Function to claim a submission's Idempotency-Key before it is processed. A key that already stored a receipt is
+// answered with that receipt's response again and one still being processed with 409; both return false. Requests
+// without a key always go ahead
+func (s *server) claimIdempotencyKey(w http.ResponseWriter, r *http.Request, scope string) bool {
+	if scope == "" {
+		return true
+	}
+	s.idempotencyMutex.Lock()
+	previous, exists := s.idempotencyKeys[scope]
+	if exists && s.clock().After(previous.expiresAt) {
+		exists = false
+	}
+	if !exists {
+		s.idempotencyKeys[scope] = &idempotentSubmission{expiresAt: s.clock().Add(idempotencyTTL)}
+		s.idempotencyMutex.Unlock()
+		return true
+	}
+	replay := *previous
+	s.idempotencyMutex.Unlock()
+
+	if !replay.done {
+		s.writeError(w, r, conflictError("A request with this Idempotency-Key is still being processed."))
+		return false
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	s.writeProcessResponse(w, r, replay.response, replay.duplicate)
+	return false
+}
+
+// Function to record the answer a keyed submission got, or to free its key when it stored nothing so a retry runs
+// again from the start
+func (s *server) settleIdempotencyKey(scope string, response *model.ResponseID, duplicate bool) {
+	if scope == "" {
+		return
+	}
+	s.idempotencyMutex.Lock()
+	defer s.idempotencyMutex.Unlock()
+	if response == nil {
+		delete(s.idempotencyKeys, scope)
+		return
+	}
+	submission := s.idempotencyKeys[scope]
+	submission.response, submission.duplicate, submission.done = *response, duplicate, true
+}
+
+// Function to forget keys past their TTL once a minute until stop is closed. Keys are not written to the WAL,
+// so a restart forgets them too
+func (s *server) expireIdempotencyKeysPeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			now := s.clock()
+			s.idempotencyMutex.Lock()
+			for scope, submission := range s.idempotencyKeys {
+				if submission.done && now.After(submission.expiresAt) {
+					delete(s.idempotencyKeys, scope)
+				}
+			}
+			s.idempotencyMutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"receipt-processor/internal/points"
+)
+
+func TestIdempotencyKeyReplaysTheFirstAnswer(t *testing.T) {
+	config := defaultConfig()
+	config.AdminToken = "admin-token"
+	server := startServer(t, points.DefaultConfig(), WithConfig(config))
+	first := processReceipt(t, server, targetReceipt, "Idempotency-Key", "retry-1")
+	response, body := send(t, server, http.MethodPost, "/receipts/process", targetReceipt, "Idempotency-Key", "retry-1")
+	if response.StatusCode != http.StatusOK || response.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry = %d %s, replayed %q, want a replayed 200", response.StatusCode, body, response.Header.Get("Idempotent-Replayed"))
+	}
+	if replayed := processReceipt(t, server, targetReceipt, "Idempotency-Key", "retry-1"); replayed != first {
+		t.Errorf("retry got ID %s, want %s", replayed, first)
+	}
+	if other := processReceipt(t, server, targetReceipt, "Idempotency-Key", "retry-2"); other == first {
+		t.Error("a different key got the first submission's ID")
+	}
+	// The key is scoped to its tenant, so another tenant reusing it stores its own receipt
+	if tenant := processReceipt(t, server, targetReceipt, "Idempotency-Key", "retry-1", "X-Tenant-ID", "acme", "X-Admin-Token", "admin-token"); tenant == first {
+		t.Error("another tenant's submission replayed the first tenant's answer")
+	}
+	// A client naming a tenant it is not trusted to speak for stays in its own scope
+	if untrusted := processReceipt(t, server, targetReceipt, "Idempotency-Key", "retry-1", "X-Tenant-ID", "acme"); untrusted != first {
+		t.Errorf("untrusted X-Tenant-ID got ID %s, want the replayed %s", untrusted, first)
+	}
+}
+
+func TestIdempotencyKeyInFlightConflicts(t *testing.T) {
+	s := newTestServer(t)
+	request := httptest.NewRequest(http.MethodPost, "/receipts/process", nil)
+	request.Header.Set("Idempotency-Key", "slow")
+	scope := s.idempotencyScope(request)
+	if !s.claimIdempotencyKey(httptest.NewRecorder(), request, scope) {
+		t.Fatal("the first claim was refused")
+	}
+	recorder := httptest.NewRecorder()
+	if s.claimIdempotencyKey(recorder, request, scope) || recorder.Code != http.StatusConflict {
+		t.Errorf("a claim while the first runs = %d, want 409", recorder.Code)
+	}
+	// A submission that stored nothing frees its key for the retry
+	s.settleIdempotencyKey(scope, nil, false)
+	if !s.claimIdempotencyKey(httptest.NewRecorder(), request, scope) {
+		t.Error("the key was still held after the first submission failed")
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const mediaMultipart = "multipart/form-data"
+
+// Largest receipt part read from a multipart submission
+const maxReceiptPartBytes = 1 << 20
+
+// Room left in a multipart body for part headers and boundaries
+const multipartOverheadBytes = 64 << 10
+
+// Image types accepted, as sniffed from the bytes rather than taken from the part's Content-Type
+var imageTypes = map[string]bool{"image/jpeg": true, "image/png": true}
+
+// Opaque blobs stored by key
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Open(key string) (io.ReadSeekCloser, error)
+	Delete(key string) error
+}
+
+// Blob store keeping one file per key in a local directory
+type localBlobStore struct {
+	dir string
+}
+
+func newLocalBlobStore(dir string) (*localBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating image directory: %w", err)
+	}
+	return &localBlobStore{dir: dir}, nil
+}
+
+// Function to map a key to its file; keys are receipt IDs, anything path-like is refused
+func (s *localBlobStore) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || key == "." || key == ".." {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+// Function to write a blob through a temporary file so readers never see half of it
+func (s *localBlobStore) Put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *localBlobStore) Open(key string) (io.ReadSeekCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *localBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Image sent along with a multipart submission; problem explains why it cannot be stored
+type attachedImage struct {
+	data    []byte
+	problem string
+}
+
+// Function to split a multipart submission into its receipt part, decoded like a JSON body, and its optional
+// image part. Image problems are recorded rather than returned so they never fail the receipt itself
+func (s *server) decodeMultipartProcess(w http.ResponseWriter, r *http.Request) (ProcessReceiptRequest, error) {
+	var request ProcessReceiptRequest
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxReceiptPartBytes+s.imageMaxBytes+multipartOverheadBytes))
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return request, err
+	}
+	found := false
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return request, err
+		}
+		switch part.FormName() {
+		case "receipt":
+			if err := json.NewDecoder(io.LimitReader(part, maxReceiptPartBytes)).Decode(&request); err != nil {
+				return request, err
+			}
+			found = true
+		case "image":
+			request.image = s.readImagePart(part)
+		}
+	}
+	if !found {
+		return request, errors.New("no receipt part")
+	}
+	return request, nil
+}
+
+func (s *server) readImagePart(part *multipart.Part) *attachedImage {
+	data, err := io.ReadAll(io.LimitReader(part, int64(s.imageMaxBytes)+1))
+	switch {
+	case err != nil:
+		return &attachedImage{problem: "the image could not be read"}
+	case len(data) > s.imageMaxBytes:
+		return &attachedImage{problem: fmt.Sprintf("the image is larger than %d bytes", s.imageMaxBytes)}
+	case !imageTypes[http.DetectContentType(data)]:
+		return &attachedImage{problem: "the image must be a JPEG or PNG"}
+	}
+	return &attachedImage{data: data}
+}
+
+// Function to store the image sent with a new receipt, returning what went wrong for the response, if anything
+func (s *server) storeReceiptImage(r *http.Request, id string, image *attachedImage) string {
+	if image.problem != "" {
+		return image.problem
+	}
+	if s.imageStore == nil {
+		return "image storage is not configured"
+	}
+	if err := s.imageStore.Put(id, image.data); err != nil {
+		s.logger.ErrorContext(r.Context(), "storing receipt image failed", "requestId", requestIDFromContext(r.Context()), "receiptId", id, "error", err)
+		return "the image could not be stored"
+	}
+	return ""
+}
+
+// Handler to send back the image stored with a receipt
+func (s *server) receiptImageHandler(w http.ResponseWriter, r *http.Request, id string) {
+	shard := s.store.Shard(id)
+	shard.RLock()
+	_, exists := shard.Lookup(id)
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	if s.imageStore == nil {
+		s.writeError(w, r, errorWithStatus(http.StatusNotFound, "No image stored for that receipt."))
+		return
+	}
+	image, err := s.imageStore.Open(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.writeError(w, r, errorWithStatus(http.StatusNotFound, "No image stored for that receipt."))
+		return
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "opening receipt image failed", "requestId", requestIDFromContext(r.Context()), "receiptId", id, "error", err)
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The image could not be read."))
+		return
+	}
+	defer image.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(image, head)
+	if _, err := image.Seek(0, io.SeekStart); err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The image could not be read."))
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(head[:n]))
+	http.ServeContent(w, r, "", time.Time{}, image)
+}
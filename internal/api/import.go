@@ -0,0 +1,250 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"receipt-processor/internal/model"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"golang.org/x/sync/errgroup"
+)
+
+// Limits on the outbound fetch made by an import
+const (
+	importFetchTimeout = 30 * time.Second
+	maxImportBytes     = 10 << 20
+)
+
+// Receipts an import stores before waiting for their records to be synced
+const importCommitBatch = 100
+
+var importClient = &http.Client{Timeout: importFetchTimeout}
+
+// Columns of a CSV import; consecutive rows with the same receipt fields are one receipt with several items
+var importCSVColumns = []string{"retailer", "purchaseDate", "purchaseTime", "total", "shortDescription", "price"}
+
+type ImportRequest struct {
+	URL    string `json:"url"`
+	APIKey string `json:"apiKey"`
+	Format string `json:"format"`
+}
+
+type ImportSummary struct {
+	Imported int      `json:"imported"`
+	Failed   int      `json:"failed"`
+	Errors   []string `json:"errors"`
+}
+
+// Function to fetch the raw receipts to import, sending the key as a bearer token
+func fetchImport(ctx context.Context, request ImportRequest) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, request.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if request.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+request.APIKey)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := importClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("source answered %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxImportBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxImportBytes {
+		return nil, fmt.Errorf("source response is larger than %d bytes", maxImportBytes)
+	}
+	return body, nil
+}
+
+// Function to parse a CSV import into receipts
+func parseImportCSV(body []byte) ([]model.Receipt, error) {
+	rows, err := csv.NewReader(strings.NewReader(string(body))).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || strings.Join(rows[0], ",") != strings.Join(importCSVColumns, ",") {
+		return nil, fmt.Errorf("the CSV header must be %s", strings.Join(importCSVColumns, ","))
+	}
+
+	var receipts []model.Receipt
+	for _, row := range rows[1:] {
+		item := model.Item{ShortDescription: row[4], Price: row[5]}
+		if n := len(receipts); n > 0 {
+			last := &receipts[n-1]
+			if last.Retailer == row[0] && last.PurchaseDate == row[1] && last.PurchaseTime == row[2] && last.Total == row[3] {
+				last.Items = append(last.Items, item)
+				continue
+			}
+		}
+		receipts = append(receipts, model.Receipt{Retailer: row[0], PurchaseDate: row[1], PurchaseTime: row[2], Total: row[3], Items: []model.Item{item}})
+	}
+	return receipts, nil
+}
+
+// Function to validate and score one imported receipt ready for storing
+func (s *server) prepareImport(receipt model.Receipt, actor, tenant string) (submission, error) {
+	err := s.normalizeReceipt(&receipt)
+	if err == nil {
+		err = s.validateReceipt(receipt)
+	}
+	if err != nil {
+		var invalid *ValidationError
+		if errors.As(err, &invalid) {
+			s.metrics.validationFailures.WithLabelValues(invalid.Reason()).Inc()
+			return submission{}, fmt.Errorf("invalid %s", invalid.Reason())
+		}
+		return submission{}, err
+	}
+	return submission{
+		receipt:     receipt,
+		points:      s.calculator.Calculate(receipt),
+		fingerprint: fingerprintReceipt(receipt),
+		actor:       actor,
+		tenant:      tenant,
+	}, nil
+}
+
+// Function to validate and score every imported receipt on a bounded pool of workers; results keep the input order
+func (s *server) prepareImports(ctx context.Context, receipts []model.Receipt, actor, tenant string) ([]submission, []error, error) {
+	subs := make([]submission, len(receipts))
+	errs := make([]error, len(receipts))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(s.importWorkers)
+	for i, receipt := range receipts {
+		if groupCtx.Err() != nil {
+			break
+		}
+		group.Go(func() error {
+			subs[i], errs[i] = s.prepareImport(receipt, actor, tenant)
+			return nil
+		})
+	}
+	group.Wait()
+	return subs, errs, ctx.Err()
+}
+
+// Function to store prepared imports, waiting on the acks of each importCommitBatch receipts together so group
+// commit can sync them at once; ids holds the stored receipt ID for every import that succeeded
+func (s *server) commitImports(ctx context.Context, subs []submission, errs []error) ([]string, error) {
+	ids := make([]string, len(subs))
+	pending := make(map[int]*pendingInsert)
+	for start := 0; start < len(subs); start += importCommitBatch {
+		end := min(start+importCommitBatch, len(subs))
+		began := time.Now()
+		for i := start; i < end; i++ {
+			if errs[i] != nil {
+				continue
+			}
+			id, duplicate, insert, err := s.storeSubmission(ctx, subs[i])
+			switch {
+			case err != nil:
+				errs[i] = err
+			case duplicate:
+				errs[i] = fmt.Errorf("duplicate of %s", id)
+			default:
+				ids[i] = id
+				pending[i] = insert
+			}
+		}
+		for i, insert := range pending {
+			if err := s.awaitInsert(ctx, insert); err != nil {
+				errs[i] = err
+				ids[i] = ""
+				continue
+			}
+			s.metrics.receiptsProcessed.Inc()
+			s.metrics.pointsAwarded.Add(float64(subs[i].points))
+		}
+		clear(pending)
+		s.observeWriteLatency(began)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// Handler to pull receipts from another system's API and store each valid one
+func (s *server) importFromURLHandler(w http.ResponseWriter, r *http.Request) {
+	var request ImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The import request is invalid."))
+		return
+	}
+	source, err := url.Parse(request.URL)
+	if err != nil || (source.Scheme != "https" && source.Scheme != "http") || source.Host == "" {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The import url must be an http or https URL."))
+		return
+	}
+	if request.Format == "" {
+		request.Format = "json"
+	}
+	if request.Format != "json" && request.Format != "csv" && request.Format != "archive" {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The import format must be json, csv or archive."))
+		return
+	}
+
+	body, err := fetchImport(r.Context(), request)
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadGateway, "Fetching the import failed: "+err.Error()))
+		return
+	}
+	if request.Format == "archive" {
+		archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			s.writeError(w, r, errorWithStatus(http.StatusBadGateway, "The import is not a zip archive."))
+			return
+		}
+		s.restoreDumpFrom(w, r, archive)
+		return
+	}
+	var imported []model.Receipt
+	if request.Format == "csv" {
+		imported, err = parseImportCSV(body)
+	} else {
+		err = json.Unmarshal(body, &imported)
+	}
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadGateway, "The import is not a "+request.Format+" array of receipts: "+err.Error()))
+		return
+	}
+
+	subs, errs, err := s.prepareImports(r.Context(), imported, s.requestActor(r), s.tenantFromRequest(r))
+	if err == nil {
+		_, err = s.commitImports(r.Context(), subs, errs)
+	}
+	if err != nil {
+		s.logger.WarnContext(r.Context(), "import cancelled", "requestId", requestIDFromContext(r.Context()), "error", err)
+		return
+	}
+
+	summary := ImportSummary{Errors: []string{}}
+	for i, err := range errs {
+		if err != nil {
+			summary.Failed++
+			summary.Errors = append(summary.Errors, fmt.Sprintf("receipt %d: %v", i, err))
+			continue
+		}
+		summary.Imported++
+	}
+	s.writeJSON(w, r, http.StatusOK, summary)
+}
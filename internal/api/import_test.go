@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/model"
+)
+
+// Function to make n distinct copies of the target receipt, breaking the ones at the invalid indexes
+func importBatch(t testing.TB, n int, invalid ...int) []model.Receipt {
+	t.Helper()
+	base := decodeReceipt(t, targetReceipt)
+	receipts := make([]model.Receipt, n)
+	for i := range receipts {
+		receipt := base
+		receipt.Items = append([]model.Item(nil), base.Items...)
+		receipt.Retailer = "Target " + strconv.Itoa(i)
+		receipts[i] = receipt
+	}
+	for _, i := range invalid {
+		receipts[i].PurchaseDate = "2022-13-01"
+	}
+	return receipts
+}
+
+func TestPrepareImportsKeepsInputOrder(t *testing.T) {
+	config := defaultConfig()
+	config.ImportWorkers = 4
+	s := newTestServer(t, WithConfig(config))
+	receipts := importBatch(t, 200, 3, 97, 199)
+
+	subs, errs, err := s.prepareImports(context.Background(), receipts, "importer", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range receipts {
+		switch i {
+		case 3, 97, 199:
+			if errs[i] == nil || !strings.Contains(errs[i].Error(), "purchase_date") {
+				t.Errorf("receipt %d error = %v, want the bad date", i, errs[i])
+			}
+		default:
+			// Each digit of the index in the retailer name is worth a point on top of the target's 28
+			want := 28 + len(strconv.Itoa(i))
+			if errs[i] != nil {
+				t.Errorf("receipt %d error = %v", i, errs[i])
+			} else if subs[i].receipt.Retailer != receipts[i].Retailer || subs[i].points != want {
+				t.Errorf("result %d is %s worth %d, want %s worth %d", i, subs[i].receipt.Retailer, subs[i].points,
+					receipts[i].Retailer, want)
+			}
+		}
+	}
+}
+
+func TestPrepareImportsStopsWhenCancelled(t *testing.T) {
+	s := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := s.prepareImports(ctx, importBatch(t, 100), "importer", "")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("prepareImports = %v, want the cancellation", err)
+	}
+}
+
+func TestCommitImports(t *testing.T) {
+	config := defaultConfig()
+	config.StrictMode = true
+	s := newTestServer(t, WithConfig(config))
+	// More than one commit batch, with a receipt that is already stored and rejected as a duplicate in strict mode
+	receipts := importBatch(t, importCommitBatch+10, 5)
+	server := httptest.NewServer(s)
+	defer server.Close()
+	body, err := json.Marshal(receipts[20])
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := processReceipt(t, server, string(body))
+
+	subs, errs, err := s.prepareImports(context.Background(), receipts, "importer", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := s.commitImports(context.Background(), subs, errs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		switch i {
+		case 5:
+			if id != "" || errs[i] == nil {
+				t.Errorf("invalid receipt stored as %q", id)
+			}
+		case 20:
+			if id != "" || errs[i] == nil || errs[i].Error() != "duplicate of "+existing {
+				t.Errorf("duplicate stored as %q with error %v, want duplicate of %s", id, errs[i], existing)
+			}
+		default:
+			if id == "" || errs[i] != nil {
+				t.Errorf("receipt %d not stored: %v", i, errs[i])
+			}
+		}
+	}
+	if got, want := s.store.Count(), len(receipts)-1; got != want {
+		t.Errorf("%d receipts stored, want %d", got, want)
+	}
+}
+
+func BenchmarkPrepareImports(b *testing.B) {
+	for _, workers := range []int{1, max(2, runtime.GOMAXPROCS(0))} {
+		config := defaultConfig()
+		config.ImportWorkers = workers
+		s := newTestServer(b, WithConfig(config))
+		for _, size := range []int{100, 1000, 10000} {
+			receipts := importBatch(b, size)
+			b.Run(fmt.Sprintf("workers=%d/receipts=%d", workers, size), func(b *testing.B) {
+				for b.Loop() {
+					if _, _, err := s.prepareImports(context.Background(), receipts, "importer", ""); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"net/netip"
+)
+
+// Middleware to answer only clients whose trusted-proxy-resolved address is in one of the ranges; nil allows everyone
+func (s *server) allowCIDRs(allowed []netip.Prefix, next http.Handler) http.Handler {
+	if allowed == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.addressAllowed(allowed, r) {
+			s.writeError(w, r, errorWithStatus(http.StatusForbidden, "Access from this address is not allowed."))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Function to parse the admin ranges; "*" on its own turns the check off, for deployments where a proxy in front
+// already restricts who reaches the admin endpoints
+func parseAdminAllow(entries []string) ([]netip.Prefix, error) {
+	if len(entries) == 1 && entries[0] == "*" {
+		return nil, nil
+	}
+	return parsePrefixes(entries)
+}
+
+// Middleware to answer 403 to clients outside the admin ranges before the admin handler sees the request
+func (s *server) adminIPGuard(next http.Handler) http.Handler {
+	return s.allowCIDRs(s.adminAllow, next)
+}
+
+// Function to check a client's trusted-proxy-resolved address against the ranges; nil allows everyone
+func (s *server) addressAllowed(allowed []netip.Prefix, r *http.Request) bool {
+	if allowed == nil {
+		return true
+	}
+	return addressInPrefixes(allowed, s.clientIP(r))
+}
+
+// Function to check a client address, as clientIP reports it, against allowed ranges
+func addressInPrefixes(allowed []netip.Prefix, ip string) bool {
+	// Unix socket peers are local by definition; access is controlled by the socket's file mode
+	if ip == "unix" {
+		return true
+	}
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		addr = addr.Unmap()
+		for _, prefix := range allowed {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
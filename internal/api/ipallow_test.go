@@ -0,0 +1,141 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+)
+
+func TestNewServerRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"admin range", func(c *Config) { c.AdminAllow = []string{"10.0.0.0/33"} }, "adminAllow"},
+		{"no admin ranges", func(c *Config) { c.AdminAllow = nil }, "adminAllow"},
+		{"trusted proxy", func(c *Config) { c.TrustedProxies = []string{"proxy.internal"} }, "trustedProxies"},
+		{"write range", func(c *Config) { c.WriteAllow = []string{"10.0.0"} }, "writeAllow"},
+		{"normalizer", func(c *Config) { c.Normalizers = []string{"no-such-normalizer"} }, "normalizers"},
+		{"log level", func(c *Config) { c.LogLevel = "loud" }, "logLevel"},
+		{"lock shards", func(c *Config) { c.LockShards = 0 }, "lockShards"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultConfig()
+			test.change(&config)
+			handler, err := NewServer(store.NewMemory(1), points.NewCalculator(points.DefaultConfig()), WithConfig(config),
+				WithLogger(discardLogger()))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("NewServer error = %v, want one naming %s", err, test.want)
+			}
+			if handler != nil {
+				t.Error("NewServer returned a handler along with its error")
+			}
+		})
+	}
+}
+
+func TestAdminRoutesBehindIPAllowList(t *testing.T) {
+	// The test client connects from loopback, which is trusted to forward the address of the client behind it
+	config := defaultConfig()
+	config.AdminAllow = []string{"10.0.0.0/8"}
+	config.TrustedProxies = []string{"127.0.0.1"}
+	config.WriteAllow = []string{"10.0.0.0/8"}
+	config.Pprof.Enabled = true
+	withToken := config
+	withToken.AdminToken = "admin-token"
+
+	disabled := startServer(t, points.DefaultConfig(), WithConfig(config))
+	enabled := startServer(t, points.DefaultConfig(), WithConfig(withToken))
+	inside, outside := "10.1.2.3", "192.0.2.7"
+	tests := []struct {
+		name   string
+		admin  bool
+		method string
+		path   string
+		client string
+		token  string
+		want   int
+	}{
+		{"disabled admin from outside", false, http.MethodGet, "/admin/rules", outside, "", http.StatusNotFound},
+		{"disabled admin from inside", false, http.MethodGet, "/admin/rules", inside, "", http.StatusNotFound},
+		{"disabled metrics from outside", false, http.MethodGet, "/metrics", outside, "", http.StatusNotFound},
+		{"open pprof from outside", false, http.MethodGet, "/debug/pprof/", outside, "", http.StatusForbidden},
+		{"open pprof from inside", false, http.MethodGet, "/debug/pprof/", inside, "", http.StatusOK},
+		{"admin without a token", true, http.MethodGet, "/admin/rules", inside, "", http.StatusUnauthorized},
+		{"admin from outside without a token", true, http.MethodGet, "/admin/rules", outside, "", http.StatusUnauthorized},
+		{"admin from outside", true, http.MethodGet, "/admin/rules", outside, "admin-token", http.StatusForbidden},
+		{"admin from inside", true, http.MethodGet, "/admin/rules", inside, "admin-token", http.StatusOK},
+		{"metrics from outside", true, http.MethodGet, "/metrics", outside, "admin-token", http.StatusForbidden},
+		{"metrics from inside", true, http.MethodGet, "/metrics", inside, "admin-token", http.StatusOK},
+		{"pprof from outside", true, http.MethodGet, "/debug/pprof/", outside, "admin-token", http.StatusForbidden},
+		{"write from outside", false, http.MethodPost, "/receipts/process", outside, "", http.StatusForbidden},
+		{"write from inside", false, http.MethodPost, "/receipts/process", inside, "", http.StatusOK},
+		{"read from outside", false, http.MethodGet, "/receipts/count", outside, "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := disabled
+			if test.admin {
+				server = enabled
+			}
+			header := []string{"X-Forwarded-For", test.client}
+			if test.token != "" {
+				header = append(header, "X-Admin-Token", test.token)
+			}
+			body := ""
+			if test.method == http.MethodPost {
+				body = targetReceipt
+			}
+			if response, body := send(t, server, test.method, test.path, body, header...); response.StatusCode != test.want {
+				t.Errorf("%s %s from %s = %d %s, want %d", test.method, test.path, test.client, response.StatusCode, body, test.want)
+			}
+		})
+	}
+}
+
+func TestAdminAllowListWithoutForwarding(t *testing.T) {
+	open := defaultConfig()
+	open.AdminAllow = []string{"*"}
+	ranges := defaultConfig()
+	ranges.AdminAllow = []string{"10.0.0.0/8", "127.0.0.1/32"}
+	tests := []struct {
+		name       string
+		config     Config
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{"default from loopback", defaultConfig(), "127.0.0.1:50000", "", http.StatusOK},
+		{"default from IPv6 loopback", defaultConfig(), "[::1]:50000", "", http.StatusOK},
+		{"default from another host", defaultConfig(), "10.1.2.3:50000", "", http.StatusForbidden},
+		{"default with a spoofed loopback header", defaultConfig(), "192.0.2.7:50000", "127.0.0.1", http.StatusForbidden},
+		{"range from inside", ranges, "10.1.2.3:50000", "", http.StatusOK},
+		{"range from outside", ranges, "192.0.2.7:50000", "", http.StatusForbidden},
+		{"range with a spoofed inside header", ranges, "192.0.2.7:50000", "10.1.2.3", http.StatusForbidden},
+		{"wildcard from anywhere", open, "192.0.2.7:50000", "", http.StatusOK},
+		{"Unix socket peer", ranges, "@", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.config.AdminToken = "admin-token"
+			s := newTestServer(t, WithConfig(test.config))
+			request := httptest.NewRequest(http.MethodGet, "/admin/rules", nil)
+			request.RemoteAddr = test.remoteAddr
+			request.Header.Set("X-Admin-Token", "admin-token")
+			if test.forwarded != "" {
+				request.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, request)
+			if recorder.Code != test.want {
+				t.Errorf("GET /admin/rules from %s = %d, want %d", test.remoteAddr, recorder.Code, test.want)
+			}
+		})
+	}
+}
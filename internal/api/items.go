@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/points"
+)
+
+// Body of an item change; the client sends the receipt's new total along with it
+type ItemChangeRequest struct {
+	Item  model.Item `json:"item"`
+	Total string     `json:"total"`
+}
+
+type ItemsResponse struct {
+	Items  []model.Item `json:"items"`
+	Total  string       `json:"total"`
+	Points int          `json:"points"`
+}
+
+// Function to check that a validated receipt's total is the sum of its item prices; a sum too large to hold
+// matches nothing
+func totalMatchesItems(receipt model.Receipt) bool {
+	var sum model.Money
+	for _, item := range receipt.Items {
+		price, _ := model.ParseMoney(item.Price)
+		next, err := sum.Add(price)
+		if err != nil {
+			return false
+		}
+		sum = next
+	}
+	total, _ := model.ParseMoney(receipt.Total)
+	return sum == total
+}
+
+// Function to store an edited receipt and rescore it. Points already reserved or redeemed stay spent: the
+// available points move by the change in score, never below zero. Callers hold the receipt's shard lock
+func (s *server) replaceReceiptLocked(id string, receipt model.Receipt, actor string) error {
+	shard := s.store.Shard(id)
+	previous := shard.Receipts[id]
+	config := s.calculator.Active()
+	delta := points.Calculate(receipt, config) - points.Calculate(previous, config)
+	delta = max(delta, -shard.Points[id])
+	fingerprint := fingerprintReceipt(receipt)
+	if err := s.store.Replace(id, receipt, delta, fingerprint); err != nil {
+		return err
+	}
+	s.pointsCache.invalidate(id)
+	s.recordPointsMutation(id, model.MutationRecalculated, delta, actor)
+	s.recordEvent(id, EventRecalculated, actor, map[string]any{"points": shard.Points[id], "delta": delta})
+	return nil
+}
+
+// Handler to list a receipt's items
+func (s *server) listItemsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if s.rejectStoreLight(w, r) {
+		return
+	}
+	shard := s.store.Shard(id)
+	shard.RLock()
+	receipt, exists := shard.Lookup(id)
+	points := shard.Points[id]
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, ItemsResponse{Items: receipt.Items, Total: receipt.Total, Points: points})
+}
+
+// Handler to explain what the description-length rule gives one item under the active scoring config
+func (s *server) descriptionScoreHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if s.rejectStoreLight(w, r) {
+		return
+	}
+	shard := s.store.Shard(id)
+	shard.RLock()
+	receipt, exists := shard.Lookup(id)
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	index, ok := s.itemIndex(w, r, receipt.Items)
+	if !ok {
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, points.ScoreDescription(receipt.Items[index], s.calculator.Active()))
+}
+
+// Function to apply one item change to a receipt under its shard lock: validate the edited receipt, check its
+// total, store it and rescore. edit returns false after answering when the change cannot apply
+func (s *server) changeItems(w http.ResponseWriter, r *http.Request, id, total string, status int, edit func(items []model.Item) ([]model.Item, bool)) {
+	if s.rejectStoreLight(w, r) {
+		return
+	}
+	shard := s.store.Shard(id)
+	shard.Lock()
+	defer shard.Unlock()
+	receipt, exists := shard.Lookup(id)
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	if s.rejectArchived(w, r, shard, id) {
+		return
+	}
+
+	items, ok := edit(append([]model.Item(nil), receipt.Items...))
+	if !ok {
+		return
+	}
+	receipt.Items, receipt.Total = items, total
+	err := s.normalizeReceipt(&receipt)
+	if err == nil {
+		err = s.validateReceipt(receipt)
+	}
+	if err != nil {
+		s.metrics.validationFailures.WithLabelValues(validationReason(err)).Inc()
+		s.writeError(w, r, err)
+		return
+	}
+	if !totalMatchesItems(receipt) {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The total must equal the sum of the item prices."))
+		return
+	}
+	if err := s.replaceReceiptLocked(id, receipt, s.requestActor(r)); err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The receipt could not be stored."))
+		return
+	}
+	s.writeJSON(w, r, status, ItemsResponse{Items: receipt.Items, Total: receipt.Total, Points: shard.Points[id]})
+}
+
+// Function to read an item change body, answering 400 when it is malformed
+func (s *server) decodeItemChange(w http.ResponseWriter, r *http.Request) (ItemChangeRequest, bool) {
+	var request ItemChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Total == "" {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The request must contain an item and the receipt's new total."))
+		return request, false
+	}
+	return request, true
+}
+
+// Function to resolve the {index} path value against a receipt's items, answering 404 when it is out of range
+func (s *server) itemIndex(w http.ResponseWriter, r *http.Request, items []model.Item) (int, bool) {
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 || index >= len(items) {
+		s.writeError(w, r, errorWithStatus(http.StatusNotFound, "No item found at that index."))
+		return 0, false
+	}
+	return index, true
+}
+
+// Handler to append an item to a receipt
+func (s *server) addItemHandler(w http.ResponseWriter, r *http.Request, id string) {
+	request, ok := s.decodeItemChange(w, r)
+	if !ok {
+		return
+	}
+	s.changeItems(w, r, id, request.Total, http.StatusCreated, func(items []model.Item) ([]model.Item, bool) {
+		return append(items, request.Item), true
+	})
+}
+
+// Handler to replace the item at a 0-based index
+func (s *server) replaceItemHandler(w http.ResponseWriter, r *http.Request, id string) {
+	request, ok := s.decodeItemChange(w, r)
+	if !ok {
+		return
+	}
+	s.changeItems(w, r, id, request.Total, http.StatusOK, func(items []model.Item) ([]model.Item, bool) {
+		index, ok := s.itemIndex(w, r, items)
+		if ok {
+			items[index] = request.Item
+		}
+		return items, ok
+	})
+}
+
+// Handler to remove the item at a 0-based index; the new total comes from the total query parameter
+func (s *server) deleteItemHandler(w http.ResponseWriter, r *http.Request, id string) {
+	total := r.URL.Query().Get("total")
+	if total == "" {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The request must give the receipt's new total as the total query parameter."))
+		return
+	}
+	s.changeItems(w, r, id, total, http.StatusOK, func(items []model.Item) ([]model.Item, bool) {
+		index, ok := s.itemIndex(w, r, items)
+		if ok {
+			items = append(items[:index], items[index+1:]...)
+		}
+		return items, ok
+	})
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// JSON Schema (draft 2020-12) of a process request body, served as is and used for strict validation
+//
+//go:embed receipt.schema.json
+var receiptSchemaJSON []byte
+
+var receiptSchema = mustCompileReceiptSchema()
+
+var schemaMessages = message.NewPrinter(language.English)
+
+// Escaping of reference tokens in a JSON pointer (RFC 6901)
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func mustCompileReceiptSchema() *jsonschema.Schema {
+	document, err := jsonschema.UnmarshalJSON(bytes.NewReader(receiptSchemaJSON))
+	if err != nil {
+		panic(err)
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat()
+	if err := compiler.AddResource("receipt.schema.json", document); err != nil {
+		panic(err)
+	}
+	return compiler.MustCompile("receipt.schema.json")
+}
+
+// One way a body fails the schema; both locations are JSON pointers
+type SchemaViolation struct {
+	InstancePath string `json:"instancePath"`
+	SchemaPath   string `json:"schemaPath"`
+	Message      string `json:"message"`
+}
+
+type SchemaErrorResponse struct {
+	Error      string            `json:"error"`
+	Violations []SchemaViolation `json:"violations"`
+}
+
+type schemaError struct {
+	violations []SchemaViolation
+}
+
+func (e *schemaError) Error() string {
+	return "The receipt does not match the schema."
+}
+
+// Function to check a JSON body against the receipt schema; bodies that are not JSON are left to the decoder
+func validateAgainstSchema(body []byte) error {
+	document, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	var invalid *jsonschema.ValidationError
+	if err := receiptSchema.Validate(document); !errors.As(err, &invalid) {
+		return err
+	}
+	violations := schemaViolations(invalid, nil)
+	slices.SortStableFunc(violations, func(a, b SchemaViolation) int { return strings.Compare(a.InstancePath, b.InstancePath) })
+	return &schemaError{violations: violations}
+}
+
+// Function to flatten a validation error into its leaves, the keywords that actually failed. The schema path is
+// a pointer into the published document, so one reached through $ref points at the definition
+func schemaViolations(invalid *jsonschema.ValidationError, violations []SchemaViolation) []SchemaViolation {
+	if len(invalid.Causes) == 0 {
+		_, fragment, _ := strings.Cut(invalid.SchemaURL, "#")
+		for _, keyword := range invalid.ErrorKind.KeywordPath() {
+			fragment += "/" + keyword
+		}
+		instancePath := ""
+		for _, token := range invalid.InstanceLocation {
+			instancePath += "/" + pointerEscaper.Replace(token)
+		}
+		return append(violations, SchemaViolation{
+			InstancePath: instancePath,
+			SchemaPath:   fragment,
+			Message:      invalid.ErrorKind.LocalizedString(schemaMessages),
+		})
+	}
+	for _, cause := range invalid.Causes {
+		violations = schemaViolations(cause, violations)
+	}
+	return violations
+}
+
+// Handler to serve the receipt JSON Schema
+func receiptSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(receiptSchemaJSON)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/model"
+)
+
+// The published schema and validateReceipt accept and refuse the same receipts. Item counts are left out, as their
+// limits are configured per server, and so are amounts too large to hold, which no pattern can bound exactly; only
+// the validator checks those
+func TestSchemaMatchesValidateReceipt(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name   string
+		change func(*model.Receipt)
+		valid  bool
+	}{
+		{"valid", func(r *model.Receipt) {}, true},
+		{"retailer with an ampersand", func(r *model.Receipt) { r.Retailer = "M&M Corner Market" }, true},
+		{"retailer with a tab and underscore", func(r *model.Receipt) { r.Retailer = "Corner\t_Market" }, true},
+		{"retailer of the longest length", func(r *model.Receipt) { r.Retailer = strings.Repeat("T", maxRetailerLength) }, true},
+		{"item description of the longest length", func(r *model.Receipt) {
+			r.Items[0].ShortDescription = strings.Repeat("G", maxShortDescriptionLength)
+		}, true},
+		{"largest total", func(r *model.Receipt) { r.Total = "92233720368547758.07" }, true},
+		{"midnight", func(r *model.Receipt) { r.PurchaseTime = "00:00" }, true},
+		{"leap day", func(r *model.Receipt) { r.PurchaseDate = "2024-02-29" }, true},
+
+		{"missing retailer", func(r *model.Receipt) { r.Retailer = "" }, false},
+		{"retailer with punctuation", func(r *model.Receipt) { r.Retailer = "Target!" }, false},
+		{"retailer with an accent", func(r *model.Receipt) { r.Retailer = "Café" }, false},
+		{"retailer too long", func(r *model.Receipt) { r.Retailer = strings.Repeat("T", maxRetailerLength+1) }, false},
+		{"missing date", func(r *model.Receipt) { r.PurchaseDate = "" }, false},
+		{"impossible date", func(r *model.Receipt) { r.PurchaseDate = "2022-02-30" }, false},
+		{"date with slashes", func(r *model.Receipt) { r.PurchaseDate = "2022/01/01" }, false},
+		{"impossible time", func(r *model.Receipt) { r.PurchaseTime = "24:00" }, false},
+		{"short time", func(r *model.Receipt) { r.PurchaseTime = "1:01" }, false},
+		{"time with seconds", func(r *model.Receipt) { r.PurchaseTime = "13:01:00" }, false},
+		{"missing total", func(r *model.Receipt) { r.Total = "" }, false},
+		{"total without cents", func(r *model.Receipt) { r.Total = "35" }, false},
+		{"total with one decimal", func(r *model.Receipt) { r.Total = "35.3" }, false},
+		{"negative total", func(r *model.Receipt) { r.Total = "-35.35" }, false},
+		{"total too long", func(r *model.Receipt) { r.Total = strings.Repeat("1", maxAmountLength-2) + ".00" }, false},
+		{"no items", func(r *model.Receipt) { r.Items = nil }, false},
+		{"item without a description", func(r *model.Receipt) { r.Items[1].ShortDescription = "" }, false},
+		{"item without a price", func(r *model.Receipt) { r.Items[2].Price = "" }, false},
+		{"item description with a slash", func(r *model.Receipt) { r.Items[1].ShortDescription = "Pizza/Cheese" }, false},
+		{"item description with an ampersand", func(r *model.Receipt) { r.Items[1].ShortDescription = "Mac & Cheese" }, false},
+		{"item description too long", func(r *model.Receipt) {
+			r.Items[0].ShortDescription = strings.Repeat("G", maxShortDescriptionLength+1)
+		}, false},
+		{"negative item price", func(r *model.Receipt) { r.Items[0].Price = "-6.49" }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receipt := decodeReceipt(t, targetReceipt)
+			test.change(&receipt)
+			body, err := json.Marshal(receipt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			schemaErr := validateAgainstSchema(body)
+			validatorErr := s.validateReceipt(receipt)
+			if (schemaErr == nil) != test.valid || (validatorErr == nil) != test.valid {
+				t.Errorf("schema says %v and validateReceipt %v, want both to %s it", schemaErr, validatorErr,
+					map[bool]string{true: "accept", false: "refuse"}[test.valid])
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Upper bound on the whole shutdown: draining the listeners and then stopping every component
+const shutdownTimeout = 10 * time.Second
+
+// A background part of the server that must be stopped cleanly before the process exits
+type Component interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+type namedComponent struct {
+	name string
+	Component
+}
+
+// Starts components in registration order and stops them in reverse
+type Lifecycle struct {
+	components []namedComponent
+	started    int
+}
+
+func (l *Lifecycle) Register(name string, component Component) {
+	l.components = append(l.components, namedComponent{name, component})
+}
+
+// Function to start every component; on failure the ones already started are stopped again
+func (l *Lifecycle) Start(ctx context.Context) error {
+	for _, component := range l.components {
+		if err := component.Start(ctx); err != nil {
+			stopCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			l.Stop(stopCtx)
+			return fmt.Errorf("starting %s: %w", component.name, err)
+		}
+		l.started++
+	}
+	return nil
+}
+
+// Function to stop the started components newest first, naming any that are still busy when ctx runs out
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	var errs []error
+	for i := l.started - 1; i >= 0; i-- {
+		component := l.components[i]
+		done := make(chan error, 1)
+		go func() { done <- component.Stop(ctx) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				errs = append(errs, fmt.Errorf("stopping %s: %w", component.name, err))
+			}
+		case <-ctx.Done():
+			slog.Warn("shutdown deadline reached", "component", component.name)
+			errs = append(errs, fmt.Errorf("stopping %s: %w", component.name, ctx.Err()))
+		}
+	}
+	l.started = 0
+	return errors.Join(errs...)
+}
+
+// Component running a loop until its stop channel is closed
+type worker struct {
+	run  func(stop <-chan struct{})
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newWorker(run func(stop <-chan struct{})) *worker {
+	return &worker{run: run}
+}
+
+func (w *worker) Start(ctx context.Context) error {
+	w.stop, w.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(w.done)
+		w.run(w.stop)
+	}()
+	return nil
+}
+
+func (w *worker) Stop(ctx context.Context) error {
+	close(w.stop)
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Component with nothing to start, only cleanup to run at shutdown
+type stopFunc func(ctx context.Context) error
+
+func (f stopFunc) Start(ctx context.Context) error { return nil }
+func (f stopFunc) Stop(ctx context.Context) error  { return f(ctx) }
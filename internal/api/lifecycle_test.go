@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Component recording its calls into a log shared with the others
+type fakeComponent struct {
+	name     string
+	log      *[]string
+	mu       *sync.Mutex
+	startErr error
+	// Closed to let a deliberately slow Stop return
+	release chan struct{}
+}
+
+func (f *fakeComponent) record(event string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*f.log = append(*f.log, event+" "+f.name)
+}
+
+func (f *fakeComponent) Start(ctx context.Context) error {
+	if f.startErr != nil {
+		return f.startErr
+	}
+	f.record("start")
+	return nil
+}
+
+func (f *fakeComponent) Stop(ctx context.Context) error {
+	if f.release != nil {
+		<-f.release
+	}
+	f.record("stop")
+	return nil
+}
+
+// Function to register fake components under their names, returning the shared call log
+func fakeLifecycle(components ...*fakeComponent) (*Lifecycle, func() []string) {
+	var log []string
+	var mu sync.Mutex
+	lifecycle := &Lifecycle{}
+	for _, component := range components {
+		component.log, component.mu = &log, &mu
+		lifecycle.Register(component.name, component)
+	}
+	return lifecycle, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), log...)
+	}
+}
+
+func TestLifecycleOrdering(t *testing.T) {
+	lifecycle, calls := fakeLifecycle(&fakeComponent{name: "sweeper"}, &fakeComponent{name: "webhooks"},
+		&fakeComponent{name: "events"})
+	if err := lifecycle.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := lifecycle.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start sweeper", "start webhooks", "start events", "stop events", "stop webhooks", "stop sweeper"}
+	if got := calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls %v, want %v", got, want)
+	}
+	// A second Stop has nothing left to stop
+	if err := lifecycle.Stop(context.Background()); err != nil || len(calls()) != len(want) {
+		t.Errorf("second Stop = %v with calls %v", err, calls())
+	}
+}
+
+func TestLifecycleStartFailureStopsStartedComponents(t *testing.T) {
+	lifecycle, calls := fakeLifecycle(&fakeComponent{name: "sweeper"}, &fakeComponent{name: "webhooks"},
+		&fakeComponent{name: "uploader", startErr: errors.New("bucket unreachable")}, &fakeComponent{name: "events"})
+	err := lifecycle.Start(context.Background())
+	if err == nil || err.Error() != "starting uploader: bucket unreachable" {
+		t.Fatalf("Start = %v, want the uploader failure", err)
+	}
+	want := []string{"start sweeper", "start webhooks", "stop webhooks", "stop sweeper"}
+	if got := calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls %v, want %v", got, want)
+	}
+}
+
+func TestLifecycleStopDeadline(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	slow := &fakeComponent{name: "recalculation", release: make(chan struct{})}
+	defer close(slow.release)
+	lifecycle, calls := fakeLifecycle(&fakeComponent{name: "sweeper"}, slow, &fakeComponent{name: "events"})
+	if err := lifecycle.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err := lifecycle.Stop(ctx)
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Stop took %v, want it to give up at the deadline", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "stopping recalculation") {
+		t.Errorf("Stop = %v, want the slow component named with the deadline", err)
+	}
+	if !strings.Contains(logs.String(), "component=recalculation") {
+		t.Errorf("no warning naming the slow component in %q", logs.String())
+	}
+	// Newer components stop before the slow one holds things up
+	want := []string{"start sweeper", "start recalculation", "start events", "stop events"}
+	if got := calls(); len(got) < len(want) || !reflect.DeepEqual(got[:len(want)], want) {
+		t.Errorf("calls %v, want events stopped before the slow component", got)
+	}
+}
+
+func TestWorkerStopsItsLoop(t *testing.T) {
+	ticks := make(chan struct{}, 1)
+	w := newWorker(func(stop <-chan struct{}) {
+		for {
+			select {
+			case <-stop:
+				return
+			case ticks <- struct{}{}:
+			}
+		}
+	})
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-ticks
+	if err := w.Stop(context.Background()); err != nil {
+		t.Errorf("Stop = %v", err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	stuck := newWorker(func(stop <-chan struct{}) { <-release })
+	stuck.Start(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := stuck.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop of a stuck worker = %v, want the deadline", err)
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type LimitsConfig struct {
+	MaxReads  int           `yaml:"maxReads"`
+	MaxWrites int           `yaml:"maxWrites"`
+	MaxWait   time.Duration `yaml:"maxWait"`
+}
+
+// Paths that must keep answering while the server sheds load
+var healthPaths = map[string]bool{"/health": true, "/healthz": true, "/readyz": true}
+
+// Semaphore bounding concurrent requests of one class; a nil channel means unlimited
+type concurrencyLimit struct {
+	class string
+	slots chan struct{}
+}
+
+func newConcurrencyLimit(class string, max int) *concurrencyLimit {
+	limit := &concurrencyLimit{class: class}
+	if max > 0 {
+		limit.slots = make(chan struct{}, max)
+	}
+	return limit
+}
+
+// Function to take a slot, waiting at most maxWait; reports false when the request should be shed
+func (l *concurrencyLimit) acquire(ctx context.Context, maxWait time.Duration) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if maxWait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *concurrencyLimit) release() {
+	<-l.slots
+}
+
+// Middleware to cap in-flight reads and writes separately, answering 503 once the wait runs out
+func (s *server) concurrencyLimitMiddleware(next http.Handler) http.Handler {
+	if s.readLimit.slots == nil && s.writeLimit.slots == nil {
+		return next
+	}
+	retryAfter := strconv.Itoa(max(1, int((s.limitWait+time.Second-1)/time.Second)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := s.writeLimit
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			limit = s.readLimit
+		}
+		if limit.slots == nil || healthPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !limit.acquire(r.Context(), s.limitWait) {
+			s.metrics.limiterShed.WithLabelValues(limit.class).Inc()
+			w.Header().Set("Retry-After", retryAfter)
+			s.writeError(w, r, errorWithStatus(http.StatusServiceUnavailable, "The server is overloaded, please retry later."))
+			return
+		}
+		// Deferred so the slot is returned even if the handler panics
+		inFlight := s.metrics.limiterInFlight.WithLabelValues(limit.class)
+		inFlight.Inc()
+		defer func() {
+			inFlight.Dec()
+			limit.release()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
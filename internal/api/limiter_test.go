@@ -0,0 +1,118 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+)
+
+// Function to build a bare server for tests that drive one middleware directly
+func newTestServer(t testing.TB, opts ...Option) *server {
+	t.Helper()
+	s, err := newServer(store.NewMemory(defaultLockShards), points.NewCalculator(points.DefaultConfig()),
+		append([]Option{WithLogger(discardLogger())}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Function to read the current value of a counter or gauge
+func metricValue(t *testing.T, metric prometheus.Metric) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metric.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Gauge != nil {
+		return m.Gauge.GetValue()
+	}
+	return m.Counter.GetValue()
+}
+
+func TestConcurrencyLimitSheds(t *testing.T) {
+	s := newTestServer(t, WithLimits(LimitsConfig{MaxReads: 1, MaxWait: 10 * time.Millisecond}))
+	entered, unblock := make(chan struct{}), make(chan struct{})
+	handler := s.concurrencyLimitMiddleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/receipts/count" {
+				entered <- struct{}{}
+				<-unblock
+			}
+		}))
+
+	// Hold the only read slot
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/receipts/count", nil))
+		close(done)
+	}()
+	<-entered
+	if got := metricValue(t, s.metrics.limiterInFlight.WithLabelValues("read")); got != 1 {
+		t.Errorf("reads in flight = %v, want 1", got)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{"second read", http.MethodGet, "/stats", http.StatusServiceUnavailable},
+		{"health check", http.MethodGet, "/healthz", http.StatusOK},
+		{"readiness check", http.MethodGet, "/readyz", http.StatusOK},
+		{"unlimited writes", http.MethodPost, "/receipts/process", http.StatusOK},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, nil))
+		if recorder.Code != test.want {
+			t.Errorf("%s: %s %s = %d, want %d", test.name, test.method, test.path, recorder.Code, test.want)
+		}
+		if test.want == http.StatusServiceUnavailable && recorder.Header().Get("Retry-After") != "1" {
+			t.Errorf("%s: Retry-After = %q, want 1", test.name, recorder.Header().Get("Retry-After"))
+		}
+	}
+	if got := metricValue(t, s.metrics.limiterShed.WithLabelValues("read")); got != 1 {
+		t.Errorf("reads shed = %v, want 1", got)
+	}
+
+	close(unblock)
+	<-done
+	if got := metricValue(t, s.metrics.limiterInFlight.WithLabelValues("read")); got != 0 {
+		t.Errorf("reads in flight after the request finished = %v, want 0", got)
+	}
+}
+
+func TestConcurrencyLimitReleasesOnPanic(t *testing.T) {
+	s := newTestServer(t, WithLimits(LimitsConfig{MaxWrites: 1}))
+	handler := s.recoveryMiddleware(s.concurrencyLimitMiddleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("panic") != "" {
+				panic("handler failed")
+			}
+		})))
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/receipts/process?panic=1", http.StatusInternalServerError},
+		{"/receipts/process?panic=1", http.StatusInternalServerError},
+		{"/receipts/process", http.StatusOK},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, test.path, nil))
+		if recorder.Code != test.want {
+			t.Errorf("POST %s = %d, want %d; a panic must not keep the slot", test.path, recorder.Code, test.want)
+		}
+	}
+}
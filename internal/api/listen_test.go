@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+)
+
+func TestServeOverUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.sock")
+	listener, err := listen("unix://"+path, "0660")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o660 {
+		t.Errorf("socket mode %v, want 0660", info.Mode().Perm())
+	}
+
+	handler, err := NewServer(store.NewMemory(defaultLockShards), points.NewCalculator(points.DefaultConfig()),
+		WithLogger(discardLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	server.URL = "http://receipts"
+	server.Client().Transport.(*http.Transport).DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", path)
+	}
+
+	id := processReceipt(t, server, targetReceipt)
+	if got := receiptPoints(t, server, id); got != 28 {
+		t.Errorf("points over the socket = %d, want 28", got)
+	}
+	if _, err := listen("unix://"+path, "0660"); err == nil || !strings.Contains(err.Error(), "already listening") {
+		t.Errorf("second listen on a live socket = %v, want it refused", err)
+	}
+
+	server.Close()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file left behind after shutdown: %v", err)
+	}
+}
+
+func TestListenRemovesStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "stale.sock")
+	previous, err := net.ListenUnix("unix", &net.UnixAddr{Name: stale, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave the file behind the way a crashed process would
+	previous.SetUnlinkOnClose(false)
+	previous.Close()
+
+	listener, err := listen("unix://"+stale, "0600")
+	if err != nil {
+		t.Fatalf("listen over a stale socket: %v", err)
+	}
+	listener.Close()
+
+	regular := filepath.Join(dir, "regular")
+	if err := os.WriteFile(regular, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen("unix://"+regular, "0600"); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("listen over a regular file = %v, want it refused", err)
+	}
+	if _, err := os.Stat(regular); err != nil {
+		t.Errorf("regular file was removed: %v", err)
+	}
+}
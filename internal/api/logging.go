@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// Middleware to log one line per request
+func (s *server) loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		s.logger.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration", time.Since(start),
+			"clientIp", s.clientIP(r),
+			"requestId", requestIDFromContext(r.Context()),
+		)
+	})
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type LogLevelStatus struct {
+	Level string `json:"level"`
+}
+
+// Function to parse one of the supported log level names
+func parseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("log level must be one of %s", strings.Join(logLevelNames, ", "))
+}
+
+// Function to make a text logger on stderr that logs at level and above
+func newLogger(level *slog.LevelVar) *slog.Logger {
+	return slog.New(traceLogHandler{slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})})
+}
+
+// Function to change the log level and audit who changed it
+func (s *server) changeLogLevel(level slog.Level, identity string) {
+	previous := s.logLevel.Level()
+	s.logLevel.Set(level)
+	s.auditLogger.Info("audit",
+		"identity", identity,
+		"event", "logLevelChanged",
+		"from", strings.ToLower(previous.String()),
+		"to", strings.ToLower(level.String()),
+	)
+}
+
+// Function to toggle between debug and info logging on SIGUSR1; SIGUSR2 already reopens the access log
+func (s *server) watchLogLevelSignal(stop <-chan struct{}) {
+	toggle := make(chan os.Signal, 1)
+	signal.Notify(toggle, syscall.SIGUSR1)
+	defer signal.Stop(toggle)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-toggle:
+			if s.logLevel.Level() == slog.LevelDebug {
+				s.changeLogLevel(slog.LevelInfo, "signal")
+			} else {
+				s.changeLogLevel(slog.LevelDebug, "signal")
+			}
+		}
+	}
+}
+
+// Handler to show or change the log level
+func (s *server) logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var request LogLevelRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The log level request is invalid."))
+			return
+		}
+		level, err := parseLogLevel(request.Level)
+		if err != nil {
+			s.writeError(w, r, errorWithStatus(http.StatusBadRequest, err.Error()))
+			return
+		}
+		s.changeLogLevel(level, identityFromContext(r.Context()))
+	default:
+		s.writeError(w, r, errorWithStatus(http.StatusMethodNotAllowed, "Method not allowed."))
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, LogLevelStatus{Level: strings.ToLower(s.logLevel.Level().String())})
+}
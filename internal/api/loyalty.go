@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"receipt-processor/internal/backoff"
+	"receipt-processor/internal/model"
+
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Limits on resubmitting a receipt to the loyalty platform
+const (
+	loyaltyTimeout       = 10 * time.Second
+	loyaltyAttempts      = 4
+	loyaltyBaseBackoff   = 200 * time.Millisecond
+	maxLoyaltyReplyBytes = 1 << 20
+)
+
+type LoyaltyConfig struct {
+	URL          string   `yaml:"url"`
+	TokenURL     string   `yaml:"tokenUrl"`
+	ClientID     string   `yaml:"clientId"`
+	ClientSecret string   `yaml:"clientSecret"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// One resubmission of a receipt. SubmissionID is new for every resubmit request and the same on each of its
+// retries, so the platform can tell a retry from a second submission
+type LoyaltySubmission struct {
+	ReceiptID    string        `json:"receiptId"`
+	SubmissionID string        `json:"submissionId"`
+	Receipt      model.Receipt `json:"receipt"`
+	Points       int           `json:"points"`
+}
+
+type ResubmitResponse struct {
+	PlatformStatus   int `json:"platformStatus"`
+	PlatformResponse any `json:"platformResponse"`
+}
+
+// Function to set up the loyalty platform client using the OAuth 2.0 client credentials flow
+func newLoyaltyClient(config LoyaltyConfig) *http.Client {
+	credentials := clientcredentials.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		TokenURL:     config.TokenURL,
+		Scopes:       config.Scopes,
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: loyaltyTimeout})
+	client := credentials.Client(ctx)
+	client.Timeout = loyaltyTimeout
+	return client
+}
+
+// Function to post a receipt to the loyalty platform, retrying network failures, 429s and 5xx answers. A failure
+// may come after the platform has taken the receipt, so every attempt carries the same Idempotency-Key and the
+// platform applies the submission once
+func (s *server) postToLoyaltyPlatform(ctx context.Context, idempotencyKey string, body []byte) (int, []byte, error) {
+	var lastErr error
+	for attempt := range loyaltyAttempts {
+		if attempt > 0 {
+			if err := backoff.Wait(ctx, loyaltyBaseBackoff, attempt); err != nil {
+				return 0, nil, err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.loyaltyURL, bytes.NewReader(body))
+		if err != nil {
+			return 0, nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+		resp, err := s.loyaltyClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reply, err := io.ReadAll(io.LimitReader(resp.Body, maxLoyaltyReplyBytes))
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("loyalty platform answered %s", resp.Status)
+			if attempt < loyaltyAttempts-1 {
+				continue
+			}
+		}
+		return resp.StatusCode, reply, nil
+	}
+	return 0, nil, lastErr
+}
+
+// Handler to send a stored receipt and its points to the external loyalty platform
+func (s *server) resubmitReceiptHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if s.loyaltyClient == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if s.rejectStoreLight(w, r) {
+		return
+	}
+	shard := s.store.Shard(id)
+	shard.RLock()
+	receipt, exists := shard.Lookup(id)
+	points := shard.Points[id]
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+
+	submission := LoyaltySubmission{ReceiptID: id, SubmissionID: uuid.New().String(), Receipt: receipt, Points: points}
+	body, _ := json.Marshal(submission)
+	status, reply, err := s.postToLoyaltyPlatform(r.Context(), id+":"+submission.SubmissionID, body)
+	if err != nil {
+		s.logger.WarnContext(r.Context(), "resubmitting receipt failed", "requestId", requestIDFromContext(r.Context()), "receiptId", id, "error", err)
+		s.writeError(w, r, errorWithStatus(http.StatusBadGateway, "The loyalty platform could not be reached."))
+		return
+	}
+	// The receipt may have been deleted while the platform was answering; a deleted receipt keeps no timeline
+	shard.Lock()
+	if _, exists := shard.Lookup(id); exists {
+		s.recordEvent(id, EventResubmitted, s.requestActor(r), map[string]any{"platformStatus": status,
+			"submissionId": submission.SubmissionID})
+	}
+	shard.Unlock()
+
+	// JSON answers are passed through as they are; anything else is returned as a string
+	response := ResubmitResponse{PlatformStatus: status, PlatformResponse: string(reply)}
+	if json.Valid(reply) {
+		response.PlatformResponse = json.RawMessage(reply)
+	}
+	code := http.StatusOK
+	if status < 200 || status > 299 {
+		code = http.StatusBadGateway
+	}
+	s.writeJSON(w, r, code, response)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Fake loyalty platform behind a client credentials token endpoint. It answers 503 to the first attempt at each
+// submission after taking it, and runs beforeReply, if set, ahead of every answer
+type fakeLoyaltyPlatform struct {
+	mutex       sync.Mutex
+	keys        []string
+	taken       map[string]int
+	beforeReply func()
+}
+
+func (p *fakeLoyaltyPlatform) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"token","token_type":"bearer","expires_in":3600}`)
+		return
+	}
+	var submission LoyaltySubmission
+	json.NewDecoder(r.Body).Decode(&submission)
+	key := r.Header.Get("Idempotency-Key")
+	p.mutex.Lock()
+	p.keys = append(p.keys, key)
+	p.taken[key]++
+	attempts := p.taken[key]
+	p.mutex.Unlock()
+	if p.beforeReply != nil {
+		p.beforeReply()
+	}
+	if attempts == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"receiptId": submission.ReceiptID})
+}
+
+// Function to serve a server resubmitting to platform until the test ends
+func startLoyaltyServer(t *testing.T, platform *fakeLoyaltyPlatform) (*server, *httptest.Server) {
+	t.Helper()
+	upstream := httptest.NewServer(platform)
+	t.Cleanup(upstream.Close)
+	config := defaultConfig()
+	config.AdminToken = "admin-token"
+	config.Loyalty = LoyaltyConfig{URL: upstream.URL + "/receipts", TokenURL: upstream.URL + "/token", ClientID: "receipts"}
+	s := newTestServer(t, WithConfig(config))
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func TestLoyaltyRetriesCarryOneIdempotencyKey(t *testing.T) {
+	platform := &fakeLoyaltyPlatform{taken: make(map[string]int)}
+	_, server := startLoyaltyServer(t, platform)
+	id := processReceipt(t, server, targetReceipt)
+
+	for range 2 {
+		if response, body := send(t, server, http.MethodPost, "/receipts/"+id+"/resubmit", ""); response.StatusCode != http.StatusOK {
+			t.Fatalf("resubmit = %d %s", response.StatusCode, body)
+		}
+	}
+	if len(platform.keys) != 4 || platform.keys[0] != platform.keys[1] || platform.keys[2] != platform.keys[3] {
+		t.Errorf("Idempotency-Keys %q, want each resubmission's retry to repeat its first attempt's key", platform.keys)
+	}
+	if platform.keys[0] == platform.keys[2] || !strings.HasPrefix(platform.keys[0], id+":") {
+		t.Errorf("Idempotency-Keys %q, want a new key per resubmission, each starting with the receipt ID", platform.keys)
+	}
+}
+
+// A receipt deleted while the platform is answering gets no resubmitted event, which would leave a timeline behind
+// for a receipt that no longer exists
+func TestResubmitOfADeletedReceiptRecordsNothing(t *testing.T) {
+	platform := &fakeLoyaltyPlatform{taken: make(map[string]int)}
+	s, server := startLoyaltyServer(t, platform)
+	id := processReceipt(t, server, targetReceipt)
+	platform.beforeReply = func() {
+		if err := s.deleteReceipt(context.Background(), id, "test"); err != nil && !errors.Is(err, ErrNotFound) {
+			t.Error(err)
+		}
+	}
+
+	send(t, server, http.MethodPost, "/receipts/"+id+"/resubmit", "")
+	shard := s.store.Shard(id)
+	shard.RLock()
+	events := shard.Events[id]
+	shard.RUnlock()
+	if len(events) != 0 {
+		t.Errorf("deleted receipt has events %+v, want none", events)
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultMaintenanceMessage = "The service is undergoing maintenance, please retry later."
+
+type maintenanceState struct {
+	Message string    `json:"message"`
+	Until   time.Time `json:"until,omitempty"`
+}
+
+type MaintenanceRequest struct {
+	Enabled         bool   `json:"enabled"`
+	Message         string `json:"message"`
+	DurationSeconds int    `json:"durationSeconds"`
+}
+
+type MaintenanceStatus struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// Function to switch maintenance mode on with an optional message and expected duration
+func (s *server) startMaintenance(message string, duration time.Duration) {
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	state := &maintenanceState{Message: message}
+	if duration > 0 {
+		state.Until = s.clock().Add(duration)
+	}
+	s.maintenance.Store(state)
+}
+
+// Middleware to answer everything except health and admin routes with 503 during maintenance
+func (s *server) maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := s.maintenance.Load()
+		if state == nil || healthPaths[r.URL.Path] || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		retryAfter := 120
+		if !state.Until.IsZero() {
+			retryAfter = max(1, int(time.Until(state.Until).Seconds()))
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		s.writeError(w, r, errorWithStatus(http.StatusServiceUnavailable, state.Message))
+	})
+}
+
+// Handler to show or change maintenance mode
+func (s *server) maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var request MaintenanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.DurationSeconds < 0 {
+			s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The maintenance request is invalid."))
+			return
+		}
+		if request.Enabled {
+			s.startMaintenance(request.Message, time.Duration(request.DurationSeconds)*time.Second)
+		} else {
+			s.maintenance.Store(nil)
+		}
+	default:
+		s.writeError(w, r, errorWithStatus(http.StatusMethodNotAllowed, "Method not allowed."))
+		return
+	}
+
+	status := MaintenanceStatus{}
+	if state := s.maintenance.Load(); state != nil {
+		status = MaintenanceStatus{Enabled: true, Message: state.Message}
+		if !state.Until.IsZero() {
+			status.Until = &state.Until
+		}
+	}
+	s.writeJSON(w, r, http.StatusOK, status)
+}
+
+type ReadyStatus struct {
+	Status string      `json:"status"`
+	Checks []SelfCheck `json:"checks,omitempty"`
+}
+
+// Handler reporting readiness; load balancers should stop routing here during maintenance
+func (s *server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if s.maintenance.Load() != nil {
+		s.writeJSON(w, r, http.StatusServiceUnavailable, ReadyStatus{Status: "maintenance", Checks: s.selfChecks})
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, ReadyStatus{Status: "ready", Checks: s.selfChecks})
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type Metrics struct {
+	registry           *prometheus.Registry
+	requests           *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+	receiptsProcessed  prometheus.Counter
+	validationFailures *prometheus.CounterVec
+	pointsAwarded      prometheus.Counter
+	limiterInFlight    *prometheus.GaugeVec
+	limiterShed        *prometheus.CounterVec
+	pointsCacheLookups *prometheus.CounterVec
+}
+
+// Function to create the service metrics on a dedicated registry
+func (s *server) newMetrics(registry *prometheus.Registry) *Metrics {
+	m := &Metrics{
+		registry: registry,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by listener, route, method and status.",
+		}, []string{"listener", "route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by listener, route, method and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"listener", "route", "method", "status"}),
+		receiptsProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "receipts_processed_total",
+			Help: "Receipts accepted and scored.",
+		}),
+		validationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "receipt_validation_failures_total",
+			Help: "Rejected receipts by reason.",
+		}, []string{"reason"}),
+		pointsAwarded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "points_awarded_total",
+			Help: "Points awarded across all processed receipts.",
+		}),
+		limiterInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "limiter_in_flight_requests",
+			Help: "Requests currently holding a concurrency limiter slot, by class.",
+		}, []string{"class"}),
+		limiterShed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "limiter_shed_requests_total",
+			Help: "Requests rejected with 503 by the concurrency limiter, by class.",
+		}, []string{"class"}),
+		pointsCacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "points_cache_lookups_total",
+			Help: "Points lookups answered from the response cache (hit) or the store (miss).",
+		}, []string{"result"}),
+	}
+	storeSize := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "receipts_stored",
+		Help: "Receipts currently held in the store.",
+	}, func() float64 {
+		return float64(s.store.Count())
+	})
+
+	storeBytes := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "receipts_stored_bytes",
+		Help: "Approximate bytes of receipt payload held in the store; much lower in store-light mode.",
+	}, func() float64 {
+		return float64(s.store.Bytes())
+	})
+
+	registry.MustRegister(m.requests, m.requestDuration, m.receiptsProcessed, m.validationFailures, m.pointsAwarded,
+		m.limiterInFlight, m.limiterShed, m.pointsCacheLookups, storeSize, storeBytes)
+	return m
+}
+
+// Function to serve the registry in the Prometheus exposition format
+func (m *Metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Function to label a request with the pattern it routes to, without the method, to keep label cardinality bounded
+func (s *server) routeLabel(r *http.Request) string {
+	if s.router == nil {
+		return "other"
+	}
+	_, pattern := s.router.Handler(r)
+	if _, path, found := strings.Cut(pattern, " "); found {
+		pattern = path
+	}
+	if pattern == "" || pattern == "/" {
+		return "other"
+	}
+	return pattern
+}
+
+// Response writer that remembers the status code and body size written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Middleware to count requests and observe their latency
+func (m *Metrics) middleware(routeLabel func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		labels := prometheus.Labels{
+			"listener": listenerName(r),
+			"route":    routeLabel(r),
+			"method":   r.Method,
+			"status":   strconv.Itoa(recorder.status),
+		}
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"receipt-processor/internal/model"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const mediaMsgpack = "application/msgpack"
+
+// Decoding limits for MessagePack bodies: the size cap of the other binary formats, and encoding/json's nesting limit
+const (
+	maxMsgpackBodyBytes = 1 << 20
+	maxMsgpackDepth     = 10000
+)
+
+// MessagePack wire form of a receipt; the keys are the JSON field names and must not change
+type msgpackReceipt struct {
+	Retailer     string        `msgpack:"retailer"`
+	PurchaseDate string        `msgpack:"purchaseDate"`
+	PurchaseTime string        `msgpack:"purchaseTime"`
+	Items        []msgpackItem `msgpack:"items"`
+	Total        string        `msgpack:"total"`
+}
+
+type msgpackItem struct {
+	ShortDescription string `msgpack:"shortDescription"`
+	Price            string `msgpack:"price"`
+}
+
+type msgpackIDResponse struct {
+	ID          string `msgpack:"id"`
+	Duplicate   bool   `msgpack:"duplicate,omitempty"`
+	ImageStored bool   `msgpack:"imageStored,omitempty"`
+	ImageError  string `msgpack:"imageError,omitempty"`
+}
+
+type msgpackPointsResponse struct {
+	Points int `msgpack:"points"`
+}
+
+type msgpackBatchPointsRequest struct {
+	IDs []string `msgpack:"ids"`
+}
+
+type msgpackErrorResponse struct {
+	Error     string `msgpack:"error"`
+	RequestID string `msgpack:"requestId"`
+}
+
+type msgpackEnvelope struct {
+	Data any            `msgpack:"data"`
+	Meta map[string]any `msgpack:"meta"`
+}
+
+// Function to read a MessagePack body into v, refusing bodies over the size or nesting limits
+func decodeMsgpackBody(w http.ResponseWriter, r *http.Request, v any) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMsgpackBodyBytes))
+	if err != nil {
+		return err
+	}
+	if err := checkMsgpackDepth(body); err != nil {
+		return err
+	}
+	return msgpack.Unmarshal(body, v)
+}
+
+func decodeMsgpackReceipt(w http.ResponseWriter, r *http.Request) (model.Receipt, error) {
+	var wire msgpackReceipt
+	if err := decodeMsgpackBody(w, r, &wire); err != nil {
+		return model.Receipt{}, err
+	}
+	receipt := model.Receipt{
+		Retailer:     wire.Retailer,
+		PurchaseDate: wire.PurchaseDate,
+		PurchaseTime: wire.PurchaseTime,
+		Total:        wire.Total,
+	}
+	for _, item := range wire.Items {
+		receipt.Items = append(receipt.Items, model.Item{ShortDescription: item.ShortDescription, Price: item.Price})
+	}
+	return receipt, nil
+}
+
+var errMsgpackMalformed = errors.New("malformed MessagePack")
+
+// Width in bytes of the length that follows each sized str, bin, ext, array and map code
+var msgpackLengthWidths = map[byte]int{
+	0xc4: 1, 0xc5: 2, 0xc6: 4, // bin
+	0xc7: 1, 0xc8: 2, 0xc9: 4, // ext
+	0xd9: 1, 0xda: 2, 0xdb: 4, // str
+	0xdc: 2, 0xdd: 4, // array
+	0xde: 2, 0xdf: 4, // map
+}
+
+// Function to walk a MessagePack document without decoding it, checking that it is well formed and no deeper
+// than maxMsgpackDepth, so the decoder never recurses through hostile input
+func checkMsgpackDepth(data []byte) error {
+	// Values still to be read in each open array or map, innermost last; the document itself is one value
+	pending := []int{1}
+	for len(pending) > 0 {
+		if pending[len(pending)-1] == 0 {
+			pending = pending[:len(pending)-1]
+			continue
+		}
+		pending[len(pending)-1]--
+		if len(data) == 0 {
+			return errMsgpackMalformed
+		}
+		code := data[0]
+		data = data[1:]
+
+		n := 0
+		if width, sized := msgpackLengthWidths[code]; sized {
+			if len(data) < width {
+				return errMsgpackMalformed
+			}
+			for _, b := range data[:width] {
+				n = n<<8 | int(b)
+			}
+			data = data[width:]
+		}
+
+		// Containers open a level holding their values; scalars skip their payload
+		children, skip := -1, 0
+		switch {
+		case code >= 0x80 && code <= 0x8f:
+			children = 2 * int(code&0x0f)
+		case code >= 0x90 && code <= 0x9f:
+			children = int(code & 0x0f)
+		case code == 0xdc || code == 0xdd:
+			children = n
+		case code == 0xde || code == 0xdf:
+			children = 2 * n
+		case code <= 0x7f || code >= 0xe0 || code == 0xc0 || code == 0xc2 || code == 0xc3:
+		case code >= 0xa0 && code <= 0xbf:
+			skip = int(code & 0x1f)
+		case code == 0xcc || code == 0xd0:
+			skip = 1
+		case code == 0xcd || code == 0xd1:
+			skip = 2
+		case code == 0xca || code == 0xce || code == 0xd2:
+			skip = 4
+		case code == 0xcb || code == 0xcf || code == 0xd3:
+			skip = 8
+		case code >= 0xd4 && code <= 0xd8:
+			skip = 1 + 1<<(code-0xd4)
+		case code >= 0xc7 && code <= 0xc9:
+			skip = 1 + n
+		case code >= 0xc4 && code <= 0xc6 || code >= 0xd9 && code <= 0xdb:
+			skip = n
+		default:
+			return errMsgpackMalformed
+		}
+		if children >= 0 {
+			// Every value takes at least a byte, so a count past the end of the body is a lie
+			if children > len(data) {
+				return errMsgpackMalformed
+			}
+			if len(pending) > maxMsgpackDepth {
+				return errors.New("MessagePack nesting too deep")
+			}
+			pending = append(pending, children)
+			continue
+		}
+		if skip > len(data) {
+			return errMsgpackMalformed
+		}
+		data = data[skip:]
+	}
+	if len(data) != 0 {
+		return errMsgpackMalformed
+	}
+	return nil
+}
+
+// Function to write a MessagePack response, wrapped in the envelope when enabled, like writeJSON
+func (s *server) writeMsgpack(w http.ResponseWriter, r *http.Request, status int, data any, meta ...map[string]any) {
+	if s.responseEnvelope {
+		envelope := msgpackEnvelope{
+			Data: data,
+			Meta: map[string]any{
+				"requestId": requestIDFromContext(r.Context()),
+				"timestamp": s.clock().UTC().Format(time.RFC3339),
+			},
+		}
+		for _, extra := range meta {
+			maps.Copy(envelope.Meta, extra)
+		}
+		data = envelope
+	}
+	body, err := msgpack.Marshal(data)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "encoding MessagePack response", "path", r.URL.Path, "error", err)
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The response could not be encoded."))
+		return
+	}
+	w.Header().Set("Content-Type", mediaMsgpack)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Writer re-encoding the plain-text errors written by http.Error as MessagePack, for clients that asked for it
+type msgpackErrorWriter struct {
+	http.ResponseWriter
+	s       *server
+	r       *http.Request
+	status  int
+	message *bytes.Buffer
+}
+
+// Function to have a handler's error responses sent as MessagePack when that is the format the client wants;
+// call finish once the handler is done
+func (s *server) withMsgpackErrors(w http.ResponseWriter, r *http.Request) *msgpackErrorWriter {
+	return &msgpackErrorWriter{ResponseWriter: w, s: s, r: r}
+}
+
+func (m *msgpackErrorWriter) WriteHeader(status int) {
+	if status >= 400 && strings.HasPrefix(m.Header().Get("Content-Type"), "text/plain") {
+		m.status = status
+		m.message = new(bytes.Buffer)
+		return
+	}
+	m.ResponseWriter.WriteHeader(status)
+}
+
+func (m *msgpackErrorWriter) Write(p []byte) (int, error) {
+	if m.message != nil {
+		return m.message.Write(p)
+	}
+	return m.ResponseWriter.Write(p)
+}
+
+func (m *msgpackErrorWriter) Unwrap() http.ResponseWriter {
+	return m.ResponseWriter
+}
+
+func (m *msgpackErrorWriter) finish() {
+	if m.message == nil {
+		return
+	}
+	m.s.writeMsgpack(m.ResponseWriter, m.r, m.status, msgpackErrorResponse{
+		Error:     strings.TrimSpace(m.message.String()),
+		RequestID: requestIDFromContext(m.r.Context()),
+	})
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"receipt-processor/internal/model"
+)
+
+// Function to write a receipt in its MessagePack wire form
+func encodeMsgpackReceipt(t testing.TB, receipt model.Receipt) []byte {
+	t.Helper()
+	wire := msgpackReceipt{Retailer: receipt.Retailer, PurchaseDate: receipt.PurchaseDate, PurchaseTime: receipt.PurchaseTime, Total: receipt.Total}
+	for _, item := range receipt.Items {
+		wire.Items = append(wire.Items, msgpackItem{ShortDescription: item.ShortDescription, Price: item.Price})
+	}
+	body, err := msgpack.Marshal(wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// Function to decode a MessagePack body the way the process endpoint does
+func decodeMsgpack(body []byte) (model.Receipt, error) {
+	request := httptest.NewRequest(http.MethodPost, "/receipts/process", bytes.NewReader(body))
+	return decodeMsgpackReceipt(httptest.NewRecorder(), request)
+}
+
+// Any body either fails to decode or gives a receipt that survives encoding again; none panics or recurses
+// past the depth check
+func FuzzDecodeMsgpack(f *testing.F) {
+	for _, body := range []string{targetReceipt, cornerMarketReceipt, morningReceipt} {
+		f.Add(encodeMsgpackReceipt(f, decodeReceipt(f, body)))
+	}
+	f.Add([]byte{0x80})
+	f.Add(bytes.Repeat([]byte{0x91}, maxMsgpackDepth+1))
+	f.Add([]byte{0xdd, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, body []byte) {
+		receipt, err := decodeMsgpack(body)
+		if err != nil {
+			return
+		}
+		again, err := decodeMsgpack(encodeMsgpackReceipt(t, receipt))
+		if err != nil || !reflect.DeepEqual(again, receipt) {
+			t.Errorf("re-encoded receipt decoded to %+v, %v, want %+v", again, err, receipt)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Wire formats for receipt bodies; JSON stays the default
+const (
+	mediaJSON     = "application/json"
+	mediaProtobuf = "application/x-protobuf"
+	mediaXML      = "application/xml"
+	mediaText     = "text/plain"
+)
+
+// Response encoders by media type; each is handed the value the endpoint offers for its format
+var responseEncoders = map[string]func(s *server, w http.ResponseWriter, r *http.Request, status int, value any){
+	mediaJSON: func(s *server, w http.ResponseWriter, r *http.Request, status int, value any) {
+		s.writeJSON(w, r, status, value)
+	},
+	mediaProtobuf: func(s *server, w http.ResponseWriter, r *http.Request, status int, value any) {
+		s.writeProtobuf(w, r, status, value.(proto.Message))
+	},
+	mediaXML: (*server).writeXML,
+	mediaMsgpack: func(s *server, w http.ResponseWriter, r *http.Request, status int, value any) {
+		s.writeMsgpack(w, r, status, value)
+	},
+	mediaText: func(s *server, w http.ResponseWriter, r *http.Request, status int, value any) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprint(w, value)
+	},
+}
+
+// Short names accepted by the ?format= query parameter
+var formatAliases = map[string]string{
+	"json":     mediaJSON,
+	"protobuf": mediaProtobuf,
+	"xml":      mediaXML,
+	"text":     mediaText,
+	"msgpack":  mediaMsgpack,
+	"csv":      "text/csv",
+	"pdf":      "application/pdf",
+	"html":     "text/html",
+	"xlsx":     mediaXLSX,
+	"archive":  mediaZip,
+}
+
+// Function to list the wire formats the receipt endpoints speak; XML only when enabled
+func (s *server) receiptWireTypes() []string {
+	if s.xmlAPI {
+		return []string{mediaJSON, mediaProtobuf, mediaMsgpack, mediaXML}
+	}
+	return []string{mediaJSON, mediaProtobuf, mediaMsgpack}
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// Function to parse an Accept header into media ranges ordered by preference
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaType == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.TrimSpace(key) == "q" {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
+
+// Function to pick the offered media type the client prefers; the first offer is the default
+// when the header is absent, and "" means nothing offered is acceptable
+func negotiateContentType(header string, offers []string) string {
+	if strings.TrimSpace(header) == "" {
+		return offers[0]
+	}
+	for _, accepted := range parseAccept(header) {
+		if accepted.q <= 0 {
+			continue
+		}
+		for _, offer := range offers {
+			if mediaRangeMatches(accepted.mediaType, offer) {
+				return offer
+			}
+		}
+	}
+	return ""
+}
+
+// Function to check a request body's Content-Type against the supported ones, answering 415 for anything else;
+// a request without a Content-Type is taken to be the first supported type
+func (s *server) requireContentType(w http.ResponseWriter, r *http.Request, supported ...string) (string, bool) {
+	header := r.Header.Get("Content-Type")
+	if header == "" {
+		return supported[0], true
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	for _, offer := range supported {
+		if err == nil && mediaType == offer {
+			return offer, true
+		}
+	}
+	s.writeError(w, r, errorWithStatus(http.StatusUnsupportedMediaType, "Supported content types: "+strings.Join(supported, ", ")+"."))
+	return "", false
+}
+
+// Function to list the response formats an endpoint offers, dropping XML unless it is enabled
+func (s *server) offeredFormats(formats ...string) []string {
+	if s.xmlAPI {
+		return formats
+	}
+	return slices.DeleteFunc(formats, func(format string) bool { return format == mediaXML })
+}
+
+// Function to choose a response format: ?format= overrides the Accept header and with neither the first offer
+// is used. Answers 406 and returns "" when the client only asked for formats not offered
+func (s *server) chooseFormat(w http.ResponseWriter, r *http.Request, offers []string) string {
+	w.Header().Set("Vary", "Accept")
+	format := negotiateContentType(r.Header.Get("Accept"), offers)
+	if requested := strings.ToLower(r.URL.Query().Get("format")); requested != "" {
+		format = formatAliases[requested]
+		if format == "" {
+			format = requested
+		}
+		if !slices.Contains(offers, format) {
+			format = ""
+		}
+	}
+	if format == "" {
+		s.writeError(w, r, errorWithStatus(http.StatusNotAcceptable, "Supported formats: "+strings.Join(offers, ", ")+"."))
+	}
+	return format
+}
+
+// Function to write a response with the encoder registered for the chosen format
+func (s *server) writeFormat(w http.ResponseWriter, r *http.Request, status int, format string, value any) {
+	responseEncoders[format](s, w, r, status, value)
+}
+
+// Function to pick a response format from the Accept header, falling back to the first offer when none match
+func responseContentType(r *http.Request, offers ...string) string {
+	if format := negotiateContentType(r.Header.Get("Accept"), offers); format != "" {
+		return format
+	}
+	return offers[0]
+}
+
+func mediaRangeMatches(mediaRange, offer string) bool {
+	if mediaRange == "*/*" || mediaRange == offer {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(mediaRange, "/*"); ok {
+		return strings.HasPrefix(offer, prefix+"/")
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"receipt-processor/internal/model"
+)
+
+// A step that rewrites a submitted receipt before it is validated; an error rejects the receipt.
+// Imports normalize receipts on several workers at once, so implementations must be safe for concurrent use
+type Normalizer interface {
+	Normalize(receipt *model.Receipt) error
+}
+
+// Normalizers run in order; the first error aborts the chain
+type NormalizerChain []Normalizer
+
+// Function to run every normalizer in the chain over the receipt
+func (c NormalizerChain) Normalize(receipt *model.Receipt) error {
+	for _, normalizer := range c {
+		if err := normalizer.Normalize(receipt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to add normalizers that run before the rest of the chain
+func (c *NormalizerChain) Prepend(normalizers ...Normalizer) {
+	*c = append(append(NormalizerChain{}, normalizers...), *c...)
+}
+
+// Function to add normalizers that run after the rest of the chain
+func (c *NormalizerChain) Append(normalizers ...Normalizer) {
+	*c = append(*c, normalizers...)
+}
+
+// Trims surrounding whitespace from every string field, items included
+type TrimSpaceNormalizer struct{}
+
+func (TrimSpaceNormalizer) Normalize(receipt *model.Receipt) error {
+	receipt.Retailer = strings.TrimSpace(receipt.Retailer)
+	receipt.PurchaseDate = strings.TrimSpace(receipt.PurchaseDate)
+	receipt.PurchaseTime = strings.TrimSpace(receipt.PurchaseTime)
+	receipt.Total = strings.TrimSpace(receipt.Total)
+	for i := range receipt.Items {
+		receipt.Items[i].ShortDescription = strings.TrimSpace(receipt.Items[i].ShortDescription)
+		receipt.Items[i].Price = strings.TrimSpace(receipt.Items[i].Price)
+	}
+	return nil
+}
+
+// Upper-cases the retailer name so spellings of one retailer are stored and counted together
+type UpperCaseRetailerNormalizer struct{}
+
+func (UpperCaseRetailerNormalizer) Normalize(receipt *model.Receipt) error {
+	receipt.Retailer = strings.ToUpper(receipt.Retailer)
+	return nil
+}
+
+// Built-in normalizers by the names used in configuration
+var builtinNormalizers = map[string]Normalizer{
+	"trimSpace":         TrimSpaceNormalizer{},
+	"upperCaseRetailer": UpperCaseRetailerNormalizer{},
+}
+
+// Function to build a chain from built-in normalizer names
+func buildNormalizers(names []string) (NormalizerChain, error) {
+	chain := make(NormalizerChain, 0, len(names))
+	for _, name := range names {
+		normalizer, ok := builtinNormalizers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown normalizer %q", name)
+		}
+		chain = append(chain, normalizer)
+	}
+	return chain, nil
+}
+
+// Function to run the configured chain, reporting a failure like any other invalid receipt
+func (s *server) normalizeReceipt(receipt *model.Receipt) error {
+	if err := s.normalizers.Normalize(receipt); err != nil {
+		return invalidField("", "normalizer", "")
+	}
+	return nil
+}
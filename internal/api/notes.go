@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"receipt-processor/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Longest note text accepted, in characters
+const maxNoteLength = 2000
+
+// Handler to list the free-text notes on a receipt
+func (s *server) listNotesHandler(w http.ResponseWriter, r *http.Request, id string) {
+	shard := s.store.Shard(id)
+	shard.RLock()
+	_, exists := shard.Lookup(id)
+	list := append([]model.Note{}, shard.Notes[id]...)
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, list)
+}
+
+// Handler to add a free-text note to a receipt. Notes go through the store, so with the WAL enabled they survive a
+// restart along with the receipt
+func (s *server) addNoteHandler(w http.ResponseWriter, r *http.Request, id string) {
+	var request model.NoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || strings.TrimSpace(request.Text) == "" {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The note must have text."))
+		return
+	}
+	if utf8.RuneCountInString(request.Text) > maxNoteLength {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, fmt.Sprintf("The note must be at most %d characters.", maxNoteLength)))
+		return
+	}
+	note := model.Note{ID: uuid.New().String(), Text: request.Text, Author: request.Author, CreatedAt: s.clock().UTC()}
+	if note.Author == "" {
+		note.Author = s.requestActor(r)
+	}
+
+	shard := s.store.Shard(id)
+	shard.Lock()
+	defer shard.Unlock()
+	if _, exists := shard.Lookup(id); !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	if s.rejectArchived(w, r, shard, id) {
+		return
+	}
+	if err := s.store.AddNote(id, note); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.recordEvent(id, EventAnnotationAdded, s.requestActor(r), map[string]any{"noteId": note.ID})
+	s.writeJSON(w, r, http.StatusCreated, note)
+}
+
+// Handler to remove one note from a receipt; registered behind requireAdmin
+func (s *server) deleteNoteHandler(w http.ResponseWriter, r *http.Request, id string) {
+	noteID := r.PathValue("noteId")
+	shard := s.store.Shard(id)
+	shard.Lock()
+	defer shard.Unlock()
+	if s.rejectArchived(w, r, shard, id) {
+		return
+	}
+	removed, err := s.store.RemoveNote(id, noteID)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if !removed {
+		s.writeError(w, r, errorWithStatus(http.StatusNotFound, "No note found for that ID."))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
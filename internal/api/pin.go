@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"receipt-processor/internal/model"
+)
+
+// Handler to pin a receipt so it cannot be deleted until it is unpinned
+func (s *server) pinReceiptHandler(w http.ResponseWriter, r *http.Request, id string) {
+	s.setPinned(w, r, id, true)
+}
+
+// Handler to unpin a receipt, letting it be deleted again
+func (s *server) unpinReceiptHandler(w http.ResponseWriter, r *http.Request, id string) {
+	s.setPinned(w, r, id, false)
+}
+
+// Function to pin or unpin a receipt under its shard lock, the same lock deleteReceipt checks the pin under.
+// Pinning a pinned receipt, or unpinning one that is not, changes nothing and is not logged
+func (s *server) setPinned(w http.ResponseWriter, r *http.Request, id string, pinned bool) {
+	shard := s.store.Shard(id)
+	shard.Lock()
+	defer shard.Unlock()
+	if _, exists := shard.Lookup(id); !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	if shard.Pinned[id] != pinned {
+		if err := s.store.Pin(id, pinned); err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		event := EventUnpinned
+		if pinned {
+			event = EventPinned
+		}
+		s.recordEvent(id, event, s.requestActor(r), nil)
+	}
+	s.writeJSON(w, r, http.StatusOK, model.ResponsePinned{ID: id, Pinned: pinned})
+}
+
+// Function to read the ?pinned= listing filter: nil when it is absent, otherwise the pin state to list
+func pinnedFilter(r *http.Request) (*bool, error) {
+	value := r.URL.Query().Get("pinned")
+	if value == "" {
+		return nil, nil
+	}
+	pinned, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, errorWithStatus(http.StatusBadRequest, "pinned must be true or false.")
+	}
+	return &pinned, nil
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/receiptpb"
+)
+
+// Function to pin or unpin a receipt over HTTP and check the state it reports
+func setPinnedOver(t *testing.T, server *httptest.Server, id string, pinned bool) {
+	t.Helper()
+	action := "/unpin"
+	if pinned {
+		action = "/pin"
+	}
+	response, body := send(t, server, http.MethodPost, "/receipts/"+id+action, "")
+	var state model.ResponsePinned
+	decodeBody(t, body, &state)
+	if response.StatusCode != http.StatusOK || state.ID != id || state.Pinned != pinned {
+		t.Fatalf("POST %s = %d %s, want pinned %v", action, response.StatusCode, body, pinned)
+	}
+}
+
+func TestPinnedReceiptsCannotBeDeleted(t *testing.T) {
+	config := defaultConfig()
+	config.AdminToken = "admin-token"
+	server := httptest.NewServer(newTestServer(t, WithConfig(config)))
+	defer server.Close()
+	id := processReceipt(t, server, targetReceipt)
+
+	if response, body := send(t, server, http.MethodPost, "/receipts/"+routeID+"/pin", ""); response.StatusCode != http.StatusNotFound {
+		t.Errorf("pinning an unknown receipt = %d %s, want 404", response.StatusCode, body)
+	}
+	setPinnedOver(t, server, id, true)
+	setPinnedOver(t, server, id, true)
+	if response, body := send(t, server, http.MethodDelete, "/receipts/"+id, "", "X-Admin-Token", "admin-token"); response.StatusCode != http.StatusConflict {
+		t.Errorf("deleting a pinned receipt = %d %s, want 409", response.StatusCode, body)
+	}
+	mutation := `{"query":"mutation { deleteReceipt(id: \"` + id + `\") }"}`
+	response, body := send(t, server, http.MethodPost, "/graphql", mutation, "Content-Type", mediaJSON, "X-Admin-Token", "admin-token")
+	if response.StatusCode != http.StatusOK || !strings.Contains(body, errPinned.Error()) {
+		t.Errorf("deleteReceipt of a pinned receipt = %d %s, want the pinned conflict", response.StatusCode, body)
+	}
+	if got := receiptPoints(t, server, id); got != 28 {
+		t.Fatalf("points after the refused deletes = %d, want 28", got)
+	}
+
+	setPinnedOver(t, server, id, false)
+	if response, body := send(t, server, http.MethodDelete, "/receipts/"+id, "", "X-Admin-Token", "admin-token"); response.StatusCode != http.StatusNoContent {
+		t.Fatalf("deleting an unpinned receipt = %d %s, want 204", response.StatusCode, body)
+	}
+	if response, _ := send(t, server, http.MethodGet, "/receipts/"+id+"/points", ""); response.StatusCode != http.StatusNotFound {
+		t.Errorf("points of the deleted receipt = %d, want 404", response.StatusCode)
+	}
+}
+
+func TestListingsFilterByPin(t *testing.T) {
+	s := newTestServer(t)
+	server := httptest.NewServer(s)
+	defer server.Close()
+	pinned := processReceipt(t, server, targetReceipt)
+	processReceipt(t, server, cornerMarketReceipt)
+	setPinnedOver(t, server, pinned, true)
+
+	query := `{"query":"{ receipts(filter: {pinned: true}) { totalCount edges { node { id pinned } } } }"}`
+	_, body := send(t, server, http.MethodPost, "/graphql", query, "Content-Type", mediaJSON)
+	var result struct {
+		Data struct {
+			Receipts struct {
+				TotalCount int
+				Edges      []struct {
+					Node struct {
+						ID     string
+						Pinned bool
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatal(err)
+	}
+	if edges := result.Data.Receipts.Edges; len(edges) != 1 || edges[0].Node.ID != pinned || !edges[0].Node.Pinned {
+		t.Errorf("GraphQL receipts filtered by pin = %s, want only %s", body, pinned)
+	}
+
+	client := receiptpb.NewReceiptServiceClient(dialGRPC(t, s))
+	page, err := client.ListReceipts(context.Background(), &receiptpb.ListReceiptsRequest{Pinned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listed := page.GetReceipts(); len(listed) != 1 || listed[0].GetId() != pinned || !listed[0].GetPinned() {
+		t.Errorf("ListReceipts of pinned receipts = %v, want only %s", listed, pinned)
+	}
+	if page, err := client.ListReceipts(context.Background(), &receiptpb.ListReceiptsRequest{}); err != nil || len(page.GetReceipts()) != 2 {
+		t.Errorf("ListReceipts = %v, %v, want both receipts", page.GetReceipts(), err)
+	}
+
+	if response, body := send(t, server, http.MethodGet, "/receipts/export?pinned=maybe", "", "Accept", mediaXLSX); response.StatusCode != http.StatusBadRequest {
+		t.Errorf("export with ?pinned=maybe = %d %s, want 400", response.StatusCode, body)
+	}
+	if response, _ := send(t, server, http.MethodGet, "/receipts/export?pinned=true", "", "Accept", mediaXLSX); response.StatusCode != http.StatusOK {
+		t.Errorf("export with ?pinned=true = %d, want 200", response.StatusCode)
+	}
+	only := true
+	var exported []string
+	for receipt := range s.exportedReceipts(&only) {
+		exported = append(exported, receipt.ID)
+	}
+	if len(exported) != 1 || exported[0] != pinned {
+		t.Errorf("exported pinned receipts %v, want only %s", exported, pinned)
+	}
+}
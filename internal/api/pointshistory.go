@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+
+	"receipt-processor/internal/model"
+)
+
+// Function to log a change already applied to a receipt's points; callers hold the receipt's shard lock
+func (s *server) recordPointsMutation(id, mutationType string, delta int, actor string) {
+	shard := s.store.Shard(id)
+	shard.PointsMutations[id] = append(shard.PointsMutations[id], model.PointsMutation{
+		Type:      mutationType,
+		Delta:     delta,
+		NewValue:  shard.Points[id],
+		Timestamp: s.clock().UTC(),
+		Actor:     actor,
+	})
+}
+
+// Handler to list every change to a receipt's points, oldest first
+func (s *server) pointsHistoryHandler(w http.ResponseWriter, r *http.Request, id string) {
+	shard := s.store.Shard(id)
+	shard.RLock()
+	_, exists := shard.Points[id]
+	history := append([]model.PointsMutation{}, shard.PointsMutations[id]...)
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, history)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/points"
+)
+
+// Function to check a receipt's points history adds up to its current points, oldest entry first
+func checkLedger(t *testing.T, server *httptest.Server, id string) []model.PointsMutation {
+	t.Helper()
+	response, body := send(t, server, http.MethodGet, "/receipts/"+id+"/points/history", "")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("points history = %d %s", response.StatusCode, body)
+	}
+	var history []model.PointsMutation
+	decodeBody(t, body, &history)
+	sum := 0
+	for _, mutation := range history {
+		sum += mutation.Delta
+		if mutation.NewValue != sum {
+			t.Errorf("%s entry has new value %d, want %d from the deltas so far", mutation.Type, mutation.NewValue, sum)
+		}
+	}
+	if current := receiptPoints(t, server, id); sum != current {
+		t.Errorf("deltas add up to %d, want the current %d points", sum, current)
+	}
+	return history
+}
+
+func TestPointsHistoryAddsUpToCurrentPoints(t *testing.T) {
+	server := startServer(t, points.DefaultConfig())
+	target := processReceipt(t, server, targetReceipt)
+	market := processReceipt(t, server, cornerMarketReceipt)
+	checkLedger(t, server, target)
+
+	// An open reservation takes its points off until it is rolled back
+	response, body := send(t, server, http.MethodPost, "/receipts/"+target+"/points/reserve", `{"points":5}`)
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("reserving = %d %s", response.StatusCode, body)
+	}
+	var reservation model.Reservation
+	decodeBody(t, body, &reservation)
+	checkLedger(t, server, target)
+	if response, body := send(t, server, http.MethodPost, "/receipts/"+target+"/points/rollback/"+reservation.ID, ""); response.StatusCode != http.StatusOK {
+		t.Fatalf("rolling back = %d %s", response.StatusCode, body)
+	}
+
+	redeemPoints(t, server, market, 10)
+	if response, body := send(t, server, http.MethodPost, "/receipts/"+market+"/points/transfer", `{"targetId":"`+target+`","amount":9}`); response.StatusCode != http.StatusOK {
+		t.Fatalf("transfer = %d %s", response.StatusCode, body)
+	}
+	checkLedger(t, server, market)
+	checkLedger(t, server, target)
+
+	// Adding an item rescores the receipt
+	if response, body := send(t, server, http.MethodPost, "/receipts/"+target+"/items", `{"item":{"shortDescription":"Gum","price":"1.00"},"total":"36.35"}`); response.StatusCode != http.StatusCreated {
+		t.Fatalf("adding an item = %d %s", response.StatusCode, body)
+	}
+	history := checkLedger(t, server, target)
+	types := make([]string, 0, len(history))
+	for _, mutation := range history {
+		types = append(types, mutation.Type)
+	}
+	want := []string{model.MutationCalculated, model.MutationReserved, model.MutationReleased, model.MutationTransferIn, model.MutationRecalculated}
+	if len(types) != len(want) {
+		t.Fatalf("target history %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("target history %v, want %v", types, want)
+			break
+		}
+	}
+	if last := history[len(history)-1]; last.Delta == 0 {
+		t.Error("the rescore recorded no change in points")
+	}
+}
@@ -0,0 +1,22 @@
+package api
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// Function to build a handler serving the runtime profiles under /debug/pprof/ to the admin ranges, and to admins
+// when an admin token is set
+func (s *server) pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	if s.adminToken != "" {
+		return s.requireAdmin(s.adminIPGuard(mux))
+	}
+	return s.adminIPGuard(mux)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/points"
+)
+
+func TestPprofEndpoints(t *testing.T) {
+	disabled := defaultConfig()
+	enabled := defaultConfig()
+	enabled.Pprof.Enabled = true
+	protected := enabled
+	protected.AdminToken = "admin-token"
+	ownListener := enabled
+	ownListener.Pprof.Addr = "127.0.0.1:6060"
+
+	tests := []struct {
+		name   string
+		config Config
+		path   string
+		token  string
+		want   int
+	}{
+		{"disabled index", disabled, "/debug/pprof/", "", http.StatusNotFound},
+		{"disabled profile", disabled, "/debug/pprof/heap", "", http.StatusNotFound},
+		{"index", enabled, "/debug/pprof/", "", http.StatusOK},
+		{"heap profile", enabled, "/debug/pprof/heap?debug=1", "", http.StatusOK},
+		{"command line", enabled, "/debug/pprof/cmdline", "", http.StatusOK},
+		{"without the admin token", protected, "/debug/pprof/", "", http.StatusUnauthorized},
+		{"with the admin token", protected, "/debug/pprof/", "admin-token", http.StatusOK},
+		{"on its own listener", ownListener, "/debug/pprof/", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startServer(t, points.DefaultConfig(), WithConfig(test.config))
+			var header []string
+			if test.token != "" {
+				header = []string{"X-Admin-Token", test.token}
+			}
+			response, body := send(t, server, http.MethodGet, test.path, "", header...)
+			if response.StatusCode != test.want {
+				t.Fatalf("GET %s = %d, want %d", test.path, response.StatusCode, test.want)
+			}
+			if test.path == "/debug/pprof/" && test.want == http.StatusOK && !strings.Contains(body, "heap") {
+				t.Errorf("index page does not list the profiles: %.200s", body)
+			}
+		})
+	}
+}
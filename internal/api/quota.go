@@ -0,0 +1,115 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var errQuotaExceeded = errors.New("daily receipt quota exceeded")
+
+// Function to name the tenant a submission is billed to. X-Tenant-ID is only honoured from admin callers and from
+// trusted proxies setting it for the clients behind them; everyone else is billed as the caller requestActor names,
+// so a client cannot spend another tenant's quota or replay its idempotency keys by naming it
+func (s *server) tenantFromRequest(r *http.Request) string {
+	if tenant := r.Header.Get("X-Tenant-ID"); tenant != "" && (s.isAdminRequest(r) || s.fromTrustedProxy(r)) {
+		return tenant
+	}
+	return s.requestActor(r)
+}
+
+func quotaDate(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+// Function to check the tenant has a submission left today; callers hold quotaMutex
+func (s *server) checkQuota(tenant string, now time.Time) error {
+	if s.dailyReceiptQuota > 0 && s.quotas[tenant][quotaDate(now)] >= s.dailyReceiptQuota {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// Function to add n submissions, or take them back when n is negative, to the tenant's count for the day;
+// callers hold quotaMutex
+func (s *server) chargeQuota(tenant string, now time.Time, n int) {
+	if s.dailyReceiptQuota <= 0 {
+		return
+	}
+	date := quotaDate(now)
+	if s.quotas[tenant] == nil {
+		s.quotas[tenant] = make(map[string]int)
+	}
+	s.quotas[tenant][date] = max(0, s.quotas[tenant][date]+n)
+}
+
+// Function to count one submission against the tenant's daily quota and the API key's quotas. Both are checked
+// before either is charged, so a submission refused by one limit uses up nothing of the other
+func (s *server) consumeQuotas(tenant, apiKey string, now time.Time) error {
+	s.quotaMutex.Lock()
+	defer s.quotaMutex.Unlock()
+	if err := s.checkQuota(tenant, now); err != nil {
+		return err
+	}
+	if err := s.checkKeyQuota(apiKey, now); err != nil {
+		return err
+	}
+	s.chargeQuota(tenant, now, 1)
+	s.chargeKeyQuota(apiKey, now, 1)
+	return nil
+}
+
+// Function to give back a submission consumeQuotas counted when storing it failed afterwards
+func (s *server) refundQuotas(tenant, apiKey string, now time.Time) {
+	s.quotaMutex.Lock()
+	defer s.quotaMutex.Unlock()
+	s.chargeQuota(tenant, now, -1)
+	s.chargeKeyQuota(apiKey, now, -1)
+}
+
+// Function to describe the tenant's quota in response headers
+func (s *server) setQuotaHeaders(w http.ResponseWriter, tenant string) {
+	if s.dailyReceiptQuota <= 0 {
+		return
+	}
+	now := s.clock()
+	s.quotaMutex.Lock()
+	used := s.quotas[tenant][quotaDate(now)]
+	s.quotaMutex.Unlock()
+
+	reset := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	w.Header().Set("X-Quota-Limit", strconv.Itoa(s.dailyReceiptQuota))
+	w.Header().Set("X-Quota-Remaining", strconv.Itoa(max(0, s.dailyReceiptQuota-used)))
+	w.Header().Set("X-Quota-Reset", reset.Format(time.RFC3339))
+}
+
+// Function to drop the previous day's counters at every UTC midnight
+func (s *server) resetQuotasDaily(stop <-chan struct{}) {
+	for {
+		now := time.Now().UTC()
+		midnight := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		timer := time.NewTimer(midnight.Sub(now))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		today := quotaDate(s.clock())
+		s.quotaMutex.Lock()
+		for tenant, days := range s.quotas {
+			for date := range days {
+				if date != today {
+					delete(days, date)
+				}
+			}
+			if len(days) == 0 {
+				delete(s.quotas, tenant)
+			}
+		}
+		s.pruneKeyUsage(s.clock())
+		s.quotaMutex.Unlock()
+	}
+}
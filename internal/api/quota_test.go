@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+)
+
+// Function to make the nth distinct copy of the target receipt, so strict mode never folds submissions together
+func distinctReceipt(n int) string {
+	return strings.Replace(targetReceipt, `"Target"`, `"Target `+strings.Repeat("I", n+1)+`"`, 1)
+}
+
+// A submission the API key's quota refuses takes nothing from the tenant's quota
+func TestKeyQuotaRefusalLeavesTenantQuota(t *testing.T) {
+	config := defaultConfig()
+	config.DailyReceiptQuota = 5
+	config.APIKeys = "acme:acme-key"
+	config.APIKeyQuotas = map[string]KeyQuota{"acme": {Daily: 1}}
+	server := startServer(t, points.DefaultConfig(), WithConfig(config))
+
+	response, body := send(t, server, http.MethodPost, "/receipts/process", distinctReceipt(0), "X-API-Key", "acme-key")
+	if response.StatusCode != http.StatusOK || response.Header.Get("X-Quota-Remaining") != "4" {
+		t.Fatalf("first submission = %d %s with %s remaining, want 200 with 4 remaining", response.StatusCode, body,
+			response.Header.Get("X-Quota-Remaining"))
+	}
+	for i := 1; i <= 3; i++ {
+		response, body := send(t, server, http.MethodPost, "/receipts/process", distinctReceipt(i), "X-API-Key", "acme-key")
+		if response.StatusCode != http.StatusTooManyRequests || response.Header.Get("X-Quota-Remaining") != "4" {
+			t.Errorf("submission %d past the key's quota = %d %s with %s remaining, want 429 with 4 remaining", i,
+				response.StatusCode, body, response.Header.Get("X-Quota-Remaining"))
+		}
+	}
+}
+
+// X-Tenant-ID only moves a submission to another tenant's quota when an admin or a trusted proxy sends it
+func TestTenantHeaderNeedsATrustedCaller(t *testing.T) {
+	config := defaultConfig()
+	config.DailyReceiptQuota = 1
+	config.AdminToken = "admin-token"
+	server := startServer(t, points.DefaultConfig(), WithConfig(config))
+
+	if response, body := send(t, server, http.MethodPost, "/receipts/process", distinctReceipt(0), "X-Tenant-ID", "acme"); response.StatusCode != http.StatusOK {
+		t.Fatalf("first submission = %d %s, want 200", response.StatusCode, body)
+	}
+	if response, body := send(t, server, http.MethodPost, "/receipts/process", distinctReceipt(1), "X-Tenant-ID", "globex"); response.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("untrusted submission naming another tenant = %d %s, want 429 from the caller's own quota", response.StatusCode, body)
+	}
+	if response, body := send(t, server, http.MethodPost, "/receipts/process", distinctReceipt(2), "X-Tenant-ID", "globex", "X-Admin-Token", "admin-token"); response.StatusCode != http.StatusOK {
+		t.Errorf("admin submission for another tenant = %d %s, want 200", response.StatusCode, body)
+	}
+
+	config.AdminToken = ""
+	config.TrustedProxies = []string{"127.0.0.1/32", "::1/128"}
+	proxied := startServer(t, points.DefaultConfig(), WithConfig(config))
+	for i, tenant := range []string{"acme", "globex"} {
+		if response, body := send(t, proxied, http.MethodPost, "/receipts/process", distinctReceipt(i), "X-Tenant-ID", tenant); response.StatusCode != http.StatusOK {
+			t.Errorf("proxied submission for %s = %d %s, want 200 from its own quota", tenant, response.StatusCode, body)
+		}
+	}
+}
+
+// Store whose inserts fail, as when the WAL cannot be written
+type failingInsertStore struct {
+	*store.Memory
+}
+
+func (failingInsertStore) Insert(string, store.Entry) (store.Ack, error) {
+	return nil, errors.New("writing WAL: disk full")
+}
+
+// A submission that could not be stored gives back what it counted against both quotas
+func TestFailedInsertRefundsQuotas(t *testing.T) {
+	config := defaultConfig()
+	config.DailyReceiptQuota = 5
+	config.APIKeys = "acme:acme-key"
+	config.APIKeyQuotas = map[string]KeyQuota{"acme": {Daily: 1}}
+	server := startServerOver(t, failingInsertStore{store.NewMemory(defaultLockShards)}, points.DefaultConfig(), WithConfig(config))
+
+	for i := range 3 {
+		if response, body := send(t, server, http.MethodPost, "/receipts/process", distinctReceipt(i), "X-API-Key", "acme-key"); response.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("submission %d = %d %s, want 500", i, response.StatusCode, body)
+		}
+	}
+	_, body := send(t, server, http.MethodGet, "/usage", "", "X-API-Key", "acme-key")
+	var usage KeyUsage
+	if decodeBody(t, body, &usage); usage.Daily.Used != 0 || usage.Monthly.Used != 0 {
+		t.Errorf("key usage after failed inserts = %+v, want none used", usage)
+	}
+}
+
+// Store whose inserts are applied but never synced, as when group commit's fsync fails
+type failingSyncStore struct {
+	*store.Memory
+}
+
+func (f failingSyncStore) Insert(id string, entry store.Entry) (store.Ack, error) {
+	f.Memory.Insert(id, entry)
+	ack := make(store.Ack, 1)
+	ack <- errors.New("writing WAL: input/output error")
+	return ack, nil
+}
+
+// A receipt whose record failed to sync is taken back: it is not served, its fingerprint is free for the retry and
+// nothing it counted stays counted
+func TestFailedSyncTakesBackTheInsert(t *testing.T) {
+	config := defaultConfig()
+	config.StrictMode = true
+	config.DailyReceiptQuota = 5
+	config.APIKeys = "acme:acme-key"
+	config.APIKeyQuotas = map[string]KeyQuota{"acme": {Daily: 1}}
+	memory := store.NewMemory(defaultLockShards)
+	server := startServerOver(t, failingSyncStore{memory}, points.DefaultConfig(), WithConfig(config))
+
+	for range 2 {
+		if response, body := send(t, server, http.MethodPost, "/receipts/process", targetReceipt, "X-API-Key", "acme-key"); response.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("submission = %d %s, want 500", response.StatusCode, body)
+		}
+	}
+	if count := memory.Count(); count != 0 {
+		t.Errorf("%d receipts stored after failed syncs, want none", count)
+	}
+	if owner, owned := memory.FingerprintOwner(fingerprintReceipt(decodeReceipt(t, targetReceipt))); owned {
+		t.Errorf("the fingerprint is still claimed by %s", owner)
+	}
+	if usage := memory.KeyUsage()["acme"]; usage[time.Now().UTC().Format(time.DateOnly)] != 0 {
+		t.Errorf("store key usage after failed syncs = %v, want none", usage)
+	}
+	_, body := send(t, server, http.MethodGet, "/usage", "", "X-API-Key", "acme-key")
+	var usage KeyUsage
+	if decodeBody(t, body, &usage); usage.Daily.Used != 0 {
+		t.Errorf("key usage after failed syncs = %+v, want none used", usage)
+	}
+	_, body = send(t, server, http.MethodGet, "/retailers/Target/points-24h", "", "X-API-Key", "acme-key")
+	var retailer RetailerPoints
+	if decodeBody(t, body, &retailer); retailer.Points != 0 {
+		t.Errorf("Target's points after failed syncs = %d, want 0", retailer.Points)
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+)
+
+type InternalErrorResponse struct {
+	Error     string `json:"error"`
+	RequestID string `json:"requestId"`
+}
+
+// Middleware to turn a handler panic into a logged 500 instead of a dropped connection
+func (s *server) recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// Handlers abort on purpose with http.ErrAbortHandler; let net/http close that connection quietly
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			requestID := requestIDFromContext(r.Context())
+			s.logger.ErrorContext(r.Context(), "handler panicked",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"requestId", requestID,
+				"panic", recovered,
+				"stack", string(debug.Stack()),
+			)
+			// Once part of the response is out it cannot be replaced, so the connection is dropped instead
+			if recorder.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			// Headers set for the abandoned response, such as a gzip encoding, no longer apply
+			w.Header().Del("Content-Encoding")
+			w.Header().Del("Content-Length")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(InternalErrorResponse{Error: "internal server error", RequestID: requestID})
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"receipt-processor/internal/model"
+)
+
+// Most related receipts returned, and how many days either side of the purchase date count as related
+const (
+	maxRelatedReceipts = 10
+	relatedWindowDays  = 7
+)
+
+type RelatedReceipt struct {
+	ID           string `json:"id"`
+	PurchaseDate string `json:"purchaseDate"`
+}
+
+type RelatedReceipts struct {
+	Related []RelatedReceipt `json:"related"`
+}
+
+// Function to measure how many whole days apart two purchase dates are
+func daysApart(a, b time.Time) int {
+	days := int(a.Sub(b).Hours() / 24)
+	if days < 0 {
+		return -days
+	}
+	return days
+}
+
+// Handler to list receipts from the same retailer purchased within a week of the given receipt, nearest first
+func (s *server) relatedReceiptsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if s.rejectStoreLight(w, r) {
+		return
+	}
+	shard := s.store.Shard(id)
+	shard.RLock()
+	receipt, exists := shard.Lookup(id)
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	date, _ := time.Parse("2006-01-02", receipt.PurchaseDate)
+	retailer := strings.TrimSpace(receipt.Retailer)
+
+	type candidate struct {
+		related RelatedReceipt
+		days    int
+	}
+	var candidates []candidate
+	for _, shard := range s.store.Shards() {
+		shard.RLock()
+		for _, stored := range []map[string]model.Receipt{shard.Receipts, shard.Archived} {
+			for otherID, other := range stored {
+				if otherID == id || !strings.EqualFold(strings.TrimSpace(other.Retailer), retailer) {
+					continue
+				}
+				otherDate, err := time.Parse("2006-01-02", other.PurchaseDate)
+				if err != nil {
+					continue
+				}
+				if days := daysApart(otherDate, date); days <= relatedWindowDays {
+					candidates = append(candidates, candidate{RelatedReceipt{otherID, other.PurchaseDate}, days})
+				}
+			}
+		}
+		shard.RUnlock()
+	}
+
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return cmp.Or(cmp.Compare(a.days, b.days), strings.Compare(a.related.ID, b.related.ID))
+	})
+	response := RelatedReceipts{Related: []RelatedReceipt{}}
+	for _, c := range candidates[:min(len(candidates), maxRelatedReceipts)] {
+		response.Related = append(response.Related, c.related)
+	}
+	s.writeJSON(w, r, http.StatusOK, response)
+}
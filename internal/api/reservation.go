@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"receipt-processor/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Function to return a reservation's points to its receipt; callers hold the receipt's shard lock and reservationsMutex
+func (s *server) releaseReservation(reservation model.Reservation, eventType, actor string) {
+	delete(s.reservations, reservation.ID)
+	shard := s.store.Shard(reservation.ReceiptID)
+	if _, exists := shard.Points[reservation.ReceiptID]; exists {
+		shard.Points[reservation.ReceiptID] += reservation.Points
+		s.pointsCache.invalidate(reservation.ReceiptID)
+		s.recordPointsMutation(reservation.ReceiptID, model.MutationReleased, reservation.Points, actor)
+	}
+	s.recordEvent(reservation.ReceiptID, eventType, actor, map[string]any{
+		"reservationId": reservation.ID,
+		"points":        reservation.Points,
+	})
+}
+
+// Function to total the points open reservations hold on each receipt
+func (s *server) reservedPoints() map[string]int {
+	s.reservationsMutex.Lock()
+	defer s.reservationsMutex.Unlock()
+	reserved := make(map[string]int)
+	for _, reservation := range s.reservations {
+		reserved[reservation.ReceiptID] += reservation.Points
+	}
+	return reserved
+}
+
+// Function to release every reservation past its TTL; callers hold no shard lock. Each is released under its
+// receipt's shard lock, if it is still open by then
+func (s *server) expireReservations(now time.Time) {
+	var expired []model.Reservation
+	s.reservationsMutex.Lock()
+	for _, reservation := range s.reservations {
+		if now.After(reservation.ExpiresAt) {
+			expired = append(expired, reservation)
+		}
+	}
+	s.reservationsMutex.Unlock()
+
+	for _, reservation := range expired {
+		shard := s.store.Shard(reservation.ReceiptID)
+		shard.Lock()
+		s.reservationsMutex.Lock()
+		if _, open := s.reservations[reservation.ID]; open {
+			s.releaseReservation(reservation, EventReservationExpired, "system")
+		}
+		s.reservationsMutex.Unlock()
+		shard.Unlock()
+	}
+}
+
+// Function to sweep expired reservations once a second until stop is closed
+func (s *server) expireReservationsPeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.expireReservations(now)
+		}
+	}
+}
+
+// Handler to hold some of a receipt's points until the caller commits or rolls back
+func (s *server) reservePointsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	var request model.ReserveRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Points <= 0 {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The reservation must ask for a positive number of points."))
+		return
+	}
+
+	s.expireReservations(s.clock())
+	shard := s.store.Shard(id)
+	shard.Lock()
+	defer shard.Unlock()
+	available, exists := shard.Points[id]
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	if s.rejectArchived(w, r, shard, id) {
+		return
+	}
+	if request.Points > available {
+		s.writeError(w, r, errInsufficientPoints)
+		return
+	}
+
+	reservation := model.Reservation{
+		ID:        uuid.New().String(),
+		ReceiptID: id,
+		Points:    request.Points,
+		ExpiresAt: s.clock().Add(s.reservationTTL).UTC(),
+	}
+	s.reservationsMutex.Lock()
+	s.reservations[reservation.ID] = reservation
+	s.reservationsMutex.Unlock()
+	shard.Points[id] -= request.Points
+	s.pointsCache.invalidate(id)
+	s.recordPointsMutation(id, model.MutationReserved, -request.Points, s.requestActor(r))
+	s.recordEvent(id, EventReserved, s.requestActor(r), map[string]any{
+		"reservationId": reservation.ID,
+		"points":        reservation.Points,
+	})
+	s.writeJSON(w, r, http.StatusCreated, reservation)
+}
+
+// Function to look up an open reservation on a receipt from the reservationId path value; callers hold the receipt's
+// shard lock, which keeps its reservations as they are
+func (s *server) findReservation(w http.ResponseWriter, r *http.Request, id string) (model.Reservation, bool) {
+	s.reservationsMutex.Lock()
+	reservation, exists := s.reservations[r.PathValue("reservationId")]
+	s.reservationsMutex.Unlock()
+	if !exists || reservation.ReceiptID != id {
+		s.writeError(w, r, errorWithStatus(http.StatusNotFound, "No open reservation found for that ID."))
+		return model.Reservation{}, false
+	}
+	return reservation, true
+}
+
+// Handler to make a reservation's redemption final
+func (s *server) commitReservationHandler(w http.ResponseWriter, r *http.Request, id string) {
+	s.expireReservations(s.clock())
+	shard := s.store.Shard(id)
+	shard.Lock()
+	defer shard.Unlock()
+	reservation, ok := s.findReservation(w, r, id)
+	if !ok {
+		return
+	}
+	if err := s.store.Redeem(id, reservation.Points); err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The redemption could not be stored."))
+		return
+	}
+	s.reservationsMutex.Lock()
+	delete(s.reservations, reservation.ID)
+	s.reservationsMutex.Unlock()
+	s.recordEvent(id, EventRedeemed, s.requestActor(r), map[string]any{
+		"reservationId": reservation.ID,
+		"points":        reservation.Points,
+	})
+	s.writeJSON(w, r, http.StatusOK, model.ResponsePoints{Points: shard.Points[id]})
+}
+
+// Handler to cancel a reservation and give its points back to the receipt
+func (s *server) rollbackReservationHandler(w http.ResponseWriter, r *http.Request, id string) {
+	s.expireReservations(s.clock())
+	shard := s.store.Shard(id)
+	shard.Lock()
+	defer shard.Unlock()
+	reservation, ok := s.findReservation(w, r, id)
+	if !ok {
+		return
+	}
+	s.reservationsMutex.Lock()
+	s.releaseReservation(reservation, EventReservationRolledBack, s.requestActor(r))
+	s.reservationsMutex.Unlock()
+	s.writeJSON(w, r, http.StatusOK, model.ResponsePoints{Points: shard.Points[id]})
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+)
+
+type Envelope struct {
+	Data any            `json:"data"`
+	Meta map[string]any `json:"meta"`
+}
+
+type requestIDKey struct{}
+
+// Middleware to tag every request with an ID, reusing the caller's X-Request-ID when it sends one
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			id = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// Function to get the ID assigned to the current request
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Encoded bodies are built in pooled buffers so they can be sized and checked before anything is sent
+var responseBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// Buffers that grew past this many bytes are dropped rather than pooled
+const maxPooledBufferSize = 64 << 10
+
+// Function to write a JSON response, wrapped in the envelope when enabled; meta entries extend the envelope metadata
+func (s *server) writeJSON(w http.ResponseWriter, r *http.Request, status int, data any, meta ...map[string]any) {
+	if s.responseEnvelope {
+		envelope := Envelope{
+			Data: data,
+			Meta: map[string]any{
+				"requestId": requestIDFromContext(r.Context()),
+				"timestamp": s.clock().UTC().Format(time.RFC3339),
+			},
+		}
+		for _, extra := range meta {
+			maps.Copy(envelope.Meta, extra)
+		}
+		data = envelope
+	}
+
+	buf := responseBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledBufferSize {
+			buf.Reset()
+			responseBuffers.Put(buf)
+		}
+	}()
+	buf.Reset()
+	if err := json.NewEncoder(buf).Encode(data); err != nil {
+		s.logger.ErrorContext(r.Context(), "encoding JSON response", "path", r.URL.Path, "error", err)
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The response could not be encoded."))
+		return
+	}
+
+	writeEncodedJSON(w, status, buf.Bytes())
+}
+
+// Function to write a protobuf response for clients that asked for application/x-protobuf
+func (s *server) writeProtobuf(w http.ResponseWriter, r *http.Request, status int, message proto.Message) {
+	body, err := proto.Marshal(message)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "encoding protobuf response", "path", r.URL.Path, "error", err)
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The response could not be encoded."))
+		return
+	}
+	w.Header().Set("Content-Type", mediaProtobuf)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Function to write an already encoded JSON body
+func writeEncodedJSON(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
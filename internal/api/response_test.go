@@ -0,0 +1,132 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/model"
+)
+
+func TestWriteJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		envelope bool
+		status   int
+		data     any
+		want     int
+		body     string
+	}{
+		{"object", false, http.StatusOK, map[string]int{"points": 28}, http.StatusOK, `{"points":28}` + "\n"},
+		{"explicit status", false, http.StatusCreated, map[string]string{"id": routeID}, http.StatusCreated,
+			`{"id":"` + routeID + `"}` + "\n"},
+		{"encoding failure", false, http.StatusOK, map[string]float64{"points": math.NaN()}, http.StatusInternalServerError,
+			"The response could not be encoded.\n"},
+		{"encoding failure in the envelope", true, http.StatusOK, []any{1, make(chan int)},
+			http.StatusInternalServerError, "The response could not be encoded.\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.ResponseEnvelope = test.envelope
+			s := newTestServer(t, WithConfig(config))
+			recorder := httptest.NewRecorder()
+			s.writeJSON(recorder, httptest.NewRequest(http.MethodGet, "/receipts/count", nil), test.status, test.data)
+
+			if recorder.Code != test.want {
+				t.Errorf("status %d, want %d", recorder.Code, test.want)
+			}
+			if recorder.Body.String() != test.body {
+				t.Errorf("body %q, want %q", recorder.Body, test.body)
+			}
+			if test.want == http.StatusOK || test.want == http.StatusCreated {
+				if got := recorder.Header().Get("Content-Length"); got != strconv.Itoa(recorder.Body.Len()) {
+					t.Errorf("Content-Length %q for a %d byte body", got, recorder.Body.Len())
+				}
+			}
+		})
+	}
+}
+
+// Function to make a receipt with n items, for benchmarks of list responses
+func receiptWithItems(n int) model.Receipt {
+	receipt := model.Receipt{Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "13:01", Total: "0.00"}
+	for i := range n {
+		receipt.Items = append(receipt.Items, model.Item{ShortDescription: "Item " + strconv.Itoa(i), Price: "1.00"})
+	}
+	return receipt
+}
+
+// Response writer throwing the body away, so benchmarks measure the encoding rather than a recorder's buffer
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discardResponseWriter) WriteHeader(status int)      {}
+
+func BenchmarkWriteJSON(b *testing.B) {
+	s := newTestServer(b)
+	request := httptest.NewRequest(http.MethodGet, "/receipts/count", nil)
+	for _, size := range []int{1, 100} {
+		receipt := receiptWithItems(size)
+		b.Run("pooled/items="+strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+			w := &discardResponseWriter{header: make(http.Header)}
+			for b.Loop() {
+				s.writeJSON(w, request, http.StatusOK, receipt)
+			}
+		})
+		// The same sized and checked body without the pool, building a fresh buffer for every response
+		b.Run("fresh-buffer/items="+strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+			w := &discardResponseWriter{header: make(http.Header)}
+			for b.Loop() {
+				var buf bytes.Buffer
+				if err := json.NewEncoder(&buf).Encode(receipt); err != nil {
+					b.Fatal(err)
+				}
+				writeEncodedJSON(w, http.StatusOK, buf.Bytes())
+			}
+		})
+		// How responses were written before: an encoder straight onto the writer, with no length and no way back
+		b.Run("direct/items="+strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+			w := &discardResponseWriter{header: make(http.Header)}
+			for b.Loop() {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				json.NewEncoder(w).Encode(receipt)
+			}
+		})
+	}
+}
+
+func BenchmarkItemsRoute(b *testing.B) {
+	s := newTestServer(b)
+	body, err := json.Marshal(receiptWithItems(100))
+	if err != nil {
+		b.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(string(body))))
+	var processed struct{ ID string }
+	if err := json.Unmarshal(recorder.Body.Bytes(), &processed); err != nil || processed.ID == "" {
+		b.Fatalf("processing the receipt: %v %s", err, recorder.Body)
+	}
+	path := "/receipts/" + processed.ID + "/items"
+	b.ReportAllocs()
+	for b.Loop() {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusOK {
+			b.Fatalf("GET %s = %d", path, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// Number of hourly buckets in the rolling window
+const windowHours = 24
+
+// Ring buffer of hourly point totals; each bucket remembers which hour it holds so stale ones read as zero
+type hourlyWindow struct {
+	totals [windowHours]int
+	hours  [windowHours]int64
+}
+
+type RetailerPoints struct {
+	Retailer string `json:"retailer"`
+	Points   int    `json:"points"`
+}
+
+func (w *hourlyWindow) add(now time.Time, points int) {
+	hour := now.Unix() / 3600
+	i := hour % windowHours
+	if w.hours[i] != hour {
+		w.hours[i], w.totals[i] = hour, 0
+	}
+	w.totals[i] += points
+}
+
+func (w *hourlyWindow) total(now time.Time) int {
+	hour := now.Unix() / 3600
+	sum := 0
+	for i := range w.totals {
+		if hour-w.hours[i] < windowHours {
+			sum += w.totals[i]
+		}
+	}
+	return sum
+}
+
+// Function to count points awarded to a retailer's receipt
+func (s *server) recordRetailerPoints(retailer string, points int, now time.Time) {
+	s.retailerPointsMutex.Lock()
+	defer s.retailerPointsMutex.Unlock()
+	window := s.retailerPoints[retailer]
+	if window == nil {
+		window = &hourlyWindow{}
+		s.retailerPoints[retailer] = window
+	}
+	window.add(now, points)
+}
+
+// Handler to report the points awarded to one retailer in the last 24 hours
+func (s *server) retailerPointsHandler(w http.ResponseWriter, r *http.Request) {
+	retailer := r.PathValue("name")
+	response := RetailerPoints{Retailer: retailer}
+	s.retailerPointsMutex.Lock()
+	if window := s.retailerPoints[retailer]; window != nil {
+		response.Points = window.total(s.clock())
+	}
+	s.retailerPointsMutex.Unlock()
+	s.writeJSON(w, r, http.StatusOK, response)
+}
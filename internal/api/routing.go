@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Fixed paths sitting where GET /receipts/{id} would otherwise take them for a receipt ID
+var reservedReceiptPaths = []string{
+	"/receipts/process",
+	"/receipts/draft",
+	"/receipts/import",
+	"/receipts/count",
+	"/receipts/export",
+	"/receipts/points",
+	"/receipts/simulate-scenarios",
+}
+
+// Methods a path can be routed with, in the order Allow headers list them
+var routeMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// Function to list the methods mux routes path with; wildcard patterns are skipped when fixedOnly is set
+func allowedMethods(mux *http.ServeMux, path string, fixedOnly bool) []string {
+	var allowed []string
+	for _, method := range routeMethods {
+		_, pattern := mux.Handler(&http.Request{Method: method, URL: &url.URL{Path: path}})
+		if pattern != "" && (!fixedOnly || !strings.Contains(pattern, "{")) {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// Middleware to route the reserved paths by their own methods, answering 405 with an Allow header rather than
+// looking up a receipt named "process", and to answer OPTIONS with the methods a path allows when no route takes it
+func (s *server) routeMethodsMiddleware(mux *http.ServeMux) http.Handler {
+	reserved := make(map[string]string, len(reservedReceiptPaths))
+	for _, path := range reservedReceiptPaths {
+		reserved[path] = strings.Join(allowedMethods(mux, path, true), ", ")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allow, isReserved := reserved[r.URL.Path]
+		switch {
+		case r.Method == http.MethodOptions:
+			// Routes registered for every method, such as /admin/, answer OPTIONS themselves
+			if _, pattern := mux.Handler(r); pattern != "" {
+				mux.ServeHTTP(w, r)
+				return
+			}
+			if !isReserved {
+				allow = strings.Join(allowedMethods(mux, r.URL.Path, false), ", ")
+			}
+			if allow == "" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Allow", allow)
+			w.WriteHeader(http.StatusNoContent)
+		case !isReserved:
+			mux.ServeHTTP(w, r)
+		case allow == "":
+			http.NotFound(w, r)
+		case !strings.Contains(", "+allow+", ", ", "+r.Method+", "):
+			w.Header().Set("Allow", allow)
+			s.writeError(w, r, errorWithStatus(http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed)))
+		default:
+			mux.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+)
+
+const routeID = "7fb1377b-b223-49d9-a31a-5a02701dd310"
+
+func TestEveryRouteIsRegistered(t *testing.T) {
+	config := defaultConfig()
+	config.AdminToken = "admin-token"
+	config.ScoringConfigFile = ""
+	config.Pprof.Enabled = true
+	s, err := newServer(store.NewMemory(1), points.NewCalculator(points.DefaultConfig()), WithConfig(config),
+		WithLogger(discardLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := "/receipts/" + routeID
+	tests := []struct {
+		method  string
+		path    string
+		pattern string
+	}{
+		{http.MethodPost, "/receipts/process", "POST /receipts/process"},
+		{http.MethodPost, "/receipts/process/stream", "POST /receipts/process/stream"},
+		{http.MethodPut, "/receipts/draft", "PUT /receipts/draft"},
+		{http.MethodPost, "/receipts/draft/token/confirm", "POST /receipts/draft/{draftToken}/confirm"},
+		{http.MethodDelete, "/receipts/draft/token", "DELETE /receipts/draft/{draftToken}"},
+		{http.MethodPost, "/receipts/import/csv", "POST /receipts/import/csv"},
+		{http.MethodGet, "/receipts/count", "GET /receipts/count"},
+		{http.MethodHead, "/receipts/count", "GET /receipts/count"},
+		{http.MethodGet, "/receipts/export", "GET /receipts/export"},
+		{http.MethodPost, "/receipts/points", "POST /receipts/points"},
+		{http.MethodPost, "/receipts/simulate-scenarios", "POST /receipts/simulate-scenarios"},
+		{http.MethodGet, id, "GET /receipts/{id}"},
+		{http.MethodDelete, id, "DELETE /receipts/{id}"},
+		{http.MethodPost, id + "/pin", "POST /receipts/{id}/pin"},
+		{http.MethodPost, id + "/unpin", "POST /receipts/{id}/unpin"},
+		{http.MethodGet, id + "/points", "GET /receipts/{id}/points"},
+		{http.MethodGet, id + "/body", "GET /receipts/{id}/body"},
+		{http.MethodGet, id + "/image", "GET /receipts/{id}/image"},
+		{http.MethodGet, id + "/breakdown/chart", "GET /receipts/{id}/breakdown/chart"},
+		{http.MethodGet, id + "/export", "GET /receipts/{id}/export"},
+		{http.MethodGet, id + "/timeline", "GET /receipts/{id}/timeline"},
+		{http.MethodGet, id + "/events", "GET /receipts/{id}/events"},
+		{http.MethodPost, id + "/diff", "POST /receipts/{id}/diff"},
+		{http.MethodGet, id + "/hash", "GET /receipts/{id}/hash"},
+		{http.MethodGet, id + "/related", "GET /receipts/{id}/related"},
+		{http.MethodGet, id + "/notes", "GET /receipts/{id}/notes"},
+		{http.MethodPost, id + "/notes", "POST /receipts/{id}/notes"},
+		{http.MethodDelete, id + "/notes/n1", "DELETE /receipts/{id}/notes/{noteId}"},
+		{http.MethodGet, id + "/items", "GET /receipts/{id}/items"},
+		{http.MethodPost, id + "/items", "POST /receipts/{id}/items"},
+		{http.MethodPut, id + "/items/0", "PUT /receipts/{id}/items/{index}"},
+		{http.MethodDelete, id + "/items/0", "DELETE /receipts/{id}/items/{index}"},
+		{http.MethodGet, id + "/items/0/description-score", "GET /receipts/{id}/items/{index}/description-score"},
+		{http.MethodPost, id + "/resubmit", "POST /receipts/{id}/resubmit"},
+		{http.MethodGet, id + "/points/history", "GET /receipts/{id}/points/history"},
+		{http.MethodPost, id + "/points/reserve", "POST /receipts/{id}/points/reserve"},
+		{http.MethodPost, id + "/points/transfer", "POST /receipts/{id}/points/transfer"},
+		{http.MethodPost, id + "/points/commit/r1", "POST /receipts/{id}/points/commit/{reservationId}"},
+		{http.MethodPost, id + "/points/rollback/r1", "POST /receipts/{id}/points/rollback/{reservationId}"},
+		{http.MethodGet, "/schema/receipt.json", "GET /schema/receipt.json"},
+		{http.MethodGet, "/graphql", "GET /graphql"},
+		{http.MethodPost, "/graphql", "POST /graphql"},
+		{http.MethodGet, "/graphiql", "GET /graphiql"},
+		{http.MethodGet, "/healthz", "/healthz"},
+		{http.MethodGet, "/health", "/health"},
+		{http.MethodGet, "/readyz", "/readyz"},
+		{http.MethodGet, "/usage", "GET /usage"},
+		{http.MethodGet, "/stats", "GET /stats"},
+		{http.MethodGet, "/retailers/Target/points-24h", "GET /retailers/{name}/points-24h"},
+		{http.MethodGet, "/admin/rules", "/admin/"},
+		{http.MethodGet, "/metrics", "/metrics"},
+		{http.MethodGet, "/debug/pprof/heap", "/debug/pprof/"},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, test.path, nil)
+		if _, pattern := s.router.Handler(request); pattern != test.pattern {
+			t.Errorf("%s %s routed to %q, want %q", test.method, test.path, pattern, test.pattern)
+		}
+	}
+}
+
+func TestRoutingNearMisses(t *testing.T) {
+	server := startServer(t, points.DefaultConfig())
+	id := "/receipts/" + routeID
+	tests := []struct {
+		method string
+		path   string
+		want   int
+		allow  string
+	}{
+		{http.MethodGet, "/receipts/process", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodPut, "/receipts/process", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodGet, "/receipts/draft", http.StatusMethodNotAllowed, "PUT"},
+		{http.MethodGet, "/receipts/import", http.StatusNotFound, ""},
+		{http.MethodGet, "/receipts/import/csv", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodPost, "/receipts/count", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodDelete, "/receipts/export", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodGet, "/receipts/points", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodGet, "/receipts/simulate-scenarios", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodDelete, id + "/points", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodGet, id + "/points", http.StatusNotFound, ""},
+		{http.MethodGet, "/receipts/not-a-uuid/points", http.StatusNotFound, ""},
+		{http.MethodGet, "/receipts/not-a-uuid", http.StatusNotFound, ""},
+		{http.MethodGet, id + "/points/", http.StatusNotFound, ""},
+		{http.MethodGet, id + "/pointz", http.StatusNotFound, ""},
+		{http.MethodGet, "/receipt" + routeID + "/points", http.StatusNotFound, ""},
+		{http.MethodGet, "/receipts", http.StatusNotFound, ""},
+		{http.MethodGet, "/receipts/", http.StatusNotFound, ""},
+		{http.MethodOptions, "/receipts/process", http.StatusNoContent, "POST"},
+		{http.MethodOptions, "/receipts/count", http.StatusNoContent, "GET, HEAD"},
+		{http.MethodOptions, id + "/items", http.StatusNoContent, "GET, HEAD, POST"},
+		{http.MethodOptions, "/nowhere", http.StatusNotFound, ""},
+		{http.MethodOptions, "/admin/rules", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		response, body := send(t, server, test.method, test.path, "")
+		if response.StatusCode != test.want || response.Header.Get("Allow") != test.allow {
+			t.Errorf("%s %s = %d with Allow %q %s, want %d with Allow %q", test.method, test.path, response.StatusCode,
+				response.Header.Get("Allow"), body, test.want, test.allow)
+		}
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	config := defaultConfig()
+	config.AdminToken = "admin-token"
+	config.ScoringConfigFile = filepath.Join(t.TempDir(), "scoring.json")
+	if err := os.WriteFile(config.ScoringConfigFile, []byte(`{"roundTotalBonus":60}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server := startServer(t, points.DefaultConfig(), WithConfig(config))
+	rule := "/admin/rules/" + points.RuleRoundTotal
+	tests := []struct {
+		method string
+		path   string
+		want   int
+		allow  string
+	}{
+		{http.MethodGet, "/admin/rules", http.StatusOK, ""},
+		{http.MethodPost, "/admin/rules", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodPost, rule + "/disable", http.StatusOK, ""},
+		{http.MethodPost, rule + "/enable", http.StatusOK, ""},
+		{http.MethodGet, rule + "/enable", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodGet, rule + "/history", http.StatusOK, ""},
+		{http.MethodPost, rule + "/history", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodPost, "/admin/rules/noSuchRule/enable", http.StatusNotFound, ""},
+		{http.MethodGet, rule + "/rename", http.StatusNotFound, ""},
+		{http.MethodPost, "/admin/config/reload", http.StatusOK, ""},
+		{http.MethodPost, "/admin/reload-config", http.StatusOK, ""},
+		{http.MethodGet, "/admin/config/reload", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodPost, "/admin/usage", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodGet, "/admin/restore", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodGet, "/admin/import-from-url", http.StatusMethodNotAllowed, "POST"},
+	}
+	for _, test := range tests {
+		response, body := send(t, server, test.method, test.path, "", "X-Admin-Token", "admin-token")
+		if response.StatusCode != test.want || response.Header.Get("Allow") != test.allow {
+			t.Errorf("%s %s = %d with Allow %q %s, want %d with Allow %q", test.method, test.path, response.StatusCode,
+				response.Header.Get("Allow"), body, test.want, test.allow)
+		}
+	}
+
+	_, body := send(t, server, http.MethodGet, rule+"/history", "", "X-Admin-Token", "admin-token")
+	var history []RuleHistoryEntry
+	decodeBody(t, body, &history)
+	if len(history) < 2 || history[len(history)-1].Value != 60 {
+		t.Errorf("%s history %+v, want the toggles and the reloaded value of 60", points.RuleRoundTotal, history)
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"time"
+
+	"receipt-processor/internal/points"
+)
+
+type RuleInfo struct {
+	Name    string  `json:"name"`
+	Value   float64 `json:"value"`
+	Type    string  `json:"type"`
+	Enabled bool    `json:"enabled"`
+}
+
+type RuleHistoryEntry struct {
+	Value     float64   `json:"value"`
+	Enabled   bool      `json:"enabled"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type ReloadConfigResponse struct {
+	Reloaded      bool     `json:"reloaded"`
+	ChangedFields []string `json:"changedFields"`
+}
+
+// Function to find a rule by name
+func findRule(name string) (points.Rule, bool) {
+	for _, rule := range points.Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return points.Rule{}, false
+}
+
+// Function to append the current value of every rule whose state changed; callers hold scoringMutex
+func (s *server) recordRuleHistory(now time.Time) {
+	config := s.calculator.Config()
+	for _, rule := range points.Rules {
+		entry := RuleHistoryEntry{Value: rule.Get(config), Enabled: s.calculator.Enabled(rule.Name), Timestamp: now}
+		history := s.ruleHistory[rule.Name]
+		if n := len(history); n > 0 && history[n-1].Value == entry.Value && history[n-1].Enabled == entry.Enabled {
+			continue
+		}
+		s.ruleHistory[rule.Name] = append(history, entry)
+	}
+}
+
+// Handler to list all scoring rules with their current values
+func (s *server) listRulesHandler(w http.ResponseWriter, r *http.Request) {
+	s.scoringMutex.RLock()
+	config := s.calculator.Config()
+	rules := make([]RuleInfo, 0, len(points.Rules))
+	for _, rule := range points.Rules {
+		rules = append(rules, RuleInfo{
+			Name:    rule.Name,
+			Value:   rule.Get(config),
+			Type:    rule.Kind,
+			Enabled: s.calculator.Enabled(rule.Name),
+		})
+	}
+	s.scoringMutex.RUnlock()
+
+	s.writeJSON(w, r, http.StatusOK, rules)
+}
+
+// Function to find the rule named in the path, answering 404 when there is none
+func (s *server) ruleFromPath(w http.ResponseWriter, r *http.Request) (points.Rule, bool) {
+	rule, ok := findRule(r.PathValue("name"))
+	if !ok {
+		s.writeError(w, r, errorWithStatus(http.StatusNotFound, "No rule found with that name."))
+	}
+	return rule, ok
+}
+
+// Handler to show the value history of a rule
+func (s *server) ruleHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	rule, ok := s.ruleFromPath(w, r)
+	if !ok {
+		return
+	}
+	s.scoringMutex.RLock()
+	history := append([]RuleHistoryEntry(nil), s.ruleHistory[rule.Name]...)
+	s.scoringMutex.RUnlock()
+
+	s.writeJSON(w, r, http.StatusOK, history)
+}
+
+// Handler to enable a rule
+func (s *server) enableRuleHandler(w http.ResponseWriter, r *http.Request) {
+	s.setRuleEnabled(w, r, true)
+}
+
+// Handler to disable a rule
+func (s *server) disableRuleHandler(w http.ResponseWriter, r *http.Request) {
+	s.setRuleEnabled(w, r, false)
+}
+
+// Function to enable or disable the rule named in the path and answer with its new state
+func (s *server) setRuleEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	rule, ok := s.ruleFromPath(w, r)
+	if !ok {
+		return
+	}
+	s.scoringMutex.Lock()
+	s.calculator.SetEnabled(rule.Name, enabled)
+	s.recordRuleHistory(s.clock())
+	info := RuleInfo{Name: rule.Name, Value: rule.Get(s.calculator.Config()), Type: rule.Kind, Enabled: s.calculator.Enabled(rule.Name)}
+	s.scoringMutex.Unlock()
+
+	s.writeJSON(w, r, http.StatusOK, info)
+}
+
+// Function to list the JSON fields whose values differ between two scoring configs, with the old and new values
+func scoringConfigChanges(before, after points.Config) ([]string, map[string][2]any) {
+	var old, current map[string]any
+	encoded, _ := json.Marshal(before)
+	json.Unmarshal(encoded, &old)
+	encoded, _ = json.Marshal(after)
+	json.Unmarshal(encoded, &current)
+
+	fields := []string{}
+	changes := make(map[string][2]any)
+	for _, field := range slices.Sorted(maps.Keys(current)) {
+		if !reflect.DeepEqual(old[field], current[field]) {
+			fields = append(fields, field)
+			changes[field] = [2]any{old[field], current[field]}
+		}
+	}
+	for field, value := range old {
+		if _, kept := current[field]; !kept {
+			fields = append(fields, field)
+			changes[field] = [2]any{value, nil}
+		}
+	}
+	return fields, changes
+}
+
+// Handler to re-read the scoring config file and swap it in; an invalid file leaves the active config as it was
+func (s *server) reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	loaded, err := loadScoringConfigFile(s.scoringConfigFile)
+	if err == nil {
+		err = loaded.Validate()
+	}
+	if err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The scoring config was not reloaded: "+err.Error()))
+		return
+	}
+
+	s.scoringMutex.Lock()
+	fields, changes := scoringConfigChanges(s.calculator.SetConfig(loaded), loaded)
+	s.recordRuleHistory(s.clock())
+	s.scoringMutex.Unlock()
+
+	s.logger.InfoContext(r.Context(), "scoring config reloaded", "file", s.scoringConfigFile, "actor", s.requestActor(r),
+		"changedFields", fields, "changes", changes)
+	s.writeJSON(w, r, http.StatusOK, ReloadConfigResponse{Reloaded: true, ChangedFields: fields})
+}
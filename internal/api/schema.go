@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+
+	"receipt-processor/internal/model"
+)
+
+// Version of the stored receipt shape. A shape change bumps it and registers the step from the previous
+// version in receiptMigrations, e.g. 1: migrateV1toV2 filling the new fields with their defaults
+const currentSchemaVersion = 1
+
+// Steps upgrading a stored receipt from the version it is keyed by to the next one
+var receiptMigrations = map[int]func(model.Receipt) model.Receipt{}
+
+// Function to run every migration from a version up to the current one
+func migrateReceipt(receipt model.Receipt, version int) (model.Receipt, error) {
+	for ; version < currentSchemaVersion; version++ {
+		migrate, exists := receiptMigrations[version]
+		if !exists {
+			return receipt, fmt.Errorf("no migration from receipt schema version %d", version)
+		}
+		receipt = migrate(receipt)
+	}
+	return receipt, nil
+}
+
+// Function to read a receipt for a client. One stored under an older schema is migrated, and the migrated
+// receipt is logged and stored straight away so the next read finds it current
+func (s *server) readReceipt(id string) (receipt model.Receipt, archived, exists bool, err error) {
+	shard := s.store.Shard(id)
+	shard.RLock()
+	receipt, exists = shard.Lookup(id)
+	_, archived = shard.Archived[id]
+	outdated := exists && shard.SchemaVersion(id) < currentSchemaVersion
+	shard.RUnlock()
+	if !outdated {
+		return receipt, archived, exists, nil
+	}
+
+	shard.Lock()
+	defer shard.Unlock()
+	receipt, exists = shard.Lookup(id)
+	_, archived = shard.Archived[id]
+	if !exists || shard.SchemaVersion(id) >= currentSchemaVersion {
+		return receipt, archived, exists, nil
+	}
+	migrated, err := migrateReceipt(receipt, shard.SchemaVersion(id))
+	if err != nil {
+		return receipt, archived, exists, err
+	}
+	if err := s.store.Migrate(id, migrated, fingerprintReceipt(migrated), currentSchemaVersion); err != nil {
+		return receipt, archived, exists, err
+	}
+	return migrated, archived, exists, nil
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+)
+
+// Points for one receipt scored from the command line
+type ScoreResult struct {
+	// Position of the receipt in the input, counting from 1
+	Receipt   int            `json:"receipt"`
+	Points    int            `json:"points"`
+	Breakdown map[string]int `json:"breakdown,omitempty"`
+}
+
+// Function to run "receipt-processor score": score receipts from a file or stdin without starting the server.
+// Returns the exit code: 1 when a receipt is invalid, 2 when the input cannot be read at all
+func RunScoreCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("receipt-processor score", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: receipt-processor score [flags] [file.json | -]")
+		flags.PrintDefaults()
+	}
+	breakdown := flags.Bool("breakdown", false, "include the points each rule awarded")
+	format := flags.String("format", "json", "output format: json or table")
+	scoringFile := flags.String("scoring-config", "", "score with the rules from this JSON file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "json" && *format != "table" || flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	config := points.DefaultConfig()
+	if *scoringFile != "" {
+		loaded, err := loadScoringConfigFile(*scoringFile)
+		if err == nil {
+			err = loaded.Validate()
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		config = loaded
+	}
+
+	input := stdin
+	if path := flags.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		defer file.Close()
+		input = file
+	}
+	receipts, single, err := readScoreInput(input)
+	if err != nil {
+		fmt.Fprintln(stderr, "reading receipts:", err)
+		return 2
+	}
+
+	// Receipts are checked as a server with the default config checks them
+	s, err := newServer(store.NewMemory(1), points.NewCalculator(config))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	code := 0
+	results := make([]ScoreResult, 0, len(receipts))
+	for i, receipt := range receipts {
+		err := s.normalizeReceipt(&receipt)
+		if err == nil {
+			err = s.validateReceipt(receipt)
+		}
+		if err != nil {
+			reason := validationReason(err)
+			fmt.Fprintf(stderr, "receipt %d is invalid: %s (%s)\n", i+1, reason, validationFields[reason])
+			code = 1
+			continue
+		}
+		result := ScoreResult{Receipt: i + 1, Points: points.Calculate(receipt, config)}
+		if *breakdown {
+			result.Breakdown = points.Breakdown(receipt, config)
+		}
+		results = append(results, result)
+	}
+	if code != 0 && single {
+		return code
+	}
+
+	if *format == "table" {
+		writeScoreTable(stdout, results, *breakdown)
+		return code
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	if single {
+		encoder.Encode(results[0])
+	} else {
+		encoder.Encode(results)
+	}
+	return code
+}
+
+// Function to read either one receipt or a JSON array of them; single reports which it was
+func readScoreInput(input io.Reader) ([]model.Receipt, bool, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, false, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var receipts []model.Receipt
+		err := json.Unmarshal(trimmed, &receipts)
+		return receipts, false, err
+	}
+	var receipt model.Receipt
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		return nil, true, err
+	}
+	return []model.Receipt{receipt}, true, nil
+}
+
+func writeScoreTable(stdout io.Writer, results []ScoreResult, breakdown bool) {
+	table := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	if breakdown {
+		fmt.Fprint(table, "RECEIPT\tPOINTS")
+		for _, rule := range points.Rules {
+			fmt.Fprint(table, "\t", rule.Name)
+		}
+		fmt.Fprintln(table)
+	} else {
+		fmt.Fprintln(table, "RECEIPT\tPOINTS")
+	}
+	for _, result := range results {
+		fmt.Fprintf(table, "%d\t%d", result.Receipt, result.Points)
+		if breakdown {
+			for _, rule := range points.Rules {
+				fmt.Fprint(table, "\t", result.Breakdown[rule.Name])
+			}
+		}
+		fmt.Fprintln(table)
+	}
+	table.Flush()
+}
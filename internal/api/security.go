@@ -0,0 +1,82 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+type SecurityHeadersConfig struct {
+	ContentSecurityPolicy string `yaml:"contentSecurityPolicy"`
+	FrameOptions          string `yaml:"frameOptions"`
+	ReferrerPolicy        string `yaml:"referrerPolicy"`
+	// Strict-Transport-Security is only sent over TLS and only when this is positive; browsers remember it
+	HSTSMaxAge            int  `yaml:"hstsMaxAge"`
+	HSTSIncludeSubdomains bool `yaml:"hstsIncludeSubdomains"`
+}
+
+// Response writer adding the HTML-only headers once the handler has settled on a text/html body
+type securityHeadersWriter struct {
+	http.ResponseWriter
+	config      SecurityHeadersConfig
+	wroteHeader bool
+}
+
+func (s *securityHeadersWriter) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.wroteHeader = true
+		header := s.Header()
+		if mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType == "text/html" {
+			// A page that needs a looser policy, such as GraphiQL with its scripts, sets its own
+			if s.config.ContentSecurityPolicy != "" && header.Get("Content-Security-Policy") == "" {
+				header.Set("Content-Security-Policy", s.config.ContentSecurityPolicy)
+			}
+			if s.config.FrameOptions != "" {
+				header.Set("X-Frame-Options", s.config.FrameOptions)
+			}
+		}
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *securityHeadersWriter) Write(p []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *securityHeadersWriter) Flush() {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *securityHeadersWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Middleware to set security headers: nosniff and the referrer policy everywhere, CSP and framing rules on HTML, HSTS over TLS
+func securityHeadersMiddleware(config SecurityHeadersConfig, next http.Handler) http.Handler {
+	hsts := ""
+	if config.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(config.HSTSMaxAge)
+		if config.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		if config.ReferrerPolicy != "" {
+			header.Set("Referrer-Policy", config.ReferrerPolicy)
+		}
+		if hsts != "" && r.TLS != nil {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		next.ServeHTTP(&securityHeadersWriter{ResponseWriter: w, config: config}, r)
+	})
+}
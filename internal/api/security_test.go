@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+)
+
+// Headers the security middleware may set; every one not listed for a route class must be absent
+var securityHeaderNames = []string{"X-Content-Type-Options", "Referrer-Policy", "Content-Security-Policy",
+	"X-Frame-Options", "Strict-Transport-Security"}
+
+func TestSecurityHeaders(t *testing.T) {
+	defaults := defaultConfig().SecurityHeaders
+	withHSTS := defaultConfig()
+	withHSTS.SecurityHeaders.HSTSMaxAge = 31536000
+	withHSTS.SecurityHeaders.HSTSIncludeSubdomains = true
+	relaxed := defaultConfig()
+	relaxed.SecurityHeaders.ContentSecurityPolicy = ""
+	relaxed.SecurityHeaders.FrameOptions = ""
+	relaxed.SecurityHeaders.ReferrerPolicy = ""
+
+	api := map[string]string{"X-Content-Type-Options": "nosniff", "Referrer-Policy": defaults.ReferrerPolicy}
+	html := map[string]string{"X-Content-Type-Options": "nosniff", "Referrer-Policy": defaults.ReferrerPolicy,
+		"Content-Security-Policy": defaults.ContentSecurityPolicy, "X-Frame-Options": defaults.FrameOptions}
+	hsts := "max-age=31536000; includeSubDomains"
+	tests := []struct {
+		name   string
+		config Config
+		tls    bool
+		path   string
+		accept string
+		want   map[string]string
+	}{
+		{"JSON API", defaultConfig(), false, "/receipts/count", "", api},
+		{"HTML export", defaultConfig(), false, "/receipts/{id}/export", "text/html", html},
+		{"CSV export", defaultConfig(), false, "/receipts/{id}/export", "text/csv", api},
+		{"not found", defaultConfig(), false, "/nowhere", "", api},
+		{"JSON API over TLS without HSTS", defaultConfig(), true, "/receipts/count", "", api},
+		{"JSON API over plain HTTP with HSTS", withHSTS, false, "/receipts/count", "", api},
+		{"JSON API over TLS with HSTS", withHSTS, true, "/receipts/count", "",
+			map[string]string{"X-Content-Type-Options": "nosniff", "Referrer-Policy": defaults.ReferrerPolicy,
+				"Strict-Transport-Security": hsts}},
+		{"HTML export over TLS with HSTS", withHSTS, true, "/receipts/{id}/export", "text/html",
+			map[string]string{"X-Content-Type-Options": "nosniff", "Referrer-Policy": defaults.ReferrerPolicy,
+				"Content-Security-Policy": defaults.ContentSecurityPolicy, "X-Frame-Options": defaults.FrameOptions,
+				"Strict-Transport-Security": hsts}},
+		{"HTML export with the policies turned off", relaxed, false, "/receipts/{id}/export", "text/html",
+			map[string]string{"X-Content-Type-Options": "nosniff"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, err := NewServer(store.NewMemory(defaultLockShards), points.NewCalculator(points.DefaultConfig()),
+				WithConfig(test.config), WithLogger(discardLogger()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := httptest.NewUnstartedServer(handler)
+			if test.tls {
+				server.StartTLS()
+			} else {
+				server.Start()
+			}
+			defer server.Close()
+
+			path := strings.ReplaceAll(test.path, "{id}", processReceipt(t, server, targetReceipt))
+			var header []string
+			if test.accept != "" {
+				header = []string{"Accept", test.accept}
+			}
+			response, _ := send(t, server, http.MethodGet, path, "", header...)
+			for _, name := range securityHeaderNames {
+				if got := response.Header.Get(name); got != test.want[name] {
+					t.Errorf("%s = %q, want %q", name, got, test.want[name])
+				}
+			}
+		})
+	}
+}
+
+func TestGraphiQLSetsItsOwnPolicy(t *testing.T) {
+	server := startServer(t, points.DefaultConfig())
+	response, body := send(t, server, http.MethodGet, "/graphiql", "")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("GET /graphiql = %d", response.StatusCode)
+	}
+	policy := response.Header.Get("Content-Security-Policy")
+	nonce := regexp.MustCompile(`script-src 'nonce-([^']+)'`).FindStringSubmatch(policy)
+	if nonce == nil {
+		t.Fatalf("GraphiQL policy %q allows no nonce scripts", policy)
+	}
+	if !regexp.MustCompile(`<script[^>]* nonce="` + regexp.QuoteMeta(nonce[1]) + `"`).MatchString(body) {
+		t.Errorf("GraphiQL scripts do not carry the policy's nonce %s", nonce[1])
+	}
+	if got := response.Header.Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("X-Frame-Options = %q, want DENY", got)
+	}
+}
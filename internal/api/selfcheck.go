@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"receipt-processor/internal/store"
+)
+
+type SelfCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+var selfCheckSteps = []struct {
+	name string
+	run  func(c Config) error
+}{
+	{"scoringConfig", func(c Config) error {
+		if c.ScoringConfigFile == "" {
+			return nil
+		}
+		scoring, err := loadScoringConfigFile(c.ScoringConfigFile)
+		if err != nil {
+			return err
+		}
+		return scoring.Validate()
+	}},
+	{"wal", func(c Config) error { return probeWAL(c.WAL) }},
+	{"tls", func(c Config) error {
+		if !c.TLS.enabled() {
+			return nil
+		}
+		_, err := buildTLSConfig(c.TLS)
+		return err
+	}},
+	{"accessLog", func(c Config) error {
+		if c.AccessLog.Path == "" {
+			return nil
+		}
+		file, err := os.OpenFile(c.AccessLog.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}},
+}
+
+// Function to check the WAL can be kept where it is configured: a probe file is created in its directory, written,
+// synced and removed, and the log itself must open for appending. A log the probe had to create is removed again
+func probeWAL(c store.LogConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	probe, err := os.CreateTemp(filepath.Dir(c.Path), ".selfcheck-*")
+	if err != nil {
+		return err
+	}
+	_, err = probe.Write([]byte("selfcheck\n"))
+	if err == nil {
+		err = probe.Sync()
+	}
+	err = errors.Join(err, probe.Close(), os.Remove(probe.Name()))
+	if err != nil {
+		return fmt.Errorf("probing %s: %w", filepath.Dir(c.Path), err)
+	}
+
+	_, statErr := os.Stat(c.Path)
+	file, err := os.OpenFile(c.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if errors.Is(statErr, fs.ErrNotExist) {
+		return os.Remove(c.Path)
+	}
+	return nil
+}
+
+// Function to run every startup check, returning all results and an error naming each failed check
+func runSelfChecks(c Config) ([]SelfCheck, error) {
+	results := make([]SelfCheck, 0, len(selfCheckSteps))
+	var errs []error
+	for _, step := range selfCheckSteps {
+		result := SelfCheck{Name: step.name, OK: true}
+		if err := step.run(c); err != nil {
+			result.OK, result.Error = false, err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", step.name, err))
+		}
+		results = append(results, result)
+	}
+	return results, errors.Join(errs...)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/store"
+)
+
+func TestSelfCheckProbesTheWAL(t *testing.T) {
+	dir := t.TempDir()
+	config := defaultConfig()
+	config.WAL = store.LogConfig{Enabled: true, Path: filepath.Join(dir, "receipts.wal"), Durability: "strict"}
+	if _, err := runSelfChecks(config); err != nil {
+		t.Fatalf("self-check of a writable WAL directory = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("the self-check left %d files behind", len(entries))
+	}
+
+	// An existing log is opened but kept
+	if err := os.WriteFile(config.WAL.Path, []byte("{}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runSelfChecks(config); err != nil {
+		t.Fatalf("self-check over an existing WAL = %v", err)
+	}
+	if contents, _ := os.ReadFile(config.WAL.Path); string(contents) != "{}\n" {
+		t.Errorf("the self-check changed the existing WAL to %q", contents)
+	}
+
+	// A regular file where the WAL directory should be cannot hold the log
+	blocker := filepath.Join(dir, "not-a-directory")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config.WAL.Path = filepath.Join(blocker, "receipts.wal")
+	checks, err := runSelfChecks(config)
+	if err == nil || !strings.Contains(err.Error(), "wal:") {
+		t.Fatalf("self-check of an unusable WAL path = %v, want a wal failure", err)
+	}
+	for _, check := range checks {
+		if check.Name == "wal" && check.OK {
+			t.Error("the wal check reported OK")
+		}
+	}
+	if err := Run(config); err == nil || !strings.Contains(err.Error(), "self-check failed") {
+		t.Errorf("Run with an unusable WAL path = %v, want the self-check to stop startup", err)
+	}
+}
@@ -0,0 +1,397 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+
+	"github.com/graphql-go/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Credentials the server accepts, in the same form as the matching Config fields
+type AuthConfig struct {
+	AdminToken string
+	APIKeys    string
+}
+
+// Setting for NewServer
+type Option func(*serverOptions)
+
+type serverOptions struct {
+	config       Config
+	logger       *slog.Logger
+	clock        func() time.Time
+	accessLogger *AccessLogger
+	logLevel     *slog.LevelVar
+}
+
+// Function to configure the server from a loaded config; without it the defaults apply. Options after it override
+// the parts they cover
+func WithConfig(config Config) Option {
+	return func(o *serverOptions) {
+		o.config = config
+	}
+}
+
+// Function to send the server's logs to logger rather than a text logger on stderr at the config's level
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *serverOptions) {
+		o.logger = logger
+	}
+}
+
+// Function to read the time from now rather than the system clock
+func WithClock(now func() time.Time) Option {
+	return func(o *serverOptions) {
+		o.clock = now
+	}
+}
+
+// Function to set the admin token and API keys
+func WithAuth(auth AuthConfig) Option {
+	return func(o *serverOptions) {
+		o.config.AdminToken = auth.AdminToken
+		o.config.APIKeys = auth.APIKeys
+	}
+}
+
+// Function to set how many reads and writes run at once
+func WithLimits(limits LimitsConfig) Option {
+	return func(o *serverOptions) {
+		o.config.Limits = limits
+	}
+}
+
+// Function to set the level of the logger given with WithLogger, so /admin/loglevel can change it
+func withLogLevel(level *slog.LevelVar) Option {
+	return func(o *serverOptions) {
+		o.logLevel = level
+	}
+}
+
+// Function to log every request to an access log Run has opened
+func withAccessLogger(accessLogger *AccessLogger) Option {
+	return func(o *serverOptions) {
+		o.accessLogger = accessLogger
+	}
+}
+
+// One API server: its settings, the store and calculator it was given, and everything its handlers keep between
+// requests. Handlers are methods on it, so two servers in one process share nothing
+type server struct {
+	// Clock for the timestamps the server records and the expiries it checks; latency measurements keep the wall clock
+	clock  func() time.Time
+	logger *slog.Logger
+	// Level of the logger NewServer builds when it is given none, changed by /admin/loglevel and SIGUSR1
+	logLevel *slog.LevelVar
+	// Audit lines bypass logLevel so raising the level never hides who did what
+	auditLogger *slog.Logger
+
+	// The store the handlers read and write; Run swaps in a logged one when the WAL is enabled
+	store store.Store
+	// Scores every receipt; the config can be reloaded and rules switched off while the server runs
+	calculator *points.Calculator
+	// Rule history, under scoringMutex; changes to the calculator are made under it too so each lands with its entry
+	ruleHistory  map[string][]RuleHistoryEntry
+	scoringMutex sync.RWMutex
+	// Scoring config file read at startup and by POST /admin/config/reload; empty when the defaults are used
+	scoringConfigFile string
+
+	// The application mux, consulted for route labels, and the middleware chain around it
+	router  *http.ServeMux
+	handler http.Handler
+	metrics *Metrics
+	// The points response cache; a capacity of 0 disables it
+	pointsCache   *responseCache
+	graphqlSchema graphql.Schema
+	// Results of the startup self-check, set once before the listener is bound
+	selfChecks []SelfCheck
+
+	// Token required for privileged operations; empty disables the admin routes entirely
+	adminToken string
+	// Regular API keys by the identity they authenticate; empty leaves the API open
+	apiKeys               map[string]string
+	apiKeyQuotas          map[string]KeyQuota
+	requireAPIKeyWithCert bool
+	// Peers allowed to report the client address through forwarding headers; headers from anyone else are ignored
+	trustedProxies []netip.Prefix
+	// Ranges allowed to reach admin, metrics and pprof endpoints; nil allows everyone
+	adminAllow []netip.Prefix
+	// Ranges allowed to submit receipts; nil allows everyone
+	writeAllow []netip.Prefix
+	// In-flight caps shared by HTTP requests and gRPC calls, and how long either may wait for a slot
+	readLimit, writeLimit *concurrencyLimit
+	limitWait             time.Duration
+
+	// When strict mode is enabled, resubmitting an identical receipt returns the existing ID
+	strictMode bool
+	// When false the store keeps only what scoring lookups need, dropping items and the other receipt fields
+	retainReceipts bool
+	// When enabled, JSON responses are wrapped as {"data":...,"meta":{...}} for API gateways that expect it
+	responseEnvelope bool
+	// When enabled, the receipt endpoints also speak application/xml; off by default, few clients need it
+	xmlAPI bool
+	// When enabled, JSON receipt bodies must match the published schema before validateReceipt runs
+	schemaValidation bool
+	// The chain every submitted receipt goes through; empty by default so receipts are stored as sent
+	normalizers NormalizerChain
+	// Bounds on the number of items a receipt lists
+	maxItemsPerReceipt int
+	minItemsPerReceipt int
+	// Largest CSV upload and receipt image accepted, in bytes
+	csvUploadMaxBytes int64
+	imageMaxBytes     int
+	// Where receipt images are kept; nil when no image directory is configured
+	imageStore BlobStore
+	// Workers validating and scoring imported receipts concurrently
+	importWorkers int
+	// Failed lines after which a stream is aborted; 0 never aborts
+	streamMaxErrors int
+	// Receipts stored longer than this are moved to the archive each night; 0 disables archiving
+	archiveAfter   time.Duration
+	reservationTTL time.Duration
+
+	// Open reservations by ID, under reservationsMutex. It is taken after the receipt's shard lock, never before
+	reservationsMutex sync.Mutex
+	reservations      map[string]model.Reservation
+	// Drafts by token, under draftsMutex. They are not written to the WAL and do not survive a restart
+	draftsMutex sync.Mutex
+	drafts      map[string]*Draft
+	// Submissions by tenant and Idempotency-Key hash, under idempotencyMutex; like drafts they live in memory only
+	idempotencyMutex sync.Mutex
+	idempotencyKeys  map[string]*idempotentSubmission
+	// Receipts each tenant may submit per UTC day, and the submissions per tenant per UTC date, under quotaMutex;
+	// a quota of 0 disables it
+	quotaMutex        sync.Mutex
+	dailyReceiptQuota int
+	quotas            map[string]map[string]int
+	// Submissions per API key identity per window ("2006-01-02" or "2006-01"), under quotaMutex
+	keyUsage map[string]map[string]int
+	// Points awarded per retailer over the last 24 hours, under retailerPointsMutex
+	retailerPointsMutex sync.Mutex
+	retailerPoints      map[string]*hourlyWindow
+
+	// Client for the loyalty platform, fetching and refreshing its bearer token itself; nil when not configured
+	loyaltyClient *http.Client
+	loyaltyURL    string
+
+	// Current maintenance window, nil when the API is serving normally; kept outside Config so reloads leave it alone
+	maintenance atomic.Pointer[maintenanceState]
+	// Fault injection for resilience testing, nil when off; like maintenance it is kept outside Config
+	chaos atomic.Pointer[chaosState]
+
+	// Requests served since startup, in total and by method and route
+	totalRequests      atomic.Int64
+	routeRequestsMutex sync.RWMutex
+	routeRequestCounts map[string]*atomic.Int64
+	// Moving average of store write latency, in milliseconds, and whether health reports it as degraded
+	healthLatencyThreshold time.Duration
+	writeLatencyMutex      sync.Mutex
+	writeLatencyEMA        float64
+	writeLatencySeen       bool
+	healthDegraded         bool
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Function to build the HTTP API over receiptStore, scoring with calc: every route behind the middleware chain, as a
+// plain handler. Each call builds an independent server. Listeners, the WAL, tracing, images and the background
+// workers are left to Run. A config that fails Validate is an error rather than a server with the bad settings off
+func NewServer(receiptStore store.Store, calc *points.Calculator, opts ...Option) (http.Handler, error) {
+	s, err := newServer(receiptStore, calc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Function to build a server, for Run, which also starts its workers and listeners
+func newServer(receiptStore store.Store, calc *points.Calculator, opts ...Option) (*server, error) {
+	options := serverOptions{config: defaultConfig(), clock: time.Now}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	config := options.config
+	// The parses below cannot fail once the config validates, so their errors are not checked again
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	s := &server{
+		clock:              options.clock,
+		logger:             options.logger,
+		logLevel:           options.logLevel,
+		auditLogger:        slog.New(traceLogHandler{slog.NewTextHandler(os.Stderr, nil)}),
+		store:              receiptStore,
+		calculator:         calc,
+		ruleHistory:        make(map[string][]RuleHistoryEntry),
+		reservations:       make(map[string]model.Reservation),
+		drafts:             make(map[string]*Draft),
+		idempotencyKeys:    make(map[string]*idempotentSubmission),
+		quotas:             make(map[string]map[string]int),
+		keyUsage:           make(map[string]map[string]int),
+		retailerPoints:     make(map[string]*hourlyWindow),
+		routeRequestCounts: make(map[string]*atomic.Int64),
+	}
+	if s.logLevel == nil {
+		s.logLevel = new(slog.LevelVar)
+		level, _ := parseLogLevel(config.LogLevel)
+		s.logLevel.Set(level)
+	}
+	if s.logger == nil {
+		s.logger = newLogger(s.logLevel)
+	}
+	s.metrics = s.newMetrics(prometheus.NewRegistry())
+	s.graphqlSchema = s.mustBuildGraphQLSchema()
+	s.recordRuleHistory(s.clock())
+
+	s.importWorkers = config.ImportWorkers
+	s.pointsCache = newResponseCache(config.PointsCacheSize, s.metrics.pointsCacheLookups)
+	s.retainReceipts = config.RetainReceipts
+	s.streamMaxErrors = config.StreamMaxErrors
+	s.csvUploadMaxBytes = int64(config.CSVUploadMaxBytes)
+	s.xmlAPI = config.XMLAPI
+	s.schemaValidation = config.SchemaValidation
+	s.imageMaxBytes = config.ImageMaxBytes
+	s.maxItemsPerReceipt = config.MaxItemsPerReceipt
+	s.minItemsPerReceipt = config.MinItemsPerReceipt
+	if config.Loyalty.URL != "" {
+		s.loyaltyURL = config.Loyalty.URL
+		s.loyaltyClient = newLoyaltyClient(config.Loyalty)
+	}
+	s.normalizers, _ = buildNormalizers(config.Normalizers)
+	s.archiveAfter = time.Duration(config.ArchiveAfterDays) * 24 * time.Hour
+	s.adminToken = config.AdminToken
+	s.apiKeys = parseAPIKeys(config.APIKeys)
+	s.apiKeyQuotas = config.APIKeyQuotas
+	// A store replayed from its log already knows what each key has used
+	s.restoreKeyUsage(receiptStore.KeyUsage())
+	s.requireAPIKeyWithCert = config.TLS.ClientCAFile != "" && config.TLS.ClientAuth == "both"
+	s.strictMode = config.StrictMode
+	s.trustedProxies, _ = parsePrefixes(config.TrustedProxies)
+	s.adminAllow, _ = parseAdminAllow(config.AdminAllow)
+	s.writeAllow, _ = parsePrefixes(config.WriteAllow)
+	s.readLimit = newConcurrencyLimit("read", config.Limits.MaxReads)
+	s.writeLimit = newConcurrencyLimit("write", config.Limits.MaxWrites)
+	s.limitWait = config.Limits.MaxWait
+	s.responseEnvelope = config.ResponseEnvelope
+	s.dailyReceiptQuota = config.DailyReceiptQuota
+	s.healthLatencyThreshold = time.Duration(config.HealthLatencyThresholdMS) * time.Millisecond
+	s.reservationTTL = time.Duration(config.ReservationTTLSeconds) * time.Second
+	if config.Maintenance {
+		s.startMaintenance("", 0)
+	}
+	s.scoringConfigFile = config.ScoringConfigFile
+
+	mux := http.NewServeMux()
+	s.router = mux
+	mux.Handle("POST /receipts/process", s.allowCIDRs(s.writeAllow, http.HandlerFunc(s.processReceiptHandler)))
+	mux.Handle("POST /receipts/process/stream", s.allowCIDRs(s.writeAllow, http.HandlerFunc(s.streamReceiptsHandler)))
+	mux.Handle("PUT /receipts/draft", s.allowCIDRs(s.writeAllow, http.HandlerFunc(s.createDraftHandler)))
+	mux.Handle("POST /receipts/draft/{draftToken}/confirm", s.allowCIDRs(s.writeAllow, http.HandlerFunc(s.confirmDraftHandler)))
+	mux.HandleFunc("DELETE /receipts/draft/{draftToken}", s.discardDraftHandler)
+	mux.Handle("POST /receipts/import/csv", s.allowCIDRs(s.writeAllow, http.HandlerFunc(s.uploadCSVHandler)))
+	mux.HandleFunc("GET /receipts/count", s.countReceiptsHandler)
+	mux.HandleFunc("GET /receipts/export", s.exportReceiptsHandler)
+	mux.HandleFunc("POST /receipts/points", s.batchPointsHandler)
+	mux.HandleFunc("POST /receipts/simulate-scenarios", s.simulateScenariosHandler)
+	mux.HandleFunc("GET /receipts/{id}", s.withReceiptID(s.getReceiptHandler))
+	mux.Handle("DELETE /receipts/{id}", s.requireAdmin(s.withReceiptID(s.deleteReceiptHandler)))
+	mux.HandleFunc("POST /receipts/{id}/pin", s.withReceiptID(s.pinReceiptHandler))
+	mux.HandleFunc("POST /receipts/{id}/unpin", s.withReceiptID(s.unpinReceiptHandler))
+	mux.HandleFunc("GET /receipts/{id}/points", s.withReceiptID(s.getPointsHandler))
+	mux.HandleFunc("GET /receipts/{id}/body", s.withReceiptID(s.receiptBodyHandler))
+	mux.HandleFunc("GET /receipts/{id}/image", s.withReceiptID(s.receiptImageHandler))
+	mux.HandleFunc("GET /receipts/{id}/breakdown/chart", s.withReceiptID(s.breakdownChartHandler))
+	mux.HandleFunc("GET /receipts/{id}/export", s.withReceiptID(s.exportReceiptHandler))
+	mux.HandleFunc("GET /receipts/{id}/timeline", s.withReceiptID(s.timelineHandler))
+	mux.HandleFunc("GET /receipts/{id}/events", s.withReceiptID(s.receiptEventsHandler))
+	mux.HandleFunc("POST /receipts/{id}/diff", s.withReceiptID(s.diffReceiptHandler))
+	mux.HandleFunc("GET /receipts/{id}/hash", s.withReceiptID(s.receiptHashHandler))
+	mux.HandleFunc("GET /receipts/{id}/related", s.withReceiptID(s.relatedReceiptsHandler))
+	mux.HandleFunc("GET /receipts/{id}/notes", s.withReceiptID(s.listNotesHandler))
+	mux.HandleFunc("POST /receipts/{id}/notes", s.withReceiptID(s.addNoteHandler))
+	mux.Handle("DELETE /receipts/{id}/notes/{noteId}", s.requireAdmin(s.withReceiptID(s.deleteNoteHandler)))
+	mux.HandleFunc("GET /receipts/{id}/items", s.withReceiptID(s.listItemsHandler))
+	mux.HandleFunc("POST /receipts/{id}/items", s.withReceiptID(s.addItemHandler))
+	mux.HandleFunc("PUT /receipts/{id}/items/{index}", s.withReceiptID(s.replaceItemHandler))
+	mux.HandleFunc("DELETE /receipts/{id}/items/{index}", s.withReceiptID(s.deleteItemHandler))
+	mux.HandleFunc("GET /receipts/{id}/items/{index}/description-score", s.withReceiptID(s.descriptionScoreHandler))
+	mux.Handle("POST /receipts/{id}/resubmit", s.allowCIDRs(s.writeAllow, s.withReceiptID(s.resubmitReceiptHandler)))
+	mux.HandleFunc("GET /receipts/{id}/points/history", s.withReceiptID(s.pointsHistoryHandler))
+	mux.HandleFunc("POST /receipts/{id}/points/reserve", s.withReceiptID(s.reservePointsHandler))
+	mux.HandleFunc("POST /receipts/{id}/points/transfer", s.withReceiptID(s.transferPointsHandler))
+	mux.HandleFunc("POST /receipts/{id}/points/commit/{reservationId}", s.withReceiptID(s.commitReservationHandler))
+	mux.HandleFunc("POST /receipts/{id}/points/rollback/{reservationId}", s.withReceiptID(s.rollbackReservationHandler))
+	mux.HandleFunc("GET /schema/receipt.json", receiptSchemaHandler)
+	mux.HandleFunc("GET /graphql", s.graphqlHandler)
+	mux.HandleFunc("POST /graphql", s.graphqlHandler)
+	mux.HandleFunc("GET /graphiql", graphiqlHandler)
+	mux.HandleFunc("/healthz", s.healthzHandler)
+	mux.HandleFunc("/health", s.healthzHandler)
+	mux.HandleFunc("/readyz", s.readyzHandler)
+	mux.HandleFunc("GET /usage", s.usageHandler)
+	mux.HandleFunc("GET /stats", s.statsHandler)
+	mux.HandleFunc("GET /retailers/{name}/points-24h", s.retailerPointsHandler)
+
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("GET /admin/rules", s.listRulesHandler)
+	adminMux.HandleFunc("POST /admin/rules/{name}/enable", s.enableRuleHandler)
+	adminMux.HandleFunc("POST /admin/rules/{name}/disable", s.disableRuleHandler)
+	adminMux.HandleFunc("GET /admin/rules/{name}/history", s.ruleHistoryHandler)
+	adminMux.HandleFunc("/admin/maintenance", s.maintenanceHandler)
+	adminMux.HandleFunc("/admin/chaos", s.chaosHandler)
+	adminMux.HandleFunc("GET /admin/usage", s.adminUsageHandler)
+	adminMux.HandleFunc("/admin/loglevel", s.logLevelHandler)
+	adminMux.HandleFunc("POST /admin/import-from-url", s.importFromURLHandler)
+	adminMux.HandleFunc("POST /admin/restore", s.restoreDumpHandler)
+	if s.scoringConfigFile != "" {
+		adminMux.HandleFunc("POST /admin/config/reload", s.reloadConfigHandler)
+		// The path the reload was first served under, kept for clients already calling it
+		adminMux.HandleFunc("POST /admin/reload-config", s.reloadConfigHandler)
+	}
+	// requireAdmin goes outermost so that with admin disabled every client gets the same 404, wherever it is
+	mux.Handle("/admin/", s.requireAdmin(s.adminIPGuard(adminMux)))
+
+	// Metrics and pprof are served here only when they have no listener of their own, which Run starts
+	if config.MetricsAddr == "" && s.adminToken != "" {
+		mux.Handle("/metrics", s.requireAdmin(s.adminIPGuard(s.metrics.handler())))
+	}
+	if config.Pprof.Addr == "" && config.Pprof.Enabled {
+		mux.Handle("/debug/pprof/", s.pprofHandler())
+	}
+
+	// Middleware is listed innermost first
+	var handler http.Handler = s.routeMethodsMiddleware(mux)
+	handler = gzipMiddleware(config.GzipMinSize, handler)
+	handler = securityHeadersMiddleware(config.SecurityHeaders, handler)
+	handler = s.chaosMiddleware(handler)
+	handler = s.maintenanceMiddleware(handler)
+	handler = s.apiKeyMiddleware(handler)
+	handler = corsMiddleware(config.CORS, handler)
+	handler = s.concurrencyLimitMiddleware(handler)
+	handler = s.recoveryMiddleware(handler)
+	handler = s.loggingMiddleware(handler)
+	if options.accessLogger != nil {
+		s.metrics.registry.MustRegister(options.accessLogger.dropped)
+		handler = options.accessLogger.middleware(s.clientIP, handler)
+	}
+	handler = requestIDMiddleware(handler)
+	handler = s.clientIPMiddleware(handler)
+	handler = s.metrics.middleware(s.routeLabel, handler)
+	handler = s.requestCountMiddleware(handler)
+	handler = s.tracingMiddleware(handler)
+	s.handler = handler
+	return s, nil
+}
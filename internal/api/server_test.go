@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+)
+
+// Receipts from the challenge's examples, worth 28 and 109 points under the default rules
+const (
+	targetReceipt = `{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01","items":[` +
+		`{"shortDescription":"Mountain Dew 12PK","price":"6.49"},{"shortDescription":"Emils Cheese Pizza","price":"12.25"},` +
+		`{"shortDescription":"Knorr Creamy Chicken","price":"1.26"},{"shortDescription":"Doritos Nacho Cheese","price":"3.35"},` +
+		`{"shortDescription":"   Klarbrunn 12-PK 12 FL OZ  ","price":"12.00"}],"total":"35.35"}`
+	cornerMarketReceipt = `{"retailer":"M&M Corner Market","purchaseDate":"2022-03-20","purchaseTime":"14:33","items":[` +
+		`{"shortDescription":"Gatorade","price":"2.25"},{"shortDescription":"Gatorade","price":"2.25"},` +
+		`{"shortDescription":"Gatorade","price":"2.25"},{"shortDescription":"Gatorade","price":"2.25"}],"total":"9.00"}`
+)
+
+// Function to give tests a logger that throws everything away
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// Function to serve a new server over a store of its own, scored with config, until the test ends
+func startServer(t *testing.T, config points.Config, opts ...Option) *httptest.Server {
+	t.Helper()
+	return startServerOver(t, store.NewMemory(defaultLockShards), config, opts...)
+}
+
+// Function to serve a new server over receiptStore, scored with config, until the test ends
+func startServerOver(t *testing.T, receiptStore store.Store, config points.Config, opts ...Option) *httptest.Server {
+	t.Helper()
+	handler, err := NewServer(receiptStore, points.NewCalculator(config), append([]Option{WithLogger(discardLogger())}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Function to send one request and read the whole response; header takes name, value pairs
+func send(t *testing.T, server *httptest.Server, method, path, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		request.Header.Set(header[i], header[i+1])
+	}
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	read, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, string(read)
+}
+
+// Function to submit a receipt that must be accepted and return its ID
+func processReceipt(t *testing.T, server *httptest.Server, receipt string, header ...string) string {
+	t.Helper()
+	response, body := send(t, server, http.MethodPost, "/receipts/process", receipt, header...)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("POST /receipts/process = %d %s", response.StatusCode, body)
+	}
+	var processed struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(body), &processed); err != nil || processed.ID == "" {
+		t.Fatalf("POST /receipts/process body %q has no id", body)
+	}
+	return processed.ID
+}
+
+// Function to read the points of a stored receipt
+func receiptPoints(t *testing.T, server *httptest.Server, id string) int {
+	t.Helper()
+	response, body := send(t, server, http.MethodGet, "/receipts/"+id+"/points", "")
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("GET /receipts/%s/points = %d %s", id, response.StatusCode, body)
+	}
+	var awarded struct {
+		Points int `json:"points"`
+	}
+	if err := json.Unmarshal([]byte(body), &awarded); err != nil {
+		t.Fatalf("GET /receipts/%s/points body %q: %v", id, body, err)
+	}
+	return awarded.Points
+}
+
+func TestServersShareNothing(t *testing.T) {
+	doubled := points.DefaultConfig()
+	doubled.RetailerCharPoints = 2
+	first := startServer(t, points.DefaultConfig(), WithAuth(AuthConfig{AdminToken: "first-token"}))
+	second := startServer(t, doubled, WithAuth(AuthConfig{AdminToken: "second-token"}))
+
+	id := processReceipt(t, first, targetReceipt)
+	if got := receiptPoints(t, first, id); got != 28 {
+		t.Errorf("first server scored the Target receipt %d, want 28", got)
+	}
+	if response, _ := send(t, second, http.MethodGet, "/receipts/"+id+"/points", ""); response.StatusCode != http.StatusNotFound {
+		t.Errorf("second server answered %d for a receipt only the first stored, want 404", response.StatusCode)
+	}
+
+	id = processReceipt(t, second, targetReceipt)
+	if got := receiptPoints(t, second, id); got != 34 {
+		t.Errorf("second server scored the Target receipt %d, want 34 with two points per retailer character", got)
+	}
+
+	tests := []struct {
+		server *httptest.Server
+		token  string
+		want   int
+	}{
+		{first, "first-token", http.StatusOK},
+		{first, "second-token", http.StatusUnauthorized},
+		{second, "second-token", http.StatusOK},
+		{second, "first-token", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		if response, _ := send(t, test.server, http.MethodGet, "/admin/rules", "", "X-Admin-Token", test.token); response.StatusCode != test.want {
+			t.Errorf("GET /admin/rules on %s with %s = %d, want %d", test.server.URL, test.token, response.StatusCode, test.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type listenerKey struct{}
+
+// One bound listener together with the handler it serves
+type boundListener struct {
+	name     string
+	addr     string
+	listener net.Listener
+	handler  http.Handler
+}
+
+// Function to close listeners bound earlier when a later one fails to bind, so startup leaves no port held
+func closeListeners(listeners []boundListener) {
+	for _, bound := range listeners {
+		bound.listener.Close()
+	}
+}
+
+// Middleware to record which listener a request arrived on, for the metrics labels
+func withListener(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), listenerKey{}, name)))
+	})
+}
+
+// Function to get the name of the listener a request arrived on
+func listenerName(r *http.Request) string {
+	if name, ok := r.Context().Value(listenerKey{}).(string); ok {
+		return name
+	}
+	return "http"
+}
+
+// Handler to send plain HTTP requests to the same path on the HTTPS listener; health checks are still answered
+func (s *server) httpsRedirectHandler(tlsAddr string, health http.Handler) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	redirect := s.metrics.middleware(s.routeLabel, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if port != "" && port != "443" {
+			host += ":" + port
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthPaths[r.URL.Path] {
+			health.ServeHTTP(w, r)
+			return
+		}
+		redirect.ServeHTTP(w, r)
+	})
+}
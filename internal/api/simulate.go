@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/points"
+)
+
+// Largest number of scoring configs one simulation request may compare
+const maxSimulationScenarios = 10
+
+type SimulateScenariosRequest struct {
+	Receipt   model.Receipt     `json:"receipt"`
+	Scenarios []json.RawMessage `json:"scenarios"`
+}
+
+type ScenarioResult struct {
+	ScenarioIndex int            `json:"scenarioIndex"`
+	Points        int            `json:"points"`
+	Breakdown     map[string]int `json:"breakdown,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// Function to score a receipt under one scenario; fields the scenario leaves out keep their default values
+func simulateScenario(receipt model.Receipt, index int, raw json.RawMessage) ScenarioResult {
+	result := ScenarioResult{ScenarioIndex: index}
+	config := points.DefaultConfig()
+	if err := json.Unmarshal(raw, &config); err != nil {
+		result.Error = fmt.Sprintf("invalid scenario: %v", err)
+		return result
+	}
+	if err := config.Validate(); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Breakdown = points.Breakdown(receipt, config)
+	for _, rulePoints := range result.Breakdown {
+		result.Points += rulePoints
+	}
+	return result
+}
+
+// Handler to score one receipt under several candidate scoring configs without storing anything
+func (s *server) simulateScenariosHandler(w http.ResponseWriter, r *http.Request) {
+	var request SimulateScenariosRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The simulation request is invalid."))
+		return
+	}
+	if len(request.Scenarios) == 0 || len(request.Scenarios) > maxSimulationScenarios {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, fmt.Sprintf("Between 1 and %d scenarios are required.", maxSimulationScenarios)))
+		return
+	}
+	err := s.normalizeReceipt(&request.Receipt)
+	if err == nil {
+		err = s.validateReceipt(request.Receipt)
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	results := make([]ScenarioResult, 0, len(request.Scenarios))
+	for i, scenario := range request.Scenarios {
+		results = append(results, simulateScenario(request.Receipt, i, scenario))
+	}
+	s.writeJSON(w, r, http.StatusOK, results)
+}
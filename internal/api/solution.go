@@ -0,0 +1,878 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/proto"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+	"receipt-processor/receiptpb"
+)
+
+// Submission body: the receipt itself plus optional per-submission settings
+type ProcessReceiptRequest struct {
+	model.Receipt
+	ScoringOverrides *ScoringOverrides `json:"scoringOverrides,omitempty"`
+
+	// Photo sent in the image part of a multipart submission
+	image *attachedImage
+}
+
+type ScoringOverrides struct {
+	EvenDayBonus         *int `json:"evenDayBonus,omitempty"`
+	RoundTotalBonus      *int `json:"roundTotalBonus,omitempty"`
+	QuarterMultipleBonus *int `json:"quarterMultipleBonus,omitempty"`
+}
+
+// Function to compute the SHA-256 fingerprint of the canonicalized receipt
+func fingerprintReceipt(receipt model.Receipt) string {
+	items := make([]map[string]string, 0, len(receipt.Items))
+	for _, item := range receipt.Items {
+		items = append(items, map[string]string{
+			"shortDescription": strings.TrimSpace(item.ShortDescription),
+			"price":            strings.TrimSpace(item.Price),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i]["shortDescription"] != items[j]["shortDescription"] {
+			return items[i]["shortDescription"] < items[j]["shortDescription"]
+		}
+		return items[i]["price"] < items[j]["price"]
+	})
+
+	// Maps are encoded with sorted keys, which gives a stable canonical form
+	canonical, _ := json.Marshal(map[string]any{
+		"retailer":     strings.TrimSpace(receipt.Retailer),
+		"purchaseDate": strings.TrimSpace(receipt.PurchaseDate),
+		"purchaseTime": strings.TrimSpace(receipt.PurchaseTime),
+		"total":        strings.TrimSpace(receipt.Total),
+		"items":        items,
+	})
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// Function to apply submission overrides on top of a scoring config, failing when the merged config is invalid
+func applyScoringOverrides(config points.Config, overrides *ScoringOverrides) (points.Config, error) {
+	if overrides == nil {
+		return config, nil
+	}
+	if overrides.EvenDayBonus != nil {
+		config.EvenDayBonus = *overrides.EvenDayBonus
+	}
+	if overrides.RoundTotalBonus != nil {
+		config.RoundTotalBonus = *overrides.RoundTotalBonus
+	}
+	if overrides.QuarterMultipleBonus != nil {
+		config.QuarterMultipleBonus = *overrides.QuarterMultipleBonus
+	}
+	return config, config.Validate()
+}
+
+// Function to accept scoring overrides only from admins and only when the scoring they make is valid, answering
+// the client itself when they are refused
+func (s *server) checkScoringOverrides(w http.ResponseWriter, r *http.Request, overrides *ScoringOverrides) bool {
+	if overrides == nil {
+		return true
+	}
+	if !s.isAdminRequest(r) {
+		s.writeError(w, r, errorWithStatus(http.StatusForbidden, "Scoring overrides require an admin token."))
+		return false
+	}
+	if _, err := applyScoringOverrides(s.calculator.Active(), overrides); err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The scoring overrides are invalid: "+err.Error()+"."))
+		return false
+	}
+	return true
+}
+
+// Function to adapt a per-receipt handler to a route pattern; IDs that are not UUIDs can never match a receipt
+func (s *server) withReceiptID(handler func(w http.ResponseWriter, r *http.Request, id string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if _, err := uuid.Parse(id); err != nil {
+			s.writeError(w, r, ErrNotFound)
+			return
+		}
+		handler(w, r, id)
+	}
+}
+
+// Patterns are compiled once at startup; all are constants, none are built from request input
+var (
+	retailerPattern         = regexp.MustCompile(`^[\w\s\-&]+$`)
+	shortDescriptionPattern = regexp.MustCompile(`^[\w\s\-]+$`)
+)
+
+// Largest field sizes in characters, and the exact sizes of the date and time. They are checked before any
+// pattern, so an oversized field is turned away without the regex engine reading it
+const (
+	maxRetailerLength         = 200
+	maxShortDescriptionLength = 200
+	maxAmountLength           = 20
+	purchaseDateLength        = 10
+	purchaseTimeLength        = 5
+)
+
+const defaultMaxItemsPerReceipt = 500
+
+// Function to name a field of the wrong length and the length it must have
+func lengthError(reason, field string, limit int, exact bool) error {
+	if exact {
+		return invalidField(field, reason, fmt.Sprintf("%s must be exactly %d characters.", field, limit))
+	}
+	return invalidField(field, reason, fmt.Sprintf("%s must be at most %d characters.", field, limit))
+}
+
+// Function to check every field of a receipt against its length limit, counting characters rather than bytes
+func checkReceiptLengths(receipt model.Receipt) error {
+	switch {
+	case utf8.RuneCountInString(receipt.Retailer) > maxRetailerLength:
+		return lengthError("retailer_length", "retailer", maxRetailerLength, false)
+	case utf8.RuneCountInString(receipt.PurchaseDate) != purchaseDateLength:
+		return lengthError("purchase_date_length", "purchaseDate", purchaseDateLength, true)
+	case utf8.RuneCountInString(receipt.PurchaseTime) != purchaseTimeLength:
+		return lengthError("purchase_time_length", "purchaseTime", purchaseTimeLength, true)
+	case utf8.RuneCountInString(receipt.Total) > maxAmountLength:
+		return lengthError("total_length", "total", maxAmountLength, false)
+	}
+	for i, item := range receipt.Items {
+		if utf8.RuneCountInString(item.ShortDescription) > maxShortDescriptionLength {
+			return lengthError("item_description_length", fmt.Sprintf("items[%d].shortDescription", i), maxShortDescriptionLength, false)
+		}
+		if utf8.RuneCountInString(item.Price) > maxAmountLength {
+			return lengthError("item_price_length", fmt.Sprintf("items[%d].price", i), maxAmountLength, false)
+		}
+	}
+	return nil
+}
+
+// Function to validate receipt data
+func (s *server) validateReceipt(receipt model.Receipt) error {
+	if missing := missingFields(receipt); missing != nil {
+		return missing
+	}
+	if len(receipt.Items) > s.maxItemsPerReceipt {
+		return invalidField("items", "too_many_items", fmt.Sprintf("items count exceeds maximum of %d.", s.maxItemsPerReceipt))
+	}
+	if len(receipt.Items) < s.minItemsPerReceipt {
+		return invalidField("items", "too_few_items", fmt.Sprintf("items count is below minimum of %d.", s.minItemsPerReceipt))
+	}
+	if err := checkReceiptLengths(receipt); err != nil {
+		return err
+	}
+
+	if !retailerPattern.MatchString(receipt.Retailer) {
+		return invalidField("retailer", "retailer", "")
+	}
+
+	if _, err := time.Parse("2006-01-02", receipt.PurchaseDate); err != nil {
+		return invalidField("purchaseDate", "purchase_date", "")
+	}
+
+	if _, err := time.Parse("15:04", receipt.PurchaseTime); err != nil {
+		return invalidField("purchaseTime", "purchase_time", "")
+	}
+
+	if _, err := model.ParseMoney(receipt.Total); err != nil {
+		return invalidField("total", "total", "")
+	}
+
+	for i, item := range receipt.Items {
+		if item.ShortDescription == "" || item.Price == "" {
+			return invalidField(fmt.Sprintf("items[%d]", i), "item_missing_field", "")
+		}
+		if !shortDescriptionPattern.MatchString(item.ShortDescription) {
+			return invalidField(fmt.Sprintf("items[%d].shortDescription", i), "item_description", "")
+		}
+		if _, err := model.ParseMoney(item.Price); err != nil {
+			return invalidField(fmt.Sprintf("items[%d].price", i), "item_price", "")
+		}
+	}
+	return nil
+}
+
+// Function to list every required field the receipt leaves empty, or nil when none is
+func missingFields(receipt model.Receipt) *ValidationError {
+	var invalid *ValidationError
+	for _, field := range []struct {
+		name    string
+		missing bool
+	}{
+		{"retailer", receipt.Retailer == ""},
+		{"purchaseDate", receipt.PurchaseDate == ""},
+		{"purchaseTime", receipt.PurchaseTime == ""},
+		{"total", receipt.Total == ""},
+		{"items", len(receipt.Items) == 0},
+	} {
+		if field.missing {
+			if invalid == nil {
+				invalid = &ValidationError{}
+			}
+			invalid.Violations = append(invalid.Violations, FieldViolation{Field: field.name, Reason: "missing_field"})
+		}
+	}
+	return invalid
+}
+
+// Handler to get points for a receipt; without the envelope the encoded body is served from pointsCache
+func (s *server) getPointsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("receipt.id", id))
+	format := s.chooseFormat(w, r, s.offeredFormats(mediaJSON, mediaText, mediaProtobuf, mediaMsgpack, mediaXML))
+	if format == "" {
+		return
+	}
+	if format == mediaMsgpack {
+		errorWriter := s.withMsgpackErrors(w, r)
+		defer errorWriter.finish()
+		w = errorWriter
+	}
+	if !s.responseEnvelope && format == mediaJSON {
+		if body, ok := s.pointsCache.get(id); ok {
+			writeEncodedJSON(w, http.StatusOK, body)
+			return
+		}
+	}
+
+	_, span := tracer.Start(r.Context(), "store.getPoints")
+	shard := s.store.Shard(id)
+	shard.RLock()
+	p, exists := shard.Points[id]
+	var body []byte
+	if exists && !s.responseEnvelope {
+		body, _ = json.Marshal(model.ResponsePoints{Points: p})
+		body = append(body, '\n')
+		s.pointsCache.put(id, body)
+	}
+	shard.RUnlock()
+	span.End()
+
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	if format == mediaJSON && body != nil {
+		writeEncodedJSON(w, http.StatusOK, body)
+		return
+	}
+	s.writeFormat(w, r, http.StatusOK, format, map[string]any{
+		mediaJSON:     model.ResponsePoints{Points: p},
+		mediaText:     strconv.Itoa(p) + "\n",
+		mediaProtobuf: &receiptpb.GetPointsResponse{Points: int64(p)},
+		mediaXML:      xmlPointsResponse{Points: p},
+		mediaMsgpack:  msgpackPointsResponse{Points: p},
+	}[format])
+}
+
+// Largest number of IDs accepted by the batch points lookup
+const maxBatchPointsIDs = 100
+
+// Handler to look up points for several receipts at once; unknown IDs map to null
+func (s *server) batchPointsHandler(w http.ResponseWriter, r *http.Request) {
+	format := responseContentType(r, mediaJSON, mediaMsgpack)
+	w.Header().Set("Vary", "Accept")
+	if format == mediaMsgpack {
+		errorWriter := s.withMsgpackErrors(w, r)
+		defer errorWriter.finish()
+		w = errorWriter
+	}
+	var request model.BatchPointsRequest
+	var err error
+	// Anything not sent as MessagePack is read as JSON, as it always has been
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == mediaMsgpack {
+		var wire msgpackBatchPointsRequest
+		err = decodeMsgpackBody(w, r, &wire)
+		request.IDs = wire.IDs
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&request)
+	}
+	if err != nil || len(request.IDs) == 0 {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The request must contain a non-empty ids array."))
+		return
+	}
+	if len(request.IDs) > maxBatchPointsIDs {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, fmt.Sprintf("At most %d ids may be requested at once.", maxBatchPointsIDs)))
+		return
+	}
+
+	// Every change to points holds the shard locks of the receipts it touches, so holding all of theirs for reading
+	// makes the batch one consistent view: a transfer between two of the receipts is seen entirely or not at all
+	result := make(map[string]*int, len(request.IDs))
+	unlock := s.store.RLockEach(request.IDs)
+	for _, id := range request.IDs {
+		if p, exists := s.store.Shard(id).Points[id]; exists {
+			result[id] = &p
+		} else {
+			result[id] = nil
+		}
+	}
+	unlock()
+
+	s.writeFormat(w, r, http.StatusOK, format, result)
+}
+
+// Handler to count stored receipts, optionally for a single retailer
+func (s *server) countReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	var count int
+	if retailer := r.URL.Query().Get("retailer"); retailer != "" {
+		count = s.store.RetailerCount(retailer)
+	} else {
+		count = s.store.Count()
+	}
+
+	s.writeJSON(w, r, http.StatusOK, model.ResponseCount{Count: count})
+}
+
+// A validated, scored receipt ready to be stored
+type submission struct {
+	receipt     model.Receipt
+	points      int
+	fingerprint string
+	actor       string
+	tenant      string
+	apiKey      string
+	body        *store.Body
+	// Set when restoring a dump: the receipt keeps its ID and stored time, and no quota is charged
+	restoredID string
+	storedAt   time.Time
+}
+
+var errReceiptExists = errors.New("a receipt with that ID is already stored")
+
+// Function to store a scored receipt; in strict mode a duplicate returns the existing ID instead
+func (s *server) insertReceipt(ctx context.Context, sub submission) (string, bool, error) {
+	_, span := tracer.Start(ctx, "store.insert")
+	defer span.End()
+	defer s.observeWriteLatency(time.Now())
+
+	id, duplicate, pending, err := s.storeSubmission(ctx, sub)
+	if err != nil {
+		return "", false, err
+	}
+	// Under strict group commit the response waits here, outside the shard lock, for the batch holding this insert to sync
+	if err := s.awaitInsert(ctx, pending); err != nil {
+		return "", false, err
+	}
+	return id, duplicate, nil
+}
+
+// A stored submission whose record group commit may not have synced yet, with what taking it back needs
+type pendingInsert struct {
+	ack     store.Ack
+	id      string
+	sub     submission
+	entry   store.Entry
+	charged bool
+}
+
+// Function to wait until a stored submission is durable. When the sync fails the insert is taken back, so a
+// receipt the client was refused is never served: the store drops it and its fingerprint claim, and its quota,
+// retailer points and timeline go with it. Returns nil at once for a duplicate, which stored nothing
+func (s *server) awaitInsert(ctx context.Context, pending *pendingInsert) error {
+	if pending == nil {
+		return nil
+	}
+	err := pending.ack.Wait()
+	if err == nil {
+		return nil
+	}
+	shard := s.store.Shard(pending.id)
+	shard.Lock()
+	if discardErr := s.store.Discard(pending.id, pending.entry); discardErr != nil {
+		s.logger.ErrorContext(ctx, "logging a discarded insert failed", "receiptId", pending.id, "error", discardErr)
+	}
+	s.forgetReceiptLocked(pending.id, "system")
+	shard.Unlock()
+	s.recordRetailerPoints(pending.sub.receipt.Retailer, -pending.sub.points, pending.entry.StoredAt)
+	if pending.charged {
+		s.refundQuotas(pending.sub.tenant, pending.sub.apiKey, pending.entry.StoredAt)
+	}
+	return err
+}
+
+// Function to store a scored receipt under its shard lock alone; callers pass the returned pending insert to
+// awaitInsert. In strict mode the fingerprint is claimed before the insert, so of two identical receipts stored at
+// once only one is kept
+func (s *server) storeSubmission(ctx context.Context, sub submission) (string, bool, *pendingInsert, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, nil, err
+	}
+
+	now := s.clock()
+	id := uuid.New().String()
+	if sub.restoredID != "" {
+		id = sub.restoredID
+		if !sub.storedAt.IsZero() {
+			now = sub.storedAt
+		}
+	}
+	if !s.retainReceipts {
+		sub.receipt = lightReceipt(sub.receipt)
+		sub.body = nil
+	}
+
+	shard := s.store.Shard(id)
+	shard.Lock()
+	defer shard.Unlock()
+	if _, exists := shard.Lookup(id); exists {
+		return "", false, nil, errReceiptExists
+	}
+	if s.strictMode {
+		if owner, claimed := s.store.ClaimFingerprint(sub.fingerprint, id); !claimed {
+			return owner, true, nil, nil
+		}
+	}
+	entry := store.Entry{Receipt: sub.receipt, Points: sub.points, Fingerprint: sub.fingerprint, StoredAt: now,
+		SchemaVersion: currentSchemaVersion, Body: sub.body}
+	var err error
+	charged := sub.restoredID == ""
+	if charged {
+		err = s.consumeQuotas(sub.tenant, sub.apiKey, now)
+		// Usage counted against a key's quotas is stored with the receipt, so a restart from the WAL keeps it
+		if _, limited := s.apiKeyQuotas[sub.apiKey]; limited && sub.apiKey != "" {
+			entry.APIKey = sub.apiKey
+		}
+	}
+	var ack store.Ack
+	if err == nil {
+		ack, err = s.store.Insert(id, entry)
+		if err != nil && charged {
+			s.refundQuotas(sub.tenant, sub.apiKey, now)
+		}
+	}
+	if err != nil {
+		if s.strictMode {
+			s.store.ReleaseFingerprint(id)
+		}
+		return "", false, nil, err
+	}
+	s.recordRetailerPoints(sub.receipt.Retailer, sub.points, now)
+	s.recordPointsMutation(id, model.MutationCalculated, sub.points, sub.actor)
+	s.recordEvent(id, EventCreated, sub.actor, nil)
+	s.recordEvent(id, EventPointsCalculated, "system", map[string]any{"points": sub.points})
+	return id, false, &pendingInsert{ack: ack, id: id, sub: sub, entry: entry, charged: charged}, nil
+}
+
+// Largest protobuf request body read into memory
+const maxProtobufBodyBytes = 1 << 20
+
+// Function to decode a process request in any wire format; only JSON requests carry scoring overrides
+func (s *server) decodeProcessRequest(w http.ResponseWriter, r *http.Request, bodyType string) (ProcessReceiptRequest, error) {
+	var request ProcessReceiptRequest
+	switch bodyType {
+	case mediaMultipart:
+		return s.decodeMultipartProcess(w, r)
+	case mediaForm:
+		receipt, err := decodeFormReceipt(w, r)
+		request.Receipt = receipt
+		return request, err
+	case mediaXML:
+		receipt, err := decodeXMLReceipt(w, r)
+		request.Receipt = receipt
+		return request, err
+	case mediaMsgpack:
+		receipt, err := decodeMsgpackReceipt(w, r)
+		request.Receipt = receipt
+		return request, err
+	case mediaJSON:
+		if s.schemaValidation {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return request, err
+			}
+			if err := validateAgainstSchema(body); err != nil {
+				return request, err
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		return request, err
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxProtobufBodyBytes))
+	if err != nil {
+		return request, err
+	}
+	var message receiptpb.ProcessReceiptRequest
+	if err := proto.Unmarshal(body, &message); err != nil {
+		return request, err
+	}
+	request.Receipt = fromProtoReceipt(message.GetReceipt())
+	return request, nil
+}
+
+// Function to answer a processed receipt in the format the client accepts; the receipt is already stored, so
+// an unsatisfiable Accept header falls back to JSON rather than a 406
+func (s *server) writeProcessResponse(w http.ResponseWriter, r *http.Request, response model.ResponseID, duplicate bool) {
+	id := response.ID
+	w.Header().Set("Vary", "Accept")
+	format := responseContentType(r, s.receiptWireTypes()...)
+	if format == mediaJSON && duplicate {
+		s.writeJSON(w, r, http.StatusOK, response, map[string]any{"duplicate": true})
+		return
+	}
+	s.writeFormat(w, r, http.StatusOK, format, map[string]any{
+		mediaJSON:     response,
+		mediaProtobuf: &receiptpb.ProcessReceiptResponse{Id: id, Duplicate: duplicate},
+		mediaXML:      xmlIDResponse{ID: id, Duplicate: duplicate},
+		mediaMsgpack: msgpackIDResponse{ID: id, Duplicate: duplicate, ImageStored: response.ImageStored,
+			ImageError: response.ImageError},
+	}[format])
+}
+
+// Metric reason for an undecodable body in each wire format
+var malformedBodyReasons = map[string]string{
+	mediaJSON:      "malformed_json",
+	mediaProtobuf:  "malformed_protobuf",
+	mediaXML:       "malformed_xml",
+	mediaMultipart: "malformed_multipart",
+	mediaForm:      "malformed_form",
+	mediaMsgpack:   "malformed_msgpack",
+}
+
+// Function to score a valid receipt and store it, answering the client itself when that fails
+func (s *server) scoreAndStoreReceipt(w http.ResponseWriter, r *http.Request, receipt model.Receipt, overrides *ScoringOverrides, body *store.Body) (string, bool, int, bool) {
+	ctx := r.Context()
+	fingerprint := fingerprintReceipt(receipt)
+	_, span := tracer.Start(ctx, "calculatePoints")
+	// Overrides were checked when the request was decoded, and an active config is always valid
+	scoring, _ := applyScoringOverrides(s.calculator.Active(), overrides)
+	awarded := points.Calculate(receipt, scoring)
+	span.End()
+
+	tenant := s.tenantFromRequest(r)
+	apiKey, _ := s.apiKeyIdentity(r)
+	// The client may have gone away while we validated and scored; don't commit a write it will retry
+	id, duplicate, err := s.insertReceipt(ctx, submission{
+		receipt:     receipt,
+		points:      awarded,
+		fingerprint: fingerprint,
+		actor:       s.requestActor(r),
+		tenant:      tenant,
+		apiKey:      apiKey,
+		body:        body,
+	})
+	var quotaErr *keyQuotaError
+	if errors.As(err, &quotaErr) {
+		s.setQuotaHeaders(w, tenant)
+		s.writeJSON(w, r, http.StatusTooManyRequests, quotaErr)
+		return "", false, 0, false
+	}
+	if errors.Is(err, errQuotaExceeded) {
+		s.setQuotaHeaders(w, tenant)
+		s.writeError(w, r, errorWithStatus(http.StatusTooManyRequests, "Daily receipt quota exceeded."))
+		return "", false, 0, false
+	}
+	if err != nil && ctx.Err() == nil {
+		s.logger.ErrorContext(ctx, "storing receipt failed", "requestId", requestIDFromContext(ctx), "error", err)
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The receipt could not be stored."))
+		return "", false, 0, false
+	}
+	if err != nil {
+		s.logger.WarnContext(ctx, "receipt processing cancelled", "requestId", requestIDFromContext(ctx), "error", err)
+		return "", false, 0, false
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("receipt.id", id))
+	if !duplicate {
+		s.setQuotaHeaders(w, tenant)
+		s.metrics.receiptsProcessed.Inc()
+		s.metrics.pointsAwarded.Add(float64(awarded))
+	}
+	return id, duplicate, awarded, true
+}
+
+// Handler to process receipts sent as JSON, protobuf, MessagePack, form fields, multipart with an image or, when
+// enabled, XML
+func (s *server) processReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	if responseContentType(r, s.receiptWireTypes()...) == mediaMsgpack {
+		errorWriter := s.withMsgpackErrors(w, r)
+		defer errorWriter.finish()
+		w = errorWriter
+	}
+	bodyType, ok := s.requireContentType(w, r, append(s.receiptWireTypes(), mediaMultipart, mediaForm)...)
+	if !ok {
+		return
+	}
+	scope := s.idempotencyScope(r)
+	if !s.claimIdempotencyKey(w, r, scope) {
+		return
+	}
+	// Left nil unless a receipt is stored, which frees the key for a retry
+	var settled *model.ResponseID
+	var settledDuplicate bool
+	defer func() { s.settleIdempotencyKey(scope, settled, settledDuplicate) }()
+	body := s.captureRawBody(w, r)
+	request, err := s.decodeProcessRequest(w, r, bodyType)
+	var schemaErr *schemaError
+	if errors.As(err, &schemaErr) {
+		s.metrics.validationFailures.WithLabelValues("schema").Inc()
+		s.writeJSON(w, r, http.StatusBadRequest, SchemaErrorResponse{Error: schemaErr.Error(), Violations: schemaErr.violations})
+		return
+	}
+	if err != nil {
+		s.metrics.validationFailures.WithLabelValues(malformedBodyReasons[bodyType]).Inc()
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, malformedBodyMessage(err)))
+		return
+	}
+	receipt := request.Receipt
+
+	if !s.checkScoringOverrides(w, r, request.ScoringOverrides) {
+		return
+	}
+
+	ctx := r.Context()
+	_, span := tracer.Start(ctx, "validateReceipt")
+	err = s.normalizeReceipt(&receipt)
+	if err == nil {
+		err = s.validateReceipt(receipt)
+	}
+	span.End()
+	if err != nil {
+		reason := validationReason(err)
+		s.metrics.validationFailures.WithLabelValues(reason).Inc()
+		if bodyType == mediaXML {
+			s.writeError(w, r, errorWithStatus(http.StatusBadRequest, xmlValidationMessage(reason)))
+			return
+		}
+		s.writeError(w, r, err)
+		return
+	}
+
+	id, duplicate, _, ok := s.scoreAndStoreReceipt(w, r, receipt, request.ScoringOverrides, body)
+	if !ok {
+		return
+	}
+	if body != nil {
+		w.Header().Set("X-Body-Stored", strconv.FormatBool(!duplicate))
+	}
+	response := model.ResponseID{ID: id}
+	if request.image != nil {
+		if duplicate {
+			response.ImageError = "the receipt was already stored, so the image was not kept"
+		} else if response.ImageError = s.storeReceiptImage(r, id, request.image); response.ImageError == "" {
+			response.ImageStored = true
+		}
+	}
+	settled, settledDuplicate = &response, duplicate
+	s.writeProcessResponse(w, r, response, duplicate)
+}
+
+// Function to run the server with a loaded configuration until it is told to stop. It runs the self-checks first,
+// and with -check stops after printing them
+func Run(config Config) error {
+	receiptStore := store.NewMemory(config.LockShards)
+	checks, err := runSelfChecks(config)
+	if config.Check {
+		for _, check := range checks {
+			if check.OK {
+				fmt.Println("ok  ", check.Name)
+			} else {
+				fmt.Println("FAIL", check.Name+":", check.Error)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("self-check failed:\n%w", err)
+	}
+	if config.Check {
+		return nil
+	}
+
+	level, _ := parseLogLevel(config.LogLevel)
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(level)
+	logger := newLogger(logLevel)
+	slog.SetDefault(logger)
+	var imageStore BlobStore
+	if config.ImageDir != "" {
+		if imageStore, err = newLocalBlobStore(config.ImageDir); err != nil {
+			return err
+		}
+	}
+	scoring := points.DefaultConfig()
+	if config.ScoringConfigFile != "" {
+		if scoring, err = loadScoringConfigFile(config.ScoringConfigFile); err != nil {
+			return err
+		}
+	}
+	options := []Option{WithConfig(config), WithLogger(logger), withLogLevel(logLevel)}
+	var accessLogger *AccessLogger
+	if config.AccessLog.Path != "" {
+		if accessLogger, err = newAccessLogger(config.AccessLog); err != nil {
+			return err
+		}
+		options = append(options, withAccessLogger(accessLogger))
+	}
+	s, err := newServer(receiptStore, points.NewCalculator(scoring), options...)
+	if err != nil {
+		return err
+	}
+	s.selfChecks = checks
+	s.imageStore = imageStore
+	var handler http.Handler = s
+
+	// Components stop in reverse order once the listeners have drained, so tracing outlives everything that emits spans
+	var components Lifecycle
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		return err
+	}
+	components.Register("tracing", stopFunc(shutdownTracing))
+	if accessLogger != nil {
+		components.Register("accessLog", stopFunc(func(ctx context.Context) error {
+			accessLogger.Close()
+			return nil
+		}))
+	}
+	if config.WAL.Enabled {
+		logged, err := store.OpenLogged(receiptStore, config.WAL, s.clock, s.reservedPoints)
+		if err != nil {
+			return err
+		}
+		s.store = logged
+		s.restoreKeyUsage(logged.KeyUsage())
+		components.Register("wal", stopFunc(func(ctx context.Context) error { return logged.Close() }))
+		if config.WAL.GroupCommitMS > 0 {
+			components.Register("walGroupCommit", newWorker(logged.FlushPeriodically))
+		}
+	}
+	components.Register("quotaReset", newWorker(s.resetQuotasDaily))
+	components.Register("logLevelSignal", newWorker(s.watchLogLevelSignal))
+	components.Register("reservationSweeper", newWorker(s.expireReservationsPeriodically))
+	components.Register("draftSweeper", newWorker(s.expireDraftsPeriodically))
+	components.Register("idempotencySweeper", newWorker(s.expireIdempotencyKeysPeriodically))
+	if s.archiveAfter > 0 {
+		components.Register("archiver", newWorker(s.archiveNightly))
+	}
+
+	if err := components.Start(context.Background()); err != nil {
+		return err
+	}
+
+	// Every listener is bound before any starts serving, so a port that is taken fails startup
+	listener, err := listen(config.Addr, config.SocketMode)
+	if err != nil {
+		return err
+	}
+	var listeners []boundListener
+	if config.TLS.enabled() {
+		tlsConfig, err := buildTLSConfig(config.TLS)
+		if err != nil {
+			return err
+		}
+		if config.TLS.Addr == "" {
+			listeners = append(listeners, boundListener{"https", config.Addr, tls.NewListener(listener, tlsConfig), handler})
+		} else {
+			secure, err := listen(config.TLS.Addr, "")
+			if err != nil {
+				listener.Close()
+				return err
+			}
+			plain := handler
+			if config.TLS.HTTPMode == "redirect" {
+				plain = s.httpsRedirectHandler(config.TLS.Addr, handler)
+			}
+			listeners = append(listeners,
+				boundListener{"http", config.Addr, listener, plain},
+				boundListener{"https", config.TLS.Addr, tls.NewListener(secure, tlsConfig), handler})
+		}
+	} else {
+		listeners = append(listeners, boundListener{"http", config.Addr, listener, handler})
+	}
+
+	// Metrics and pprof get their own listeners when addresses are configured for them
+	sideListeners := []boundListener{
+		{"metrics", config.MetricsAddr, nil, s.adminIPGuard(s.metrics.handler())},
+		{"pprof", config.Pprof.Addr, nil, s.pprofHandler()},
+	}
+	for _, side := range sideListeners {
+		if side.addr == "" {
+			continue
+		}
+		if side.listener, err = listen(side.addr, ""); err != nil {
+			closeListeners(listeners)
+			return fmt.Errorf("%s listener: %w", side.name, err)
+		}
+		listeners = append(listeners, side)
+	}
+
+	var grpcListener net.Listener
+	if config.GRPCAddr != "" {
+		if grpcListener, err = listen(config.GRPCAddr, ""); err != nil {
+			closeListeners(listeners)
+			return err
+		}
+	}
+
+	group, groupCtx := errgroup.WithContext(context.Background())
+	servers := make([]*http.Server, 0, len(listeners))
+	for _, bound := range listeners {
+		server := &http.Server{Handler: withListener(bound.name, bound.handler)}
+		servers = append(servers, server)
+		group.Go(func() error {
+			if err := server.Serve(bound.listener); err != http.ErrServerClosed {
+				return fmt.Errorf("%s listener on %s: %w", bound.name, bound.addr, err)
+			}
+			return nil
+		})
+		fmt.Println("Server started on", bound.addr, "("+bound.name+")")
+	}
+	grpcServer := s.newGRPCServer()
+	if grpcListener != nil {
+		group.Go(func() error {
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				return fmt.Errorf("grpc listener on %s: %w", config.GRPCAddr, err)
+			}
+			return nil
+		})
+		fmt.Println("Server started on", config.GRPCAddr, "(grpc)")
+	}
+
+	// Drain in-flight requests on SIGINT/SIGTERM, or when any listener fails, then stop the background components;
+	// closing a listener also removes a Unix socket file
+	group.Go(func() error {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		select {
+		case <-signals:
+		case <-groupCtx.Done():
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		for _, server := range servers {
+			server.Shutdown(ctx)
+		}
+		stopGRPCServer(ctx, grpcServer)
+		if err := components.Stop(ctx); err != nil {
+			s.logger.Warn("unclean shutdown", "error", err)
+		}
+		return nil
+	})
+	return group.Wait()
+}
@@ -0,0 +1,398 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/points"
+	"receipt-processor/internal/store"
+	"receipt-processor/receiptpb"
+)
+
+func TestBatchPointsSeesTransfersWhole(t *testing.T) {
+	s, err := newServer(store.NewMemory(defaultLockShards), points.NewCalculator(points.DefaultConfig()),
+		WithLogger(discardLogger()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	target := processReceipt(t, server, targetReceipt)
+	market := processReceipt(t, server, cornerMarketReceipt)
+
+	// Stop a transfer halfway through, with its source debited but its target not yet credited
+	unlock := s.store.LockPair(market, target)
+	s.store.Shard(market).Points[market] -= 9
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/receipts/points", strings.NewReader(`{"ids":["`+target+`","`+market+`"]}`))
+		s.ServeHTTP(recorder, request)
+		done <- recorder
+	}()
+	select {
+	case recorder := <-done:
+		unlock()
+		t.Fatalf("batch points answered %s in the middle of a transfer", recorder.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.store.Shard(target).Points[target] += 9
+	unlock()
+
+	recorder := <-done
+	var result map[string]*int
+	decodeBody(t, recorder.Body.String(), &result)
+	if result[target] == nil || result[market] == nil || *result[target] != 37 || *result[market] != 100 {
+		t.Errorf("batch points = %s, want 37 and 100 once the transfer finished", recorder.Body)
+	}
+}
+
+func TestScoringOverrides(t *testing.T) {
+	config := defaultConfig()
+	config.AdminToken = "admin-token"
+	withOverrides := func(receipt, overrides string) string {
+		return strings.TrimSuffix(receipt, "}") + `,"scoringOverrides":` + overrides + "}"
+	}
+	tests := []struct {
+		name    string
+		receipt string
+		token   string
+		want    int
+		points  int
+	}{
+		{"even day bonus on an even day", withOverrides(cornerMarketReceipt, `{"evenDayBonus":100}`), "admin-token", http.StatusOK, 209},
+		{"even day bonus on an odd day", withOverrides(targetReceipt, `{"evenDayBonus":100}`), "admin-token", http.StatusOK, 28},
+		{"round total bonus", withOverrides(cornerMarketReceipt, `{"roundTotalBonus":0}`), "admin-token", http.StatusOK, 59},
+		{"quarter multiple bonus", withOverrides(cornerMarketReceipt, `{"quarterMultipleBonus":75}`), "admin-token", http.StatusOK, 159},
+		{"without the admin token", withOverrides(cornerMarketReceipt, `{"evenDayBonus":100}`), "", http.StatusForbidden, 0},
+		{"negative even day bonus", withOverrides(cornerMarketReceipt, `{"evenDayBonus":-5}`), "admin-token", http.StatusBadRequest, 0},
+		{"negative round total bonus", withOverrides(targetReceipt, `{"roundTotalBonus":-50}`), "admin-token", http.StatusBadRequest, 0},
+		{"negative quarter multiple bonus", withOverrides(targetReceipt, `{"quarterMultipleBonus":-1}`), "admin-token", http.StatusBadRequest, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// A server per case, so no submission is taken for a duplicate of an earlier one
+			server := startServer(t, points.DefaultConfig(), WithConfig(config))
+			response, body := send(t, server, http.MethodPost, "/receipts/process", test.receipt, "X-Admin-Token", test.token)
+			if response.StatusCode != test.want {
+				t.Fatalf("process = %d %s, want %d", response.StatusCode, body, test.want)
+			}
+			if test.want != http.StatusOK {
+				var count model.ResponseCount
+				_, body := send(t, server, http.MethodGet, "/receipts/count", "")
+				if decodeBody(t, body, &count); count.Count != 0 {
+					t.Errorf("%d receipts stored after a refused submission, want 0", count.Count)
+				}
+				return
+			}
+			var created struct {
+				ID string `json:"id"`
+			}
+			decodeBody(t, body, &created)
+			if got := receiptPoints(t, server, created.ID); got != test.points {
+				t.Errorf("scored %d, want %d", got, test.points)
+			}
+		})
+	}
+}
+
+func TestReadHandlersShareTheLocks(t *testing.T) {
+	s := newTestServer(t)
+	server := httptest.NewServer(s)
+	defer server.Close()
+	id := processReceipt(t, server, targetReceipt)
+
+	// Another reader holds every shard lock in shared mode
+	for _, shard := range s.store.Shards() {
+		shard.RLock()
+	}
+	release := func() {
+		for _, shard := range s.store.Shards() {
+			shard.RUnlock()
+		}
+	}
+	serve := func(method, path, body string) <-chan int {
+		done := make(chan int, 1)
+		go func() {
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+			done <- recorder.Code
+		}()
+		return done
+	}
+
+	reads := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, "/receipts/" + id + "/points", ""},
+		{http.MethodGet, "/receipts/" + id, ""},
+		{http.MethodGet, "/receipts/count", ""},
+		{http.MethodPost, "/receipts/points", `{"ids":["` + id + `"]}`},
+	}
+	for _, read := range reads {
+		select {
+		case code := <-serve(read.method, read.path, read.body):
+			if code != http.StatusOK {
+				t.Errorf("%s %s = %d", read.method, read.path, code)
+			}
+		case <-time.After(time.Second):
+			release()
+			t.Fatalf("%s %s waited for a reader to finish", read.method, read.path)
+		}
+	}
+
+	write := serve(http.MethodPost, "/receipts/process", cornerMarketReceipt)
+	select {
+	case code := <-write:
+		release()
+		t.Fatalf("POST /receipts/process answered %d while a reader held the store", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	if code := <-write; code != http.StatusOK {
+		t.Errorf("POST /receipts/process = %d once the reader finished", code)
+	}
+}
+
+// Function to decode one of the test receipts
+func decodeReceipt(t testing.TB, body string) model.Receipt {
+	t.Helper()
+	var receipt model.Receipt
+	if err := json.Unmarshal([]byte(body), &receipt); err != nil {
+		t.Fatal(err)
+	}
+	return receipt
+}
+
+func TestValidateReceipt(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name   string
+		change func(*model.Receipt)
+		field  string
+		reason string
+	}{
+		{"valid", func(r *model.Receipt) {}, "", ""},
+		{"retailer with an ampersand", func(r *model.Receipt) { r.Retailer = "M&M Corner Market" }, "", ""},
+		{"missing retailer", func(r *model.Receipt) { r.Retailer = "" }, "retailer", "missing_field"},
+		{"retailer with punctuation", func(r *model.Receipt) { r.Retailer = "Target!" }, "retailer", "retailer"},
+		{"retailer too long", func(r *model.Receipt) { r.Retailer = strings.Repeat("T", maxRetailerLength+1) },
+			"retailer", "retailer_length"},
+		{"impossible date", func(r *model.Receipt) { r.PurchaseDate = "2022-02-30" }, "purchaseDate", "purchase_date"},
+		{"impossible time", func(r *model.Receipt) { r.PurchaseTime = "25:01" }, "purchaseTime", "purchase_time"},
+		{"short time", func(r *model.Receipt) { r.PurchaseTime = "1:01" }, "purchaseTime", "purchase_time_length"},
+		{"total without cents", func(r *model.Receipt) { r.Total = "35" }, "total", "total"},
+		{"no items", func(r *model.Receipt) { r.Items = nil }, "items", "missing_field"},
+		{"exactly the most items", func(r *model.Receipt) {
+			r.Items = slices.Repeat(r.Items[:1], defaultMaxItemsPerReceipt)
+		}, "", ""},
+		{"too many items", func(r *model.Receipt) {
+			r.Items = slices.Repeat(r.Items[:1], defaultMaxItemsPerReceipt+1)
+		}, "items", "too_many_items"},
+		{"item without a price", func(r *model.Receipt) { r.Items[2].Price = "" }, "items[2]", "item_missing_field"},
+		{"item description with a slash", func(r *model.Receipt) { r.Items[1].ShortDescription = "Pizza/Cheese" },
+			"items[1].shortDescription", "item_description"},
+		{"negative item price", func(r *model.Receipt) { r.Items[0].Price = "-6.49" }, "items[0].price", "item_price"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receipt := decodeReceipt(t, targetReceipt)
+			test.change(&receipt)
+			err := s.validateReceipt(receipt)
+			if test.field == "" {
+				if err != nil {
+					t.Fatalf("validateReceipt = %v, want it accepted", err)
+				}
+				return
+			}
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) || len(invalid.Violations) == 0 {
+				t.Fatalf("validateReceipt = %v, want a ValidationError", err)
+			}
+			if got := invalid.Violations[0]; got.Field != test.field || got.Reason != test.reason {
+				t.Errorf("violation %s/%s, want %s/%s", got.Field, got.Reason, test.field, test.reason)
+			}
+		})
+	}
+}
+
+func BenchmarkValidateReceipt(b *testing.B) {
+	s := newTestServer(b)
+	receipt := decodeReceipt(b, targetReceipt)
+	b.Run("precompiled", func(b *testing.B) {
+		for b.Loop() {
+			if err := s.validateReceipt(receipt); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	// What validation cost when every receipt and item compiled its pattern afresh
+	b.Run("compiled per receipt", func(b *testing.B) {
+		for b.Loop() {
+			if err := s.validateReceipt(receipt); err != nil {
+				b.Fatal(err)
+			}
+			regexp.MustCompile(retailerPattern.String()).MatchString(receipt.Retailer)
+			for _, item := range receipt.Items {
+				regexp.MustCompile(shortDescriptionPattern.String()).MatchString(item.ShortDescription)
+			}
+		}
+	})
+}
+
+func BenchmarkPointsRoute(b *testing.B) {
+	s := newTestServer(b)
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(targetReceipt)))
+	var processed struct{ ID string }
+	if err := json.Unmarshal(recorder.Body.Bytes(), &processed); err != nil {
+		b.Fatal(err)
+	}
+	path := "/receipts/" + processed.ID + "/points"
+	for b.Loop() {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusOK {
+			b.Fatalf("GET %s = %d", path, recorder.Code)
+		}
+	}
+}
+
+func TestStrictModeStoresOneOfConcurrentDuplicates(t *testing.T) {
+	config := defaultConfig()
+	config.StrictMode = true
+	s := newTestServer(t, WithConfig(config))
+	sub, err := s.prepareImport(decodeReceipt(t, targetReceipt), "tester", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const submitters = 20
+	ids := make([]string, submitters)
+	duplicates := make([]bool, submitters)
+	errs := make([]error, submitters)
+	var group sync.WaitGroup
+	for i := range submitters {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			ids[i], duplicates[i], errs[i] = s.insertReceipt(context.Background(), sub)
+		}()
+	}
+	group.Wait()
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored := s.store.Count(); stored != 1 {
+		t.Fatalf("%d receipts stored from %d identical submissions, want 1", stored, submitters)
+	}
+	if kept := slices.Index(duplicates, false); kept < 0 || slices.Index(duplicates[kept+1:], false) >= 0 {
+		t.Fatalf("duplicates = %v, want every submission but one reported as a duplicate", duplicates)
+	}
+	for i, id := range ids {
+		if id != ids[0] {
+			t.Errorf("submission %d got %s, want the stored %s", i, id, ids[0])
+		}
+	}
+}
+
+// Inserts through the whole write path, fingerprinting, quotas and events included, from every processor at once
+func BenchmarkInsertReceipt(b *testing.B) {
+	for _, shards := range []int{1, defaultLockShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			s, err := newServer(store.NewMemory(shards), points.NewCalculator(points.DefaultConfig()),
+				WithLogger(discardLogger()))
+			if err != nil {
+				b.Fatal(err)
+			}
+			sub, err := s.prepareImport(decodeReceipt(b, targetReceipt), "bench", "")
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, _, err := s.insertReceipt(context.Background(), sub); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// Function to submit a receipt as protobuf, returning the ID and whether it was a duplicate
+func processProtobuf(t *testing.T, server *httptest.Server, receipt model.Receipt) (string, bool) {
+	t.Helper()
+	body, err := proto.Marshal(&receiptpb.ProcessReceiptRequest{Receipt: toProtoReceipt(receipt)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, answer := send(t, server, http.MethodPost, "/receipts/process", string(body),
+		"Content-Type", mediaProtobuf, "Accept", mediaProtobuf)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("protobuf submission = %d %s", response.StatusCode, answer)
+	}
+	var processed receiptpb.ProcessReceiptResponse
+	if err := proto.Unmarshal([]byte(answer), &processed); err != nil {
+		t.Fatal(err)
+	}
+	return processed.GetId(), processed.GetDuplicate()
+}
+
+// The same receipt sent as JSON and as protobuf is one receipt: strict mode answers the second submission with
+// the first one's ID, and both formats read back the same points
+func TestJSONAndProtobufSubmissionsAgree(t *testing.T) {
+	config := defaultConfig()
+	config.StrictMode = true
+	for _, body := range []string{targetReceipt, cornerMarketReceipt, morningReceipt} {
+		receipt := decodeReceipt(t, body)
+		t.Run(receipt.Retailer, func(t *testing.T) {
+			server := startServer(t, points.DefaultConfig(), WithConfig(config))
+			id := processReceipt(t, server, body)
+			if protobufID, duplicate := processProtobuf(t, server, receipt); protobufID != id || !duplicate {
+				t.Errorf("protobuf submission = %s, duplicate %v, want the JSON submission's %s as a duplicate", protobufID, duplicate, id)
+			}
+
+			// And the other way round on a fresh server
+			other := startServer(t, points.DefaultConfig(), WithConfig(config))
+			protobufID, duplicate := processProtobuf(t, other, receipt)
+			if duplicate {
+				t.Fatal("first protobuf submission was answered as a duplicate")
+			}
+			response, answer := send(t, other, http.MethodPost, "/receipts/process", body)
+			var processed model.ResponseID
+			decodeBody(t, answer, &processed)
+			if response.StatusCode != http.StatusOK || processed.ID != protobufID {
+				t.Errorf("JSON submission = %d %s, want the protobuf submission's %s", response.StatusCode, answer, protobufID)
+			}
+
+			want := receiptPoints(t, server, id)
+			if got := receiptPoints(t, other, protobufID); got != want {
+				t.Errorf("points of the protobuf submission = %d, want %d as for JSON", got, want)
+			}
+			response, answer = send(t, other, http.MethodGet, "/receipts/"+protobufID+"/points", "", "Accept", mediaProtobuf)
+			var awarded receiptpb.GetPointsResponse
+			if err := proto.Unmarshal([]byte(answer), &awarded); response.StatusCode != http.StatusOK || err != nil || awarded.GetPoints() != int64(want) {
+				t.Errorf("points read as protobuf = %d %d, %v, want %d", response.StatusCode, awarded.GetPoints(), err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"receipt-processor/internal/model"
+)
+
+// Default number of lock shards the per-receipt state is split across
+const defaultLockShards = 16
+
+// Events a stream may fall behind by before it is dropped
+const subscriberBuffer = 64
+
+// Function to start receiving a receipt's events as they are recorded, also returning its timeline so far. The
+// channel is closed when the subscriber falls too far behind; call cancel once done
+func (s *server) subscribeEvents(id string) (events <-chan model.Event, timeline []model.Event, cancel func(), exists bool) {
+	shard := s.store.Shard(id)
+	shard.Lock()
+	defer shard.Unlock()
+	if _, exists := shard.Lookup(id); !exists {
+		return nil, nil, nil, false
+	}
+	timeline = slices.Clone(shard.Events[id])
+	channel := make(chan model.Event, subscriberBuffer)
+	if shard.Subscribers[id] == nil {
+		shard.Subscribers[id] = make(map[chan model.Event]bool)
+	}
+	shard.Subscribers[id][channel] = true
+	cancel = func() {
+		shard.Lock()
+		defer shard.Unlock()
+		shard.Unsubscribe(id, channel)
+	}
+	return channel, timeline, cancel, true
+}
+
+// Function to delete a receipt for good, logging the delete first. Its open reservations go with it, its event
+// streams get a deleted event and are closed, and its image is removed. Returns ErrNotFound when there is no receipt
+// and a conflict when it is pinned
+func (s *server) deleteReceipt(ctx context.Context, id, actor string) error {
+	shard := s.store.Shard(id)
+	shard.Lock()
+	if _, exists := shard.Lookup(id); !exists {
+		shard.Unlock()
+		return ErrNotFound
+	}
+	if shard.Pinned[id] {
+		shard.Unlock()
+		return errPinned
+	}
+	if err := s.store.Delete(id); err != nil {
+		shard.Unlock()
+		return err
+	}
+	s.forgetReceiptLocked(id, actor)
+	shard.Unlock()
+
+	if s.imageStore != nil {
+		if err := s.imageStore.Delete(id); err != nil {
+			s.logger.WarnContext(ctx, "deleting receipt image failed", "receiptId", id, "error", err)
+		}
+	}
+	return nil
+}
+
+// Function to drop what the server keeps about a receipt the store no longer holds: its open reservations, cached
+// points, notes and events. Its event streams get a deleted event and are closed; callers hold the shard lock
+func (s *server) forgetReceiptLocked(id, actor string) {
+	shard := s.store.Shard(id)
+	s.reservationsMutex.Lock()
+	for reservationID, reservation := range s.reservations {
+		if reservation.ReceiptID == id {
+			delete(s.reservations, reservationID)
+		}
+	}
+	s.reservationsMutex.Unlock()
+	s.pointsCache.invalidate(id)
+	s.recordEvent(id, EventDeleted, actor, nil)
+	for channel := range shard.Subscribers[id] {
+		shard.Unsubscribe(id, channel)
+	}
+	delete(shard.Events, id)
+	delete(shard.Notes, id)
+}
+
+// Handler to delete a receipt for good; registered behind requireAdmin. A pinned receipt is refused with 409
+func (s *server) deleteReceiptHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if err := s.deleteReceipt(r.Context(), id, s.requestActor(r)); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
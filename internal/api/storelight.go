@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"receipt-processor/internal/model"
+)
+
+type StoreStats struct {
+	Mode          string `json:"mode"`
+	Receipts      int    `json:"receipts"`
+	ReceiptBytes  int64  `json:"receiptBytes"`
+	ReservedCount int    `json:"openReservations"`
+}
+
+// Function to name the store mode for stats and errors
+func (s *server) storeMode() string {
+	if s.retainReceipts {
+		return "full"
+	}
+	return "light"
+}
+
+// Function to reduce a scored receipt to the fields store-light mode keeps
+func lightReceipt(receipt model.Receipt) model.Receipt {
+	return model.Receipt{Retailer: receipt.Retailer, Total: receipt.Total}
+}
+
+// Function to answer 409 from endpoints that need the full receipt body when it is not retained
+func (s *server) rejectStoreLight(w http.ResponseWriter, r *http.Request) bool {
+	if s.retainReceipts {
+		return false
+	}
+	s.writeError(w, r, errStoreLight)
+	return true
+}
+
+// Function to gather the store mode and size
+func (s *server) currentStoreStats() StoreStats {
+	s.reservationsMutex.Lock()
+	reserved := len(s.reservations)
+	s.reservationsMutex.Unlock()
+	return StoreStats{
+		Mode:          s.storeMode(),
+		Receipts:      s.store.Count(),
+		ReceiptBytes:  s.store.Bytes(),
+		ReservedCount: reserved,
+	}
+}
+
+// Handler to report the store mode and size, so a store-light deployment is easy to recognise
+func (s *server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	format := s.chooseFormat(w, r, s.offeredFormats(mediaJSON, mediaText))
+	if format == "" {
+		return
+	}
+	stats := s.currentStoreStats()
+	s.writeFormat(w, r, http.StatusOK, format, map[string]any{
+		mediaJSON: stats,
+		mediaText: fmt.Sprintf("mode: %s\nreceipts: %d\nreceiptBytes: %d\nopenReservations: %d\n",
+			stats.Mode, stats.Receipts, stats.ReceiptBytes, stats.ReservedCount),
+	}[format])
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"receipt-processor/internal/model"
+)
+
+// Longest NDJSON line accepted by the streaming endpoint
+const maxStreamLineBytes = 1 << 20
+
+type StreamResult struct {
+	Line      int    `json:"line"`
+	ID        string `json:"id,omitempty"`
+	Points    *int   `json:"points,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Function to normalize, validate, score and store one streamed receipt
+func (s *server) processStreamLine(r *http.Request, line []byte) (StreamResult, error) {
+	var receipt model.Receipt
+	if err := json.Unmarshal(line, &receipt); err != nil {
+		s.metrics.validationFailures.WithLabelValues("malformed_json").Inc()
+		return StreamResult{}, errors.New("malformed JSON")
+	}
+	err := s.normalizeReceipt(&receipt)
+	if err == nil {
+		err = s.validateReceipt(receipt)
+	}
+	if err != nil {
+		reason := validationReason(err)
+		s.metrics.validationFailures.WithLabelValues(reason).Inc()
+		return StreamResult{}, fmt.Errorf("invalid %s", reason)
+	}
+
+	awarded := s.calculator.Calculate(receipt)
+	apiKey, _ := s.apiKeyIdentity(r)
+	id, duplicate, err := s.insertReceipt(r.Context(), submission{
+		receipt:     receipt,
+		points:      awarded,
+		fingerprint: fingerprintReceipt(receipt),
+		actor:       s.requestActor(r),
+		tenant:      s.tenantFromRequest(r),
+		apiKey:      apiKey,
+	})
+	var quotaErr *keyQuotaError
+	switch {
+	case errors.As(err, &quotaErr):
+		return StreamResult{}, quotaErr
+	case errors.Is(err, errQuotaExceeded):
+		return StreamResult{}, errors.New("daily receipt quota exceeded")
+	case err != nil && r.Context().Err() == nil:
+		s.logger.ErrorContext(r.Context(), "storing streamed receipt failed", "requestId", requestIDFromContext(r.Context()), "error", err)
+		return StreamResult{}, errors.New("the receipt could not be stored")
+	case err != nil:
+		return StreamResult{}, err
+	}
+	if duplicate {
+		return StreamResult{ID: id, Duplicate: true}, nil
+	}
+	s.metrics.receiptsProcessed.Inc()
+	s.metrics.pointsAwarded.Add(float64(awarded))
+	return StreamResult{ID: id, Points: &awarded}, nil
+}
+
+// Handler to process newline-delimited JSON receipts as they arrive, answering one NDJSON result per line.
+// Only one line is held at a time, so memory stays flat whatever the stream length
+func (s *server) streamReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireContentType(w, r, "application/x-ndjson"); !ok {
+		return
+	}
+
+	// Results are written while the body is still being read
+	controller := http.NewResponseController(w)
+	controller.EnableFullDuplex()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineBytes)
+	failed, line := 0, 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		result, err := s.processStreamLine(r, scanner.Bytes())
+		if err := r.Context().Err(); err != nil {
+			s.logger.WarnContext(r.Context(), "receipt stream cancelled", "requestId", requestIDFromContext(r.Context()), "line", line)
+			return
+		}
+		result.Line = line
+		if err != nil {
+			failed++
+			result.Error = err.Error()
+		}
+		if encoder.Encode(result) != nil {
+			return
+		}
+		controller.Flush()
+		if s.streamMaxErrors > 0 && failed >= s.streamMaxErrors {
+			encoder.Encode(StreamResult{Line: line, Error: fmt.Sprintf("stream aborted after %d failed lines", failed)})
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil && r.Context().Err() == nil {
+		encoder.Encode(StreamResult{Line: line + 1, Error: "stream aborted: " + err.Error()})
+	}
+}
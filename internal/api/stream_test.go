@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Worth 15 points under the default rules
+const morningReceipt = `{"retailer":"Walgreens","purchaseDate":"2022-01-02","purchaseTime":"08:13","items":[` +
+	`{"shortDescription":"Pepsi - 12-oz","price":"1.25"},{"shortDescription":"Dasani","price":"1.40"}],"total":"2.65"}`
+
+// Request body handing out one line per read, running a hook before each line goes out
+type lineReader struct {
+	lines  []string
+	before func(line int)
+	sent   int
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	if l.sent == len(l.lines) {
+		return 0, io.EOF
+	}
+	l.before(l.sent + 1)
+	n := copy(p, l.lines[l.sent]+"\n")
+	l.sent++
+	return n, nil
+}
+
+func TestStreamStopsStoringOnceCancelled(t *testing.T) {
+	s := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The client goes away as the third receipt arrives, after the first two were stored
+	body := &lineReader{lines: []string{targetReceipt, cornerMarketReceipt, morningReceipt}, before: func(line int) {
+		if line == 3 {
+			cancel()
+		}
+	}}
+	request := httptest.NewRequest(http.MethodPost, "/receipts/process/stream", body).WithContext(ctx)
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, request)
+
+	var stored []string
+	for _, line := range strings.Split(strings.TrimSpace(recorder.Body.String()), "\n") {
+		var result StreamResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatalf("result line %q: %v", line, err)
+		}
+		stored = append(stored, result.ID)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("stream answered %d lines %s, want the two stored before the cancellation", len(stored), recorder.Body)
+	}
+	if got := s.store.Count(); got != 2 {
+		t.Errorf("%d receipts stored, want only the 2 committed before the cancellation", got)
+	}
+	for _, id := range stored {
+		if s.store.Shard(id).Points[id] == 0 {
+			t.Errorf("receipt %s from the committed prefix is missing", id)
+		}
+	}
+}
+
+// Request body repeating one line, running a hook before each line goes out, without ever holding more than it
+type repeatReader struct {
+	line    string
+	count   int
+	before  func(line int)
+	sent    int
+	pending string
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	if r.pending == "" {
+		if r.sent == r.count {
+			return 0, io.EOF
+		}
+		r.sent++
+		r.before(r.sent)
+		r.pending = r.line + "\n"
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Response writer that checks each result line as it is written and keeps none of them
+type resultCounter struct {
+	header  http.Header
+	results int
+	stored  int
+	failed  []string
+}
+
+func (c *resultCounter) Header() http.Header { return c.header }
+func (c *resultCounter) WriteHeader(int)     {}
+func (c *resultCounter) Flush()              {}
+
+func (c *resultCounter) Write(p []byte) (int, error) {
+	var result StreamResult
+	if err := json.Unmarshal(p, &result); err != nil || result.Error != "" {
+		c.failed = append(c.failed, string(p))
+	}
+	if result.Points != nil {
+		c.stored++
+	}
+	c.results++
+	return len(p), nil
+}
+
+// Function to read the live heap after a collection
+func liveHeap() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// A long stream holds one line at a time. Strict mode stores the receipt once and answers the rest as
+// duplicates, so the store does not grow and what the heap holds is the stream's own
+func TestLongStreamKeepsMemoryFlat(t *testing.T) {
+	const lines, sampleEvery, maxGrowth = 50000, 5000, 1 << 20
+	config := defaultConfig()
+	config.StrictMode = true
+	s := newTestServer(t, WithConfig(config))
+
+	var baseline, peak uint64
+	body := &repeatReader{line: targetReceipt, count: lines, before: func(line int) {
+		if line%sampleEvery != 0 {
+			return
+		}
+		heap := liveHeap()
+		if line == sampleEvery {
+			baseline = heap
+		}
+		peak = max(peak, heap)
+	}}
+	request := httptest.NewRequest(http.MethodPost, "/receipts/process/stream", body)
+	request.Header.Set("Content-Type", "application/x-ndjson")
+	response := &resultCounter{header: make(http.Header)}
+	s.ServeHTTP(response, request)
+
+	if response.results != lines || response.stored != 1 || len(response.failed) != 0 {
+		t.Fatalf("stream answered %d results, %d stored, failures %v; want %d results with one stored",
+			response.results, response.stored, response.failed, lines)
+	}
+	if growth := int64(peak) - int64(baseline); growth > maxGrowth {
+		t.Errorf("live heap grew by %d bytes over the stream, want at most %d", growth, maxGrowth)
+	}
+}
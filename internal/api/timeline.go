@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"receipt-processor/internal/model"
+)
+
+// Lifecycle event types recorded on a receipt's timeline
+const (
+	EventCreated               = "created"
+	EventPointsCalculated      = "points_calculated"
+	EventRecalculated          = "recalculated"
+	EventReserved              = "reserved"
+	EventRedeemed              = "redeemed"
+	EventReservationRolledBack = "reservation_rolled_back"
+	EventReservationExpired    = "reservation_expired"
+	EventAnnotationAdded       = "annotation_added"
+	EventLocked                = "locked"
+	EventUnlocked              = "unlocked"
+	EventDeleted               = "deleted"
+	EventArchived              = "archived"
+	EventPointsTransferredOut  = "points_transferred_out"
+	EventPointsTransferredIn   = "points_transferred_in"
+	EventResubmitted           = "resubmitted"
+	EventPinned                = "pinned"
+	EventUnpinned              = "unpinned"
+)
+
+// Function to append an event to a receipt's timeline; callers hold the receipt's shard lock. Events are kept in
+// memory only and never written to the WAL, so a restart replays the changes they describe but not the events
+func (s *server) recordEvent(id, eventType, actor string, details map[string]any) {
+	shard := s.store.Shard(id)
+	event := model.Event{
+		Type:      eventType,
+		Timestamp: s.clock().UTC(),
+		Actor:     actor,
+		Details:   details,
+	}
+	shard.Events[id] = append(shard.Events[id], event)
+	shard.Publish(id, event)
+}
+
+// Function to describe who is acting on a request, for the timeline
+func (s *server) requestActor(r *http.Request) string {
+	if identity := identityFromContext(r.Context()); identity != "" {
+		return identity
+	}
+	return "client:" + s.clientIP(r)
+}
+
+// Handler to list the lifecycle events of a receipt in chronological order. The timeline is not persisted: it covers
+// what happened to the receipt since the server last started
+func (s *server) timelineHandler(w http.ResponseWriter, r *http.Request, id string) {
+	shard := s.store.Shard(id)
+	shard.RLock()
+	_, exists := shard.Lookup(id)
+	timeline := append([]model.Event(nil), shard.Events[id]...)
+	shard.RUnlock()
+	if !exists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].Timestamp.Before(timeline[j].Timestamp) })
+	s.writeJSON(w, r, http.StatusOK, timeline)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+)
+
+type TLSConfig struct {
+	CertFile     string `yaml:"certFile"`
+	KeyFile      string `yaml:"keyFile"`
+	ClientCAFile string `yaml:"clientCAFile"`
+	// Glob patterns matched against a client certificate's CN and SANs; empty accepts any certificate the CA signed
+	AllowedSubjects []string `yaml:"allowedSubjects"`
+	// "either" lets a client certificate stand in for an API key, "both" requires an API key as well
+	ClientAuth string `yaml:"clientAuth"`
+	// Separate HTTPS address; when set the main address keeps serving plain HTTP
+	Addr string `yaml:"addr"`
+	// What the plain HTTP listener does while Addr is set: "serve" normally or "redirect" to HTTPS
+	HTTPMode string `yaml:"httpMode"`
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// Function to list the names a client certificate can be matched and identified by
+func certSubjects(cert *x509.Certificate) []string {
+	var subjects []string
+	if cert.Subject.CommonName != "" {
+		subjects = append(subjects, cert.Subject.CommonName)
+	}
+	subjects = append(subjects, cert.DNSNames...)
+	subjects = append(subjects, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		subjects = append(subjects, uri.String())
+	}
+	return subjects
+}
+
+// Function to check a client certificate against the subject allowlist
+func subjectAllowed(patterns []string, cert *x509.Certificate) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, subject := range certSubjects(cert) {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, subject); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Function to build the listener's TLS settings, requiring verified client certificates when a client CA is given
+func buildTLSConfig(c TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if c.ClientCAFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("loading client CA: no certificates found")
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	// Runs after chain verification, so a subject outside the allowlist fails the handshake
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 || !subjectAllowed(c.AllowedSubjects, state.PeerCertificates[0]) {
+			return errors.New("client certificate subject is not allowed")
+		}
+		return nil
+	}
+	return config, nil
+}
+
+// Function to get the identity of a verified client certificate: its CN, or else its first SAN
+func clientCertIdentity(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", false
+	}
+	subjects := certSubjects(r.TLS.VerifiedChains[0][0])
+	if len(subjects) == 0 {
+		return "", false
+	}
+	return subjects[0], true
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Certificate authority generated for one test
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// Function to create a self-signed certificate authority
+func newTestCA(t *testing.T, name string) testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCA{cert, key}
+}
+
+// Function to issue a leaf certificate: a server certificate for 127.0.0.1, or a client certificate for name
+func (ca testCA) issue(t *testing.T, name string, server bool) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// Function to write PEM blocks to a file in dir and return its path
+func writePEM(t *testing.T, dir, name string, blocks ...*pem.Block) string {
+	t.Helper()
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, pem.EncodeToMemory(block)...)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, otherCA := newTestCA(t, "receipts test CA"), newTestCA(t, "untrusted CA")
+	serverCert := ca.issue(t, "receipts", true)
+	keyDER, err := x509.MarshalECPrivateKey(serverCert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverTLS := TLSConfig{
+		CertFile:        writePEM(t, dir, "server.pem", &pem.Block{Type: "CERTIFICATE", Bytes: serverCert.Certificate[0]}),
+		KeyFile:         writePEM(t, dir, "server-key.pem", &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		ClientCAFile:    writePEM(t, dir, "ca.pem", &pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}),
+		AllowedSubjects: []string{"appliance-*"},
+		HTTPMode:        "serve",
+	}
+	tlsConfig, err := buildTLSConfig(serverTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	appliance := ca.issue(t, "appliance-7", false)
+	tests := []struct {
+		name       string
+		clientAuth string
+		cert       *tls.Certificate
+		apiKey     string
+		want       int
+		identity   string
+	}{
+		{"no client certificate", "either", nil, "", 0, ""},
+		{"certificate from another CA", "either", ptr(otherCA.issue(t, "appliance-7", false)), "", 0, ""},
+		{"subject outside the allowlist", "either", ptr(ca.issue(t, "laptop-3", false)), "", 0, ""},
+		{"allowed certificate", "either", &appliance, "", http.StatusOK, "appliance-7"},
+		{"allowed certificate with an API key", "either", &appliance, "reader-key", http.StatusOK, "reader"},
+		{"certificate alone when both are required", "both", &appliance, "", http.StatusUnauthorized, ""},
+		{"certificate and API key when both are required", "both", &appliance, "reader-key", http.StatusOK, "reader"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultConfig()
+			config.APIKeys = "reader:reader-key"
+			config.TLS = serverTLS
+			config.TLS.ClientAuth = test.clientAuth
+			s := newTestServer(t, WithConfig(config))
+			var identity string
+			server := httptest.NewUnstartedServer(s.apiKeyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				identity = identityFromContext(r.Context())
+			})))
+			server.TLS = tlsConfig
+			// The handshake failures are the point of the test
+			server.Config.ErrorLog = log.New(io.Discard, "", 0)
+			server.StartTLS()
+			t.Cleanup(server.Close)
+
+			clientTLS := &tls.Config{RootCAs: roots}
+			if test.cert != nil {
+				clientTLS.Certificates = []tls.Certificate{*test.cert}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+			request, err := http.NewRequest(http.MethodGet, server.URL+"/receipts/count", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.apiKey != "" {
+				request.Header.Set("X-API-Key", test.apiKey)
+			}
+			response, err := client.Do(request)
+			if test.want == 0 {
+				if err == nil {
+					response.Body.Close()
+					t.Fatalf("request answered %d, want the TLS handshake rejected", response.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.StatusCode != test.want {
+				t.Errorf("status %d, want %d", response.StatusCode, test.want)
+			}
+			if identity != test.identity {
+				t.Errorf("identity %q, want %q", identity, test.identity)
+			}
+		})
+	}
+}
+
+// Function to take the address of a value built inline
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Resolved through the global provider, so it stays a no-op until tracing is set up
+var tracer = otel.Tracer("receipt-processor")
+
+// Function to report whether the standard OTEL_* variables ask for trace export
+func tracingConfigured() bool {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" ||
+		os.Getenv("OTEL_TRACES_EXPORTER") == "otlp"
+}
+
+// Function to install an OTLP tracer provider; returns a shutdown func that flushes pending spans
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !tracingConfigured() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.Environment())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Middleware to open a server span per request, named after the templated route
+func (s *server) tracingMiddleware(next http.Handler) http.Handler {
+	routed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.route", s.routeLabel(r)))
+		ctx := ensureTraceContext(r.Context())
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(w.Header()))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+	return otelhttp.NewHandler(routed, "receipt-processor",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + s.routeLabel(r)
+		}))
+}
+
+// Function to give a request its own span in the W3C trace even when no SDK is recording one.
+// An incoming traceparent keeps its trace ID; otherwise a new trace is started
+func ensureTraceContext(ctx context.Context) context.Context {
+	span := trace.SpanFromContext(ctx)
+	if span.IsRecording() {
+		return ctx
+	}
+	parent := span.SpanContext()
+	config := trace.SpanContextConfig{
+		TraceID:    parent.TraceID(),
+		TraceFlags: parent.TraceFlags(),
+		TraceState: parent.TraceState(),
+	}
+	if !parent.TraceID().IsValid() {
+		rand.Read(config.TraceID[:])
+		config.TraceFlags = trace.FlagsSampled
+	}
+	rand.Read(config.SpanID[:])
+	return trace.ContextWithSpanContext(ctx, trace.NewSpanContext(config))
+}
+
+// Function to get the W3C trace ID of the current request, empty outside one
+func traceIDFromContext(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.TraceID().IsValid() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// Log handler that stamps every record logged with a request context with its trace and span IDs
+type traceLogHandler struct {
+	slog.Handler
+}
+
+func (h traceLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		record.AddAttrs(slog.String("traceId", sc.TraceID().String()), slog.String("spanId", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h traceLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceLogHandler) WithGroup(name string) slog.Handler {
+	return traceLogHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"receipt-processor/internal/points"
+)
+
+func TestProcessReceiptSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	server := startServer(t, points.DefaultConfig())
+	id := processReceipt(t, server, targetReceipt)
+	provider.ForceFlush(t.Context())
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	root, ok := spans["POST /receipts/process"]
+	if !ok {
+		t.Fatalf("no server span among %v", spanNames(recorder.Ended()))
+	}
+	attributes := make(map[attribute.Key]attribute.Value)
+	for _, kv := range root.Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	tests := []struct {
+		key  attribute.Key
+		want string
+	}{
+		{"http.route", "/receipts/process"},
+		{"receipt.id", id},
+	}
+	for _, test := range tests {
+		if got := attributes[test.key].Emit(); got != test.want {
+			t.Errorf("server span %s = %q, want %q", test.key, got, test.want)
+		}
+	}
+	if got := attributes["http.status_code"].AsInt64(); got != http.StatusOK {
+		t.Errorf("server span status code = %d, want 200", got)
+	}
+
+	for _, name := range []string{"validateReceipt", "calculatePoints", "store.insert"} {
+		child, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span among %v", name, spanNames(recorder.Ended()))
+			continue
+		}
+		if child.Parent().SpanID() != root.SpanContext().SpanID() || child.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Errorf("%s span is not a child of the server span", name)
+		}
+	}
+}
+
+// Function to list span names for failure messages
+func spanNames(spans []sdktrace.ReadOnlySpan) []string {
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	return names
+}
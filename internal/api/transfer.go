@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"receipt-processor/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Handler to move points from one receipt to another in a single step
+func (s *server) transferPointsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	var request model.TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Amount <= 0 {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The transfer must name a target and a positive amount."))
+		return
+	}
+	if _, err := uuid.Parse(request.TargetID); err != nil || request.TargetID == id {
+		s.writeError(w, r, errorWithStatus(http.StatusBadRequest, "The transfer target must be another receipt."))
+		return
+	}
+
+	defer s.store.LockPair(id, request.TargetID)()
+	source, target := s.store.Shard(id), s.store.Shard(request.TargetID)
+	available, sourceExists := source.Points[id]
+	_, targetExists := target.Points[request.TargetID]
+	if !sourceExists || !targetExists {
+		s.writeError(w, r, ErrNotFound)
+		return
+	}
+	if s.rejectArchived(w, r, source, id) || s.rejectArchived(w, r, target, request.TargetID) {
+		return
+	}
+	if request.Amount > available {
+		s.writeError(w, r, errInsufficientPoints)
+		return
+	}
+
+	if err := s.store.Transfer(id, request.TargetID, request.Amount); err != nil {
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The transfer could not be stored."))
+		return
+	}
+	s.pointsCache.invalidate(id)
+	s.pointsCache.invalidate(request.TargetID)
+	actor := s.requestActor(r)
+	s.recordPointsMutation(id, model.MutationTransferOut, -request.Amount, actor)
+	s.recordPointsMutation(request.TargetID, model.MutationTransferIn, request.Amount, actor)
+	s.recordEvent(id, EventPointsTransferredOut, actor, map[string]any{"targetId": request.TargetID, "points": request.Amount})
+	s.recordEvent(request.TargetID, EventPointsTransferredIn, actor, map[string]any{"sourceId": id, "points": request.Amount})
+	s.writeJSON(w, r, http.StatusOK, model.TransferResponse{SourceRemaining: source.Points[id], TargetTotal: target.Points[request.TargetID]})
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Receipt submission limits for one API key; 0 leaves a window unlimited
+type KeyQuota struct {
+	Daily   int `yaml:"daily"`
+	Monthly int `yaml:"monthly"`
+}
+
+type WindowUsage struct {
+	Limit   int       `json:"limit"`
+	Used    int       `json:"used"`
+	ResetAt time.Time `json:"resetAt"`
+}
+
+type KeyUsage struct {
+	Key     string      `json:"key"`
+	Daily   WindowUsage `json:"daily"`
+	Monthly WindowUsage `json:"monthly"`
+}
+
+// Returned when a submission would take an API key past one of its quotas
+type keyQuotaError struct {
+	Message string    `json:"error"`
+	Window  string    `json:"window"`
+	Quota   int       `json:"quota"`
+	Usage   int       `json:"usage"`
+	ResetAt time.Time `json:"resetAt"`
+}
+
+func (e *keyQuotaError) Error() string {
+	return e.Message
+}
+
+// Function to parse key quotas given as comma separated name:daily:monthly entries
+func parseKeyQuotas(value string) (map[string]KeyQuota, error) {
+	quotas := make(map[string]KeyQuota)
+	for _, entry := range splitList(value) {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("must look like name:daily:monthly")
+		}
+		daily, err1 := strconv.Atoi(parts[1])
+		monthly, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("must look like name:daily:monthly")
+		}
+		quotas[parts[0]] = KeyQuota{Daily: daily, Monthly: monthly}
+	}
+	return quotas, nil
+}
+
+func dayWindow(now time.Time) (string, time.Time) {
+	now = now.UTC()
+	return now.Format("2006-01-02"), now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+func monthWindow(now time.Time) (string, time.Time) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return now.Format("2006-01"), start.AddDate(0, 1, 0)
+}
+
+// Function to explain which of the key's quotas one more submission would exceed, or nil when it fits;
+// callers hold quotaMutex
+func (s *server) checkKeyQuota(key string, now time.Time) *keyQuotaError {
+	quota, ok := s.apiKeyQuotas[key]
+	if key == "" || !ok {
+		return nil
+	}
+	day, dayReset := dayWindow(now)
+	month, monthReset := monthWindow(now)
+	usage := s.keyUsage[key]
+	if quota.Daily > 0 && usage[day] >= quota.Daily {
+		return &keyQuotaError{Message: "daily quota exceeded", Window: "daily", Quota: quota.Daily, Usage: usage[day], ResetAt: dayReset}
+	}
+	if quota.Monthly > 0 && usage[month] >= quota.Monthly {
+		return &keyQuotaError{Message: "monthly quota exceeded", Window: "monthly", Quota: quota.Monthly, Usage: usage[month], ResetAt: monthReset}
+	}
+	return nil
+}
+
+// Function to add n submissions, or take them back when n is negative, to the key's daily and monthly windows;
+// callers hold quotaMutex
+func (s *server) chargeKeyQuota(key string, now time.Time, n int) {
+	if _, ok := s.apiKeyQuotas[key]; key == "" || !ok {
+		return
+	}
+	day, _ := dayWindow(now)
+	month, _ := monthWindow(now)
+	usage := s.keyUsage[key]
+	if usage == nil {
+		usage = make(map[string]int)
+		s.keyUsage[key] = usage
+	}
+	usage[day] = max(0, usage[day]+n)
+	usage[month] = max(0, usage[month]+n)
+}
+
+// Function to add the per-day key usage a store rebuilt from its log to the daily and monthly windows
+func (s *server) restoreKeyUsage(days map[string]map[string]int) {
+	s.quotaMutex.Lock()
+	defer s.quotaMutex.Unlock()
+	for key, counts := range days {
+		usage := s.keyUsage[key]
+		if usage == nil {
+			usage = make(map[string]int)
+			s.keyUsage[key] = usage
+		}
+		for day, submissions := range counts {
+			at, err := time.Parse(time.DateOnly, day)
+			if err != nil {
+				continue
+			}
+			dayName, _ := dayWindow(at)
+			monthName, _ := monthWindow(at)
+			usage[dayName] += submissions
+			usage[monthName] += submissions
+		}
+	}
+	s.pruneKeyUsage(s.clock())
+}
+
+// Function to drop usage from windows that have closed; callers hold quotaMutex
+func (s *server) pruneKeyUsage(now time.Time) {
+	day, _ := dayWindow(now)
+	month, _ := monthWindow(now)
+	for key, windows := range s.keyUsage {
+		for window := range windows {
+			if window != day && window != month {
+				delete(windows, window)
+			}
+		}
+		if len(windows) == 0 {
+			delete(s.keyUsage, key)
+		}
+	}
+}
+
+// Function to report a key's consumption in its current windows; callers hold quotaMutex
+func (s *server) usageForKey(key string, now time.Time) KeyUsage {
+	day, dayReset := dayWindow(now)
+	month, monthReset := monthWindow(now)
+	quota := s.apiKeyQuotas[key]
+	return KeyUsage{
+		Key:     key,
+		Daily:   WindowUsage{Limit: quota.Daily, Used: s.keyUsage[key][day], ResetAt: dayReset},
+		Monthly: WindowUsage{Limit: quota.Monthly, Used: s.keyUsage[key][month], ResetAt: monthReset},
+	}
+}
+
+// Handler letting an API key inspect its own consumption
+func (s *server) usageHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.apiKeyIdentity(r)
+	if !ok {
+		s.writeError(w, r, errorWithStatus(http.StatusUnauthorized, "A valid API key is required."))
+		return
+	}
+
+	s.quotaMutex.Lock()
+	usage := s.usageForKey(key, s.clock())
+	s.quotaMutex.Unlock()
+	s.writeJSON(w, r, http.StatusOK, usage)
+}
+
+// Handler listing the consumption of every API key
+func (s *server) adminUsageHandler(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(s.apiKeys))
+	for name := range s.apiKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := s.clock()
+	s.quotaMutex.Lock()
+	usage := make([]KeyUsage, 0, len(names))
+	for _, name := range names {
+		usage = append(usage, s.usageForKey(name, now))
+	}
+	s.quotaMutex.Unlock()
+	s.writeJSON(w, r, http.StatusOK, usage)
+}
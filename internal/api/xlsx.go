@@ -0,0 +1,405 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"receipt-processor/internal/model"
+)
+
+const mediaXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// Cell styles, indexes into cellXfs in xlsxStyles
+const (
+	xlsxStyleGeneral = iota
+	xlsxStyleDate
+	xlsxStyleTime
+	xlsxStyleDateTime
+	xlsxStyleAmount
+	xlsxStyleHeader
+)
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="3"><numFmt numFmtId="164" formatCode="yyyy-mm-dd"/><numFmt numFmtId="165" formatCode="hh:mm"/><numFmt numFmtId="166" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="6">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="166" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="2" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+</cellXfs>
+<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>
+</styleSheet>
+`
+
+// Day zero of spreadsheet date serials; it absorbs the 1900 leap year bug for every date after February 1900
+var xlsxEpoch = time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+
+// One cell: text, a number shown in one of the styles, or a boolean. Amounts are numbers kept as their text
+type xlsxCell struct {
+	kind   string
+	text   string
+	number float64
+	style  int
+}
+
+func xlsxText(text string) xlsxCell {
+	return xlsxCell{kind: "inlineStr", text: text}
+}
+
+func xlsxHeader(text string) xlsxCell {
+	return xlsxCell{kind: "inlineStr", text: text, style: xlsxStyleHeader}
+}
+
+func xlsxNumber(number float64, style int) xlsxCell {
+	return xlsxCell{kind: "n", number: number, style: style}
+}
+
+func xlsxBool(value bool) xlsxCell {
+	if value {
+		return xlsxCell{kind: "b", number: 1}
+	}
+	return xlsxCell{kind: "b"}
+}
+
+// Function to turn a time into a date serial: whole days since the epoch plus the fraction of the day
+func xlsxSerial(t time.Time) float64 {
+	return t.Sub(xlsxEpoch).Seconds() / (24 * 60 * 60)
+}
+
+// Function to store a dollar amount as a number, keeping the text when it does not parse
+func xlsxAmount(amount string) xlsxCell {
+	if value, err := model.ParseMoney(amount); err == nil {
+		return xlsxMoney(value)
+	}
+	return xlsxText(amount)
+}
+
+// Function to store an amount as a number written out in its two-decimal form, never through a float
+func xlsxMoney(amount model.Money) xlsxCell {
+	return xlsxCell{kind: "n", text: amount.String(), style: xlsxStyleAmount}
+}
+
+// Function to store a value laid out as layout as a date or time number, keeping the text when it does not parse
+func xlsxTimeCell(value, layout string, style int) xlsxCell {
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return xlsxText(value)
+	}
+	if style == xlsxStyleTime {
+		return xlsxNumber(float64(t.Hour()*60+t.Minute())/(24*60), style)
+	}
+	return xlsxNumber(xlsxSerial(t), style)
+}
+
+// Function to name a column the way spreadsheets do: A to Z, then AA
+func xlsxColumn(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+// Rows of one sheet, written out as they are added; the first write error is kept and later rows dropped
+type xlsxRows struct {
+	w    *bufio.Writer
+	next int
+	err  error
+}
+
+func newXLSXRows(w io.Writer) *xlsxRows {
+	return &xlsxRows{w: bufio.NewWriter(w), next: 1}
+}
+
+func (rows *xlsxRows) add(cells ...xlsxCell) {
+	if rows.err != nil {
+		return
+	}
+	fmt.Fprintf(rows.w, `<row r="%d">`, rows.next)
+	for i, cell := range cells {
+		fmt.Fprintf(rows.w, `<c r="%s%d" t="%s"`, xlsxColumn(i), rows.next, cell.kind)
+		if cell.style != xlsxStyleGeneral {
+			fmt.Fprintf(rows.w, ` s="%d"`, cell.style)
+		}
+		if cell.kind == "inlineStr" {
+			rows.w.WriteString(`><is><t xml:space="preserve">`)
+			xml.EscapeText(rows.w, []byte(cell.text))
+			rows.w.WriteString(`</t></is></c>`)
+		} else if cell.text != "" {
+			fmt.Fprintf(rows.w, `><v>%s</v></c>`, cell.text)
+		} else {
+			fmt.Fprintf(rows.w, `><v>%s</v></c>`, strconv.FormatFloat(cell.number, 'f', -1, 64))
+		}
+	}
+	_, rows.err = rows.w.WriteString(`</row>`)
+	rows.next++
+}
+
+func (rows *xlsxRows) flush() error {
+	if rows.err != nil {
+		return rows.err
+	}
+	return rows.w.Flush()
+}
+
+// Workbook streamed straight into a zip archive, one sheet after another
+type xlsxWorkbook struct {
+	zip    *zip.Writer
+	sheets []string
+}
+
+func newXLSXWorkbook(w io.Writer) *xlsxWorkbook {
+	return &xlsxWorkbook{zip: zip.NewWriter(w)}
+}
+
+// Function to start the next sheet; rows added to the result go into it until endSheet
+func (b *xlsxWorkbook) startSheet(name string) (*xlsxRows, error) {
+	b.sheets = append(b.sheets, name)
+	entry, err := b.zip.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(b.sheets)))
+	if err != nil {
+		return nil, err
+	}
+	rows := newXLSXRows(entry)
+	rows.w.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return rows, nil
+}
+
+func (b *xlsxWorkbook) endSheet(rows *xlsxRows) error {
+	rows.w.WriteString(`</sheetData></worksheet>`)
+	return rows.flush()
+}
+
+// Function to write the parts naming the sheets, then finish the archive
+func (b *xlsxWorkbook) Close() error {
+	var contentTypes, workbook, relationships strings.Builder
+	contentTypes.WriteString(xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	workbook.WriteString(xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	relationships.WriteString(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i, name := range b.sheets {
+		n := i + 1
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" `+
+			`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		escaped := new(strings.Builder)
+		xml.EscapeText(escaped, []byte(name))
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escaped, n, n)
+		fmt.Fprintf(&relationships, `<Relationship Id="rId%d" `+
+			`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(&relationships, `<Relationship Id="rId%d" `+
+		`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`,
+		len(b.sheets)+1)
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", contentTypes.String()},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" ` +
+			`Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", relationships.String()},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, part := range parts {
+		entry, err := b.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(entry, part.body); err != nil {
+			return err
+		}
+	}
+	return b.zip.Close()
+}
+
+// A receipt as exported to a workbook
+type exportedReceipt struct {
+	ID        string
+	Receipt   model.Receipt
+	Points    int
+	Archived  bool
+	CreatedAt time.Time
+}
+
+// Function to visit every stored receipt a shard at a time, so only one shard's receipts are ever copied out.
+// Receipts stored or deleted while the export runs may or may not be included
+func (s *server) exportedReceipts(pinned *bool) iter.Seq[exportedReceipt] {
+	return func(yield func(exportedReceipt) bool) {
+		for _, shard := range s.store.Shards() {
+			var batch []exportedReceipt
+			shard.RLock()
+			for _, receipts := range []map[string]model.Receipt{shard.Receipts, shard.Archived} {
+				for id, receipt := range receipts {
+					if pinned != nil && shard.Pinned[id] != *pinned {
+						continue
+					}
+					_, archived := shard.Archived[id]
+					batch = append(batch, exportedReceipt{ID: id, Receipt: receipt, Points: shard.Points[id],
+						Archived: archived, CreatedAt: shard.StoredAt[id]})
+				}
+			}
+			shard.RUnlock()
+			slices.SortFunc(batch, func(a, b exportedReceipt) int { return strings.Compare(a.ID, b.ID) })
+			for _, receipt := range batch {
+				if !yield(receipt) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Function to write the Receipts, Items and Summary sheets in one pass over the receipts. Items rows are spooled
+// to a temporary file while the Receipts sheet is written, so memory stays flat however many receipts there are
+func (s *server) writeReceiptsWorkbook(w io.Writer, receipts iter.Seq[exportedReceipt]) error {
+	spool, err := os.CreateTemp("", "receipt-items-*.xml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	workbook := newXLSXWorkbook(w)
+	receiptRows, err := workbook.startSheet("Receipts")
+	if err != nil {
+		return err
+	}
+	receiptRows.add(xlsxHeader("id"), xlsxHeader("retailer"), xlsxHeader("purchaseDate"), xlsxHeader("purchaseTime"),
+		xlsxHeader("total"), xlsxHeader("itemCount"), xlsxHeader("points"), xlsxHeader("archived"), xlsxHeader("createdAt"))
+	itemRows := newXLSXRows(spool)
+	itemRows.add(xlsxHeader("receiptId"), xlsxHeader("line"), xlsxHeader("shortDescription"), xlsxHeader("price"))
+
+	var count, archived, items, points int
+	var spent model.Money
+	spentOverflow := false
+	var firstPurchase, lastPurchase string
+	retailers := make(map[string]bool)
+	for stored := range receipts {
+		receipt := stored.Receipt
+		createdAt := xlsxText("")
+		if !stored.CreatedAt.IsZero() {
+			createdAt = xlsxNumber(xlsxSerial(stored.CreatedAt.UTC()), xlsxStyleDateTime)
+		}
+		receiptRows.add(xlsxText(stored.ID), xlsxText(receipt.Retailer),
+			xlsxTimeCell(receipt.PurchaseDate, "2006-01-02", xlsxStyleDate), xlsxTimeCell(receipt.PurchaseTime, "15:04", xlsxStyleTime),
+			xlsxAmount(receipt.Total), xlsxNumber(float64(len(receipt.Items)), xlsxStyleGeneral),
+			xlsxNumber(float64(stored.Points), xlsxStyleGeneral), xlsxBool(stored.Archived), createdAt)
+		for i, item := range receipt.Items {
+			itemRows.add(xlsxText(stored.ID), xlsxNumber(float64(i+1), xlsxStyleGeneral), xlsxText(item.ShortDescription),
+				xlsxAmount(item.Price))
+		}
+
+		count++
+		items += len(receipt.Items)
+		points += stored.Points
+		if stored.Archived {
+			archived++
+		}
+		if total, err := model.ParseMoney(receipt.Total); err == nil && !spentOverflow {
+			spent, err = spent.Add(total)
+			spentOverflow = err != nil
+		}
+		if firstPurchase == "" || receipt.PurchaseDate < firstPurchase {
+			firstPurchase = receipt.PurchaseDate
+		}
+		lastPurchase = max(lastPurchase, receipt.PurchaseDate)
+		retailers[receipt.Retailer] = true
+	}
+	if err := workbook.endSheet(receiptRows); err != nil {
+		return err
+	}
+
+	if err := itemRows.flush(); err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	itemSheet, err := workbook.startSheet("Items")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(itemSheet.w, spool); err != nil {
+		return err
+	}
+	if err := workbook.endSheet(itemSheet); err != nil {
+		return err
+	}
+
+	summary, err := workbook.startSheet("Summary")
+	if err != nil {
+		return err
+	}
+	summary.add(xlsxHeader("metric"), xlsxHeader("value"))
+	summary.add(xlsxText("receipts"), xlsxNumber(float64(count), xlsxStyleGeneral))
+	summary.add(xlsxText("archivedReceipts"), xlsxNumber(float64(archived), xlsxStyleGeneral))
+	summary.add(xlsxText("items"), xlsxNumber(float64(items), xlsxStyleGeneral))
+	summary.add(xlsxText("retailers"), xlsxNumber(float64(len(retailers)), xlsxStyleGeneral))
+	summary.add(xlsxText("points"), xlsxNumber(float64(points), xlsxStyleGeneral))
+	if spentOverflow {
+		summary.add(xlsxText("totalSpent"), xlsxText("out of range"))
+	} else {
+		summary.add(xlsxText("totalSpent"), xlsxMoney(spent))
+	}
+	if count > 0 {
+		summary.add(xlsxText("averagePoints"), xlsxNumber(float64(points)/float64(count), xlsxStyleAmount))
+		summary.add(xlsxText("firstPurchaseDate"), xlsxTimeCell(firstPurchase, "2006-01-02", xlsxStyleDate))
+		summary.add(xlsxText("lastPurchaseDate"), xlsxTimeCell(lastPurchase, "2006-01-02", xlsxStyleDate))
+	}
+	summary.add(xlsxText("exportedAt"), xlsxNumber(xlsxSerial(s.clock().UTC()), xlsxStyleDateTime))
+	if err := workbook.endSheet(summary); err != nil {
+		return err
+	}
+	return workbook.Close()
+}
+
+// Function to send a workbook as a download. Once the archive has started there is no status left to change,
+// so a failure part way is only logged and the client gets a truncated file
+func (s *server) writeXLSXDownload(w http.ResponseWriter, r *http.Request, filename string, receipts iter.Seq[exportedReceipt]) {
+	w.Header().Set("Content-Type", mediaXLSX)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if err := s.writeReceiptsWorkbook(w, receipts); err != nil {
+		s.logger.ErrorContext(r.Context(), "writing xlsx export failed", "path", r.URL.Path, "error", err)
+	}
+}
+
+// Handler to export every stored receipt as an xlsx workbook, only the pinned ones with ?pinned=true, or, with
+// ?format=archive, a restorable zip dump of them all
+func (s *server) exportReceiptsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.rejectStoreLight(w, r) {
+		return
+	}
+	switch s.chooseFormat(w, r, []string{mediaXLSX, mediaZip}) {
+	case mediaXLSX:
+		pinned, err := pinnedFilter(r)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		s.writeXLSXDownload(w, r, "receipts-"+s.clock().UTC().Format("20060102")+".xlsx", s.exportedReceipts(pinned))
+	case mediaZip:
+		s.writeDumpDownload(w, r)
+	}
+}
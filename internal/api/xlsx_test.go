@@ -0,0 +1,128 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"receipt-processor/internal/model"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files from the current output")
+
+// One worksheet as read back: every cell with its type, style and value
+type parsedSheet struct {
+	Rows []struct {
+		Ref   string `xml:"r,attr"`
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Style  string `xml:"s,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// Function to read a zip entry, failing the test when it is missing
+func readZipEntry(t *testing.T, archive *zip.Reader, name string) []byte {
+	t.Helper()
+	entry, err := archive.Open(name)
+	if err != nil {
+		t.Fatalf("workbook has no %s: %v", name, err)
+	}
+	defer entry.Close()
+	body, err := io.ReadAll(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// Function to re-parse a workbook and write each sheet out a cell per line, in the order the workbook names them
+func dumpWorkbook(t *testing.T, workbook []byte) string {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(workbook), int64(len(workbook)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"[Content_Types].xml", "_rels/.rels", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		if body := readZipEntry(t, archive, part); xml.Unmarshal(body, new(struct{})) != nil {
+			t.Errorf("%s is not well-formed XML", part)
+		}
+	}
+	var book struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal(readZipEntry(t, archive, "xl/workbook.xml"), &book); err != nil {
+		t.Fatal(err)
+	}
+
+	var dump strings.Builder
+	for i, sheet := range book.Sheets {
+		part := fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)
+		if !bytes.Contains(readZipEntry(t, archive, "[Content_Types].xml"), []byte("/"+part)) {
+			t.Errorf("[Content_Types].xml does not declare %s", part)
+		}
+		var parsed parsedSheet
+		if err := xml.Unmarshal(readZipEntry(t, archive, part), &parsed); err != nil {
+			t.Fatalf("%s: %v", part, err)
+		}
+		fmt.Fprintf(&dump, "== %s\n", sheet.Name)
+		for _, row := range parsed.Rows {
+			for _, cell := range row.Cells {
+				value := cell.Value
+				if cell.Type == "inlineStr" {
+					value = fmt.Sprintf("%q", cell.Inline)
+				}
+				fmt.Fprintf(&dump, "%s %s s=%s %s\n", cell.Ref, cell.Type, cmp.Or(cell.Style, "0"), value)
+			}
+		}
+	}
+	return dump.String()
+}
+
+func TestReceiptsWorkbookGolden(t *testing.T) {
+	exportedAt := time.Date(2022, 3, 21, 9, 30, 0, 0, time.UTC)
+	s := newTestServer(t, WithClock(func() time.Time { return exportedAt }))
+	receipts := []exportedReceipt{
+		{ID: "0b7c7bc2-7a4e-4c44-9d1c-6b2f4d6c0a01", Receipt: decodeReceipt(t, targetReceipt), Points: 28,
+			CreatedAt: time.Date(2022, 1, 1, 13, 5, 0, 0, time.UTC)},
+		{ID: "0b7c7bc2-7a4e-4c44-9d1c-6b2f4d6c0a02", Receipt: decodeReceipt(t, cornerMarketReceipt), Points: 109, Archived: true,
+			CreatedAt: time.Date(2022, 3, 20, 14, 40, 0, 0, time.UTC)},
+		// Amounts and times that do not parse stay text, and a receipt with no creation time leaves the cell empty
+		{ID: "0b7c7bc2-7a4e-4c44-9d1c-6b2f4d6c0a03", Receipt: model.Receipt{Retailer: "Tom & Jerry's", PurchaseDate: "2022-13-01",
+			PurchaseTime: "noon", Total: "n/a", Items: []model.Item{{ShortDescription: "<Gum>", Price: "1.2"}}}},
+	}
+	var workbook bytes.Buffer
+	if err := s.writeReceiptsWorkbook(&workbook, slices.Values(receipts)); err != nil {
+		t.Fatal(err)
+	}
+	got := dumpWorkbook(t, workbook.Bytes())
+
+	golden := filepath.Join("testdata", "receipts.xlsx.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("workbook read back as\n%s\nwant\n%s", got, want)
+	}
+}
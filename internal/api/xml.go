@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"receipt-processor/internal/model"
+)
+
+// Largest XML request body decoded
+const maxXMLBodyBytes = 1 << 20
+
+// XML wire form of a receipt; the element names are the JSON field names
+type xmlReceipt struct {
+	XMLName      xml.Name  `xml:"receipt"`
+	Retailer     string    `xml:"retailer"`
+	PurchaseDate string    `xml:"purchaseDate"`
+	PurchaseTime string    `xml:"purchaseTime"`
+	Total        string    `xml:"total"`
+	Items        []xmlItem `xml:"items>item"`
+}
+
+type xmlItem struct {
+	ShortDescription string `xml:"shortDescription"`
+	Price            string `xml:"price"`
+}
+
+type xmlIDResponse struct {
+	XMLName   xml.Name `xml:"response"`
+	ID        string   `xml:"id"`
+	Duplicate bool     `xml:"duplicate,omitempty"`
+}
+
+type xmlPointsResponse struct {
+	XMLName xml.Name `xml:"response"`
+	Points  int      `xml:"points"`
+}
+
+// Element each validation reason points at, so XML clients can find the offending field
+var xmlValidationElements = map[string]string{
+	"missing_field":      "receipt",
+	"retailer":           "receipt/retailer",
+	"purchase_date":      "receipt/purchaseDate",
+	"purchase_time":      "receipt/purchaseTime",
+	"total":              "receipt/total",
+	"item_missing_field": "receipt/items/item",
+	"item_description":   "receipt/items/item/shortDescription",
+	"item_price":         "receipt/items/item/price",
+	"normalizer":         "receipt",
+
+	"retailer_length":         "receipt/retailer",
+	"purchase_date_length":    "receipt/purchaseDate",
+	"purchase_time_length":    "receipt/purchaseTime",
+	"total_length":            "receipt/total",
+	"item_description_length": "receipt/items/item/shortDescription",
+	"item_price_length":       "receipt/items/item/price",
+	"too_many_items":          "receipt/items",
+	"too_few_items":           "receipt/items",
+}
+
+// Function to decode an XML receipt body into the core receipt
+func decodeXMLReceipt(w http.ResponseWriter, r *http.Request) (model.Receipt, error) {
+	var wire xmlReceipt
+	if err := xml.NewDecoder(http.MaxBytesReader(w, r.Body, maxXMLBodyBytes)).Decode(&wire); err != nil {
+		return model.Receipt{}, err
+	}
+	receipt := model.Receipt{
+		Retailer:     wire.Retailer,
+		PurchaseDate: wire.PurchaseDate,
+		PurchaseTime: wire.PurchaseTime,
+		Total:        wire.Total,
+	}
+	for _, item := range wire.Items {
+		receipt.Items = append(receipt.Items, model.Item{ShortDescription: item.ShortDescription, Price: item.Price})
+	}
+	return receipt, nil
+}
+
+// Function to describe a validation failure to an XML client by element
+func xmlValidationMessage(reason string) string {
+	return "The receipt is invalid: check <" + xmlValidationElements[reason] + ">."
+}
+
+// Function to write an XML response for clients that asked for application/xml
+func (s *server) writeXML(w http.ResponseWriter, r *http.Request, status int, data any) {
+	body, err := xml.Marshal(data)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "encoding XML response", "path", r.URL.Path, "error", err)
+		s.writeError(w, r, errorWithStatus(http.StatusInternalServerError, "The response could not be encoded."))
+		return
+	}
+	w.Header().Set("Content-Type", mediaXML)
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
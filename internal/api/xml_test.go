@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"receipt-processor/internal/model"
+	"receipt-processor/internal/points"
+)
+
+// Function to write a receipt in its XML wire form
+func encodeXMLReceipt(t *testing.T, receipt model.Receipt) string {
+	t.Helper()
+	wire := xmlReceipt{Retailer: receipt.Retailer, PurchaseDate: receipt.PurchaseDate, PurchaseTime: receipt.PurchaseTime, Total: receipt.Total}
+	for _, item := range receipt.Items {
+		wire.Items = append(wire.Items, xmlItem{ShortDescription: item.ShortDescription, Price: item.Price})
+	}
+	body, err := xml.Marshal(wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return xml.Header + string(body)
+}
+
+// Each example receipt survives XML unchanged, is stored as its JSON submission is, and scores the same
+func TestXMLRoundTrip(t *testing.T) {
+	config := defaultConfig()
+	config.XMLAPI = true
+	server := startServer(t, points.DefaultConfig(), WithConfig(config))
+	for _, body := range []string{targetReceipt, cornerMarketReceipt, morningReceipt} {
+		receipt := decodeReceipt(t, body)
+		t.Run(receipt.Retailer, func(t *testing.T) {
+			encoded := encodeXMLReceipt(t, receipt)
+			request := httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(encoded))
+			decoded, err := decodeXMLReceipt(httptest.NewRecorder(), request)
+			if err != nil || !reflect.DeepEqual(decoded, receipt) {
+				t.Fatalf("decoded XML = %+v, %v, want %+v", decoded, err, receipt)
+			}
+
+			response, answer := send(t, server, http.MethodPost, "/receipts/process", encoded,
+				"Content-Type", mediaXML, "Accept", mediaXML)
+			var processed xmlIDResponse
+			if err := xml.Unmarshal([]byte(answer), &processed); response.StatusCode != http.StatusOK || err != nil || processed.ID == "" {
+				t.Fatalf("XML submission = %d %s, %v", response.StatusCode, answer, err)
+			}
+			jsonID := processReceipt(t, server, body)
+
+			_, stored := send(t, server, http.MethodGet, "/receipts/"+processed.ID, "")
+			_, want := send(t, server, http.MethodGet, "/receipts/"+jsonID, "")
+			if stored != want {
+				t.Errorf("receipt stored from XML\n%s\nwant it stored as from JSON\n%s", stored, want)
+			}
+
+			response, answer = send(t, server, http.MethodGet, "/receipts/"+processed.ID+"/points", "", "Accept", mediaXML)
+			var awarded xmlPointsResponse
+			if err := xml.Unmarshal([]byte(answer), &awarded); response.StatusCode != http.StatusOK || err != nil ||
+				awarded.Points != receiptPoints(t, server, jsonID) {
+				t.Errorf("points read as XML = %d %s, %v, want %d", response.StatusCode, answer, err, receiptPoints(t, server, jsonID))
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+// Package backoff spaces out retries with exponential backoff and full jitter, for the API client and the calls
+// the server makes to other services.
+package backoff
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// Function to wait before retry attempt n: a random delay of up to base doubled n times, cut short when ctx is done
+func Wait(ctx context.Context, base time.Duration, attempt int) error {
+	delay := time.Duration(rand.Int64N(int64(base<<attempt) + 1))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
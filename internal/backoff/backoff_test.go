@@ -0,0 +1,19 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitStopsWhenTheContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Wait(ctx, time.Hour, 3); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait with a canceled context = %v, want context.Canceled", err)
+	}
+	if err := Wait(context.Background(), 0, 3); err != nil {
+		t.Errorf("Wait with no base delay = %v, want nil", err)
+	}
+}
@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// Kinds of change to a receipt's points value
+const (
+	MutationCalculated   = "calculated"
+	MutationRecalculated = "recalculated"
+	MutationReserved     = "reserved"
+	MutationReleased     = "released"
+	MutationRedeemed     = "redeemed"
+	MutationTransferOut  = "transfer_out"
+	MutationTransferIn   = "transfer_in"
+)
+
+// One change to a receipt's points; the deltas of a receipt's history, summed from 0, give its current points
+type PointsMutation struct {
+	Type      string    `json:"type"`
+	Delta     int       `json:"delta"`
+	NewValue  int       `json:"newValue"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+}
+
+// One entry on a receipt's timeline
+type Event struct {
+	Type      string         `json:"type"`
+	Timestamp time.Time      `json:"timestamp"`
+	Actor     string         `json:"actor"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+type Note struct {
+	ID        string    `json:"id"`
+	Text      string    `json:"text"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"createdAt"`
+}
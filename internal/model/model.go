@@ -0,0 +1,69 @@
+// Package model holds the receipt and the bodies the API answers with, as they go over the wire.
+package model
+
+import "time"
+
+type Receipt struct {
+	Retailer     string `json:"retailer"`
+	PurchaseDate string `json:"purchaseDate"`
+	PurchaseTime string `json:"purchaseTime"`
+	Items        []Item `json:"items"`
+	Total        string `json:"total"`
+}
+
+type Item struct {
+	ShortDescription string `json:"shortDescription"`
+	Price            string `json:"price"`
+}
+
+type ResponseID struct {
+	ID string `json:"id"`
+	// Set for multipart submissions: whether the image was kept, and why not when it was not
+	ImageStored bool   `json:"imageStored,omitempty"`
+	ImageError  string `json:"imageError,omitempty"`
+}
+
+type ResponsePoints struct {
+	Points int `json:"points"`
+}
+
+type BatchPointsRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// Whether a receipt is pinned against deletion
+type ResponsePinned struct {
+	ID     string `json:"id"`
+	Pinned bool   `json:"pinned"`
+}
+
+type ResponseCount struct {
+	Count int `json:"count"`
+}
+
+type ReserveRequest struct {
+	Points int `json:"points"`
+}
+
+// Points held against a receipt until they are committed, rolled back or expire
+type Reservation struct {
+	ID        string    `json:"reservationId"`
+	ReceiptID string    `json:"receiptId"`
+	Points    int       `json:"points"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type TransferRequest struct {
+	TargetID string `json:"targetId"`
+	Amount   int    `json:"amount"`
+}
+
+type TransferResponse struct {
+	SourceRemaining int `json:"sourceRemaining"`
+	TargetTotal     int `json:"targetTotal"`
+}
+
+type NoteRequest struct {
+	Text   string `json:"text"`
+	Author string `json:"author"`
+}
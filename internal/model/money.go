@@ -0,0 +1,182 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// An amount of money in whole cents. Receipts carry amounts as strings with two decimals, such as "6.49", which
+// ParseMoney reads; in JSON it is a number, 6.49, read from its digits so amounts never pass through a float
+type Money int64
+
+// A multiplier as a whole percentage, so 20 multiplies by 0.2. In JSON it is the multiplier itself, 0.2, read from
+// its digits like Money
+type Percent int64
+
+// How a result that falls between two whole values is rounded
+type RoundingMode int
+
+const (
+	RoundCeil RoundingMode = iota
+	RoundFloor
+	// Halves round away from zero
+	RoundHalfUp
+)
+
+var (
+	ErrInvalidMoney   = errors.New("amounts must be digits, a point and two more digits, like 6.49")
+	ErrMoneyOverflow  = errors.New("amount out of range")
+	ErrFractionalCent = errors.New("amounts must be numbers of whole cents, like 6.49")
+	ErrInvalidPercent = errors.New("multipliers must be numbers of whole percentages, like 0.2")
+)
+
+// Function to parse an amount in the canonical form the API accepts: one or more digits, a point, two digits
+func ParseMoney(s string) (Money, error) {
+	point := len(s) - 3
+	if point < 1 || s[point] != '.' {
+		return 0, ErrInvalidMoney
+	}
+	var cents int64
+	for i := 0; i < len(s); i++ {
+		if i == point {
+			continue
+		}
+		digit := s[i] - '0'
+		if digit > 9 {
+			return 0, ErrInvalidMoney
+		}
+		if cents > (math.MaxInt64-int64(digit))/10 {
+			return 0, ErrMoneyOverflow
+		}
+		cents = cents*10 + int64(digit)
+	}
+	return Money(cents), nil
+}
+
+// Function to render the amount with two decimals, e.g. "6.49" or "-0.05"
+func (m Money) String() string {
+	sign, cents := "", uint64(m)
+	if m < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	hundredths, err := parseHundredths(data)
+	if errors.Is(err, errNotHundredths) {
+		return ErrFractionalCent
+	}
+	if err != nil {
+		return err
+	}
+	*m = Money(hundredths)
+	return nil
+}
+
+// Function to render the multiplier the percentage stands for, e.g. "0.2" or "1.25"
+func (p Percent) String() string {
+	return strings.TrimSuffix(strings.TrimRight(Money(p).String(), "0"), ".")
+}
+
+func (p Percent) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Percent) UnmarshalJSON(data []byte) error {
+	hundredths, err := parseHundredths(data)
+	if errors.Is(err, errNotHundredths) {
+		return ErrInvalidPercent
+	}
+	if err != nil {
+		return err
+	}
+	*p = Percent(hundredths)
+	return nil
+}
+
+var errNotHundredths = errors.New("not a whole number of hundredths")
+
+// Function to read a JSON number exactly and scale it by 100, failing when that leaves a fraction
+func parseHundredths(data []byte) (int64, error) {
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil || len(data) == 0 || data[0] == '"' {
+		return 0, errNotHundredths
+	}
+	value, ok := new(big.Rat).SetString(number.String())
+	if !ok {
+		return 0, errNotHundredths
+	}
+	value.Mul(value, big.NewRat(100, 1))
+	if !value.IsInt() {
+		return 0, errNotHundredths
+	}
+	if !value.Num().IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+	return value.Num().Int64(), nil
+}
+
+// Function to add two amounts, failing rather than wrapping around when the sum does not fit
+func (m Money) Add(other Money) (Money, error) {
+	sum := m + other
+	if (other > 0 && sum < m) || (other < 0 && sum > m) {
+		return 0, ErrMoneyOverflow
+	}
+	return sum, nil
+}
+
+// Function to take a whole percentage of the amount, rounding the fraction of a cent as mode says
+func (m Money) MulPercent(percent Percent, mode RoundingMode) (Money, error) {
+	if m == 0 || percent == 0 {
+		return 0, nil
+	}
+	product := int64(m) * int64(percent)
+	if product/int64(percent) != int64(m) || (m == math.MinInt64 && percent == -1) {
+		return 0, ErrMoneyOverflow
+	}
+	return Money(divide(product, 100, mode)), nil
+}
+
+// Function to get the amount in whole currency units, rounding any cents as mode says
+func (m Money) Units(mode RoundingMode) int64 {
+	return divide(int64(m), 100, mode)
+}
+
+// Function to report whether the amount is a whole multiple of step, e.g. of 0.25
+func (m Money) MultipleOf(step Money) bool {
+	return step != 0 && m%step == 0
+}
+
+// Function to divide by a positive divisor, rounding the remainder as mode says
+func divide(n, d int64, mode RoundingMode) int64 {
+	quotient, remainder := n/d, n%d
+	if remainder == 0 {
+		return quotient
+	}
+	switch mode {
+	case RoundCeil:
+		if remainder > 0 {
+			quotient++
+		}
+	case RoundFloor:
+		if remainder < 0 {
+			quotient--
+		}
+	case RoundHalfUp:
+		if remainder >= d-remainder && remainder > 0 {
+			quotient++
+		} else if -remainder >= d+remainder && remainder < 0 {
+			quotient--
+		}
+	}
+	return quotient
+}
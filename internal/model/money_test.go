@@ -0,0 +1,189 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		err  error
+	}{
+		{"6.49", 649, nil},
+		{"0.00", 0, nil},
+		{"1000.01", 100001, nil},
+		{"6.4", 0, ErrInvalidMoney},
+		{"6", 0, ErrInvalidMoney},
+		{".49", 0, ErrInvalidMoney},
+		{"-6.49", 0, ErrInvalidMoney},
+		{"6,49", 0, ErrInvalidMoney},
+		{"92233720368547758.08", 0, ErrMoneyOverflow},
+	}
+	for _, test := range tests {
+		got, err := ParseMoney(test.in)
+		if got != test.want || !errors.Is(err, test.err) {
+			t.Errorf("ParseMoney(%q) = %d, %v, want %d, %v", test.in, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Money
+		want Money
+	}{
+		{"20% of 12.25 rounded up", must(Money(1225).MulPercent(20, RoundCeil)), 245},
+		{"20% of 1.26 rounded up", must(Money(126).MulPercent(20, RoundCeil)), 26},
+		{"20% of 1.26 rounded down", must(Money(126).MulPercent(20, RoundFloor)), 25},
+		{"50% of 0.05 rounded half up", must(Money(5).MulPercent(50, RoundHalfUp)), 3},
+		{"50% of -0.05 rounded half up", must(Money(-5).MulPercent(50, RoundHalfUp)), -3},
+		{"sum", must(Money(649).Add(1225)), 1874},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s = %d, want %d", test.name, test.got, test.want)
+		}
+	}
+	if _, err := Money(1 << 62).Add(1 << 62); !errors.Is(err, ErrMoneyOverflow) {
+		t.Errorf("overflowing Add error = %v, want ErrMoneyOverflow", err)
+	}
+	if got := Money(245).Units(RoundCeil); got != 3 {
+		t.Errorf("2.45 in whole units rounded up = %d, want 3", got)
+	}
+	if !Money(900).MultipleOf(25) || Money(935).MultipleOf(100) {
+		t.Error("MultipleOf disagrees with 9.00 being a multiple of 0.25 and 9.35 not of 1.00")
+	}
+}
+
+func must(m Money, err error) Money {
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		out  string
+		err  error
+	}{
+		{"1.50", 150, "1.50", nil},
+		{"1.5", 150, "1.50", nil},
+		{"0", 0, "0.00", nil},
+		{"2e1", 2000, "20.00", nil},
+		{"-0.05", -5, "-0.05", nil},
+		{"1.505", 0, "", ErrFractionalCent},
+		{`"1.50"`, 0, "", ErrFractionalCent},
+		{"1e18", 0, "", ErrMoneyOverflow},
+	}
+	for _, test := range tests {
+		var got Money
+		err := json.Unmarshal([]byte(test.in), &got)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Errorf("unmarshalling %s = %d, %v, want %d, %v", test.in, got, err, test.want, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if out, _ := json.Marshal(got); string(out) != test.out {
+			t.Errorf("marshalling %d = %s, want %s", got, out, test.out)
+		}
+	}
+}
+
+func TestPercentJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Percent
+		out  string
+		err  error
+	}{
+		{"0.2", 20, "0.2", nil},
+		{"0.25", 25, "0.25", nil},
+		{"1", 100, "1", nil},
+		{"10", 1000, "10", nil},
+		{"0", 0, "0", nil},
+		{"0.205", 0, "", ErrInvalidPercent},
+		{`"0.2"`, 0, "", ErrInvalidPercent},
+	}
+	for _, test := range tests {
+		var got Percent
+		err := json.Unmarshal([]byte(test.in), &got)
+		if !errors.Is(err, test.err) || got != test.want {
+			t.Errorf("unmarshalling %s = %d, %v, want %d, %v", test.in, got, err, test.want, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if out, _ := json.Marshal(got); string(out) != test.out {
+			t.Errorf("marshalling %d = %s, want %s", got, out, test.out)
+		}
+	}
+}
+
+// Amounts at the edges of the range, which random values seldom reach
+var edgeAmounts = []Money{0, 1, 99, 100, math.MaxInt64, -1, -99, -100, math.MinInt64 + 1, math.MinInt64}
+
+func TestMoneyFormatParsesBack(t *testing.T) {
+	parsesBack := func(m Money) bool {
+		var decoded Money
+		if err := json.Unmarshal([]byte(m.String()), &decoded); err != nil || decoded != m {
+			t.Logf("%d formatted as %s decodes to %d, %v", m, m, decoded, err)
+			return false
+		}
+		if m < 0 {
+			return true
+		}
+		parsed, err := ParseMoney(m.String())
+		if err != nil || parsed != m {
+			t.Logf("%d formatted as %s parses to %d, %v", m, m, parsed, err)
+			return false
+		}
+		return true
+	}
+	for _, m := range edgeAmounts {
+		if !parsesBack(m) {
+			t.Errorf("%d does not survive formatting", m)
+		}
+	}
+	if err := quick.Check(parsesBack, &quick.Config{MaxCount: 10000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParsedMoneyFormatsBack(t *testing.T) {
+	// Any run of digits with two decimals parses to an amount that formats as the same text less its leading
+	// zeros, or overflows when it is too large. The shift spreads the whole part over every magnitude
+	formatsBack := func(whole uint64, shift, cents, zeros uint8) bool {
+		whole >>= shift % 64
+		canonical := fmt.Sprintf("%d.%02d", whole, cents%100)
+		text := strings.Repeat("0", int(zeros%4)) + canonical
+		parsed, err := ParseMoney(text)
+		if whole > math.MaxInt64/100 || whole == math.MaxInt64/100 && cents%100 > math.MaxInt64%100 {
+			return errors.Is(err, ErrMoneyOverflow)
+		}
+		if err != nil || parsed.String() != canonical {
+			t.Logf("ParseMoney(%q) = %s, %v, want %s", text, parsed, err, canonical)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(formatsBack, &quick.Config{MaxCount: 10000}); err != nil {
+		t.Error(err)
+	}
+	if !formatsBack(math.MaxInt64/100, 0, 7, 0) || !formatsBack(math.MaxInt64/100, 0, 8, 0) {
+		t.Error("the largest amount does not survive parsing, or the next one up does not overflow")
+	}
+}
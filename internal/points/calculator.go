@@ -0,0 +1,77 @@
+package points
+
+import (
+	"sync"
+
+	"receipt-processor/internal/model"
+)
+
+// Scores receipts under a config that can be swapped and rules that can be switched off while it is in use.
+// It is safe for concurrent use
+type Calculator struct {
+	mu       sync.RWMutex
+	config   Config
+	disabled map[string]bool
+}
+
+// Function to make a calculator scoring under config with every rule enabled
+func NewCalculator(config Config) *Calculator {
+	return &Calculator{config: config, disabled: make(map[string]bool)}
+}
+
+// Function to get the config as it was set, with disabled rules keeping their values
+func (c *Calculator) Config() Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config
+}
+
+// Function to swap in a new config, returning the one it replaced. Disabled rules stay disabled
+func (c *Calculator) SetConfig(config Config) Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous := c.config
+	c.config = config
+	return previous
+}
+
+// Function to report whether a rule is enabled; rule names it has never seen are
+func (c *Calculator) Enabled(rule string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !c.disabled[rule]
+}
+
+// Function to switch a rule on or off
+func (c *Calculator) SetEnabled(rule string, enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if enabled {
+		delete(c.disabled, rule)
+	} else {
+		c.disabled[rule] = true
+	}
+}
+
+// Function to get the config scoring uses: the one set, with disabled rules zeroed out
+func (c *Calculator) Active() Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	config := c.config
+	for _, rule := range Rules {
+		if c.disabled[rule.Name] {
+			rule.Clear(&config)
+		}
+	}
+	return config
+}
+
+// Function to calculate a receipt's points under the active config
+func (c *Calculator) Calculate(receipt model.Receipt) int {
+	return Calculate(receipt, c.Active())
+}
+
+// Function to calculate the points each rule contributes to a receipt under the active config
+func (c *Calculator) Breakdown(receipt model.Receipt) map[string]int {
+	return Breakdown(receipt, c.Active())
+}
@@ -0,0 +1,54 @@
+package points
+
+import (
+	"strings"
+
+	"receipt-processor/internal/model"
+)
+
+// How the description-length rule scored one item
+type DescriptionScore struct {
+	Description  string      `json:"description"`
+	Length       int         `json:"length"`
+	DivisibleBy3 bool        `json:"divisibleBy3"`
+	Price        model.Money `json:"price"`
+	RawScore     float64     `json:"rawScore"`
+	RoundedScore int         `json:"roundedScore"`
+}
+
+// Rounding strategies for the description rule, by DescriptionRuleRounding value
+var descriptionRounding = map[string]model.RoundingMode{
+	"":      model.RoundCeil,
+	"ceil":  model.RoundCeil,
+	"floor": model.RoundFloor,
+	"round": model.RoundHalfUp,
+}
+
+// Function to score one item under the description-length rule; items below the minimum price score nothing.
+// The item must already be validated
+func ScoreDescription(item model.Item, config Config) DescriptionScore {
+	description := strings.TrimSpace(item.ShortDescription)
+	price, _ := model.ParseMoney(item.Price)
+	mode := descriptionRounding[config.DescriptionRuleRounding]
+	percent := config.DescriptionMultiplier
+	score := DescriptionScore{
+		Description:  description,
+		Length:       len(description),
+		DivisibleBy3: len(description)%3 == 0,
+		Price:        price,
+		// Shown for explanation only; the score itself is worked out in cents
+		RawScore: float64(int64(price)*int64(percent)) / 10000,
+	}
+	if score.DivisibleBy3 && price >= config.DescriptionRuleMinPrice {
+		// Truncating to the cent first, or lifting to it when rounding up, cannot change the whole-point
+		// result for a price that is never negative
+		first := model.RoundFloor
+		if mode == model.RoundCeil {
+			first = model.RoundCeil
+		}
+		if cents, err := price.MulPercent(percent, first); err == nil {
+			score.RoundedScore = int(cents.Units(mode))
+		}
+	}
+	return score
+}
@@ -0,0 +1,134 @@
+// Package points scores receipts. It holds the scoring rules and the config that sets what each is worth, and
+// keeps no state of its own.
+package points
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"receipt-processor/internal/model"
+)
+
+// Point values awarded by each scoring rule; items priced below DescriptionRuleMinPrice skip the description rule
+type Config struct {
+	RetailerCharPoints      int           `json:"retailerCharPoints"`
+	RoundTotalBonus         int           `json:"roundTotalBonus"`
+	QuarterMultipleBonus    int           `json:"quarterMultipleBonus"`
+	ItemPairPoints          int           `json:"itemPairPoints"`
+	DescriptionMultiplier   model.Percent `json:"descriptionMultiplier"`
+	DescriptionRuleMinPrice model.Money   `json:"descriptionRuleMinPrice"`
+	OddDayBonus             int           `json:"oddDayBonus"`
+	AfternoonBonus          int           `json:"afternoonBonus"`
+	// Awarded on even purchase days; off by default, and set per receipt by promotional scoring overrides
+	EvenDayBonus int `json:"evenDayBonus,omitempty"`
+	// How the description rule turns price * multiplier into whole points: ceil, floor or round; empty means ceil
+	DescriptionRuleRounding string `json:"descriptionRuleRounding,omitempty"`
+}
+
+// Function to give the config the challenge's rules describe
+func DefaultConfig() Config {
+	return Config{
+		RetailerCharPoints:      1,
+		RoundTotalBonus:         50,
+		QuarterMultipleBonus:    25,
+		ItemPairPoints:          5,
+		DescriptionMultiplier:   20,
+		OddDayBonus:             6,
+		AfternoonBonus:          10,
+		DescriptionRuleRounding: "ceil",
+	}
+}
+
+// Function to reject scoring configs that could never be deployed
+func (c Config) Validate() error {
+	if c.RetailerCharPoints < 0 || c.RoundTotalBonus < 0 || c.QuarterMultipleBonus < 0 || c.ItemPairPoints < 0 ||
+		c.DescriptionMultiplier < 0 || c.DescriptionRuleMinPrice < 0 || c.OddDayBonus < 0 || c.AfternoonBonus < 0 ||
+		c.EvenDayBonus < 0 {
+		return errors.New("scoring values must not be negative")
+	}
+	if _, ok := descriptionRounding[c.DescriptionRuleRounding]; !ok {
+		return errors.New(`descriptionRuleRounding must be "ceil", "floor" or "round"`)
+	}
+	return nil
+}
+
+// Function to count the letters and digits in a retailer name
+func alphanumericCount(retailer string) int {
+	count := 0
+	for _, r := range retailer {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			count++
+		}
+	}
+	return count
+}
+
+// Function to feed the points each scoring rule contributes to a receipt into add, without building intermediate
+// values. The receipt must already be validated
+func Score(receipt model.Receipt, config Config, add func(rule string, points int)) {
+	add(RuleRetailerChar, alphanumericCount(receipt.Retailer)*config.RetailerCharPoints)
+
+	total, _ := model.ParseMoney(receipt.Total)
+
+	roundTotal, quarterMultiple := 0, 0
+	if total.MultipleOf(100) {
+		roundTotal = config.RoundTotalBonus
+	}
+	if total.MultipleOf(25) {
+		quarterMultiple = config.QuarterMultipleBonus
+	}
+	add(RuleRoundTotal, roundTotal)
+	add(RuleQuarterMultiple, quarterMultiple)
+
+	add(RuleItemPair, (len(receipt.Items)/2)*config.ItemPairPoints)
+
+	description := 0
+	for _, item := range receipt.Items {
+		description += ScoreDescription(item, config).RoundedScore
+	}
+	add(RuleDescription, description)
+
+	// Validation guarantees YYYY-MM-DD and HH:MM, so the fields are read in place rather than split
+	oddDay, evenDay := 0, 0
+	if i := strings.LastIndexByte(receipt.PurchaseDate, '-'); i >= 0 {
+		day, _ := strconv.Atoi(receipt.PurchaseDate[i+1:])
+		if day%2 != 0 {
+			oddDay = config.OddDayBonus
+		} else {
+			evenDay = config.EvenDayBonus
+		}
+	}
+	add(RuleOddDay, oddDay)
+	add(RuleEvenDay, evenDay)
+
+	afternoon := 0
+	if hourPart, minutePart, ok := strings.Cut(receipt.PurchaseTime, ":"); ok {
+		hour, _ := strconv.Atoi(hourPart)
+		minute, _ := strconv.Atoi(minutePart)
+		timeVal := hour*60 + minute
+		if timeVal >= 840 && timeVal < 960 {
+			afternoon = config.AfternoonBonus
+		}
+	}
+	add(RuleAfternoon, afternoon)
+}
+
+// Function to calculate the points each scoring rule contributes to a receipt
+func Breakdown(receipt model.Receipt, config Config) map[string]int {
+	breakdown := make(map[string]int, len(Rules))
+	Score(receipt, config, func(rule string, points int) {
+		breakdown[rule] = points
+	})
+	return breakdown
+}
+
+// Function to calculate points for a given receipt
+func Calculate(receipt model.Receipt, config Config) int {
+	points := 0
+	Score(receipt, config, func(_ string, rulePoints int) {
+		points += rulePoints
+	})
+	return points
+}
@@ -0,0 +1,140 @@
+package points
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"receipt-processor/internal/model"
+)
+
+// One scoring regression case; dropping a file of this shape into testdata adds a test
+type fixture struct {
+	Receipt        model.Receipt `json:"receipt"`
+	ExpectedPoints int           `json:"expectedPoints"`
+}
+
+// Function to read every scoring fixture in testdata, keyed by file name
+func loadFixtures(t testing.TB) map[string]fixture {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no fixtures in testdata")
+	}
+	fixtures := make(map[string]fixture, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		var test fixture
+		if err := decoder.Decode(&test); err != nil {
+			t.Fatalf("reading %s: %v", path, err)
+		}
+		fixtures[filepath.Base(path)] = test
+	}
+	return fixtures
+}
+
+func TestCalculatePointsFromFixtures(t *testing.T) {
+	for name, test := range loadFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			if got := Calculate(test.Receipt, DefaultConfig()); got != test.ExpectedPoints {
+				t.Errorf("Calculate = %d, want %d", got, test.ExpectedPoints)
+			}
+			total := 0
+			for _, points := range Breakdown(test.Receipt, DefaultConfig()) {
+				total += points
+			}
+			if total != test.ExpectedPoints {
+				t.Errorf("Breakdown adds up to %d, want %d", total, test.ExpectedPoints)
+			}
+		})
+	}
+}
+
+func TestAlphanumericCount(t *testing.T) {
+	tests := []struct {
+		retailer string
+		want     int
+	}{
+		{"Target", 6},
+		{"M&M Corner Market", 14},
+		{"7-Eleven", 7},
+		{"Café Müller", 10},
+		{"東京 Mart", 6},
+		{"  - & -  ", 0},
+	}
+	for _, test := range tests {
+		if got := alphanumericCount(test.retailer); got != test.want {
+			t.Errorf("alphanumericCount(%q) = %d, want %d", test.retailer, got, test.want)
+		}
+	}
+}
+
+func TestDescriptionRounding(t *testing.T) {
+	// 0.14 * 0.2 = 0.028 points for a description of three characters
+	item := model.Item{ShortDescription: "Gum", Price: "0.14"}
+	tests := []struct {
+		rounding string
+		want     int
+	}{
+		{"ceil", 1},
+		{"floor", 0},
+		{"round", 0},
+	}
+	for _, test := range tests {
+		config := DefaultConfig()
+		config.DescriptionRuleRounding = test.rounding
+		if got := ScoreDescription(item, config).RoundedScore; got != test.want {
+			t.Errorf("$0.14 rounded with %s = %d, want %d", test.rounding, got, test.want)
+		}
+	}
+}
+
+func TestDescriptionRuleMinPrice(t *testing.T) {
+	config := DefaultConfig()
+	config.DescriptionRuleMinPrice = 500
+	tests := []struct {
+		price string
+		want  int
+	}{
+		{"5.01", 2},
+		{"5.00", 1},
+		{"4.99", 0},
+	}
+	for _, test := range tests {
+		item := model.Item{ShortDescription: "Gum", Price: test.price}
+		if got := ScoreDescription(item, config).RoundedScore; got != test.want {
+			t.Errorf("$%s with a $5.00 minimum = %d, want %d", test.price, got, test.want)
+		}
+	}
+}
+
+func TestCalculatePointsAllocations(t *testing.T) {
+	config := DefaultConfig()
+	for name, test := range loadFixtures(t) {
+		if allocs := testing.AllocsPerRun(100, func() { Calculate(test.Receipt, config) }); allocs > 0 {
+			t.Errorf("%s: Calculate made %v allocations, want none", name, allocs)
+		}
+	}
+}
+
+func BenchmarkCalculatePoints(b *testing.B) {
+	config := DefaultConfig()
+	for name, test := range loadFixtures(b) {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				Calculate(test.Receipt, config)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package points
+
+// Rule names, shared by the rules endpoints and the points breakdown
+const (
+	RuleRetailerChar    = "pointsPerRetailerChar"
+	RuleRoundTotal      = "pointsForRoundTotal"
+	RuleQuarterMultiple = "pointsForQuarterMultiple"
+	RuleItemPair        = "pointsPerItemPair"
+	RuleDescription     = "descriptionMultiplier"
+	RuleOddDay          = "pointsForOddDay"
+	RuleEvenDay         = "pointsForEvenDay"
+	RuleAfternoon       = "pointsForAfternoon"
+)
+
+// A named view onto one field of the scoring config. Kind is fixed, perUnit or multiplier
+type Rule struct {
+	Name  string
+	Kind  string
+	Get   func(c Config) float64
+	Clear func(c *Config)
+}
+
+// Every rule, in the order breakdowns list them
+var Rules = []Rule{
+	{RuleRetailerChar, "perUnit",
+		func(c Config) float64 { return float64(c.RetailerCharPoints) },
+		func(c *Config) { c.RetailerCharPoints = 0 }},
+	{RuleRoundTotal, "fixed",
+		func(c Config) float64 { return float64(c.RoundTotalBonus) },
+		func(c *Config) { c.RoundTotalBonus = 0 }},
+	{RuleQuarterMultiple, "fixed",
+		func(c Config) float64 { return float64(c.QuarterMultipleBonus) },
+		func(c *Config) { c.QuarterMultipleBonus = 0 }},
+	{RuleItemPair, "perUnit",
+		func(c Config) float64 { return float64(c.ItemPairPoints) },
+		func(c *Config) { c.ItemPairPoints = 0 }},
+	{RuleDescription, "multiplier",
+		func(c Config) float64 { return float64(c.DescriptionMultiplier) / 100 },
+		func(c *Config) { c.DescriptionMultiplier = 0 }},
+	{RuleOddDay, "fixed",
+		func(c Config) float64 { return float64(c.OddDayBonus) },
+		func(c *Config) { c.OddDayBonus = 0 }},
+	{RuleEvenDay, "fixed",
+		func(c Config) float64 { return float64(c.EvenDayBonus) },
+		func(c *Config) { c.EvenDayBonus = 0 }},
+	{RuleAfternoon, "fixed",
+		func(c Config) float64 { return float64(c.AfternoonBonus) },
+		func(c *Config) { c.AfternoonBonus = 0 }},
+}
@@ -0,0 +1,605 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"receipt-processor/internal/model"
+)
+
+// Operations recorded in the write-ahead log
+const (
+	opInsert   = "INSERT"
+	opUpdate   = "UPDATE"
+	opDelete   = "DELETE"
+	opArchive  = "ARCHIVE"
+	opTransfer = "TRANSFER"
+	opReplace  = "REPLACE"
+	opMigrate  = "MIGRATE"
+	opUsage    = "USAGE"
+	opPin      = "PIN"
+	opUnpin    = "UNPIN"
+	opNote     = "NOTE"
+	opUnnote   = "UNNOTE"
+)
+
+// How long compaction keeps API key usage; longer than any quota window, which is at most a calendar month
+const keyUsageRetention = 62 * 24 * time.Hour
+
+// With GroupCommitMS set, receipt inserts are fsynced together every GroupCommitMS or GroupCommitRecords records.
+// Durability "strict" holds each response until its record is synced; "relaxed" answers at once and may lose
+// up to GroupCommitMS of acknowledged receipts in a crash
+type LogConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	Path               string `yaml:"path"`
+	MaxSizeMB          int    `yaml:"maxSizeMB"`
+	GroupCommitMS      int    `yaml:"groupCommitMs"`
+	GroupCommitRecords int    `yaml:"groupCommitRecords"`
+	Durability         string `yaml:"durability"`
+}
+
+// One store operation; UPDATE carries the change to a receipt's committed points, TRANSFER the points moved to
+// TargetID, REPLACE an edited receipt with the resulting change in points, and MIGRATE a receipt upgraded to
+// SchemaVersion. A DELETE carrying APIKey takes back an insert whose sync failed, giving back the submission
+// counted against the key on the day of StoredAt. PIN and UNPIN pin a receipt against deletion and release it
+// again; NOTE attaches Note to a receipt and UNNOTE removes the note with NoteID. USAGE is written by compaction alone: Points submissions counted against APIKey on the day of StoredAt,
+// standing in for the INSERTs it rewrote without their key
+type record struct {
+	Op            string         `json:"op"`
+	ID            string         `json:"id"`
+	Receipt       *model.Receipt `json:"receipt,omitempty"`
+	Points        int            `json:"points,omitempty"`
+	PointsDelta   int            `json:"pointsDelta,omitempty"`
+	Fingerprint   string         `json:"fingerprint,omitempty"`
+	TargetID      string         `json:"targetId,omitempty"`
+	StoredAt      time.Time      `json:"storedAt,omitzero"`
+	SchemaVersion int            `json:"schemaVersion,omitempty"`
+	Body          *Body          `json:"body,omitempty"`
+	APIKey        string         `json:"apiKey,omitempty"`
+	Note          *model.Note    `json:"note,omitempty"`
+	NoteID        string         `json:"noteId,omitempty"`
+}
+
+// A store that writes every change to an append-only JSON lines log before applying it in memory, and rebuilds
+// itself from that log at startup
+type Logged struct {
+	*Memory
+
+	// Guards the file and the group commit state below. It is taken after any shard lock; each change is applied
+	// in memory before it is released, so the log and memory see changes in the same order
+	mutex   sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+	// Size right after the last compaction; the log rotates once it has grown maxSize past this
+	base int64
+
+	groupWindow  time.Duration
+	groupRecords int
+	strict       bool
+	// Records written since the last sync, and the strict-mode acks waiting on it
+	unsynced int
+	waiters  []chan error
+	flushNow chan struct{}
+	// Held while syncing outside mutex, so compaction and Close never close the file under a running fsync
+	syncing sync.Mutex
+	// Signalled once the log has outgrown maxSize; compaction runs on its own goroutine because writers hold shard
+	// locks it needs. closed is set under mutex once Close has run
+	compactNow chan struct{}
+	stop       chan struct{}
+	closed     bool
+
+	// Points held by open reservations per receipt, which are not logged; compaction adds them back
+	reserved func() map[string]int
+	now      func() time.Time
+}
+
+// Function to replay an existing log into memory and open it for appending. Receipts logged without a storage
+// time get now, and reserved reports the points open reservations hold when the log is compacted
+func OpenLogged(memory *Memory, config LogConfig, now func() time.Time, reserved func() map[string]int) (*Logged, error) {
+	if err := replay(memory, config.Path, now); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening WAL: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("opening WAL: %w", err)
+	}
+	l := &Logged{
+		Memory:       memory,
+		path:         config.Path,
+		maxSize:      int64(config.MaxSizeMB) << 20,
+		file:         file,
+		size:         info.Size(),
+		groupWindow:  time.Duration(config.GroupCommitMS) * time.Millisecond,
+		groupRecords: config.GroupCommitRecords,
+		strict:       config.Durability != "relaxed",
+		flushNow:     make(chan struct{}, 1),
+		compactNow:   make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		reserved:     reserved,
+		now:          now,
+	}
+	go l.compactWhenFull()
+	return l, nil
+}
+
+// Function to rebuild a store from a log; a torn final line from a crash is ignored
+func replay(memory *Memory, path string, now func() time.Time) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("replaying WAL: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var logged record
+		if err := json.Unmarshal(scanner.Bytes(), &logged); err != nil {
+			if !scanner.Scan() {
+				break
+			}
+			return fmt.Errorf("replaying WAL: line %d: %w", line, err)
+		}
+		memory.replay(logged, now)
+	}
+	return scanner.Err()
+}
+
+// Function to apply one logged operation, noting each change in points as the system's
+func (m *Memory) replay(logged record, now func() time.Time) {
+	if logged.Op == opUsage {
+		m.indexes.Lock()
+		defer m.indexes.Unlock()
+		m.countKeyUsage(logged.APIKey, logged.StoredAt, logged.Points)
+		return
+	}
+	if logged.Op == opTransfer {
+		defer m.LockPair(logged.ID, logged.TargetID)()
+		if m.transfer(logged.ID, logged.TargetID, logged.PointsDelta) {
+			m.notePoints(logged.ID, model.MutationTransferOut, -logged.PointsDelta, now)
+			m.notePoints(logged.TargetID, model.MutationTransferIn, logged.PointsDelta, now)
+		}
+		return
+	}
+
+	shard := m.Shard(logged.ID)
+	shard.Lock()
+	defer shard.Unlock()
+	switch logged.Op {
+	case opInsert:
+		if logged.Receipt == nil {
+			return
+		}
+		// Logs written before receipts carried a storage time restart their archive clock at replay
+		if logged.StoredAt.IsZero() {
+			logged.StoredAt = now()
+		}
+		m.insert(logged.ID, Entry{Receipt: *logged.Receipt, Points: logged.Points, Fingerprint: logged.Fingerprint,
+			StoredAt: logged.StoredAt, SchemaVersion: logged.SchemaVersion, Body: logged.Body, APIKey: logged.APIKey})
+		m.notePoints(logged.ID, model.MutationCalculated, logged.Points, now)
+	case opUpdate:
+		if _, exists := shard.Points[logged.ID]; exists {
+			shard.Points[logged.ID] += logged.PointsDelta
+			m.notePoints(logged.ID, model.MutationRedeemed, logged.PointsDelta, now)
+		}
+	case opDelete:
+		m.discard(logged.ID, Entry{APIKey: logged.APIKey, StoredAt: logged.StoredAt})
+		// The server drops a deleted receipt's notes itself; nothing logs their removal one by one
+		delete(shard.Notes, logged.ID)
+	case opReplace:
+		if logged.Receipt != nil && m.replace(logged.ID, *logged.Receipt, logged.PointsDelta, logged.Fingerprint) {
+			m.notePoints(logged.ID, model.MutationRecalculated, logged.PointsDelta, now)
+		}
+	case opMigrate:
+		if logged.Receipt != nil {
+			m.migrate(logged.ID, *logged.Receipt, logged.Fingerprint, logged.SchemaVersion)
+		}
+	case opArchive:
+		m.archive(logged.ID)
+	case opPin, opUnpin:
+		m.pin(logged.ID, logged.Op == opPin)
+	case opNote:
+		if logged.Note != nil {
+			m.addNote(logged.ID, *logged.Note)
+		}
+	case opUnnote:
+		m.removeNote(logged.ID, logged.NoteID)
+	}
+}
+
+// Function to add a replayed change to a receipt's points history; callers hold the receipt's shard lock
+func (m *Memory) notePoints(id, mutationType string, delta int, now func() time.Time) {
+	shard := m.Shard(id)
+	shard.PointsMutations[id] = append(shard.PointsMutations[id], model.PointsMutation{
+		Type:      mutationType,
+		Delta:     delta,
+		NewValue:  shard.Points[id],
+		Timestamp: now().UTC(),
+		Actor:     "system",
+	})
+}
+
+func (l *Logged) Insert(id string, entry Entry) (Ack, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	ack, err := l.appendDeferred(record{Op: opInsert, ID: id, Receipt: &entry.Receipt, Points: entry.Points,
+		Fingerprint: entry.Fingerprint, StoredAt: entry.StoredAt, SchemaVersion: entry.SchemaVersion, Body: entry.Body,
+		APIKey: entry.APIKey})
+	if err != nil {
+		return nil, err
+	}
+	l.insert(id, entry)
+	return ack, nil
+}
+
+// The receipt goes from memory whether or not its DELETE is logged: the failed sync may have left the INSERT off
+// the disk, and if it did reach it, the DELETE written after it keeps it from coming back at replay
+func (l *Logged) Discard(id string, entry Entry) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	err := l.append(record{Op: opDelete, ID: id, APIKey: entry.APIKey, StoredAt: entry.StoredAt})
+	l.discard(id, entry)
+	return err
+}
+
+func (l *Logged) Redeem(id string, points int) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.append(record{Op: opUpdate, ID: id, PointsDelta: -points})
+}
+
+func (l *Logged) Transfer(id, targetID string, points int) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.append(record{Op: opTransfer, ID: id, TargetID: targetID, PointsDelta: points}); err != nil {
+		return err
+	}
+	l.transfer(id, targetID, points)
+	return nil
+}
+
+func (l *Logged) Replace(id string, receipt model.Receipt, delta int, fingerprint string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.append(record{Op: opReplace, ID: id, Receipt: &receipt, PointsDelta: delta, Fingerprint: fingerprint}); err != nil {
+		return err
+	}
+	l.replace(id, receipt, delta, fingerprint)
+	return nil
+}
+
+func (l *Logged) Migrate(id string, receipt model.Receipt, fingerprint string, version int) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.append(record{Op: opMigrate, ID: id, Receipt: &receipt, Fingerprint: fingerprint, SchemaVersion: version}); err != nil {
+		return err
+	}
+	l.migrate(id, receipt, fingerprint, version)
+	return nil
+}
+
+func (l *Logged) Archive(id string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.append(record{Op: opArchive, ID: id}); err != nil {
+		return err
+	}
+	l.archive(id)
+	return nil
+}
+
+func (l *Logged) Pin(id string, pinned bool) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	op := opUnpin
+	if pinned {
+		op = opPin
+	}
+	if err := l.append(record{Op: op, ID: id}); err != nil {
+		return err
+	}
+	l.pin(id, pinned)
+	return nil
+}
+
+func (l *Logged) AddNote(id string, note model.Note) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.append(record{Op: opNote, ID: id, Note: &note}); err != nil {
+		return err
+	}
+	l.addNote(id, note)
+	return nil
+}
+
+func (l *Logged) RemoveNote(id, noteID string) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !slices.ContainsFunc(l.Shard(id).Notes[id], func(note model.Note) bool { return note.ID == noteID }) {
+		return false, nil
+	}
+	if err := l.append(record{Op: opUnnote, ID: id, NoteID: noteID}); err != nil {
+		return false, err
+	}
+	return l.removeNote(id, noteID), nil
+}
+
+func (l *Logged) Delete(id string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.append(record{Op: opDelete, ID: id}); err != nil {
+		return err
+	}
+	l.delete(id)
+	return nil
+}
+
+// Function to durably record an operation before it is applied; callers hold mutex
+func (l *Logged) append(logged record) error {
+	if err := l.write(logged); err != nil {
+		return err
+	}
+	return l.syncLocked()
+}
+
+// Function to record an operation that group commit may sync later; the caller waits on the ack after
+// releasing its locks. Without group commit this is append. Callers hold mutex
+func (l *Logged) appendDeferred(logged record) (Ack, error) {
+	if l.groupWindow <= 0 {
+		return nil, l.append(logged)
+	}
+	if err := l.write(logged); err != nil {
+		return nil, err
+	}
+	l.unsynced++
+	if l.groupRecords > 0 && l.unsynced >= l.groupRecords {
+		select {
+		case l.flushNow <- struct{}{}:
+		default:
+		}
+	}
+	if !l.strict {
+		return nil, nil
+	}
+	ack := make(Ack, 1)
+	l.waiters = append(l.waiters, ack)
+	return ack, nil
+}
+
+// Function to write one record without syncing it; callers hold mutex
+func (l *Logged) write(logged record) error {
+	if l.maxSize > 0 && l.size-l.base > l.maxSize {
+		select {
+		case l.compactNow <- struct{}{}:
+		default:
+		}
+	}
+	line, err := json.Marshal(logged)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("writing WAL: %w", err)
+	}
+	return nil
+}
+
+// Function to sync the log and release every pending ack; callers hold mutex
+func (l *Logged) syncLocked() error {
+	l.syncing.Lock()
+	err := l.file.Sync()
+	l.syncing.Unlock()
+	if err != nil {
+		err = fmt.Errorf("writing WAL: %w", err)
+	}
+	l.release(l.waiters, err)
+	l.unsynced, l.waiters = 0, nil
+	return err
+}
+
+func (l *Logged) release(waiters []chan error, err error) {
+	for _, ack := range waiters {
+		ack <- err
+	}
+}
+
+// Function to sync deferred records every group window, or sooner once groupRecords have built up, until stop
+// is closed
+func (l *Logged) FlushPeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(l.groupWindow)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-l.flushNow:
+		}
+
+		// Writers keep appending while the fsync runs; only records written before it started are acked
+		l.mutex.Lock()
+		if l.unsynced == 0 {
+			l.mutex.Unlock()
+			continue
+		}
+		waiters, file := l.waiters, l.file
+		l.unsynced, l.waiters = 0, nil
+		l.syncing.Lock()
+		l.mutex.Unlock()
+		err := file.Sync()
+		l.syncing.Unlock()
+		if err != nil {
+			slog.Error("group commit sync failed", "records", len(waiters), "error", err)
+			err = fmt.Errorf("writing WAL: %w", err)
+		}
+		l.release(waiters, err)
+	}
+}
+
+// Function to compact the log each time a write finds it has outgrown maxSize, until Close. It takes every shard
+// lock in lock order, then mutex; every logged change is applied before mutex is released, so the snapshot holds
+// exactly what has been written
+func (l *Logged) compactWhenFull() {
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-l.compactNow:
+		}
+		for _, shard := range l.shards {
+			shard.Lock()
+		}
+		// Open reservations only change under the shard lock of their receipt, so they hold still from here on
+		reserved := l.reserved()
+		l.mutex.Lock()
+		if !l.closed && l.size-l.base > l.maxSize {
+			if err := l.compact(reserved); err != nil {
+				slog.Error("compacting WAL failed", "error", err)
+			}
+		}
+		l.mutex.Unlock()
+		for _, shard := range slices.Backward(l.shards) {
+			shard.Unlock()
+		}
+	}
+}
+
+// Function to rotate the log by rewriting it as one INSERT per stored receipt, then one USAGE per API key and day
+// still within keyUsageRetention; callers hold every shard lock and mutex. Open reservations are not durable, so
+// the snapshot holds committed points with reserved added back
+func (l *Logged) compact(reserved map[string]int) error {
+
+	tmpPath := l.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("compacting WAL: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	l.indexes.Lock()
+	defer l.indexes.Unlock()
+	for _, shard := range l.shards {
+		for id, receipt := range shard.Receipts {
+			err = encoder.Encode(record{Op: opInsert, ID: id, Receipt: &receipt,
+				Points: shard.Points[id] + reserved[id], Fingerprint: l.fingerprintOf[id], StoredAt: shard.StoredAt[id],
+				SchemaVersion: shard.Versions[id], Body: shard.body(id)})
+			if err == nil {
+				err = encodeAnnotations(encoder, shard, id)
+			}
+			if err != nil {
+				break
+			}
+		}
+		for id, receipt := range shard.Archived {
+			if err != nil {
+				break
+			}
+			err = encoder.Encode(record{Op: opInsert, ID: id, Receipt: &receipt,
+				Points: shard.Points[id], Fingerprint: l.fingerprintOf[id], StoredAt: shard.StoredAt[id],
+				SchemaVersion: shard.Versions[id], Body: shard.body(id)})
+			if err == nil {
+				err = encoder.Encode(record{Op: opArchive, ID: id})
+			}
+			if err == nil {
+				err = encodeAnnotations(encoder, shard, id)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	oldest := l.now().Add(-keyUsageRetention).UTC().Format(time.DateOnly)
+	for key, days := range l.keyUsage {
+		for day, submissions := range days {
+			if day < oldest {
+				delete(days, day)
+				continue
+			}
+			if err == nil {
+				storedAt, _ := time.Parse(time.DateOnly, day)
+				err = encoder.Encode(record{Op: opUsage, APIKey: key, Points: submissions, StoredAt: storedAt})
+			}
+		}
+		if len(days) == 0 {
+			delete(l.keyUsage, key)
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmpPath, l.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("compacting WAL: %w", err)
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("compacting WAL: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("compacting WAL: %w", err)
+	}
+	// The snapshot already holds everything written so far, and it has been synced
+	l.syncing.Lock()
+	l.file.Close()
+	l.syncing.Unlock()
+	l.file, l.size, l.base = file, info.Size(), info.Size()
+	l.release(l.waiters, nil)
+	l.unsynced, l.waiters = 0, nil
+	return nil
+}
+
+// Function to write what compaction keeps of a receipt after its INSERT: its pin and its notes
+func encodeAnnotations(encoder *json.Encoder, shard *Shard, id string) error {
+	if shard.Pinned[id] {
+		if err := encoder.Encode(record{Op: opPin, ID: id}); err != nil {
+			return err
+		}
+	}
+	for _, note := range shard.Notes[id] {
+		if err := encoder.Encode(record{Op: opNote, ID: id, Note: &note}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to sync and close the log file at shutdown
+func (l *Logged) Close() error {
+	close(l.stop)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closed = true
+	syncErr := l.syncLocked()
+	return errors.Join(syncErr, l.file.Close())
+}
@@ -0,0 +1,372 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"receipt-processor/internal/model"
+)
+
+var testTime = time.Date(2022, 3, 20, 14, 33, 0, 0, time.UTC)
+
+func testNow() time.Time { return testTime }
+
+// Function to open a log at path over an empty store, closing it when the test ends
+func openTestLog(t *testing.T, path string, reserved map[string]int) *Logged {
+	t.Helper()
+	logged, err := OpenLogged(NewMemory(4), LogConfig{Enabled: true, Path: path, Durability: "strict"}, testNow,
+		func() map[string]int { return reserved })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A failed test may have left a writer stuck holding the log's lock, which Close would wait on forever
+	t.Cleanup(func() {
+		if !logged.closed && !t.Failed() {
+			logged.Close()
+		}
+	})
+	return logged
+}
+
+// Function to make a change with the shard locks of the receipts it touches held
+func change(t *testing.T, s Store, id, targetID string, apply func() error) {
+	t.Helper()
+	if targetID == "" {
+		targetID = id
+	}
+	defer s.LockPair(id, targetID)()
+	if err := apply(); err != nil {
+		t.Error(err)
+	}
+}
+
+// Function to run one of every logged change against a store, on receipts whose IDs start with prefix
+func changeEverything(t *testing.T, s Store, prefix string) {
+	t.Helper()
+	a, b, c, d := prefix+"a", prefix+"b", prefix+"c", prefix+"d"
+	receipts := map[string]model.Receipt{
+		a: {Retailer: "Target", Total: "35.35"},
+		b: {Retailer: "Walgreens", Total: "2.65"},
+		c: {Retailer: "Target", Total: "1.25"},
+		d: {Retailer: "Corner", Total: "9.00"},
+	}
+	for _, id := range []string{a, b, c, d} {
+		change(t, s, id, "", func() error {
+			_, err := s.Insert(id, Entry{Receipt: receipts[id], Points: 20, Fingerprint: "fp-" + id, StoredAt: testTime, SchemaVersion: 1})
+			return err
+		})
+	}
+	// Reserving takes the points off in memory alone; only the redemption is logged
+	change(t, s, a, "", func() error {
+		s.Shard(a).Points[a] -= 5
+		return s.Redeem(a, 5)
+	})
+	change(t, s, a, b, func() error { return s.Transfer(a, b, 3) })
+	change(t, s, b, "", func() error { return s.Replace(b, model.Receipt{Retailer: "Walgreens", Total: "3.65"}, 4, "fp-"+b+"2") })
+	change(t, s, c, "", func() error { return s.Migrate(c, model.Receipt{Retailer: "Target", Total: "1.25"}, "fp-"+c+"2", 2) })
+	change(t, s, a, "", func() error {
+		return s.AddNote(a, model.Note{ID: a + "-kept", Text: "Matched the paper copy", CreatedAt: testTime})
+	})
+	change(t, s, a, "", func() error { return s.AddNote(a, model.Note{ID: a + "-removed", Text: "Typo", CreatedAt: testTime}) })
+	change(t, s, a, "", func() error {
+		_, err := s.RemoveNote(a, a+"-removed")
+		return err
+	})
+	change(t, s, c, "", func() error {
+		return s.AddNote(c, model.Note{ID: c + "-kept", Text: "Archived early", CreatedAt: testTime})
+	})
+	change(t, s, d, "", func() error {
+		return s.AddNote(d, model.Note{ID: d + "-dropped", Text: "About to go", CreatedAt: testTime})
+	})
+	change(t, s, c, "", func() error { return s.Archive(c) })
+	change(t, s, a, "", func() error { return s.Pin(a, true) })
+	change(t, s, c, "", func() error { return s.Pin(c, true) })
+	change(t, s, b, "", func() error { return s.Pin(b, true) })
+	change(t, s, b, "", func() error { return s.Pin(b, false) })
+	change(t, s, d, "", func() error { return s.Pin(d, true) })
+	change(t, s, d, "", func() error { return s.Delete(d) })
+}
+
+// Function to collect the notes of every receipt a store still holds
+func storedNotes(m *Memory) map[string][]model.Note {
+	notes := make(map[string][]model.Note)
+	for _, shard := range m.shards {
+		shard.RLock()
+		for id, list := range shard.Notes {
+			if _, exists := shard.Lookup(id); exists && len(list) > 0 {
+				notes[id] = list
+			}
+		}
+		shard.RUnlock()
+	}
+	return notes
+}
+
+func TestLoggedReplaysToTheSameState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.wal")
+	logged := openTestLog(t, path, nil)
+	changeEverything(t, logged, "")
+	want := logged.Snapshot()
+	wantNotes := storedNotes(logged.Memory)
+	if err := logged.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := openTestLog(t, path, nil)
+	if got := replayed.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed snapshot\n%+v\nwant\n%+v", got, want)
+	}
+	if got := storedNotes(replayed.Memory); len(got) != 2 || !reflect.DeepEqual(got, wantNotes) || len(replayed.Shard("d").Notes["d"]) != 0 {
+		t.Errorf("replayed notes %+v, want %+v and none left on the deleted receipt", got, wantNotes)
+	}
+	tests := []struct {
+		fingerprint string
+		owner       string
+		owned       bool
+	}{
+		{"fp-a", "a", true},
+		{"fp-b", "", false},
+		{"fp-b2", "b", true},
+		{"fp-c2", "c", true},
+		{"fp-d", "", false},
+	}
+	for _, test := range tests {
+		if owner, owned := replayed.FingerprintOwner(test.fingerprint); owner != test.owner || owned != test.owned {
+			t.Errorf("FingerprintOwner(%q) = %q, %v, want %q, %v", test.fingerprint, owner, owned, test.owner, test.owned)
+		}
+	}
+	if got := replayed.RetailerCount("Target"); got != 2 {
+		t.Errorf("RetailerCount(Target) = %d, want 2", got)
+	}
+}
+
+func TestReplayIgnoresATornFinalLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.wal")
+	logged := openTestLog(t, path, nil)
+	changeEverything(t, logged, "")
+	want := logged.Snapshot()
+	logged.file.WriteString(`{"op":"INSERT","id":"e","rece`)
+	logged.Close()
+
+	if got := openTestLog(t, path, nil).Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed snapshot\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+// Writers hold shard write locks while they log, so compaction running inside the write deadlocked
+func TestCompactionWhileWritersHoldShardLocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.wal")
+	logged := openTestLog(t, path, nil)
+	logged.maxSize = 1
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for round := range 20 {
+			changeEverything(t, logged, strconv.Itoa(round)+"-")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("writes did not finish; compaction is waiting on a shard lock a writer holds")
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		logged.mutex.Lock()
+		compacted := logged.base > 0
+		logged.mutex.Unlock()
+		if compacted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the log was never compacted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	want := logged.Snapshot()
+	wantNotes := storedNotes(logged.Memory)
+	if err := logged.Close(); err != nil {
+		t.Fatal(err)
+	}
+	replayed := openTestLog(t, path, nil)
+	if got := replayed.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot after compaction and replay\n%+v\nwant\n%+v", got, want)
+	}
+	if got := storedNotes(replayed.Memory); !reflect.DeepEqual(got, wantNotes) {
+		t.Errorf("notes after compaction and replay\n%+v\nwant\n%+v", got, wantNotes)
+	}
+}
+
+func TestCompactionAddsOpenReservationsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.wal")
+	logged := openTestLog(t, path, map[string]int{"a": 7})
+	change(t, logged, "a", "", func() error {
+		_, err := logged.Insert("a", Entry{Receipt: model.Receipt{Retailer: "Target"}, Points: 28, StoredAt: testTime})
+		logged.Shard("a").Points["a"] -= 7
+		return err
+	})
+	logged.mutex.Lock()
+	err := logged.compact(logged.reserved())
+	logged.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged.Close()
+
+	replayed := openTestLog(t, path, nil)
+	if got := replayed.Shard("a").Points["a"]; got != 28 {
+		t.Errorf("points after compaction and replay = %d, want 28 with the reservation returned", got)
+	}
+}
+
+func TestKeyUsageSurvivesReplayAndCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.wal")
+	logged := openTestLog(t, path, nil)
+	stale := testTime.Add(-keyUsageRetention - 24*time.Hour)
+	for id, storedAt := range map[string]time.Time{"a": testTime, "b": testTime, "stale": stale} {
+		change(t, logged, id, "", func() error {
+			_, err := logged.Insert(id, Entry{Receipt: model.Receipt{Retailer: "Target"}, Points: 1, StoredAt: storedAt, APIKey: "acme"})
+			return err
+		})
+	}
+	// Deleting a receipt does not give back the submission it used
+	change(t, logged, "a", "", func() error { return logged.Delete("a") })
+	logged.Close()
+
+	today, staleDay := testTime.Format(time.DateOnly), stale.Format(time.DateOnly)
+	replayed := openTestLog(t, path, nil)
+	if got, want := replayed.KeyUsage(), map[string]map[string]int{"acme": {today: 2, staleDay: 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("usage after replay = %v, want %v", got, want)
+	}
+
+	replayed.mutex.Lock()
+	err := replayed.compact(nil)
+	replayed.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed.Close()
+	compacted := openTestLog(t, path, nil)
+	if got, want := compacted.KeyUsage(), map[string]map[string]int{"acme": {today: 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("usage after compaction and replay = %v, want %v without the stale day", got, want)
+	}
+}
+
+// An insert whose group sync fails is taken back in memory, and its records are replayed to nothing if they reached
+// the disk after all
+func TestDiscardAfterAFailedSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.wal")
+	logged, err := OpenLogged(NewMemory(4), LogConfig{Enabled: true, Path: path, GroupCommitMS: 1000, Durability: "strict"},
+		testNow, func() map[string]int { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writes to a pipe succeed but syncing it fails, as an fsync that hits an I/O error does
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	file := logged.file
+	logged.file = writer
+
+	entry := Entry{Receipt: model.Receipt{Retailer: "Target"}, Points: 28, Fingerprint: "fp-a", StoredAt: testTime, APIKey: "acme"}
+	var ack Ack
+	change(t, logged, "a", "", func() error {
+		ack, err = logged.Insert("a", entry)
+		return err
+	})
+	logged.mutex.Lock()
+	logged.syncLocked()
+	logged.mutex.Unlock()
+	if err := ack.Wait(); err == nil {
+		t.Fatal("the ack of an unsynced insert succeeded")
+	}
+	unlock := logged.LockPair("a", "a")
+	if err := logged.Discard("a", entry); err == nil {
+		t.Error("Discard logged its DELETE on a log that cannot sync")
+	}
+	unlock()
+
+	today := testTime.Format(time.DateOnly)
+	if got := logged.Count(); got != 0 {
+		t.Errorf("%d receipts left after the discard, want none", got)
+	}
+	if owner, owned := logged.FingerprintOwner("fp-a"); owned {
+		t.Errorf("fingerprint still owned by %s after the discard", owner)
+	}
+	if got := logged.KeyUsage()["acme"][today]; got != 0 {
+		t.Errorf("key usage after the discard = %d, want 0", got)
+	}
+
+	// Land what went to the pipe in the real log, as if the records reached the disk despite the failed sync
+	writer.Close()
+	unsynced, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged.file = file
+	if _, err := file.Write(unsynced); err != nil {
+		t.Fatal(err)
+	}
+	logged.Close()
+	replayed := openTestLog(t, path, nil)
+	if got := replayed.Count(); got != 0 {
+		t.Errorf("%d receipts after replay, want the discarded insert left out", got)
+	}
+	if got := replayed.KeyUsage()["acme"][today]; got != 0 {
+		t.Errorf("key usage after replay = %d, want 0", got)
+	}
+}
+
+// Function to benchmark strict inserts from many writers, each waiting for its record to be synced, with records
+// synced together every window; a zero window syncs each record on its own
+func benchmarkGroupCommit(b *testing.B, window time.Duration) {
+	config := LogConfig{Enabled: true, Path: filepath.Join(b.TempDir(), "receipts.wal"), GroupCommitMS: int(window / time.Millisecond), Durability: "strict"}
+	logged, err := OpenLogged(NewMemory(16), config, testNow, func() map[string]int { return nil })
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer logged.Close()
+	if window > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go logged.FlushPeriodically(stop)
+	}
+
+	var next atomic.Int64
+	b.SetParallelism(64)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id := strconv.FormatInt(next.Add(1), 10)
+			shard := logged.Shard(id)
+			shard.Lock()
+			ack, err := logged.Insert(id, Entry{Receipt: model.Receipt{Retailer: "Target", Total: "35.35"}, Points: 28, StoredAt: testTime})
+			shard.Unlock()
+			if err == nil {
+				err = ack.Wait()
+			}
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "inserts/s")
+}
+
+func BenchmarkGroupCommit(b *testing.B) {
+	for _, window := range []time.Duration{0, time.Millisecond, 5 * time.Millisecond, 20 * time.Millisecond} {
+		b.Run(fmt.Sprintf("window=%v", window), func(b *testing.B) { benchmarkGroupCommit(b, window) })
+	}
+}
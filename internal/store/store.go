@@ -0,0 +1,605 @@
+// Package store keeps stored receipts and everything recorded about them. Memory holds them in memory only;
+// Logged also writes every change to a write-ahead log first and replays it at startup.
+package store
+
+import (
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"receipt-processor/internal/model"
+)
+
+// Raw request body a receipt was submitted with, kept so a client that lost it can fetch it again
+type Body struct {
+	ContentType string `json:"contentType"`
+	Data        []byte `json:"data"`
+	// SHA-256 of the submission's Idempotency-Key, which lets the submitter read the body back without the admin token
+	KeyHash string `json:"keyHash,omitempty"`
+}
+
+// A receipt as it is first stored
+type Entry struct {
+	Receipt     model.Receipt
+	Points      int
+	Fingerprint string
+	StoredAt    time.Time
+	// Schema version the receipt is stored under
+	SchemaVersion int
+	Body          *Body
+	// API key whose quota the submission counted against, if any
+	APIKey string
+}
+
+// One receipt as it stood when a snapshot was taken
+type Stored struct {
+	ID       string
+	Receipt  model.Receipt
+	Points   int
+	Archived bool
+	Pinned   bool
+	StoredAt time.Time
+}
+
+// Closed once a deferred record is synced, carrying the sync error; nil when nothing is left to wait for
+type Ack chan error
+
+// Function to wait until the acknowledged record is durable
+func (a Ack) Wait() error {
+	if a == nil {
+		return nil
+	}
+	return <-a
+}
+
+// A receipt store. Each shard's lock guards the receipts in it, and the indexes spanning receipts sit behind a lock
+// of the store's own that it takes itself, so writers to different shards never wait on each other. Changes need
+// the shard locks of the receipts they touch held; what they change is durable once they return without an error
+type Store interface {
+	// Shard holds the state of the receipt with the given ID; Shards lists every shard in lock order
+	Shard(id string) *Shard
+	Shards() []*Shard
+	// LockPair locks the shards of two receipts in lock order and returns the matching unlock
+	LockPair(a, b string) func()
+	// RLockEach read-locks the shards of every given receipt at once, in lock order, and returns the matching unlock
+	RLockEach(ids []string) func()
+
+	// FingerprintOwner finds the first receipt stored with a fingerprint
+	FingerprintOwner(fingerprint string) (string, bool)
+	// ClaimFingerprint makes id the owner of a fingerprint no receipt owns yet, ahead of inserting it, and returns
+	// the owner and whether id became it. ReleaseFingerprint gives up the claim of a receipt that was never inserted
+	ClaimFingerprint(fingerprint, id string) (string, bool)
+	ReleaseFingerprint(id string)
+	// RetailerCount counts the receipts stored for a retailer
+	RetailerCount(retailer string) int
+	// KeyUsage copies the submissions counted against each API key, per UTC day ("2006-01-02")
+	KeyUsage() map[string]map[string]int
+	// Count counts every stored receipt, taking each shard's lock in turn
+	Count() int
+	// Bytes estimates the memory held by receipt payloads
+	Bytes() int64
+	// Snapshot copies every stored receipt, in ID order, as of a single moment
+	Snapshot() []Stored
+
+	// Insert stores a new receipt. With group commit its record may be synced after the call returns; the caller
+	// releases its locks before waiting on the ack
+	Insert(id string, entry Entry) (Ack, error)
+	// Discard takes back an insert whose ack failed: the receipt, its fingerprint claim and its key usage go, even
+	// when the record taking it back cannot be logged either
+	Discard(id string, entry Entry) error
+	// Redeem makes final the spending of points already taken off a receipt's value
+	Redeem(id string, points int) error
+	// Transfer moves points from one receipt to another
+	Transfer(id, targetID string, points int) error
+	// Replace swaps in an edited receipt, moving its points by delta
+	Replace(id string, receipt model.Receipt, delta int, fingerprint string) error
+	// Migrate swaps in a receipt upgraded to a newer schema version
+	Migrate(id string, receipt model.Receipt, fingerprint string, version int) error
+	// Archive moves a receipt out of the hot map; it stays readable but can no longer change
+	Archive(id string) error
+	// Pin marks a receipt as pinned, or no longer pinned; the caller refuses to delete a pinned receipt
+	Pin(id string, pinned bool) error
+	// AddNote attaches a free-text note to a receipt
+	AddNote(id string, note model.Note) error
+	// RemoveNote takes a note off a receipt, reporting whether it had one with that ID
+	RemoveNote(id, noteID string) (bool, error)
+	// Delete drops a receipt and what was stored with it; its events and notes are left for the caller
+	Delete(id string) error
+
+	// Close makes everything written so far durable
+	Close() error
+}
+
+// One slice of the per-receipt state, with its own lock so receipts in different shards never contend
+type Shard struct {
+	sync.RWMutex
+	Receipts map[string]model.Receipt
+	// Receipts moved out of the hot map by the archiver; still readable, no longer modifiable
+	Archived map[string]model.Receipt
+	StoredAt map[string]time.Time
+	// Schema version each receipt was stored under
+	Versions map[string]int
+	Points   map[string]int
+	Events   map[string][]model.Event
+	Notes    map[string][]model.Note
+	// Raw bodies kept for receipts submitted with X-Store-Body
+	Bodies map[string]Body
+	// Every change to each receipt's points, oldest first
+	PointsMutations map[string][]model.PointsMutation
+	// Open event streams per receipt, each fed by Publish
+	Subscribers map[string]map[chan model.Event]bool
+	// Receipts pinned against deletion
+	Pinned map[string]bool
+}
+
+func newShard() *Shard {
+	return &Shard{
+		Receipts:        make(map[string]model.Receipt),
+		Archived:        make(map[string]model.Receipt),
+		StoredAt:        make(map[string]time.Time),
+		Versions:        make(map[string]int),
+		Points:          make(map[string]int),
+		Events:          make(map[string][]model.Event),
+		Notes:           make(map[string][]model.Note),
+		Bodies:          make(map[string]Body),
+		PointsMutations: make(map[string][]model.PointsMutation),
+		Subscribers:     make(map[string]map[chan model.Event]bool),
+		Pinned:          make(map[string]bool),
+	}
+}
+
+// Function to find a receipt in the hot or the archived map; callers hold the shard lock
+func (s *Shard) Lookup(id string) (model.Receipt, bool) {
+	if receipt, exists := s.Receipts[id]; exists {
+		return receipt, true
+	}
+	receipt, exists := s.Archived[id]
+	return receipt, exists
+}
+
+// Function to get the schema version a receipt was stored under; callers hold the shard lock. Receipts logged
+// before versioning existed are version 1
+func (s *Shard) SchemaVersion(id string) int {
+	if version := s.Versions[id]; version > 0 {
+		return version
+	}
+	return 1
+}
+
+// Function to pass an event on to a receipt's streams without waiting; callers hold the shard lock
+func (s *Shard) Publish(id string, event model.Event) {
+	for channel := range s.Subscribers[id] {
+		select {
+		case channel <- event:
+		default:
+			s.Unsubscribe(id, channel)
+		}
+	}
+}
+
+// Function to drop a stream, closing its channel; callers hold the shard lock
+func (s *Shard) Unsubscribe(id string, channel chan model.Event) {
+	if !s.Subscribers[id][channel] {
+		return
+	}
+	delete(s.Subscribers[id], channel)
+	if len(s.Subscribers[id]) == 0 {
+		delete(s.Subscribers, id)
+	}
+	close(channel)
+}
+
+// Function to get a receipt's stored raw body, nil when it has none; callers hold the shard lock
+func (s *Shard) body(id string) *Body {
+	if body, ok := s.Bodies[id]; ok {
+		return &body
+	}
+	return nil
+}
+
+// A store that keeps everything in memory: per-receipt state sharded by receipt ID, and the indexes that span
+// receipts under its own lock
+type Memory struct {
+	shards []*Shard
+	// Guards the indexes below. It is taken after any shard lock and nothing else is locked while it is held
+	indexes sync.Mutex
+	// Fingerprint of each stored receipt's canonical form, for strict mode, and the other way round
+	fingerprints  map[string]string
+	fingerprintOf map[string]string
+	// Running per-retailer totals so counts never need a scan of the store
+	retailerCounts map[string]int
+	// Submissions per API key per UTC day. Deleting a receipt does not give its submission back
+	keyUsage map[string]map[string]int
+	// Approximate bytes of receipt payload held
+	receiptBytes atomic.Int64
+}
+
+// Function to make an empty in-memory store split across lockShards shards
+func NewMemory(lockShards int) *Memory {
+	m := &Memory{
+		shards:         make([]*Shard, lockShards),
+		fingerprints:   make(map[string]string),
+		fingerprintOf:  make(map[string]string),
+		retailerCounts: make(map[string]int),
+		keyUsage:       make(map[string]map[string]int),
+	}
+	for i := range m.shards {
+		m.shards[i] = newShard()
+	}
+	return m
+}
+
+// Function to find the position in lock order of the shard holding a receipt
+func (m *Memory) shardIndex(id string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	return int(hash.Sum32() % uint32(len(m.shards)))
+}
+
+func (m *Memory) Shard(id string) *Shard {
+	return m.shards[m.shardIndex(id)]
+}
+
+func (m *Memory) Shards() []*Shard {
+	return m.shards
+}
+
+func (m *Memory) LockPair(a, b string) func() {
+	first, second := m.shardIndex(a), m.shardIndex(b)
+	if first == second {
+		m.shards[first].Lock()
+		return m.shards[first].Unlock
+	}
+	if first > second {
+		first, second = second, first
+	}
+	m.shards[first].Lock()
+	m.shards[second].Lock()
+	return func() {
+		m.shards[second].Unlock()
+		m.shards[first].Unlock()
+	}
+}
+
+func (m *Memory) RLockEach(ids []string) func() {
+	indexes := make([]int, 0, len(ids))
+	for _, id := range ids {
+		indexes = append(indexes, m.shardIndex(id))
+	}
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+	for _, index := range indexes {
+		m.shards[index].RLock()
+	}
+	return func() {
+		for _, index := range slices.Backward(indexes) {
+			m.shards[index].RUnlock()
+		}
+	}
+}
+
+func (m *Memory) FingerprintOwner(fingerprint string) (string, bool) {
+	m.indexes.Lock()
+	defer m.indexes.Unlock()
+	id, exists := m.fingerprints[fingerprint]
+	return id, exists
+}
+
+func (m *Memory) ClaimFingerprint(fingerprint, id string) (string, bool) {
+	if fingerprint == "" {
+		return id, true
+	}
+	m.indexes.Lock()
+	defer m.indexes.Unlock()
+	m.claimFingerprint(id, fingerprint)
+	owner := m.fingerprints[fingerprint]
+	return owner, owner == id
+}
+
+func (m *Memory) ReleaseFingerprint(id string) {
+	m.indexes.Lock()
+	defer m.indexes.Unlock()
+	m.releaseFingerprint(id)
+}
+
+func (m *Memory) RetailerCount(retailer string) int {
+	m.indexes.Lock()
+	defer m.indexes.Unlock()
+	return m.retailerCounts[retailer]
+}
+
+func (m *Memory) KeyUsage() map[string]map[string]int {
+	m.indexes.Lock()
+	defer m.indexes.Unlock()
+	usage := make(map[string]map[string]int, len(m.keyUsage))
+	for key, days := range m.keyUsage {
+		usage[key] = maps.Clone(days)
+	}
+	return usage
+}
+
+// Function to count submissions against an API key on the UTC day of at; callers hold indexes
+func (m *Memory) countKeyUsage(key string, at time.Time, submissions int) {
+	days := m.keyUsage[key]
+	if days == nil {
+		days = make(map[string]int)
+		m.keyUsage[key] = days
+	}
+	days[at.UTC().Format(time.DateOnly)] += submissions
+}
+
+func (m *Memory) Count() int {
+	count := 0
+	for _, shard := range m.shards {
+		shard.RLock()
+		count += len(shard.Receipts) + len(shard.Archived)
+		shard.RUnlock()
+	}
+	return count
+}
+
+func (m *Memory) Bytes() int64 {
+	return m.receiptBytes.Load()
+}
+
+// Every shard is read-locked, in lock order, before any is read
+func (m *Memory) Snapshot() []Stored {
+	for _, shard := range m.shards {
+		shard.RLock()
+	}
+	var snapshot []Stored
+	for _, shard := range m.shards {
+		for _, receipts := range []map[string]model.Receipt{shard.Receipts, shard.Archived} {
+			for id, receipt := range receipts {
+				_, archived := shard.Archived[id]
+				snapshot = append(snapshot, Stored{ID: id, Receipt: receipt, Points: shard.Points[id],
+					Archived: archived, Pinned: shard.Pinned[id], StoredAt: shard.StoredAt[id]})
+			}
+		}
+	}
+	for _, shard := range m.shards {
+		shard.RUnlock()
+	}
+	slices.SortFunc(snapshot, func(a, b Stored) int { return strings.Compare(a.ID, b.ID) })
+	return snapshot
+}
+
+func (m *Memory) Insert(id string, entry Entry) (Ack, error) {
+	m.insert(id, entry)
+	return nil, nil
+}
+
+func (m *Memory) Discard(id string, entry Entry) error {
+	m.discard(id, entry)
+	return nil
+}
+
+// The points were taken off when they were reserved, so there is nothing left to change in memory
+func (m *Memory) Redeem(id string, points int) error {
+	return nil
+}
+
+func (m *Memory) Transfer(id, targetID string, points int) error {
+	m.transfer(id, targetID, points)
+	return nil
+}
+
+func (m *Memory) Replace(id string, receipt model.Receipt, delta int, fingerprint string) error {
+	m.replace(id, receipt, delta, fingerprint)
+	return nil
+}
+
+func (m *Memory) Migrate(id string, receipt model.Receipt, fingerprint string, version int) error {
+	m.migrate(id, receipt, fingerprint, version)
+	return nil
+}
+
+func (m *Memory) Archive(id string) error {
+	m.archive(id)
+	return nil
+}
+
+func (m *Memory) Pin(id string, pinned bool) error {
+	m.pin(id, pinned)
+	return nil
+}
+
+func (m *Memory) AddNote(id string, note model.Note) error {
+	m.addNote(id, note)
+	return nil
+}
+
+func (m *Memory) RemoveNote(id, noteID string) (bool, error) {
+	return m.removeNote(id, noteID), nil
+}
+
+func (m *Memory) Delete(id string) error {
+	m.delete(id)
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// Function to estimate the memory a stored receipt's strings take
+func receiptSize(receipt model.Receipt) int64 {
+	size := len(receipt.Retailer) + len(receipt.PurchaseDate) + len(receipt.PurchaseTime) + len(receipt.Total)
+	for _, item := range receipt.Items {
+		size += len(item.ShortDescription) + len(item.Price)
+	}
+	return int64(size)
+}
+
+// Function to record that a receipt owns a fingerprint, unless an earlier receipt already does; callers hold the
+// index lock. A receipt inserted after claiming its fingerprint finds it already its own
+func (m *Memory) claimFingerprint(id, fingerprint string) {
+	if _, exists := m.fingerprints[fingerprint]; !exists && fingerprint != "" {
+		m.fingerprints[fingerprint] = id
+		m.fingerprintOf[id] = fingerprint
+	}
+}
+
+// Function to give up the fingerprint a receipt owns, if any; callers hold the index lock
+func (m *Memory) releaseFingerprint(id string) {
+	if fingerprint, exists := m.fingerprintOf[id]; exists {
+		delete(m.fingerprints, fingerprint)
+		delete(m.fingerprintOf, id)
+	}
+}
+
+// The changes below are shared by Memory and by Logged, live and at replay; callers hold the shard locks of the
+// receipts they touch, and each takes the index lock itself for the indexes it updates
+
+func (m *Memory) insert(id string, entry Entry) {
+	shard := m.Shard(id)
+	shard.Receipts[id] = entry.Receipt
+	shard.Points[id] = entry.Points
+	shard.StoredAt[id] = entry.StoredAt
+	shard.Versions[id] = entry.SchemaVersion
+	if entry.Body != nil {
+		shard.Bodies[id] = *entry.Body
+	}
+	m.receiptBytes.Add(receiptSize(entry.Receipt))
+	m.indexes.Lock()
+	defer m.indexes.Unlock()
+	m.retailerCounts[entry.Receipt.Retailer]++
+	m.claimFingerprint(id, entry.Fingerprint)
+	if entry.APIKey != "" {
+		m.countKeyUsage(entry.APIKey, entry.StoredAt, 1)
+	}
+}
+
+// Function to move points between two receipts, reporting whether both exist
+func (m *Memory) transfer(id, targetID string, points int) bool {
+	source, target := m.Shard(id), m.Shard(targetID)
+	_, sourceExists := source.Points[id]
+	_, targetExists := target.Points[targetID]
+	if !sourceExists || !targetExists {
+		return false
+	}
+	source.Points[id] -= points
+	target.Points[targetID] += points
+	return true
+}
+
+// Function to report whether the receipt was there to replace; archived receipts are never replaced
+func (m *Memory) replace(id string, receipt model.Receipt, delta int, fingerprint string) bool {
+	shard := m.Shard(id)
+	previous, exists := shard.Receipts[id]
+	if !exists {
+		return false
+	}
+	m.indexes.Lock()
+	m.releaseFingerprint(id)
+	m.claimFingerprint(id, fingerprint)
+	m.indexes.Unlock()
+	m.receiptBytes.Add(receiptSize(receipt) - receiptSize(previous))
+	shard.Receipts[id] = receipt
+	shard.Points[id] += delta
+	return true
+}
+
+// A migrated receipt only takes over the fingerprint if the receipt it replaces owned one
+func (m *Memory) migrate(id string, receipt model.Receipt, fingerprint string, version int) {
+	shard := m.Shard(id)
+	previous, exists := shard.Lookup(id)
+	if !exists {
+		return
+	}
+	m.indexes.Lock()
+	if _, owned := m.fingerprintOf[id]; owned {
+		m.releaseFingerprint(id)
+		m.claimFingerprint(id, fingerprint)
+	}
+	m.retailerCounts[previous.Retailer]--
+	m.retailerCounts[receipt.Retailer]++
+	m.indexes.Unlock()
+	m.receiptBytes.Add(receiptSize(receipt) - receiptSize(previous))
+	if _, archived := shard.Archived[id]; archived {
+		shard.Archived[id] = receipt
+	} else {
+		shard.Receipts[id] = receipt
+	}
+	shard.Versions[id] = version
+}
+
+func (m *Memory) archive(id string) {
+	shard := m.Shard(id)
+	if receipt, exists := shard.Receipts[id]; exists {
+		shard.Archived[id] = receipt
+		delete(shard.Receipts, id)
+	}
+}
+
+// Function to delete a receipt whose insert never became durable, giving back the submission it counted against
+// its API key
+func (m *Memory) discard(id string, entry Entry) {
+	if _, exists := m.Shard(id).Lookup(id); !exists {
+		return
+	}
+	m.delete(id)
+	if entry.APIKey == "" {
+		return
+	}
+	m.indexes.Lock()
+	defer m.indexes.Unlock()
+	m.countKeyUsage(entry.APIKey, entry.StoredAt, -1)
+}
+
+func (m *Memory) pin(id string, pinned bool) {
+	shard := m.Shard(id)
+	if _, exists := shard.Lookup(id); !exists {
+		return
+	}
+	if pinned {
+		shard.Pinned[id] = true
+	} else {
+		delete(shard.Pinned, id)
+	}
+}
+
+func (m *Memory) addNote(id string, note model.Note) {
+	shard := m.Shard(id)
+	if _, exists := shard.Lookup(id); !exists {
+		return
+	}
+	shard.Notes[id] = append(shard.Notes[id], note)
+}
+
+func (m *Memory) removeNote(id, noteID string) bool {
+	shard := m.Shard(id)
+	list := shard.Notes[id]
+	for i, note := range list {
+		if note.ID == noteID {
+			shard.Notes[id] = append(list[:i:i], list[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) delete(id string) {
+	shard := m.Shard(id)
+	receipt, exists := shard.Lookup(id)
+	if !exists {
+		return
+	}
+	m.indexes.Lock()
+	m.retailerCounts[receipt.Retailer]--
+	m.releaseFingerprint(id)
+	m.indexes.Unlock()
+	m.receiptBytes.Add(-receiptSize(receipt))
+	delete(shard.Receipts, id)
+	delete(shard.Archived, id)
+	delete(shard.StoredAt, id)
+	delete(shard.Versions, id)
+	delete(shard.Bodies, id)
+	delete(shard.PointsMutations, id)
+	delete(shard.Points, id)
+	delete(shard.Pinned, id)
+}
@@ -0,0 +1,357 @@
+package store
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"receipt-processor/internal/model"
+)
+
+// Function to store a receipt the way the API does, with its shard lock alone held
+func insertLocked(s Store, id string, points int) {
+	shard := s.Shard(id)
+	shard.Lock()
+	defer shard.Unlock()
+	s.Insert(id, Entry{Receipt: model.Receipt{Retailer: "Target", Total: "1.00"}, Points: points, Fingerprint: "fp-" + id})
+}
+
+// Function to look up a receipt's points the way the points endpoint does, under its shard's read lock alone
+func lookupPoints(s Store, id string) (int, bool) {
+	shard := s.Shard(id)
+	shard.RLock()
+	defer shard.RUnlock()
+	points, exists := shard.Points[id]
+	return points, exists
+}
+
+// Function to benchmark point lookups mixed with inserts, writePercent of every hundred operations being writes
+func benchmarkStore(b *testing.B, lockShards, writePercent int) {
+	const preloaded = 10000
+	s := NewMemory(lockShards)
+	for i := range preloaded {
+		insertLocked(s, strconv.Itoa(i), i)
+	}
+	var inserted atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		random := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+		for pb.Next() {
+			if random.IntN(100) < writePercent {
+				insertLocked(s, "new-"+strconv.FormatInt(inserted.Add(1), 10), 1)
+			} else {
+				lookupPoints(s, strconv.Itoa(random.IntN(preloaded)))
+			}
+		}
+	})
+}
+
+func BenchmarkStoreReadHeavy(b *testing.B) {
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) { benchmarkStore(b, shards, 5) })
+	}
+}
+
+func BenchmarkStoreMixed(b *testing.B) {
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) { benchmarkStore(b, shards, 50) })
+	}
+}
+
+// Function to benchmark the latency spread of 1000 goroutines looking up points while a fifth of their operations
+// insert, reporting the median, 99th percentile and worst operation
+func benchmarkLatency(b *testing.B, lockShards int) {
+	const preloaded, goroutines = 10000, 1000
+	s := NewMemory(lockShards)
+	for i := range preloaded {
+		insertLocked(s, strconv.Itoa(i), i)
+	}
+	perGoroutine := max(b.N/goroutines, 1)
+	latencies := make([]time.Duration, goroutines*perGoroutine)
+	var group sync.WaitGroup
+	b.ResetTimer()
+	for g := range goroutines {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			random := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+			for i := range perGoroutine {
+				started := time.Now()
+				if random.IntN(100) < 20 {
+					insertLocked(s, fmt.Sprintf("new-%d-%d", g, i), 1)
+				} else {
+					lookupPoints(s, strconv.Itoa(random.IntN(preloaded)))
+				}
+				latencies[g*perGoroutine+i] = time.Since(started)
+			}
+		}()
+	}
+	group.Wait()
+	b.StopTimer()
+
+	slices.Sort(latencies)
+	b.ReportMetric(float64(latencies[len(latencies)/2].Nanoseconds()), "p50-ns")
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+	b.ReportMetric(float64(latencies[len(latencies)-1].Nanoseconds()), "max-ns")
+}
+
+func BenchmarkStoreLatency(b *testing.B) {
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) { benchmarkLatency(b, shards) })
+	}
+}
+
+// Function to benchmark 100 concurrent point lookups on one shard, taking its lock exclusively the way reads did when
+// the store used a sync.Mutex, or shared the way they do now
+func benchmarkReaders(b *testing.B, exclusive bool) {
+	const preloaded, readers = 10000, 100
+	s := NewMemory(1)
+	for i := range preloaded {
+		insertLocked(s, strconv.Itoa(i), i)
+	}
+	b.SetParallelism((readers + runtime.GOMAXPROCS(0) - 1) / runtime.GOMAXPROCS(0))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		random := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+		for pb.Next() {
+			id := strconv.Itoa(random.IntN(preloaded))
+			if !exclusive {
+				lookupPoints(s, id)
+				continue
+			}
+			shard := s.Shard(id)
+			shard.Lock()
+			_ = shard.Points[id]
+			shard.Unlock()
+		}
+	})
+}
+
+func BenchmarkConcurrentReaders(b *testing.B) {
+	b.Run("mutex", func(b *testing.B) { benchmarkReaders(b, true) })
+	b.Run("rwmutex", func(b *testing.B) { benchmarkReaders(b, false) })
+}
+
+// Run with -race: readers, writers, transfers and snapshots all at once, then the totals must still add up
+func TestMemoryConcurrentAccess(t *testing.T) {
+	const (
+		workers   = 8
+		perWorker = 200
+		seeded    = 20
+	)
+	s := NewMemory(4)
+	for i := range seeded {
+		insertLocked(s, "seed-"+strconv.Itoa(i), 100)
+	}
+
+	var group sync.WaitGroup
+	for worker := range workers {
+		group.Add(4)
+		go func() {
+			defer group.Done()
+			for i := range perWorker {
+				insertLocked(s, fmt.Sprintf("w%d-%d", worker, i), 1)
+			}
+		}()
+		go func() {
+			defer group.Done()
+			for i := range perWorker {
+				from, to := "seed-"+strconv.Itoa((worker+i)%seeded), "seed-"+strconv.Itoa((worker+i+1)%seeded)
+				unlock := s.LockPair(from, to)
+				if s.Shard(from).Points[from] > 0 {
+					s.Transfer(from, to, 1)
+				}
+				unlock()
+			}
+		}()
+		go func() {
+			defer group.Done()
+			for i := range perWorker {
+				lookupPoints(s, "seed-"+strconv.Itoa(i%seeded))
+				s.Count()
+			}
+		}()
+		go func() {
+			defer group.Done()
+			for range perWorker / 10 {
+				s.Snapshot()
+				s.RetailerCount("Target")
+			}
+		}()
+	}
+	group.Wait()
+
+	if got, want := s.Count(), seeded+workers*perWorker; got != want {
+		t.Errorf("Count = %d, want %d", got, want)
+	}
+	if got, want := s.RetailerCount("Target"), seeded+workers*perWorker; got != want {
+		t.Errorf("RetailerCount = %d, want %d", got, want)
+	}
+	total := 0
+	for _, stored := range s.Snapshot() {
+		total += stored.Points
+	}
+	if want := seeded*100 + workers*perWorker; total != want {
+		t.Errorf("points total %d after transfers, want %d", total, want)
+	}
+}
+
+func TestShardsSpreadReceipts(t *testing.T) {
+	s := NewMemory(16)
+	perShard := make(map[*Shard]int)
+	for i := range 1600 {
+		id := fmt.Sprintf("%08x-receipt", i)
+		if s.Shard(id) != s.Shard(id) {
+			t.Fatalf("receipt %s moved between shards", id)
+		}
+		perShard[s.Shard(id)]++
+	}
+	if len(perShard) != 16 {
+		t.Fatalf("receipts landed on %d of 16 shards", len(perShard))
+	}
+	for _, count := range perShard {
+		if count < 50 || count > 150 {
+			t.Errorf("a shard holds %d of 1600 receipts, want about 100", count)
+		}
+	}
+	if single := NewMemory(1); single.Shard("a") != single.Shard("b") {
+		t.Error("a one-shard store used more than one shard")
+	}
+}
+
+func TestLockPairTakesShardsInOrder(t *testing.T) {
+	s := NewMemory(4)
+	// Two IDs on different shards and two sharing one
+	a, b := "0", "1"
+	for i := 1; s.Shard(a) == s.Shard(b); i++ {
+		b = strconv.Itoa(i)
+	}
+	c := b
+	for i := 0; c == b || s.Shard(c) != s.Shard(b); i++ {
+		c = "c" + strconv.Itoa(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var group sync.WaitGroup
+		for worker := range 8 {
+			group.Add(1)
+			go func() {
+				defer group.Done()
+				for range 1000 {
+					// Opposite argument orders would deadlock if the locks were taken as given
+					if worker%2 == 0 {
+						s.LockPair(a, b)()
+					} else {
+						s.LockPair(b, a)()
+					}
+					s.LockPair(b, c)()
+				}
+			}()
+		}
+		group.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("LockPair deadlocked")
+	}
+}
+
+// Function to benchmark inserts alone from every processor at once, each holding only its receipt's shard lock
+func benchmarkWrites(b *testing.B, lockShards int) {
+	s := NewMemory(lockShards)
+	var inserted atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			insertLocked(s, "new-"+strconv.FormatInt(inserted.Add(1), 10), 1)
+		}
+	})
+}
+
+func BenchmarkStoreWrites(b *testing.B) {
+	for _, shards := range []int{1, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) { benchmarkWrites(b, shards) })
+	}
+}
+
+func TestInsertDoesNotWaitOnOtherShards(t *testing.T) {
+	s := NewMemory(4)
+	held, other := "0", "1"
+	for i := 1; s.Shard(held) == s.Shard(other); i++ {
+		other = strconv.Itoa(i)
+	}
+	s.Shard(held).Lock()
+	defer s.Shard(held).Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		insertLocked(s, other, 1)
+		s.RetailerCount("Target")
+		s.FingerprintOwner("fp-" + other)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("an insert waited on the lock of another shard")
+	}
+}
+
+func TestClaimFingerprint(t *testing.T) {
+	s := NewMemory(4)
+	if owner, claimed := s.ClaimFingerprint("fp", "a"); !claimed || owner != "a" {
+		t.Fatalf("first claim = %q, %v, want a, true", owner, claimed)
+	}
+	if owner, claimed := s.ClaimFingerprint("fp", "b"); claimed || owner != "a" {
+		t.Errorf("second claim = %q, %v, want a, false", owner, claimed)
+	}
+	// Inserting the claimant keeps its claim, and releasing a claim that was never inserted frees the fingerprint
+	insertLocked(s, "a", 1)
+	if owner, _ := s.FingerprintOwner("fp-a"); owner != "a" {
+		t.Errorf("inserted receipt does not own its own fingerprint")
+	}
+	s.ClaimFingerprint("fp2", "c")
+	s.ReleaseFingerprint("c")
+	if owner, claimed := s.ClaimFingerprint("fp2", "d"); !claimed || owner != "d" {
+		t.Errorf("claim after release = %q, %v, want d, true", owner, claimed)
+	}
+}
+
+func TestRLockEachTakesShardsInOrder(t *testing.T) {
+	s := NewMemory(4)
+	ids := []string{"3", "0", "1", "2", "0", "7", "5"}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var group sync.WaitGroup
+		for worker := range 8 {
+			group.Add(1)
+			go func() {
+				defer group.Done()
+				for i := range 1000 {
+					// Writers locking pairs in lock order must never deadlock against readers locking many
+					if worker%2 == 0 {
+						s.RLockEach(ids)()
+					} else {
+						s.LockPair(ids[i%len(ids)], ids[(i+3)%len(ids)])()
+					}
+				}
+			}()
+		}
+		group.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("RLockEach deadlocked")
+	}
+}
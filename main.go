@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"receipt-processor/internal/api"
+)
+
+func main() {
+	// "score" works offline and exits; "serve", the default, starts the server
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "score" {
+		os.Exit(api.RunScoreCommand(args[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
+	if len(args) > 0 && args[0] == "serve" {
+		args = args[1:]
+	}
+
+	// Every configuration problem is reported before giving up, not just the first one found
+	config, err := api.LoadConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Println("invalid configuration:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if config.PrintConfig {
+		fmt.Print(config.Redacted())
+		return
+	}
+	if config.DryRun {
+		fmt.Println("configuration is valid")
+		return
+	}
+	if err := api.Run(config); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
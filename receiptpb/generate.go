@@ -0,0 +1,5 @@
+// Package receiptpb holds the protobuf messages and gRPC service generated from receipt.proto.
+package receiptpb
+
+// go generate ./receiptpb regenerates the code; it needs protoc, protoc-gen-go and protoc-gen-go-grpc on the PATH
+//go:generate protoc -I . --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative receipt.proto